@@ -0,0 +1,298 @@
+package download
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-zoox/fs"
+)
+
+// isResuming returns true if an existing file is continued, with IfExistsResume or PartialPath
+func (d *Downloader) isResuming() bool {
+	return d.IfExists == IfExistsResume || d.PartialPath != ""
+}
+
+// prepareResume moves the existing file to the staging path for IfExistsResume, or copies the PartialPath of
+// another tool, left untouched, so the rest is appended to it, the staging file left by an interrupted download
+// is resumed as well. contentLength is the size of the remote file, -1 when unknown before the download.
+func (d *Downloader) prepareResume(contentLength int64) error {
+	d.resumeOffset = 0
+	// the pieces are hashed from the start of the file
+	if !d.isResuming() || d.Range != nil || len(d.PieceHashes) > 0 {
+		return nil
+	}
+
+	filePath := d.getFilePath()
+	stagingPath := d.stagingPath()
+	if d.isRestarted {
+		// the remote file changed, the existing bytes are from the old one
+		return removeIfExists(stagingPath)
+	}
+
+	if info, err := os.Stat(filePath); err == nil && info.Mode().IsRegular() {
+		if contentLength > 0 && info.Size() > contentLength {
+			return fmt.Errorf("cannot resume %s: larger than the remote file (%d > %d bytes)", filePath, info.Size(), contentLength)
+		}
+
+		if err := moveFile(filePath, stagingPath, d.inProgressSuffix(), d.fileMode()); err != nil {
+			return err
+		}
+	}
+
+	// the partial file of another tool, unless a download of ours is already under way
+	if d.PartialPath != "" && !fs.IsExist(stagingPath) {
+		if info, err := os.Stat(d.PartialPath); err == nil && info.Mode().IsRegular() {
+			if err := copyFile(d.PartialPath, stagingPath, d.inProgressSuffix(), d.fileMode()); err != nil {
+				return err
+			}
+		}
+	}
+
+	info, err := os.Stat(stagingPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if contentLength > 0 && info.Size() > contentLength {
+		// a staging file of another version, start over
+		return os.Remove(stagingPath)
+	}
+
+	if ok, err := d.verifyResumeTail(info.Size()); err != nil {
+		return err
+	} else if !ok {
+		if os.Getenv("DEBUG") == "true" {
+			fmt.Println("resume: the end of the file differs from the remote, downloading from the start:", stagingPath)
+		}
+		return os.Remove(stagingPath)
+	}
+
+	d.resumeOffset = info.Size()
+	return nil
+}
+
+// verifyResumeTail compares the last ResumeVerifySize bytes of the staging file of size bytes with the remote
+// ones, fetched with a ranged request, false when they differ or the server ignores the range
+func (d *Downloader) verifyResumeTail(size int64) (bool, error) {
+	window := int64(d.ResumeVerifySize)
+	if window <= 0 || size == 0 {
+		return true, nil
+	}
+	if window > size {
+		window = size
+	}
+
+	part := &FilePart{RangeStart: size - window, RangeEnd: size - 1}
+	var remote []byte
+	err := d.retry(part, func() (err error) {
+		remote, err = d.fetchSegment(part)
+		return err
+	})
+	var e *Error
+	if errors.As(err, &e) && e.StatusCode == http.StatusOK {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	local := make([]byte, window)
+	f, err := os.Open(d.stagingPath())
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if _, err := f.ReadAt(local, size-window); err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(local, remote), nil
+}
+
+// directResumeSuffix is appended to the staging file of a direct download for its resume record
+const directResumeSuffix = ".resume"
+
+// directResume is the record of a direct download in progress, the staging file of an interrupted one
+// is continued when the remote file did not change since
+type directResume struct {
+	URL string
+	// Validator is the strong etag or the last modified date of the remote file, sent with If-Range
+	Validator string
+}
+
+func (d *Downloader) directResumePath() string {
+	return d.stagingPath() + directResumeSuffix
+}
+
+// prepareDirectResume keeps the staging file of an interrupted direct download of the url, so it is continued
+// from its size with If-Range, the ones without a record of the remote file are started over
+func (d *Downloader) prepareDirectResume() error {
+	d.directValidator = ""
+	if d.isResuming() || d.Range != nil {
+		return nil
+	}
+
+	record := &directResume{}
+	if data, err := os.ReadFile(d.directResumePath()); err == nil && json.Unmarshal(data, record) == nil {
+		if record.URL == d.URL && record.Validator != "" && fs.IsExist(d.stagingPath()) {
+			d.directValidator = record.Validator
+			return nil
+		}
+	}
+
+	if err := removeIfExists(d.stagingPath()); err != nil {
+		return err
+	}
+
+	return removeIfExists(d.directResumePath())
+}
+
+// recordDirectResume records the remote file of the response, which the staging file is written from
+func (d *Downloader) recordDirectResume(resp *http.Response) error {
+	if d.isResuming() {
+		return nil
+	}
+
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	if validator == "" {
+		// without validator an interrupted download is started over
+		return removeIfExists(d.directResumePath())
+	}
+
+	data, err := json.Marshal(&directResume{URL: d.URL, Validator: validator})
+	if err != nil {
+		return err
+	}
+
+	d.directValidator = validator
+	return os.WriteFile(d.directResumePath(), data, 0644)
+}
+
+// downloadByAppend downloads the rest of the staging file with a single request, appending it,
+// the staging file is measured again on every attempt, so a retry continues where the last one stopped.
+// The file is downloaded again from the start when the server ignores the range, or the remote file
+// changed since the direct download started.
+func (d *Downloader) downloadByAppend() error {
+	stagingPath := d.stagingPath()
+	var offset int64
+	if info, err := os.Stat(stagingPath); err == nil {
+		offset = info.Size()
+	}
+
+	var config *requestConfig
+	if offset > 0 {
+		config = &requestConfig{
+			Headers: map[string]string{
+				"Range": fmt.Sprintf("bytes=%d-", offset),
+			},
+		}
+		if d.directValidator != "" {
+			config.Headers["If-Range"] = d.directValidator
+		}
+	}
+
+	err := d.do(http.MethodGet, d.URL, config, func(resp *http.Response, body io.Reader) error {
+		if resp.StatusCode == http.StatusOK {
+			if err := d.recordDirectResume(resp); err != nil {
+				return err
+			}
+		}
+
+		if offset > 0 {
+			switch resp.StatusCode {
+			case http.StatusPartialContent:
+				if err := d.checkAppendResponse(resp, offset); err != nil {
+					return err
+				}
+				d.setProgressTotal(d.ContentLength)
+
+				w, err := d.appendToFile(stagingPath)
+				if err != nil {
+					return err
+				}
+
+				// the appended bytes are kept on failure, for the next attempt
+				if _, err := io.Copy(w, d.countProgress(stagingPath, offset, body)); err != nil {
+					w.Close()
+					return err
+				}
+
+				return w.Close()
+			case http.StatusRequestedRangeNotSatisfiable:
+				// nothing after the end, the file is complete
+				if resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset) {
+					d.ContentLength = offset
+					return nil
+				}
+
+				return newStatusError(resp.StatusCode)
+			case http.StatusOK:
+				if os.Getenv("DEBUG") == "true" {
+					fmt.Println("resume: range ignored, downloading from the start:", d.URL)
+				}
+			default:
+				return newStatusError(resp.StatusCode)
+			}
+		}
+
+		if err := d.checkDirectResponse(resp); err != nil {
+			return err
+		}
+
+		return d.writeFile(stagingPath, body)
+	})
+	if err != nil {
+		return classify(err)
+	}
+
+	return removeIfExists(d.directResumePath())
+}
+
+// checkAppendResponse checks the response to the rest of a file from offset,
+// the size of the remote file is taken from its Content-Range.
+func (d *Downloader) checkAppendResponse(resp *http.Response, offset int64) error {
+	start, _, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return newProtocolError(err)
+	}
+
+	if start != offset {
+		return newProtocolError(fmt.Errorf("unexpected content range: expect start %d, got %s", offset, resp.Header.Get("Content-Range")))
+	}
+
+	if err := d.checkContentType(resp.Header.Get("Content-Type")); err != nil {
+		return err
+	}
+
+	if err := d.checkExpectedSize(total); err != nil {
+		return err
+	}
+
+	if total > 0 {
+		d.ContentLength = total
+	}
+
+	return nil
+}
+
+// removeIfExists removes the file at path, if any
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,299 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIfExistsResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var mu sync.Mutex
+	var downloaded int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			var start, end int
+			end = len(content) - 1
+			if n, _ := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); n == 0 {
+				start = 0
+			}
+
+			mu.Lock()
+			downloaded += end - start + 1
+			mu.Unlock()
+		}
+
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		name     string
+		disabled bool
+		existing int
+	}{
+		{"ranges", false, 437},
+		{"direct", true, 437},
+		{"ranges complete", false, len(content)},
+		{"direct complete", true, len(content)},
+		{"ranges missing", false, -1},
+		{"direct missing", true, -1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "file.mp4")
+			if c.existing >= 0 {
+				if err := os.WriteFile(filePath, content[:c.existing], 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			mu.Lock()
+			downloaded = 0
+			mu.Unlock()
+
+			_, err := Download(server.URL+"/file.mp4", &Config{
+				FilePath:         filePath,
+				TmpDir:           t.TempDir(),
+				SegmentSize:      64,
+				IsRangesDisabled: c.disabled,
+				IfExists:         IfExistsResume,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, content) {
+				t.Errorf("unexpected content: %s", data)
+			}
+
+			expected := len(content)
+			if c.existing > 0 {
+				expected = len(content) - c.existing
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if downloaded != expected {
+				t.Errorf("expected %d bytes downloaded, got %d", expected, downloaded)
+			}
+		})
+	}
+}
+
+func TestIfExistsResumeRangeIgnored(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	filePath := filepath.Join(t.TempDir(), "file.mp4")
+	if err := os.WriteFile(filePath, []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:         filePath,
+		IsRangesDisabled: true,
+		IfExists:         IfExistsResume,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("expected the file downloaded again from the start, got %s", data)
+	}
+}
+
+func TestIfExistsResumeLarger(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	filePath := filepath.Join(t.TempDir(), "file.mp4")
+	existing := append(append([]byte{}, content...), "trailing"...)
+	if err := os.WriteFile(filePath, existing, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath: filePath,
+		TmpDir:   t.TempDir(),
+		IfExists: IfExistsResume,
+	})
+	if err == nil {
+		t.Fatal("expected an error resuming a larger file")
+	}
+
+	data, _ := os.ReadFile(filePath)
+	if !bytes.Equal(data, existing) {
+		t.Error("expected the existing file untouched")
+	}
+}
+
+func TestPartialPath(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var mu sync.Mutex
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name    string
+		partial []byte
+		// fetched is the range of the first part downloaded
+		fetched string
+	}{
+		{"adopted", content[:300], "bytes=300-399"},
+		{"differs", append(append([]byte{}, content[:250]...), bytes.Repeat([]byte("x"), 50)...), "bytes=0-99"},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			// left by wget or a browser
+			partialPath := filepath.Join(dir, "file.mp4.crdownload")
+			if err := os.WriteFile(partialPath, c.partial, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			ranges = nil
+			mu.Unlock()
+
+			result, err := Download(server.URL+"/file.mp4", &Config{
+				FilePath:         filepath.Join(dir, "file.mp4"),
+				TmpDir:           t.TempDir(),
+				SegmentSize:      100,
+				PartialPath:      partialPath,
+				ResumeVerifySize: 50,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(result.FinalPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, content) {
+				t.Errorf("unexpected content: %d bytes", len(data))
+			}
+			// the file of the user is copied, even when it differs
+			if partial, err := os.ReadFile(partialPath); err != nil || !bytes.Equal(partial, c.partial) {
+				t.Errorf("expected the partial file left untouched, got %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			fetched := map[string]bool{}
+			for _, r := range ranges {
+				fetched[r] = true
+			}
+			if !fetched["bytes=250-299"] || !fetched[c.fetched] {
+				t.Errorf("expected the end of the partial file compared and %s downloaded, got %v", c.fetched, ranges)
+			}
+			if c.fetched != "bytes=0-99" && fetched["bytes=0-99"] {
+				t.Errorf("expected the partial file to be resumed, got %v", ranges)
+			}
+		})
+	}
+}
+
+func TestDirectResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var mu sync.Mutex
+	etag := `"v1"`
+	isCut := true
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Header.Get("Range")+" "+r.Header.Get("If-Range"))
+		cut := isCut
+		w.Header().Set("ETag", etag)
+		mu.Unlock()
+
+		if cut {
+			// the connection drops after 300 bytes
+			w.Header().Set("Content-Length", "1000")
+			w.Write(content[:300])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name string
+		etag string
+		// expected is the request continuing the download
+		expected string
+	}{
+		{"unchanged", `"v1"`, `bytes=300- "v1"`},
+		{"changed", `"v2"`, `bytes=300- "v1"`},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			mu.Lock()
+			etag, isCut, requests = `"v1"`, true, nil
+			mu.Unlock()
+
+			config := &Config{
+				FilePath:         filepath.Join(t.TempDir(), "file.mp4"),
+				IsRangesDisabled: true,
+			}
+			if _, err := Download(server.URL+"/file.mp4", config); err == nil {
+				t.Fatal("expected the download to be cut")
+			}
+
+			mu.Lock()
+			etag, isCut, requests = c.etag, false, nil
+			mu.Unlock()
+
+			result, err := Download(server.URL+"/file.mp4", config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(result.FinalPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, content) {
+				t.Errorf("unexpected content: %d bytes", len(data))
+			}
+			if _, err := os.Stat(config.FilePath + stagingSuffix + directResumeSuffix); !os.IsNotExist(err) {
+				t.Errorf("expected the resume record to be removed, got %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(requests) != 1 || requests[0] != c.expected {
+				t.Errorf("expected %s, got %q", c.expected, requests)
+			}
+		})
+	}
+}
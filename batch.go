@@ -0,0 +1,469 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBatchConcurrency is the number of files of a batch downloaded at the same time
+var DefaultBatchConcurrency = 4
+
+// BatchEntry represents one file of a batch
+type BatchEntry struct {
+	// URL is the url of the file
+	URL string `json:"url"`
+	// Output is the path of the file, relative to Config.Dir, derived from the url when empty,
+	// an absolute path or one outside Config.Dir fails the entry with ErrUnsafeOutput
+	Output string `json:"output,omitempty"`
+	// Checksum is the checksum the file must match, as Config.ExpectedChecksum
+	Checksum string `json:"checksum,omitempty"`
+	// ID names the entry for DependsOn, optional
+	ID string `json:"id,omitempty"`
+	// Group names a set of entries for DependsOn, e.g. checksums, optional
+	Group string `json:"group,omitempty"`
+	// DependsOn are the IDs or groups of the entries to download successfully before this one starts,
+	// a failed dependency fails the entry with ErrDependencyFailed
+	DependsOn []string `json:"depends_on,omitempty"`
+	// Priority starts the entry before the ones of lower priority, as Config.Priority for its parts
+	Priority int `json:"priority,omitempty"`
+	// Manifest adds the entries of the downloaded file, a batch manifest, to the batch once it succeeded,
+	// e.g. to fetch a manifest then the files it lists. Their relative urls are resolved against the url
+	// of the entry, and their DependsOn may name the entries of the batch.
+	Manifest bool `json:"manifest,omitempty"`
+}
+
+// ErrDependencyFailed is the error of a batch entry whose dependency failed, see BatchEntry.DependsOn
+var ErrDependencyFailed = errors.New("dependency failed")
+
+// ErrUnsafeOutput is the error of a batch entry whose output is outside the directory of the batch,
+// see BatchEntry.Output
+var ErrUnsafeOutput = errors.New("unsafe output")
+
+// BatchEntryResult represents the result of one file of a batch
+type BatchEntryResult struct {
+	Entry *BatchEntry
+	// FilePath is where the downloaded file landed, in ContentStore when set, the location of a sink
+	FilePath string
+	// Size is the size of the downloaded file
+	Size int64
+	// Elapsed is the time spent downloading the file
+	Elapsed time.Duration
+	// Err is the error of a failed download, nil on success
+	Err error
+	// Skipped is true when robots.txt disallows the file, see Config.Robots, Err tells why
+	Skipped bool
+}
+
+// BatchResult represents the result of a batch, the entries in the input order,
+// followed by the ones added by the manifests of the batch, see BatchEntry.Manifest
+type BatchResult struct {
+	Entries   []*BatchEntryResult
+	Succeeded int
+	Failed    int
+	Skipped   int
+}
+
+// Err returns an error when any file failed, nil otherwise
+func (r *BatchResult) Err() error {
+	if r.Failed == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%d of %d downloads failed", r.Failed, len(r.Entries))
+}
+
+// Summary returns one line per file, then the totals
+func (r *BatchResult) Summary() string {
+	var b strings.Builder
+	for _, e := range r.Entries {
+		if e.Skipped {
+			fmt.Fprintf(&b, "SKIP %s: %s\n", e.Entry.URL, e.Err)
+			continue
+		}
+		if e.Err != nil {
+			fmt.Fprintf(&b, "FAIL %s: %s\n", e.Entry.URL, e.Err)
+			continue
+		}
+
+		fmt.Fprintf(&b, "OK   %s -> %s (%d bytes in %s)\n", e.Entry.URL, e.FilePath, e.Size, e.Elapsed.Round(time.Millisecond))
+	}
+	fmt.Fprintf(&b, "%d succeeded, %d failed", r.Succeeded, r.Failed)
+	if r.Skipped > 0 {
+		fmt.Fprintf(&b, ", %d skipped", r.Skipped)
+	}
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// DownloadBatch downloads the entries, DefaultBatchConcurrency at a time, every file with a copy of cfg,
+// their parts by a single pool of workers, a failed file does not stop the others, cancelling ctx does.
+func DownloadBatch(ctx context.Context, entries []*BatchEntry, cfg ...*Config) *BatchResult {
+	configX := &Config{}
+	if len(cfg) > 0 {
+		configX = cfg[0]
+	}
+	if configX.WorkerPool == nil {
+		shared := *configX
+		shared.WorkerPool = NewWorkerPool(DefaultBatchWorkers)
+		configX = &shared
+	}
+
+	result := &BatchResult{}
+
+	concurrency := DefaultBatchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// the entries start once their dependencies succeeded, by priority then in the input order
+	s := newBatchScheduler(result)
+	s.add(entries)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				index, entry, ok := s.next()
+				if !ok {
+					return
+				}
+
+				result := downloadBatchEntry(ctx, entry, configX)
+				var added []*BatchEntry
+				if entry.Manifest && result.Err == nil {
+					added, result.Err = readBatchManifestEntry(entry, result.FilePath)
+				}
+				s.done(index, result, added)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, e := range result.Entries {
+		if e.Skipped {
+			result.Skipped++
+		} else if e.Err != nil {
+			result.Failed++
+		} else {
+			result.Succeeded++
+		}
+	}
+
+	return result
+}
+
+// batchScheduler resolves the dependencies of the entries of a batch, including the ones added on the way
+type batchScheduler struct {
+	mu      sync.Mutex
+	entries []*BatchEntry
+	result  *BatchResult
+	// names are the entries by ID and group
+	names map[string][]int
+	// dependents are the entries waiting for each entry
+	dependents [][]int
+	// waiting are the numbers of dependencies not done yet
+	waiting   []int
+	remaining int
+	// ready are the entries free to start, by priority then index
+	ready []int
+	cond  *sync.Cond
+}
+
+func newBatchScheduler(result *BatchResult) *batchScheduler {
+	s := &batchScheduler{
+		result: result,
+		names:  map[string][]int{},
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	return s
+}
+
+// next waits for an entry free to start, false once all are done
+func (s *batchScheduler) next() (int, *BatchEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.ready) == 0 && s.remaining > 0 {
+		s.cond.Wait()
+	}
+	if len(s.ready) == 0 {
+		return 0, nil, false
+	}
+
+	index := s.ready[0]
+	s.ready = s.ready[1:]
+	return index, s.entries[index], true
+}
+
+// queue frees the entry to start
+func (s *batchScheduler) queue(index int) {
+	priority := s.entries[index].Priority
+	i := len(s.ready)
+	for i > 0 && (s.entries[s.ready[i-1]].Priority < priority || (s.entries[s.ready[i-1]].Priority == priority && s.ready[i-1] > index)) {
+		i--
+	}
+	s.ready = append(s.ready, 0)
+	copy(s.ready[i+1:], s.ready[i:])
+	s.ready[i] = index
+
+	s.cond.Signal()
+}
+
+// add adds the entries to the batch, queuing the ones without dependencies, failing the ones with an unknown
+// or failed dependency, or in a cycle. The entries already in the batch never depend on the added ones.
+func (s *batchScheduler) add(entries []*BatchEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	first := len(s.entries)
+	s.entries = append(s.entries, entries...)
+	s.result.Entries = append(s.result.Entries, make([]*BatchEntryResult, len(entries))...)
+	s.dependents = append(s.dependents, make([][]int, len(entries))...)
+	s.waiting = append(s.waiting, make([]int, len(entries))...)
+	s.remaining += len(entries)
+
+	for i, entry := range entries {
+		if entry.ID != "" {
+			s.names[entry.ID] = append(s.names[entry.ID], first+i)
+		}
+		if entry.Group != "" && entry.Group != entry.ID {
+			s.names[entry.Group] = append(s.names[entry.Group], first+i)
+		}
+	}
+
+	invalid := map[int]error{}
+	for i := first; i < len(s.entries); i++ {
+		deps := map[int]bool{}
+	names:
+		for _, name := range s.entries[i].DependsOn {
+			indexes, ok := s.names[name]
+			if !ok {
+				invalid[i] = fmt.Errorf("unknown dependency: %s", name)
+				break
+			}
+			for _, dep := range indexes {
+				if dep == i || deps[dep] {
+					continue
+				}
+				deps[dep] = true
+
+				// an entry already done is waited for no more
+				if result := s.result.Entries[dep]; result != nil {
+					if result.Err != nil {
+						invalid[i] = fmt.Errorf("%w: %s", ErrDependencyFailed, name)
+						break names
+					}
+					continue
+				}
+				s.dependents[dep] = append(s.dependents[dep], i)
+				s.waiting[i]++
+			}
+		}
+	}
+
+	// the added entries never freed of their dependencies are in a cycle
+	waiting := make([]int, len(s.entries))
+	for i := first; i < len(s.entries); i++ {
+		for _, dependent := range s.dependents[i] {
+			waiting[dependent]++
+		}
+	}
+	var queue []int
+	for i := first; i < len(s.entries); i++ {
+		if waiting[i] == 0 {
+			queue = append(queue, i)
+		}
+	}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		for _, dependent := range s.dependents[i] {
+			if waiting[dependent]--; waiting[dependent] == 0 {
+				queue = append(queue, dependent)
+			}
+		}
+	}
+	for i := first; i < len(s.entries); i++ {
+		if waiting[i] > 0 && invalid[i] == nil {
+			invalid[i] = errors.New("dependency cycle")
+		}
+	}
+
+	for i, err := range invalid {
+		s.record(i, &BatchEntryResult{Entry: s.entries[i], Err: err})
+	}
+	for i := first; i < len(s.entries); i++ {
+		if invalid[i] != nil {
+			s.release(i)
+		}
+	}
+	for i := first; i < len(s.entries); i++ {
+		if s.waiting[i] == 0 && invalid[i] == nil && s.result.Entries[i] == nil {
+			s.queue(i)
+		}
+	}
+}
+
+// done records the result of the entry, queuing the entries it freed, or failing them,
+// after adding the entries of the manifest it downloaded, which may depend on it
+func (s *batchScheduler) done(index int, result *BatchEntryResult, added []*BatchEntry) {
+	if len(added) > 0 {
+		s.add(added)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.complete(index, result)
+}
+
+func (s *batchScheduler) complete(index int, result *BatchEntryResult) {
+	if s.record(index, result) {
+		s.release(index)
+	}
+}
+
+// record records the result of the entry, false if it has one already
+func (s *batchScheduler) record(index int, result *BatchEntryResult) bool {
+	if s.result.Entries[index] != nil {
+		return false
+	}
+	s.result.Entries[index] = result
+	s.remaining--
+
+	// the workers waiting for an entry are done
+	if s.remaining == 0 {
+		s.cond.Broadcast()
+	}
+
+	return true
+}
+
+// release queues the dependents of the entry once it succeeded, or fails them
+func (s *batchScheduler) release(index int) {
+	result := s.result.Entries[index]
+	for _, dependent := range s.dependents[index] {
+		if result.Err != nil {
+			name := s.entries[index].ID
+			if name == "" {
+				name = s.entries[index].URL
+			}
+			s.fail(dependent, fmt.Errorf("%w: %s", ErrDependencyFailed, name))
+			continue
+		}
+
+		if s.waiting[dependent]--; s.waiting[dependent] == 0 && s.result.Entries[dependent] == nil {
+			s.queue(dependent)
+		}
+	}
+}
+
+// fail fails the entry without downloading it, and its dependents
+func (s *batchScheduler) fail(index int, err error) {
+	s.complete(index, &BatchEntryResult{Entry: s.entries[index], Err: err})
+}
+
+func downloadBatchEntry(ctx context.Context, entry *BatchEntry, cfg *Config) *BatchEntryResult {
+	config := *cfg
+	if entry.Output != "" {
+		output, err := batchOutputPath(cfg.Dir, entry.Output)
+		if err != nil {
+			return &BatchEntryResult{Entry: entry, Err: err}
+		}
+		config.FilePath = output
+	}
+	if entry.Checksum != "" {
+		config.ExpectedChecksum = entry.Checksum
+	}
+	if entry.Priority != 0 {
+		config.Priority = entry.Priority
+	}
+
+	result := &BatchEntryResult{
+		Entry: entry,
+	}
+	if ctx.Err() != nil {
+		result.Err = ctx.Err()
+		return result
+	}
+
+	d := New(entry.URL, &config)
+	startedAt := time.Now()
+	err := safeRun(func() error {
+		return d.DownloadWithContext(ctx)
+	})
+	result.Elapsed = time.Since(startedAt)
+	if err != nil {
+		result.Err = err
+		result.Skipped = errors.Is(err, ErrRobotsDisallowed)
+		return result
+	}
+
+	// where the file landed, ContentStore or the location of a sink included
+	result.FilePath = d.FilePath
+	if r := d.Result(); r != nil {
+		result.Size = r.Size
+	}
+
+	return result
+}
+
+// readBatchManifestEntry returns the entries of the manifest downloaded by entry at path,
+// their relative urls resolved against the url of the entry
+func readBatchManifestEntry(entry *BatchEntry, path string) ([]*BatchEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := ParseBatchManifest(f, batchFormatOf(entry.URL))
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := url.Parse(entry.URL)
+	if err != nil {
+		return nil, err
+	}
+	for _, added := range entries {
+		u, err := url.Parse(added.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid batch manifest: %s", err)
+		}
+		added.URL = base.ResolveReference(u).String()
+	}
+
+	return entries, nil
+}
+
+// batchOutputPath returns the path of the output of an entry in dir, the current directory when empty,
+// the manifest may be remote, so an absolute output or one leaving dir is rejected.
+func batchOutputPath(dir, output string) (string, error) {
+	if filepath.IsAbs(output) || filepath.VolumeName(output) != "" || strings.HasPrefix(filepath.ToSlash(output), "/") {
+		return "", fmt.Errorf("%w: absolute path: %s", ErrUnsafeOutput, output)
+	}
+
+	clean := filepath.Clean(output)
+	if clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: outside the directory: %s", ErrUnsafeOutput, output)
+	}
+
+	if dir == "" {
+		return clean, nil
+	}
+
+	return filepath.Join(dir, clean), nil
+}
@@ -0,0 +1,289 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDownloadBatchManifest(t *testing.T) {
+	files := map[string][]byte{
+		"/a.mp4": bytes.Repeat([]byte("a"), 100),
+		"/b.mp4": bytes.Repeat([]byte("b"), 200),
+	}
+	sum := sha256.Sum256(files["/b.mp4"])
+
+	manifests := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if manifest, ok := manifests[r.URL.Path]; ok {
+			w.Write([]byte(manifest))
+			return
+		}
+
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	manifests["/list.txt"] = "# files\n" + server.URL + "/a.mp4\n\n" + server.URL + "/b.mp4\n" + server.URL + "/missing.mp4\n"
+	manifests["/list.csv"] = "url,checksum,output\n" +
+		server.URL + "/a.mp4,,one.mp4\n" +
+		server.URL + "/b.mp4,sha256:" + hex.EncodeToString(sum[:]) + ",two.mp4\n" +
+		server.URL + "/missing.mp4,,three.mp4\n"
+	manifests["/list"] = `["` + server.URL + `/a.mp4", {"url": "` + server.URL + `/b.mp4", "output": "two.mp4", "checksum": "sha256:` + hex.EncodeToString(sum[:]) + `"}, "` + server.URL + `/missing.mp4"]`
+
+	for _, name := range []string{"/list.txt", "/list.csv", "/list"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			result, err := DownloadBatchManifest(context.Background(), server.URL+name, &Config{
+				Dir:    dir,
+				TmpDir: t.TempDir(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.Succeeded != 2 || result.Failed != 1 || result.Err() == nil {
+				t.Fatalf("expected 2 succeeded and 1 failed, got:\n%s", result.Summary())
+			}
+			if result.Entries[2].Err == nil || !strings.Contains(result.Summary(), "FAIL "+server.URL+"/missing.mp4") {
+				t.Errorf("expected the missing file reported, got:\n%s", result.Summary())
+			}
+
+			for i, path := range []string{"/a.mp4", "/b.mp4"} {
+				e := result.Entries[i]
+				if filepath.Dir(e.FilePath) != dir || e.Size != int64(len(files[path])) {
+					t.Errorf("unexpected result of %s: %+v", path, e)
+				}
+
+				data, err := os.ReadFile(e.FilePath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(data, files[path]) {
+					t.Errorf("unexpected content of %s", e.FilePath)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadBatchUnsafeOutput(t *testing.T) {
+	server := newRangeServer(bytes.Repeat([]byte("a"), 100))
+	defer server.Close()
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "batch")
+	outputs := []string{"../escaped.mp4", "sub/../../escaped.mp4", filepath.Join(root, "absolute.mp4"), "sub/../kept.mp4"}
+	var entries []*BatchEntry
+	for _, output := range outputs {
+		entries = append(entries, &BatchEntry{URL: server.URL + "/file.mp4", Output: output})
+	}
+
+	result := DownloadBatch(context.Background(), entries, &Config{Dir: dir, TmpDir: t.TempDir()})
+	for i, e := range result.Entries[:3] {
+		if !errors.Is(e.Err, ErrUnsafeOutput) {
+			t.Errorf("expected %s rejected, got %v", outputs[i], e.Err)
+		}
+	}
+	if e := result.Entries[3]; e.Err != nil || e.FilePath != filepath.Join(dir, "kept.mp4") {
+		t.Errorf("expected the output in the directory kept, got %+v", e)
+	}
+
+	files, _ := os.ReadDir(root)
+	if len(files) != 1 {
+		t.Errorf("expected nothing written outside the directory, got %d files", len(files))
+	}
+}
+
+func TestParseBatchManifest(t *testing.T) {
+	cases := []struct {
+		name     string
+		format   string
+		manifest string
+		expected []BatchEntry
+	}{
+		{"text", "", "https://a/x.zip\n  # comment\nhttps://b/y,z.zip\n", []BatchEntry{{URL: "https://a/x.zip"}, {URL: "https://b/y,z.zip"}}},
+		{"csv header", "", "checksum,url\nmd5:00,https://a/x.zip\n", []BatchEntry{{URL: "https://a/x.zip", Checksum: "md5:00"}}},
+		{"csv positional", BatchFormatCSV, "https://a/x.zip,out.zip,md5:00\nhttps://b/y.zip\n", []BatchEntry{{URL: "https://a/x.zip", Output: "out.zip", Checksum: "md5:00"}, {URL: "https://b/y.zip"}}},
+		{"json", "", `[{"url": "https://a/x.zip", "output": "out.zip"}, "https://b/y.zip"]`, []BatchEntry{{URL: "https://a/x.zip", Output: "out.zip"}, {URL: "https://b/y.zip"}}},
+		{"json dependencies", "", `[{"url": "https://a/x.sha256", "group": "checksums"}, {"url": "https://a/x.zip", "id": "x", "depends_on": ["checksums"]}]`, []BatchEntry{{URL: "https://a/x.sha256", Group: "checksums"}, {URL: "https://a/x.zip", ID: "x", DependsOn: []string{"checksums"}}}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			entries, err := ParseBatchManifest(strings.NewReader(c.manifest), c.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(c.expected) {
+				t.Fatalf("expected %d entries, got %d", len(c.expected), len(entries))
+			}
+			for i, e := range entries {
+				if !reflect.DeepEqual(*e, c.expected[i]) {
+					t.Errorf("entry %d: expected %+v, got %+v", i, c.expected[i], *e)
+				}
+			}
+		})
+	}
+
+	if _, err := ParseBatchManifest(strings.NewReader(`[{"output": "out.zip"}]`), ""); err == nil {
+		t.Error("expected an error for an entry without url")
+	}
+}
+
+func TestDownloadBatchDependencies(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	var mu sync.Mutex
+	var started []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.zip" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodHead {
+			mu.Lock()
+			started = append(started, r.URL.Path)
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "file.zip", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	result := DownloadBatch(context.Background(), []*BatchEntry{
+		{URL: server.URL + "/artifact.zip", DependsOn: []string{"checksums"}},
+		{URL: server.URL + "/a.sha256", Group: "checksums"},
+		{URL: server.URL + "/b.sha256", Group: "checksums"},
+		{URL: server.URL + "/missing.zip", ID: "missing"},
+		{URL: server.URL + "/orphan.zip", DependsOn: []string{"missing"}},
+		{URL: server.URL + "/p.zip", ID: "p", DependsOn: []string{"q"}},
+		{URL: server.URL + "/q.zip", ID: "q", DependsOn: []string{"p"}},
+		{URL: server.URL + "/unknown.zip", DependsOn: []string{"nothing"}},
+	}, &Config{
+		Dir:         t.TempDir(),
+		TmpDir:      t.TempDir(),
+		RetryPolicy: &RetryPolicy{MaxAttempts: 1},
+	})
+
+	if result.Succeeded != 3 || result.Failed != 5 {
+		t.Fatalf("expected 3 downloads and 5 failures, got %s", result.Summary())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(started) != 3 || started[2] != "/artifact.zip" {
+		t.Errorf("expected the artifact to start after the checksums, got %v", started)
+	}
+
+	if err := result.Entries[4].Err; !errors.Is(err, ErrDependencyFailed) || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("expected the orphan to fail with its dependency, got %v", err)
+	}
+	for _, i := range []int{5, 6} {
+		if err := result.Entries[i].Err; err == nil || err.Error() != "dependency cycle" {
+			t.Errorf("expected entry %d to fail with the cycle, got %v", i, err)
+		}
+	}
+	if err := result.Entries[7].Err; err == nil || err.Error() != "unknown dependency: nothing" {
+		t.Errorf("expected an unknown dependency, got %v", err)
+	}
+}
+
+func TestDownloadBatchManifestEntry(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	manifest := `[{"url": "a.bin", "group": "files"}, {"url": "/files/b.bin", "depends_on": ["files", "list"]},
+		{"url": "c.bin", "id": "c", "depends_on": ["d"]}, {"url": "d.bin", "id": "d", "depends_on": ["c"]}]`
+	var mu sync.Mutex
+	var started []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			mu.Lock()
+			started = append(started, r.URL.Path)
+			mu.Unlock()
+		}
+		if r.URL.Path == "/files/list.json" {
+			http.ServeContent(w, r, "list.json", time.Time{}, strings.NewReader(manifest))
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// the files listed by the manifest are added to the batch once it is downloaded
+	result := DownloadBatch(context.Background(), []*BatchEntry{
+		{URL: server.URL + "/files/list.json", ID: "list", Manifest: true},
+		{URL: server.URL + "/after.bin", DependsOn: []string{"list"}},
+	}, &Config{
+		Dir:    t.TempDir(),
+		TmpDir: t.TempDir(),
+	})
+
+	if len(result.Entries) != 6 || result.Succeeded != 4 || result.Failed != 2 {
+		t.Fatalf("expected the 4 entries of the manifest added, got %s", result.Summary())
+	}
+	for i, url := range []string{"/files/a.bin", "/files/b.bin", "/files/c.bin", "/files/d.bin"} {
+		if entry := result.Entries[i+2].Entry; entry.URL != server.URL+url {
+			t.Errorf("expected %s resolved against the manifest, got %s", url, entry.URL)
+		}
+	}
+	for _, i := range []int{4, 5} {
+		if err := result.Entries[i].Err; err == nil || err.Error() != "dependency cycle" {
+			t.Errorf("expected entry %d to fail with the cycle, got %v", i, err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(started) != 4 || started[0] != "/files/list.json" || started[3] != "/files/b.bin" {
+		t.Errorf("expected the files started after the manifest, b after a, got %v", started)
+	}
+}
+
+func TestDownloadBatchContentStore(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/list.txt" {
+			http.ServeContent(w, r, "list.txt", time.Time{}, strings.NewReader("file.bin\n"))
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// the manifest and the file it lists are moved to the store
+	store := t.TempDir()
+	result := DownloadBatch(context.Background(), []*BatchEntry{
+		{URL: server.URL + "/list.txt", Manifest: true},
+	}, &Config{
+		Dir:          t.TempDir(),
+		TmpDir:       t.TempDir(),
+		ContentStore: store,
+	})
+	if result.Succeeded != 2 {
+		t.Fatalf("expected the manifest and its file, got %s", result.Summary())
+	}
+
+	e := result.Entries[1]
+	if !strings.HasPrefix(e.FilePath, store) || e.Size != int64(len(content)) {
+		t.Errorf("expected the file in the store, got %+v", e)
+	}
+	if data, err := os.ReadFile(e.FilePath); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("unexpected file in the store: %v", err)
+	}
+}
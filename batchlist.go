@@ -0,0 +1,232 @@
+package download
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Batch manifest formats, see ParseBatchManifest
+const (
+	// BatchFormatText is one url per line, blank lines and lines starting with # are ignored
+	BatchFormatText = "text"
+	// BatchFormatCSV has url, output and checksum columns, named by a header row or in this order
+	BatchFormatCSV = "csv"
+	// BatchFormatJSON is an array of urls or of {"url", "output", "checksum", "id", "group", "depends_on", "manifest"} objects
+	BatchFormatJSON = "json"
+)
+
+// ParseBatchManifest parses the entries of a batch manifest, an empty format is detected from the content
+func ParseBatchManifest(r io.Reader, format string) ([]*BatchEntry, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == "" {
+		format = detectBatchFormat(data)
+	}
+
+	switch format {
+	case BatchFormatText:
+		return parseBatchText(data)
+	case BatchFormatCSV:
+		return parseBatchCSV(data)
+	case BatchFormatJSON:
+		return parseBatchJSON(data)
+	default:
+		return nil, fmt.Errorf("unsupported batch manifest format: %s", format)
+	}
+}
+
+// detectBatchFormat guesses the format of a manifest, json starts with [ and csv with a header naming the url column,
+// a csv without header is only detected by its extension, urls may contain commas.
+func detectBatchFormat(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		return BatchFormatJSON
+	}
+
+	firstLine := trimmed
+	if i := bytes.IndexByte(trimmed, '\n'); i >= 0 {
+		firstLine = trimmed[:i]
+	}
+	if record, err := csv.NewReader(bytes.NewReader(firstLine)).Read(); err == nil && len(record) > 1 && isBatchCSVHeader(record) {
+		return BatchFormatCSV
+	}
+
+	return BatchFormatText
+}
+
+// batchFormatOf returns the format of a manifest by its extension, empty if unknown
+func batchFormatOf(location string) string {
+	if u, err := url.Parse(location); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		location = u.Path
+	}
+
+	switch strings.ToLower(path.Ext(location)) {
+	case ".csv":
+		return BatchFormatCSV
+	case ".json":
+		return BatchFormatJSON
+	case ".txt":
+		return BatchFormatText
+	}
+
+	return ""
+}
+
+func parseBatchText(data []byte) ([]*BatchEntry, error) {
+	entries := []*BatchEntry{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entries = append(entries, &BatchEntry{URL: line})
+	}
+
+	return entries, scanner.Err()
+}
+
+func parseBatchCSV(data []byte) ([]*BatchEntry, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid batch manifest: %s", err)
+	}
+
+	columns := map[string]int{"url": 0, "output": 1, "checksum": 2}
+	if len(records) > 0 && isBatchCSVHeader(records[0]) {
+		columns = map[string]int{}
+		for i, name := range records[0] {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		records = records[1:]
+
+		if _, ok := columns["url"]; !ok {
+			return nil, fmt.Errorf("invalid batch manifest: no url column")
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+
+		return strings.TrimSpace(record[i])
+	}
+
+	entries := []*BatchEntry{}
+	for i, record := range records {
+		entry := &BatchEntry{
+			URL:      field(record, "url"),
+			Output:   field(record, "output"),
+			Checksum: field(record, "checksum"),
+		}
+		if entry.URL == "" {
+			return nil, fmt.Errorf("invalid batch manifest: no url on row %d", i+1)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// isBatchCSVHeader returns true if the row names the columns, one of them being url
+func isBatchCSVHeader(record []string) bool {
+	for _, name := range record {
+		if strings.EqualFold(strings.TrimSpace(name), "url") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseBatchJSON(data []byte) ([]*BatchEntry, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid batch manifest: %s", err)
+	}
+
+	entries := []*BatchEntry{}
+	for i, item := range raw {
+		entry := &BatchEntry{}
+		if err := json.Unmarshal(item, &entry.URL); err != nil {
+			if err := json.Unmarshal(item, entry); err != nil {
+				return nil, fmt.Errorf("invalid batch manifest: entry %d: %s", i, err)
+			}
+		}
+		if entry.URL == "" {
+			return nil, fmt.Errorf("invalid batch manifest: no url in entry %d", i)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// LoadBatchManifest reads the batch manifest at location, a local file or a http(s) url,
+// downloaded with the connection options of cfg, the format is detected from the extension or the content.
+func LoadBatchManifest(ctx context.Context, location string, cfg ...*Config) ([]*BatchEntry, error) {
+	configX := &Config{}
+	if len(cfg) > 0 {
+		configX = cfg[0]
+	}
+
+	format := batchFormatOf(location)
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		data, err := ioutil.ReadFile(location)
+		if err != nil {
+			return nil, err
+		}
+
+		return ParseBatchManifest(bytes.NewReader(data), format)
+	}
+
+	d := New(location, &Config{
+		ConnectTo:  configX.ConnectTo,
+		Host:       configX.Host,
+		ServerName: configX.ServerName,
+		LocalAddr:  configX.LocalAddr,
+		Interface:  configX.Interface,
+		Proxy:      configX.Proxy,
+		ProxyChain: configX.ProxyChain,
+		Timeouts:   configX.Timeouts,
+		Transport:  configX.Transport,
+		Client:     configX.Client,
+	})
+	var buf bytes.Buffer
+	if err := d.DownloadToWithContext(ctx, &buf); err != nil {
+		return nil, err
+	}
+
+	return ParseBatchManifest(&buf, format)
+}
+
+// DownloadBatchManifest downloads every entry of the batch manifest at location, see LoadBatchManifest and DownloadBatch
+func DownloadBatchManifest(ctx context.Context, location string, cfg ...*Config) (*BatchResult, error) {
+	entries, err := LoadBatchManifest(ctx, location, cfg...)
+	if err != nil {
+		return nil, err
+	}
+
+	return DownloadBatch(ctx, entries, cfg...), nil
+}
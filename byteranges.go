@@ -0,0 +1,230 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-zoox/fs"
+)
+
+// errMultiRangeUnsupported means the server answered a multi-range request with the whole file
+var errMultiRangeUnsupported = errors.New("multi-range requests are not supported")
+
+// parseContentRange parses a Content-Range header, e.g. bytes 0-10485759/35519965,
+// total is -1 when unknown (*).
+func parseContentRange(raw string) (start, end, total int64, err error) {
+	invalid := fmt.Errorf("invalid content range: %s", raw)
+
+	if !strings.HasPrefix(raw, "bytes ") {
+		return 0, 0, 0, invalid
+	}
+	parts := strings.Split(strings.TrimPrefix(raw, "bytes "), "/")
+	if len(parts) != 2 {
+		return 0, 0, 0, invalid
+	}
+
+	bounds := strings.Split(parts[0], "-")
+	if len(bounds) != 2 {
+		return 0, 0, 0, invalid
+	}
+	if start, err = strconv.ParseInt(bounds[0], 10, 64); err != nil {
+		return 0, 0, 0, invalid
+	}
+	if end, err = strconv.ParseInt(bounds[1], 10, 64); err != nil || end < start {
+		return 0, 0, 0, invalid
+	}
+
+	total = -1
+	if parts[1] != "*" {
+		if total, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return 0, 0, 0, invalid
+		}
+	}
+
+	return start, end, total, nil
+}
+
+// batchFileParts groups the parts by RangeBatch, each group is requested at once
+func (d *Downloader) batchFileParts() [][]*FilePart {
+	size := d.RangeBatch
+	if size < 1 {
+		size = 1
+	}
+
+	batches := [][]*FilePart{}
+	for i := 0; i < len(d.FileParts); i += size {
+		end := i + size
+		if end > len(d.FileParts) {
+			end = len(d.FileParts)
+		}
+
+		batches = append(batches, d.FileParts[i:end])
+	}
+
+	return d.orderBatches(batches)
+}
+
+// downloadFilePartBatch downloads the parts with one multi-range request with ctx,
+// it returns the parts left, downloaded one by one by the caller.
+func (d *Downloader) downloadFilePartBatch(ctx context.Context, parts []*FilePart) []*FilePart {
+	pending := []*FilePart{}
+	for _, part := range parts {
+		if !d.isFilePartDone(part) {
+			pending = append(pending, part)
+		}
+	}
+	// consensus cross-checks each part on its own
+	if len(pending) < 2 || atomic.LoadInt32(&d.isMultiRangeUnsupported) == 1 || d.isConsensusEnabled() {
+		return pending
+	}
+
+	src, url := d.sources.pick(nil)
+	if src == nil {
+		return pending
+	}
+
+	startedAt := time.Now()
+	err := safeRun(func() error {
+		return d.downloadFilePartBatchFrom(ctx, url, pending)
+	})
+	if errors.Is(err, errMultiRangeUnsupported) {
+		// not the fault of the source, stop batching and go on part by part
+		atomic.StoreInt32(&d.isMultiRangeUnsupported, 1)
+		err = nil
+	}
+
+	left := []*FilePart{}
+	var bytes int64
+	for _, part := range pending {
+		if d.isFilePartDone(part) {
+			bytes += part.RangeEnd - part.RangeStart + 1
+		} else {
+			left = append(left, part)
+		}
+	}
+	d.sources.done(src, bytes, time.Since(startedAt), err)
+
+	if err != nil && os.Getenv("DEBUG") == "true" {
+		fmt.Println("failed to download parts in batch from", url, err)
+	}
+
+	return left
+}
+
+func (d *Downloader) downloadFilePartBatchFrom(ctx context.Context, url string, parts []*FilePart) error {
+	dirPath := filepath.Dir(parts[0].Path)
+	if !fs.IsExist(dirPath) {
+		if err := fs.Mkdirp(dirPath); err != nil {
+			return err
+		}
+	}
+
+	ranges := make([]string, 0, len(parts))
+	var size int64
+	for _, part := range parts {
+		ranges = append(ranges, fmt.Sprintf("%d-%d", part.RangeStart, part.RangeEnd))
+		size += part.RangeEnd - part.RangeStart + 1
+	}
+
+	headers := map[string]string{
+		"Range": "bytes=" + strings.Join(ranges, ","),
+	}
+	if validator := d.validator(); validator != "" {
+		headers["If-Range"] = validator
+	}
+
+	return d.do(http.MethodGet, url, &requestConfig{
+		Headers: headers,
+		Timeout: d.segmentTimeout(size),
+		Context: ctx,
+	}, func(resp *http.Response, body io.Reader) error {
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+		case http.StatusOK:
+			return errMultiRangeUnsupported
+		default:
+			return newStatusError(resp.StatusCode)
+		}
+
+		mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" {
+			// a single range, the server coalesced adjacent ranges
+			return d.writeCoalescedRange(resp.Header.Get("Content-Range"), body, parts)
+		}
+
+		byRange := map[string]*FilePart{}
+		for _, part := range parts {
+			byRange[fmt.Sprintf("%d-%d", part.RangeStart, part.RangeEnd)] = part
+		}
+
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := reader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return newProtocolError(err)
+			}
+
+			start, end, _, err := parseContentRange(p.Header.Get("Content-Range"))
+			if err != nil {
+				return newProtocolError(err)
+			}
+
+			// ranges not asked for are skipped, the parts missing are downloaded one by one
+			part, ok := byRange[fmt.Sprintf("%d-%d", start, end)]
+			if !ok {
+				continue
+			}
+
+			if err := d.writeFile(part.Path, p); err != nil {
+				return classify(err)
+			}
+		}
+	})
+}
+
+// writeCoalescedRange splits a single range body covering several parts into the parts
+func (d *Downloader) writeCoalescedRange(contentRange string, body io.Reader, parts []*FilePart) error {
+	start, end, _, err := parseContentRange(contentRange)
+	if err != nil {
+		return newProtocolError(err)
+	}
+
+	sorted := append([]*FilePart{}, parts...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].RangeStart < sorted[j].RangeStart
+	})
+
+	offset := start
+	for _, part := range sorted {
+		if part.RangeStart < offset || part.RangeEnd > end {
+			continue
+		}
+
+		if _, err := io.CopyN(ioutil.Discard, body, part.RangeStart-offset); err != nil {
+			return classify(err)
+		}
+
+		if err := d.writeFile(part.Path, io.LimitReader(body, part.RangeEnd-part.RangeStart+1)); err != nil {
+			return classify(err)
+		}
+		offset = part.RangeEnd + 1
+	}
+
+	return nil
+}
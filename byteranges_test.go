@@ -0,0 +1,119 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseContentRange(t *testing.T) {
+	cases := []struct {
+		raw               string
+		start, end, total int64
+		isInvalid         bool
+	}{
+		{raw: "bytes 0-10485759/35519965", start: 0, end: 10485759, total: 35519965},
+		{raw: "bytes 10-19/*", start: 10, end: 19, total: -1},
+		{raw: "bytes 19-10/100", isInvalid: true},
+		{raw: "bytes */100", isInvalid: true},
+		{raw: "items 0-1/2", isInvalid: true},
+	}
+
+	for _, c := range cases {
+		start, end, total, err := parseContentRange(c.raw)
+		if c.isInvalid {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.raw)
+			}
+			continue
+		}
+
+		if err != nil || start != c.start || end != c.end || total != c.total {
+			t.Errorf("%s: got %d-%d/%d (%v)", c.raw, start, end, total, err)
+		}
+	}
+}
+
+func TestRangeBatch(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+
+	var single, multi int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Range"), ",") {
+			atomic.AddInt32(&multi, 1)
+		} else if r.Header.Get("Range") != "" {
+			atomic.AddInt32(&single, 1)
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	filePath := t.TempDir() + "/batch.mp4"
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:    filePath,
+		TmpDir:      t.TempDir(),
+		SegmentSize: 10,
+		RangeBatch:  4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("expected %q, got %q", content, data)
+	}
+	// 10 parts in batches of 4, 4 and 2
+	if multi != 3 || single != 0 {
+		t.Errorf("expected 3 multi-range requests only, got %d multi and %d single", multi, single)
+	}
+}
+
+func TestRangeBatchCoalesced(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges := strings.Split(strings.TrimPrefix(r.Header.Get("Range"), "bytes="), ",")
+		if len(ranges) < 2 {
+			http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+			return
+		}
+
+		// adjacent ranges coalesced into a single one
+		var start, end int
+		fmt.Sscanf(ranges[0], "%d-", &start)
+		fmt.Sscanf(ranges[len(ranges)-1], "%d-%d", new(int), &end)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content[start : end+1])
+	}))
+	defer server.Close()
+
+	filePath := t.TempDir() + "/coalesced.mp4"
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:    filePath,
+		TmpDir:      t.TempDir(),
+		SegmentSize: 10,
+		RangeBatch:  5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("expected %q, got %q", content, data)
+	}
+}
@@ -0,0 +1,359 @@
+package download
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-zoox/crypto/md5"
+)
+
+// The cache status of a download, see Downloader.CacheStatus
+const (
+	// CacheMiss means the file was not cached, or changed, and was downloaded
+	CacheMiss = "miss"
+	// CacheHit means the cached file was fresh, no request was sent
+	CacheHit = "hit"
+	// CacheRevalidated means the server answered 304 Not Modified, the cached file was used
+	CacheRevalidated = "revalidated"
+)
+
+// Cache stores the downloaded files and their response headers, see Config.Cache
+type Cache interface {
+	// Get returns the entry of key, nil if there is none
+	Get(key string) (*CacheEntry, error)
+	// Open returns the body of the entry of key
+	Open(key string) (io.ReadCloser, error)
+	// Put stores the entry of key, a nil body keeps the stored body (after a revalidation)
+	Put(key string, entry *CacheEntry, body io.Reader) error
+}
+
+// CacheEntry represents a cached response
+type CacheEntry struct {
+	URL string
+	// Headers are the response headers, the validators and the freshness headers among them
+	Headers http.Header
+	// Size is the size of the body
+	Size int64
+	// StoredAt is when the response was received or revalidated
+	StoredAt time.Time
+}
+
+// isFresh returns true if the entry can be used without asking the server
+func (e *CacheEntry) isFresh(now time.Time) bool {
+	return e.age(now) < e.freshnessLifetime()
+}
+
+// freshnessLifetime is how long the response stays fresh, from max-age, Expires
+// or 10% of the time since its Last-Modified date, as a heuristic.
+func (e *CacheEntry) freshnessLifetime() time.Duration {
+	directives := parseCacheControl(e.Headers.Get("Cache-Control"))
+	if _, ok := directives["no-cache"]; ok {
+		return 0
+	}
+
+	if maxAge, ok := directives["max-age"]; ok {
+		seconds, err := strconv.Atoi(maxAge)
+		if err != nil {
+			return 0
+		}
+
+		return time.Duration(seconds) * time.Second
+	}
+
+	date := e.StoredAt
+	if t, err := http.ParseTime(e.Headers.Get("Date")); err == nil {
+		date = t
+	}
+
+	if expires := e.Headers.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			// invalid dates, such as 0, mean already expired
+			return 0
+		}
+
+		return t.Sub(date)
+	}
+
+	if t, err := http.ParseTime(e.Headers.Get("Last-Modified")); err == nil && t.Before(date) {
+		return date.Sub(t) / 10
+	}
+
+	return 0
+}
+
+// age is the time since the response was generated by the server
+func (e *CacheEntry) age(now time.Time) time.Duration {
+	age := now.Sub(e.StoredAt)
+	if seconds, err := strconv.Atoi(e.Headers.Get("Age")); err == nil && seconds > 0 {
+		age += time.Duration(seconds) * time.Second
+	}
+
+	return age
+}
+
+// parseCacheControl returns the directives of a Cache-Control header, lower cased, with their value if any
+func parseCacheControl(header string) map[string]string {
+	directives := map[string]string{}
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.TrimSpace(directive)
+		if directive == "" {
+			continue
+		}
+
+		name, value := directive, ""
+		if i := strings.IndexByte(directive, '='); i >= 0 {
+			name, value = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+		}
+
+		directives[strings.ToLower(strings.TrimSpace(name))] = value
+	}
+
+	return directives
+}
+
+// isCacheable returns true if the response can be stored
+func isCacheable(headers http.Header) bool {
+	if _, ok := parseCacheControl(headers.Get("Cache-Control"))["no-store"]; ok {
+		return false
+	}
+
+	return true
+}
+
+// downloadFromCache uses the cached file of the url when it is fresh, or revalidated by the server with a 304,
+// a changed file is downloaded by the revalidation request itself. It returns false to download the file.
+func (d *Downloader) downloadFromCache() (bool, error) {
+	if d.Cache == nil || d.Range != nil {
+		return false, nil
+	}
+
+	d.CacheStatus = CacheMiss
+	entry, err := d.Cache.Get(d.URL)
+	if err != nil || entry == nil {
+		return false, err
+	}
+
+	if entry.isFresh(time.Now()) {
+		if os.Getenv("DEBUG") == "true" {
+			fmt.Println("cache: fresh:", d.URL)
+		}
+
+		d.CacheStatus = CacheHit
+		return true, d.restoreFromCache(entry)
+	}
+
+	config := &requestConfig{
+		Headers: map[string]string{},
+	}
+	if etag := entry.Headers.Get("ETag"); etag != "" {
+		config.Headers["If-None-Match"] = etag
+	}
+	if lastModified := entry.Headers.Get("Last-Modified"); lastModified != "" {
+		config.Headers["If-Modified-Since"] = lastModified
+	}
+	if len(config.Headers) == 0 {
+		// nothing to revalidate with
+		return false, nil
+	}
+
+	isNotModified := false
+	err = d.do(http.MethodGet, d.URL, config, func(resp *http.Response, body io.Reader) error {
+		if resp.StatusCode == http.StatusNotModified {
+			isNotModified = true
+			// the headers of the 304 replace the stored ones
+			for k, v := range resp.Header {
+				entry.Headers[k] = v
+			}
+			entry.StoredAt = time.Now()
+			return nil
+		}
+
+		// changed, the response is the new file
+		if err := d.prepareDirect(); err != nil {
+			return err
+		}
+
+		if err := d.checkDirectResponse(resp); err != nil {
+			return err
+		}
+
+		return d.writeFile(d.stagingPath(), body)
+	})
+	if err != nil {
+		return true, classify(err)
+	}
+
+	if !isNotModified {
+		return true, d.finalize()
+	}
+
+	if os.Getenv("DEBUG") == "true" {
+		fmt.Println("cache: revalidated:", d.URL)
+	}
+
+	d.CacheStatus = CacheRevalidated
+	if err := d.Cache.Put(d.URL, entry, nil); err != nil {
+		return true, err
+	}
+
+	return true, d.restoreFromCache(entry)
+}
+
+// restoreFromCache writes the cached file to the file path, verified as a downloaded one
+func (d *Downloader) restoreFromCache(entry *CacheEntry) error {
+	d.HeadHeaders = entry.Headers
+	d.ContentLength = entry.Size
+	// named as the download would, direct downloads keep the url name
+	if !d.IsRangesDisabled {
+		if err := d.parseContentInfo(); err != nil {
+			return err
+		}
+		if err := d.parseFileInfo(); err != nil {
+			return err
+		}
+	}
+
+	if err := d.prepareDirect(); err != nil {
+		return err
+	}
+
+	body, err := d.Cache.Open(d.URL)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if err := d.writeFile(d.stagingPath(), body); err != nil {
+		return err
+	}
+
+	return d.finalize()
+}
+
+// storeCache stores the downloaded file in Cache
+func (d *Downloader) storeCache() error {
+	if d.Cache == nil || d.Range != nil || d.CacheStatus != CacheMiss {
+		return nil
+	}
+
+	headers := d.responseHeaders
+	if headers == nil {
+		headers = d.HeadHeaders
+	}
+	if headers == nil || !isCacheable(headers) {
+		return nil
+	}
+
+	f, err := os.Open(d.FilePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return d.Cache.Put(d.URL, &CacheEntry{
+		URL:      d.URL,
+		Headers:  headers,
+		Size:     info.Size(),
+		StoredAt: time.Now(),
+	}, f)
+}
+
+// dirCache is a Cache in a local directory, an entry is a json file and a body file named by the key hash
+type dirCache struct {
+	dir string
+}
+
+// NewDirCache returns a Cache storing the entries in dir
+func NewDirCache(dir string) Cache {
+	return &dirCache{dir: dir}
+}
+
+func (c *dirCache) path(key string, ext string) string {
+	return fixLongPath(filepath.Join(c.dir, md5.Md5(key)+ext))
+}
+
+func (c *dirCache) Get(key string) (*CacheEntry, error) {
+	data, err := ioutil.ReadFile(c.path(key, ".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	entry := &CacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		// a corrupted entry is a miss
+		return nil, nil
+	}
+
+	return entry, nil
+}
+
+func (c *dirCache) Open(key string) (io.ReadCloser, error) {
+	return os.Open(c.path(key, ".body"))
+}
+
+func (c *dirCache) Put(key string, entry *CacheEntry, body io.Reader) error {
+	if err := os.MkdirAll(fixLongPath(c.dir), 0755); err != nil {
+		return err
+	}
+
+	if body != nil {
+		if err := writeFileAtomic(c.path(key, ".body"), body); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(c.path(key, ".json"), strings.NewReader(string(data)))
+}
+
+// writeFileAtomic writes r to a temporary file renamed to path, so path is never seen torn,
+// the temporary file is unique, the writers of the same path in the process included
+func writeFileAtomic(path string, r io.Reader) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	// as readable as a file created by os.Create, not only by its owner
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
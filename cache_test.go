@@ -0,0 +1,205 @@
+package download
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	var mu sync.Mutex
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	etag := `"v1"`
+	cacheControl := "max-age=60"
+	statuses := []int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// recorded, to know the status of the GET requests
+		rec := httptest.NewRecorder()
+		rec.Header().Set("ETag", etag)
+		rec.Header().Set("Cache-Control", cacheControl)
+		http.ServeContent(rec, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+		if r.Method == http.MethodGet {
+			statuses = append(statuses, rec.Code)
+		}
+
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	}))
+	defer server.Close()
+
+	cache := NewDirCache(t.TempDir())
+	download := func(disabled bool) *Downloader {
+		d := New(server.URL+"/file.mp4", &Config{
+			FilePath:         filepath.Join(t.TempDir(), "file.mp4"),
+			TmpDir:           t.TempDir(),
+			IsRangesDisabled: disabled,
+			Cache:            cache,
+		})
+		if err := d.Download(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(d.FilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !bytes.Equal(data, content) {
+			t.Errorf("unexpected content: %s", data)
+		}
+
+		return d
+	}
+	requests := func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		list := statuses
+		statuses = []int{}
+		return list
+	}
+
+	for _, disabled := range []bool{false, true} {
+		if d := download(disabled); d.CacheStatus != CacheMiss || len(requests()) == 0 {
+			t.Fatalf("expected the first download to miss, got %s", d.CacheStatus)
+		}
+
+		if d := download(disabled); d.CacheStatus != CacheHit || len(requests()) != 0 {
+			t.Errorf("expected a fresh hit without request, got %s", d.CacheStatus)
+		}
+
+		// the stored entry is stale, the file changed and is now revalidated on every download
+		stale, _ := cache.Get(server.URL + "/file.mp4")
+		stale.StoredAt = stale.StoredAt.Add(-time.Hour)
+		cache.Put(server.URL+"/file.mp4", stale, nil)
+		mu.Lock()
+		etag, content, cacheControl = `"v2"`, bytes.Repeat([]byte("abcdefghij"), 100), "no-cache"
+		mu.Unlock()
+		if d := download(disabled); d.CacheStatus != CacheMiss {
+			t.Errorf("expected a changed file downloaded, got %s", d.CacheStatus)
+		}
+		if list := requests(); len(list) != 1 || list[0] != http.StatusOK {
+			t.Errorf("expected the file downloaded by the revalidation request, got %v", list)
+		}
+
+		if d := download(disabled); d.CacheStatus != CacheRevalidated {
+			t.Errorf("expected a revalidated hit, got %s", d.CacheStatus)
+		}
+		if list := requests(); len(list) != 1 || list[0] != http.StatusNotModified {
+			t.Errorf("expected a single 304, got %v", list)
+		}
+
+		mu.Lock()
+		etag, content, cacheControl = `"v1"`, bytes.Repeat([]byte("0123456789"), 100), "max-age=60"
+		mu.Unlock()
+		cache = NewDirCache(t.TempDir())
+	}
+}
+
+func TestCacheNoStore(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store, max-age=60")
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	cache := NewDirCache(t.TempDir())
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath: filepath.Join(t.TempDir(), "file.mp4"),
+		TmpDir:   t.TempDir(),
+		Cache:    cache,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry, _ := cache.Get(server.URL + "/file.mp4"); entry != nil {
+		t.Error("expected a no-store response not stored")
+	}
+}
+
+func TestCacheFreshness(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	date := now.Format(http.TimeFormat)
+	cases := []struct {
+		headers  http.Header
+		expected time.Duration
+	}{
+		{http.Header{"Cache-Control": {"public, max-age=300"}}, 300 * time.Second},
+		{http.Header{"Cache-Control": {"no-cache, max-age=300"}}, 0},
+		{http.Header{"Date": {date}, "Expires": {now.Add(time.Hour).Format(http.TimeFormat)}}, time.Hour},
+		{http.Header{"Date": {date}, "Expires": {"0"}}, 0},
+		{http.Header{"Date": {date}, "Last-Modified": {now.Add(-10 * time.Hour).Format(http.TimeFormat)}}, time.Hour},
+		{http.Header{}, 0},
+	}
+
+	for _, c := range cases {
+		entry := &CacheEntry{Headers: c.headers, StoredAt: now}
+		if lifetime := entry.freshnessLifetime(); lifetime != c.expected {
+			t.Errorf("%v: expected %s, got %s", c.headers, c.expected, lifetime)
+		}
+	}
+}
+
+// chunkReader reads its data a few bytes at a time, so the concurrent writers interleave
+type chunkReader struct {
+	data []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+
+	if len(p) > 7 {
+		p = p[:7]
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	runtime.Gosched()
+	return n, nil
+}
+
+func TestWriteFileAtomicConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "entry.body")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := writeFileAtomic(path, &chunkReader{bytes.Repeat([]byte{byte('a' + i)}, 4096)}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// the file of a single writer, never a mix of them
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 4096 || !bytes.Equal(data, bytes.Repeat(data[:1], 4096)) {
+		t.Errorf("expected the whole content of one writer, got %d bytes mixed", len(data))
+	}
+
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*"))
+	if len(files) != 1 {
+		t.Errorf("expected no temporary file left, got %v", files)
+	}
+}
@@ -0,0 +1,569 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zoox/crypto/md5"
+)
+
+// DefaultProxyMaxSize caps the bytes of the segments cached by CachingProxy, see CachingProxy.MaxSize
+var DefaultProxyMaxSize int64 = 10 * 1024 * 1024 * 1024
+
+// ErrOriginForbidden means CachingProxy does not fetch the requested url, a host not allowed or a private address
+var ErrOriginForbidden = errors.New("origin forbidden")
+
+// DefaultProxyRevalidate is how long CachingProxy trusts the size and the validators probed from the origin,
+// a file changed since is cached again under its new validators
+var DefaultProxyRevalidate = time.Minute
+
+// CachingProxy is a caching http proxy of the remote files: the clients request the original url through it,
+// as a forward proxy (http_proxy) or at /?url=..., and the ranges are served from the segments cached
+// in a local directory, the missing ones fetched from the origin once, whoever asked them first.
+// It turns the package into a download accelerator shared by a LAN or a CI fleet.
+// The origins which do not support ranges are streamed through, uncached.
+//
+// It fetches the urls its clients ask for: listen on the loopback, or set AllowedHosts and Authorize
+// before sharing it. The loopback, private and link-local addresses are never fetched unless IsPrivateAllowed.
+type CachingProxy struct {
+	// AllowedHosts are the hosts of the origins the clients may request, "example.com" or "*.example.com"
+	// for its subdomains, empty allows any host
+	AllowedHosts []string
+	// IsPrivateAllowed allows the origins on the loopback, private and link-local (cloud metadata) addresses
+	IsPrivateAllowed bool
+	// Authorize authorizes the clients, e.g. BasicAuth, nil allows every client
+	Authorize func(r *http.Request) bool
+	// MaxSize caps the bytes of the cached segments, the files used the least recently are evicted,
+	// zero means DefaultProxyMaxSize, negative no cap
+	MaxSize int64
+
+	dir    string
+	config *Config
+
+	mu sync.Mutex
+	// files are the probed files, by url
+	files map[string]*proxiedFile
+	// fetches are the segments being fetched from the origin, by path
+	fetches map[string]*segmentFetch
+	// cached are the bytes cached of each file, by key, cachedSize their sum, scanned from dir once
+	cached     map[string]*cachedFile
+	cachedSize int64
+	scanOnce   sync.Once
+}
+
+// cachedFile is the usage of the cache by a file
+type cachedFile struct {
+	size   int64
+	usedAt time.Time
+}
+
+// proxiedFile is what the origin told of a file
+type proxiedFile struct {
+	url          string
+	size         int64
+	contentType  string
+	etag         string
+	lastModified string
+	probedAt     time.Time
+}
+
+// key names the cache of the file, a changed file gets another one
+func (f *proxiedFile) key() string {
+	return md5.Md5(fmt.Sprintf("%s\n%s\n%s\n%d", f.url, f.etag, f.lastModified, f.size))
+}
+
+// segmentFetch is a segment being fetched, err is set once done is closed
+type segmentFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewCachingProxy returns a CachingProxy caching the segments in dir, the origin is requested with config
+// (headers, proxy, retries...), its SegmentSize is the size of the cached segments.
+func NewCachingProxy(dir string, config *Config) *CachingProxy {
+	if config == nil {
+		config = &Config{}
+	}
+
+	return &CachingProxy{
+		dir:     dir,
+		config:  config,
+		files:   map[string]*proxiedFile{},
+		fetches: map[string]*segmentFetch{},
+	}
+}
+
+// originURL returns the url requested through the proxy, the absolute request uri of a forward proxy
+// request or the url parameter
+func originURL(r *http.Request) string {
+	if r.URL.IsAbs() {
+		return r.URL.String()
+	}
+
+	return r.URL.Query().Get("url")
+}
+
+// BasicAuth returns a CachingProxy.Authorize accepting the clients sending username and password,
+// in Proxy-Authorization as a forward proxy or in Authorization
+func BasicAuth(username, password string) func(r *http.Request) bool {
+	expected := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return func(r *http.Request) bool {
+		for _, header := range []string{"Proxy-Authorization", "Authorization"} {
+			credentials := strings.TrimPrefix(r.Header.Get(header), "Basic ")
+			if subtle.ConstantTimeCompare([]byte(credentials), []byte(expected)) == 1 {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func (p *CachingProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p.Authorize != nil && !p.Authorize(r) {
+		if r.URL.IsAbs() {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="download"`)
+			http.Error(w, http.StatusText(http.StatusProxyAuthRequired), http.StatusProxyAuthRequired)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="download"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	origin := originURL(r)
+	if origin == "" {
+		http.Error(w, "expect an absolute url or the url parameter", http.StatusBadRequest)
+		return
+	}
+
+	if err := p.checkOrigin(r.Context(), origin); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	d := New(origin, p.config)
+	d.ctx = r.Context()
+	// the addresses are checked again as they are dialed, the redirects and the dns answers included
+	d.isPrivateRejected = !p.IsPrivateAllowed
+	file, err := p.probe(d)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if file == nil {
+		p.passThrough(w, r, d)
+		return
+	}
+
+	// the segments are fetched as the probed file, a change in between is an error, not a mix
+	d.URL, d.ContentLength, d.ETag, d.LastModified = file.url, file.size, file.etag, file.lastModified
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Type", file.contentType)
+	if file.etag != "" {
+		w.Header().Set("ETag", file.etag)
+	}
+	if file.lastModified != "" {
+		w.Header().Set("Last-Modified", file.lastModified)
+	}
+
+	start, end, partial, err := parseServedRange(r.Header.Get("Range"), file.size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", file.size))
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		p.writeHeader(w, file, start, end, partial)
+		return
+	}
+
+	// the first segment is fetched before the response, so a failing origin is answered 502
+	segmentSize := d.SegmentSize
+	path, err := p.segment(d, file, start/segmentSize)
+	if err != nil {
+		p.fail(w, file, err)
+		return
+	}
+	p.writeHeader(w, file, start, end, partial)
+
+	for off := start; off <= end; {
+		index := off / segmentSize
+		if index != start/segmentSize {
+			if path, err = p.segment(d, file, index); err != nil {
+				if os.Getenv("DEBUG") == "true" {
+					fmt.Println("proxy: failed to fetch the segment:", err)
+				}
+				return
+			}
+		}
+
+		n, err := copySegment(w, path, off-index*segmentSize, end-off+1)
+		if err != nil {
+			return
+		}
+		off += n
+	}
+}
+
+func (p *CachingProxy) writeHeader(w http.ResponseWriter, file *proxiedFile, start, end int64, partial bool) {
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	if !partial {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, file.size))
+	w.WriteHeader(http.StatusPartialContent)
+}
+
+// fail answers the error of the origin, a changed file is probed again by the next request
+func (p *CachingProxy) fail(w http.ResponseWriter, file *proxiedFile, err error) {
+	if errors.Is(err, ErrRemoteChanged) {
+		p.mu.Lock()
+		if p.files[file.url] == file {
+			delete(p.files, file.url)
+		}
+		p.mu.Unlock()
+	}
+
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
+
+// checkOrigin checks the client may request origin, an http url of an allowed host, public unless IsPrivateAllowed
+func (p *CachingProxy) checkOrigin(ctx context.Context, origin string) error {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("%w: expect an http url: %s", ErrOriginForbidden, origin)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if len(p.AllowedHosts) > 0 {
+		isAllowed := false
+		for _, allowed := range p.AllowedHosts {
+			allowed = strings.ToLower(allowed)
+			if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+				isAllowed = true
+				break
+			}
+		}
+		if !isAllowed {
+			return fmt.Errorf("%w: host not allowed: %s", ErrOriginForbidden, host)
+		}
+	}
+
+	if p.IsPrivateAllowed {
+		return nil
+	}
+
+	_, err = resolvePublic(ctx, net.JoinHostPort(host, "80"))
+	return err
+}
+
+// isPublicIP reports whether ip is a public address, not a loopback, private, link-local or unspecified one
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// resolvePublic resolves the host of addr, host:port, to a public address, ErrOriginForbidden if any is not
+func resolvePublic(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no address for %s", host)
+	}
+
+	for _, ip := range ips {
+		if !isPublicIP(ip.IP) {
+			return "", fmt.Errorf("%w: %s resolves to the private address %s", ErrOriginForbidden, host, ip.IP)
+		}
+	}
+
+	return net.JoinHostPort(ips[0].IP.String(), port), nil
+}
+
+// probe returns the file of the url of d, probed again after DefaultProxyRevalidate,
+// nil when the origin does not support ranges
+func (p *CachingProxy) probe(d *Downloader) (*proxiedFile, error) {
+	p.mu.Lock()
+	file, ok := p.files[d.URL]
+	p.mu.Unlock()
+	if ok && time.Since(file.probedAt) < DefaultProxyRevalidate {
+		return file, nil
+	}
+
+	origin := d.URL
+	if err := d.extract(); err != nil {
+		return nil, err
+	}
+
+	isSupportRange, err := d.checkSupportRange()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.parseContentInfo(); err != nil {
+		return nil, err
+	}
+
+	if !isSupportRange || d.ContentLength <= 0 {
+		return nil, nil
+	}
+
+	file = &proxiedFile{
+		url:          d.URL,
+		size:         d.ContentLength,
+		contentType:  d.ContentType,
+		etag:         d.ETag,
+		lastModified: d.LastModified,
+		probedAt:     time.Now(),
+	}
+	if file.contentType == "" {
+		file.contentType = "application/octet-stream"
+	}
+
+	p.mu.Lock()
+	// the expired files are probed again, forgotten until then
+	for u, f := range p.files {
+		if time.Since(f.probedAt) >= DefaultProxyRevalidate {
+			delete(p.files, u)
+		}
+	}
+	p.files[origin] = file
+	p.mu.Unlock()
+
+	return file, nil
+}
+
+// passThrough streams the file of an origin which does not support ranges, uncached
+func (p *CachingProxy) passThrough(w http.ResponseWriter, r *http.Request, d *Downloader) {
+	if d.ContentType != "" {
+		w.Header().Set("Content-Type", d.ContentType)
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	body, err := d.openDirect()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer body.Close()
+
+	io.Copy(w, body)
+}
+
+// segment returns the path of the segment at index of the file, fetched from the origin when it is not cached,
+// a segment asked again while it is fetched waits for it.
+func (p *CachingProxy) segment(d *Downloader, file *proxiedFile, index int64) (string, error) {
+	key := file.key()
+	path := filepath.Join(p.dir, key, strconv.FormatInt(d.SegmentSize, 10)+"-"+strconv.FormatInt(index, 10))
+	p.scanOnce.Do(p.scanCache)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			p.useCache(key, 0)
+			return path, nil
+		}
+
+		p.mu.Lock()
+		fetch, ok := p.fetches[path]
+		if !ok {
+			fetch = &segmentFetch{done: make(chan struct{})}
+			p.fetches[path] = fetch
+		}
+		p.mu.Unlock()
+
+		if !ok {
+			fetch.err = p.fetch(d, file, index, path)
+			if fetch.err == nil {
+				if info, err := os.Stat(path); err == nil {
+					p.useCache(key, info.Size())
+				}
+			}
+
+			p.mu.Lock()
+			delete(p.fetches, path)
+			p.mu.Unlock()
+			close(fetch.done)
+
+			if fetch.err != nil {
+				return "", fetch.err
+			}
+			return path, nil
+		}
+
+		select {
+		case <-fetch.done:
+		case <-d.getContext().Done():
+			return "", d.getContext().Err()
+		}
+
+		// the request which fetched it went away, fetched again by this one
+		if fetch.err != nil && !errors.Is(fetch.err, context.Canceled) {
+			return "", fetch.err
+		}
+	}
+}
+
+// fetch fetches the segment at index from the origin to path
+func (p *CachingProxy) fetch(d *Downloader, file *proxiedFile, index int64, path string) error {
+	segmentSize := d.SegmentSize
+	end := (index+1)*segmentSize - 1
+	if end >= file.size {
+		end = file.size - 1
+	}
+
+	part := &FilePart{Index: int(index), RangeStart: index * segmentSize, RangeEnd: end}
+	var data []byte
+	err := d.retry(part, func() (err error) {
+		data, err = d.fetchSegment(part)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if os.Getenv("DEBUG") == "true" {
+		fmt.Println("proxy: cached:", file.url, part.RangeStart, part.RangeEnd)
+	}
+
+	return writeFileAtomic(path, bytes.NewReader(data))
+}
+
+// maxSize returns the cap of the cached bytes, negative for no cap
+func (p *CachingProxy) maxSize() int64 {
+	if p.MaxSize == 0 {
+		return DefaultProxyMaxSize
+	}
+
+	return p.MaxSize
+}
+
+// scanCache counts the segments cached in dir by a previous run
+func (p *CachingProxy) scanCache() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.cached = map[string]*cachedFile{}
+	dirs, err := ioutil.ReadDir(p.dir)
+	if err != nil {
+		return
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		segments, err := ioutil.ReadDir(filepath.Join(p.dir, dir.Name()))
+		if err != nil {
+			continue
+		}
+
+		file := &cachedFile{usedAt: dir.ModTime()}
+		for _, segment := range segments {
+			file.size += segment.Size()
+		}
+		p.cached[dir.Name()] = file
+		p.cachedSize += file.size
+	}
+}
+
+// useCache records a use of the file of key, with size bytes added to the cache, then evicts the files
+// used the least recently while the cache is over its cap, never the file of key.
+func (p *CachingProxy) useCache(key string, size int64) {
+	p.mu.Lock()
+	file, ok := p.cached[key]
+	if !ok {
+		file = &cachedFile{}
+		p.cached[key] = file
+	}
+	file.size += size
+	file.usedAt = time.Now()
+	p.cachedSize += size
+
+	max := p.maxSize()
+	var evicted []string
+	for max >= 0 && p.cachedSize > max {
+		oldest := ""
+		for k, f := range p.cached {
+			if k != key && (oldest == "" || f.usedAt.Before(p.cached[oldest].usedAt)) {
+				oldest = k
+			}
+		}
+		if oldest == "" {
+			break
+		}
+
+		p.cachedSize -= p.cached[oldest].size
+		delete(p.cached, oldest)
+		evicted = append(evicted, oldest)
+	}
+	p.mu.Unlock()
+
+	for _, k := range evicted {
+		if os.Getenv("DEBUG") == "true" {
+			fmt.Println("proxy: evicted:", k)
+		}
+
+		os.RemoveAll(filepath.Join(p.dir, k))
+	}
+}
+
+// copySegment writes at most n bytes of the segment at path from off, returning the bytes written
+func copySegment(w io.Writer, path string, off, n int64) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	written, err := io.Copy(w, io.LimitReader(f, n))
+	if err == nil && written == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	return written, err
+}
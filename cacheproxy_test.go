@@ -0,0 +1,179 @@
+package download
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCachingProxy(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var mu sync.Mutex
+	fetched := map[string]int{}
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if byteRange := r.Header.Get("Range"); byteRange != "" && byteRange != "bytes=0-1" {
+			mu.Lock()
+			fetched[byteRange]++
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer origin.Close()
+
+	dir := t.TempDir()
+	config := &Config{SegmentSize: 100}
+	// the origin is on the loopback
+	newProxy := func() *CachingProxy {
+		p := NewCachingProxy(dir, config)
+		p.IsPrivateAllowed = true
+		return p
+	}
+	proxy := httptest.NewServer(newProxy())
+	defer proxy.Close()
+
+	fileURL := proxy.URL + "/?url=" + url.QueryEscape(origin.URL+"/file.mp4")
+	for i := 0; i < 2; i++ {
+		resp, data, err := getRange(fileURL, "bytes=150-349")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusPartialContent || resp.Header.Get("Content-Range") != "bytes 150-349/1000" {
+			t.Errorf("expected 206 bytes 150-349/1000, got %d %s", resp.StatusCode, resp.Header.Get("Content-Range"))
+		}
+		if !bytes.Equal(data, content[150:350]) {
+			t.Errorf("unexpected content: %q", data)
+		}
+	}
+
+	mu.Lock()
+	if len(fetched) != 3 || fetched["bytes=100-199"] != 1 || fetched["bytes=200-299"] != 1 || fetched["bytes=300-399"] != 1 {
+		t.Errorf("expected the 3 segments fetched once, got %v", fetched)
+	}
+	mu.Unlock()
+
+	// another proxy of the same directory, as a forward proxy, fetches only the segments not cached
+	forward := httptest.NewServer(newProxy())
+	defer forward.Close()
+	proxyURL, _ := url.Parse(forward.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	resp, err := client.Get(origin.URL + "/file.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(data, content) {
+		t.Errorf("expected the whole file, got %d, %d bytes", resp.StatusCode, len(data))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(fetched) != 10 {
+		t.Errorf("expected the 10 segments fetched, got %v", fetched)
+	}
+	for byteRange, n := range fetched {
+		if n != 1 {
+			t.Errorf("expected %s fetched once, got %d", byteRange, n)
+		}
+	}
+}
+
+func TestCachingProxyOrigins(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	origin := newRangeServer(content)
+	defer origin.Close()
+	fileURL := origin.URL + "/file.mp4"
+
+	get := func(p *CachingProxy, origin string, isAuthorized bool) int {
+		proxy := httptest.NewServer(p)
+		defer proxy.Close()
+
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/?url="+url.QueryEscape(origin), nil)
+		if isAuthorized {
+			req.SetBasicAuth("user", "secret")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && !bytes.Equal(data, content) {
+			t.Errorf("unexpected content of %s", origin)
+		}
+		return resp.StatusCode
+	}
+
+	// the loopback, private and metadata addresses are not fetched by default
+	p := NewCachingProxy(t.TempDir(), &Config{SegmentSize: 100})
+	for _, u := range []string{fileURL, "http://169.254.169.254/latest/meta-data/", "http://10.0.0.1/", "file:///etc/passwd"} {
+		if status := get(p, u, false); status != http.StatusForbidden {
+			t.Errorf("expected %s forbidden, got %d", u, status)
+		}
+	}
+
+	p = NewCachingProxy(t.TempDir(), &Config{SegmentSize: 100})
+	p.IsPrivateAllowed = true
+	p.AllowedHosts = []string{"*.example.com"}
+	if status := get(p, fileURL, false); status != http.StatusForbidden {
+		t.Errorf("expected a host not allowed forbidden, got %d", status)
+	}
+
+	p = NewCachingProxy(t.TempDir(), &Config{SegmentSize: 100})
+	p.IsPrivateAllowed = true
+	p.AllowedHosts = []string{"127.0.0.1"}
+	p.Authorize = BasicAuth("user", "secret")
+	if status := get(p, fileURL, false); status != http.StatusUnauthorized {
+		t.Errorf("expected an unauthorized client rejected, got %d", status)
+	}
+	if status := get(p, fileURL, true); status != http.StatusOK {
+		t.Errorf("expected the file for an allowed host and client, got %d", status)
+	}
+}
+
+func TestCachingProxyEviction(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	origin := newRangeServer(content)
+	defer origin.Close()
+
+	dir := t.TempDir()
+	p := NewCachingProxy(dir, &Config{SegmentSize: 100})
+	p.IsPrivateAllowed = true
+	p.MaxSize = 1500
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	for _, name := range []string{"a.mp4", "b.mp4"} {
+		resp, data, err := getRange(proxy.URL+"/?url="+url.QueryEscape(origin.URL+"/"+name), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || !bytes.Equal(data, content) {
+			t.Fatalf("expected %s, got %d", name, resp.StatusCode)
+		}
+	}
+
+	// the first file was evicted for the second one
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected one file cached, got %d", len(files))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cachedSize != 1000 {
+		t.Errorf("expected the 1000 bytes of the second file cached, got %d", p.cachedSize)
+	}
+}
@@ -0,0 +1,98 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCaptureCommand is the default command used to capture live streams
+var DefaultCaptureCommand = "ffmpeg"
+
+// CaptureConfig represents the live stream (rtmp:// and rtsp://) capture config
+type CaptureConfig struct {
+	// Duration limits the capture duration, zero means no limit
+	Duration time.Duration
+	// MaxSize limits the captured file size in bytes, zero means no limit
+	MaxSize int64
+	// Command is the capture command, default is ffmpeg
+	Command string
+}
+
+// isStreamURL returns true if the url is a live stream (rtmp:// or rtsp://)
+func isStreamURL(u string) bool {
+	parsedURL, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(parsedURL.Scheme) {
+	case "rtmp", "rtmps", "rtsp", "rtsps":
+		return true
+	}
+
+	return false
+}
+
+func (d *Downloader) captureArgs() []string {
+	args := []string{"-y", "-loglevel", "error"}
+	if strings.HasPrefix(strings.ToLower(d.URL), "rtsp") {
+		args = append(args, "-rtsp_transport", "tcp")
+	}
+	args = append(args, "-i", d.URL, "-c", "copy")
+
+	if d.Capture.Duration > 0 {
+		args = append(args, "-t", strconv.FormatFloat(d.Capture.Duration.Seconds(), 'f', -1, 64))
+	}
+	if d.Capture.MaxSize > 0 {
+		args = append(args, "-fs", strconv.FormatInt(d.Capture.MaxSize, 10))
+	}
+
+	return append(args, d.getFilePath())
+}
+
+func (d *Downloader) downloadByCapture() error {
+	if d.Capture == nil {
+		return errors.New("stream capture is not enabled, set Config.Capture to record " + d.URL)
+	}
+
+	if d.FileExt == "" {
+		// flv keeps rtmp streams as is, mp4 suits the h264/aac payload of most rtsp cameras
+		if strings.HasPrefix(strings.ToLower(d.URL), "rtmp") {
+			d.FileExt = "flv"
+		} else {
+			d.FileExt = "mp4"
+		}
+	}
+
+	if err := d.ensureFileDir(); err != nil {
+		return err
+	}
+
+	if err := d.reserveFilePath(); err != nil {
+		return err
+	}
+
+	command := d.Capture.Command
+	if command == "" {
+		command = DefaultCaptureCommand
+	}
+
+	cmd := exec.CommandContext(d.getContext(), command, d.captureArgs()...)
+	if os.Getenv("DEBUG") == "true" {
+		fmt.Println("capturing:", cmd.String())
+		cmd.Stdout = os.Stdout
+	}
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to capture stream %s: %s", d.URL, err)
+	}
+
+	return nil
+}
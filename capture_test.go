@@ -0,0 +1,32 @@
+package download
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptureArgs(t *testing.T) {
+	d := New("rtsp://127.0.0.1:554/live/camera", &Config{
+		FilePath: "/tmp/camera.mp4",
+		Capture: &CaptureConfig{
+			Duration: 90 * time.Second,
+			MaxSize:  1024,
+		},
+	})
+
+	args := strings.Join(d.captureArgs(), " ")
+	expected := "-y -loglevel error -rtsp_transport tcp -i rtsp://127.0.0.1:554/live/camera -c copy -t 90 -fs 1024 /tmp/camera.mp4"
+	if args != expected {
+		t.Errorf("expected %s, got %s", expected, args)
+	}
+}
+
+func TestCaptureNotEnabled(t *testing.T) {
+	_, err := Download("rtmp://127.0.0.1/live/stream", &Config{
+		FilePath: "/tmp/stream.flv",
+	})
+	if err == nil || !strings.Contains(err.Error(), "capture is not enabled") {
+		t.Errorf("expected capture not enabled error, got %v", err)
+	}
+}
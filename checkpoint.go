@@ -0,0 +1,38 @@
+package download
+
+// initCheckpoints records the parts already on disk, they count in the bytes of the checkpoints but are not reported
+func (d *Downloader) initCheckpoints() {
+	if d.OnCheckpoint == nil {
+		return
+	}
+
+	d.checkpointed = map[int]bool{}
+	d.checkpointBytes = d.resumeOffset
+	for _, part := range d.FileParts {
+		if d.isFilePartDone(part) {
+			d.checkpointed[part.Index] = true
+			d.checkpointBytes += part.RangeEnd - part.RangeStart + 1
+		}
+	}
+}
+
+// checkpoint calls OnCheckpoint once the part is written, the calls are serialized, so bytesTotal only grows
+func (d *Downloader) checkpoint(part *FilePart) error {
+	if d.OnCheckpoint == nil {
+		return nil
+	}
+
+	d.checkpointMu.Lock()
+	defer d.checkpointMu.Unlock()
+
+	if d.checkpointed[part.Index] {
+		return nil
+	}
+	d.checkpointed[part.Index] = true
+	d.checkpointBytes += part.RangeEnd - part.RangeStart + 1
+
+	return safeRun(func() error {
+		d.OnCheckpoint(*part, d.checkpointBytes)
+		return nil
+	})
+}
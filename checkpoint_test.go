@@ -0,0 +1,75 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOnCheckpoint(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	// the part at 500 fails until published
+	var isPublished int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=500-599" && atomic.LoadInt32(&isPublished) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	filePath := filepath.Join(t.TempDir(), "file.mp4")
+	tmpDir := t.TempDir()
+
+	indexes := map[int]bool{}
+	var last int64
+	onCheckpoint := func(part FilePart, bytesTotal int64) {
+		if indexes[part.Index] {
+			t.Errorf("part %d reported twice", part.Index)
+		}
+		indexes[part.Index] = true
+
+		if bytesTotal <= last {
+			t.Errorf("expected bytesTotal to grow, got %d after %d", bytesTotal, last)
+		}
+		last = bytesTotal
+	}
+
+	err := New(server.URL+"/file.mp4", &Config{
+		FilePath:     filePath,
+		TmpDir:       tmpDir,
+		SegmentSize:  100,
+		OnCheckpoint: onCheckpoint,
+		RetryPolicy:  &RetryPolicy{MaxAttempts: 1},
+	}).Download()
+	if err == nil {
+		t.Fatal("expected the missing part to fail the download")
+	}
+
+	if len(indexes) != 9 || indexes[5] || last != 900 {
+		t.Errorf("expected the 9 written parts reported up to 900 bytes, got %d parts up to %d", len(indexes), last)
+	}
+
+	// the resumed download reports the missing part only, counting the parts on disk
+	atomic.StoreInt32(&isPublished, 1)
+	indexes, last = map[int]bool{}, 0
+	err = New(server.URL+"/file.mp4", &Config{
+		FilePath:     filePath,
+		TmpDir:       tmpDir,
+		SegmentSize:  100,
+		OnCheckpoint: onCheckpoint,
+	}).Download()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(indexes) != 1 || !indexes[5] || last != int64(len(content)) {
+		t.Errorf("expected part 5 reported at %d bytes, got %v at %d", len(content), indexes, last)
+	}
+}
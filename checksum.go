@@ -0,0 +1,142 @@
+package download
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrChecksumMismatch means the downloaded file doesn't match Config.ExpectedChecksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// The algorithms of Config.ExpectedChecksum
+const (
+	ChecksumMD5    = "md5"
+	ChecksumSHA1   = "sha1"
+	ChecksumSHA256 = "sha256"
+	ChecksumSHA512 = "sha512"
+)
+
+var checksumHashes = map[string]func() hash.Hash{
+	ChecksumMD5:    md5.New,
+	ChecksumSHA1:   sha1.New,
+	ChecksumSHA256: sha256.New,
+	ChecksumSHA512: sha512.New,
+}
+
+// invalidSuffix is appended to the files failing the verification, with Config.KeepInvalid
+const invalidSuffix = ".invalid"
+
+// checksum is a parsed Config.ExpectedChecksum
+type checksum struct {
+	algo string
+	sum  []byte
+}
+
+// parseChecksum parses algo:digest, or algo-digest as in subresource integrity,
+// the digest is hex or base64 (standard or url, padded or not).
+func parseChecksum(raw string) (*checksum, error) {
+	i := strings.IndexAny(raw, ":-")
+	if i == -1 {
+		return nil, fmt.Errorf("invalid checksum %s: expect algo:digest", raw)
+	}
+
+	algo := strings.ToLower(raw[:i])
+	newHash, ok := checksumHashes[algo]
+	if !ok {
+		return nil, fmt.Errorf("invalid checksum %s: unsupported algorithm %s", raw, algo)
+	}
+
+	digest := strings.TrimSpace(raw[i+1:])
+	size := newHash().Size()
+	if sum, err := hex.DecodeString(digest); err == nil && len(sum) == size {
+		return &checksum{algo: algo, sum: sum}, nil
+	}
+
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if sum, err := encoding.DecodeString(digest); err == nil && len(sum) == size {
+			return &checksum{algo: algo, sum: sum}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid checksum %s: expect a %d bytes hex or base64 digest", raw, size)
+}
+
+func (c *checksum) String() string {
+	return c.algo + ":" + hex.EncodeToString(c.sum)
+}
+
+// verify checks the file against the checksum
+func (c *checksum) verify(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := c.newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	return c.check(h.Sum(nil))
+}
+
+// sha256File returns the hex sha256 of the file at path
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (c *checksum) newHash() hash.Hash {
+	return checksumHashes[c.algo]()
+}
+
+// check compares the computed sum to the checksum
+func (c *checksum) check(actual []byte) error {
+	if !bytes.Equal(actual, c.sum) {
+		return newChecksumError(fmt.Errorf("%w: expect %s, got %s:%x", ErrChecksumMismatch, c, c.algo, actual))
+	}
+
+	return nil
+}
+
+// expectedChecksum returns the parsed ExpectedChecksum, nil if not set
+func (d *Downloader) expectedChecksum() (*checksum, error) {
+	if d.ExpectedChecksum == "" {
+		return nil, nil
+	}
+
+	return parseChecksum(d.ExpectedChecksum)
+}
+
+// discardFile removes a file failing the verification,
+// or keeps it with the .invalid suffix for inspection with KeepInvalid.
+func (d *Downloader) discardFile(stagingPath string) {
+	if !d.KeepInvalid {
+		os.Remove(stagingPath)
+		return
+	}
+
+	os.Rename(stagingPath, strings.TrimSuffix(stagingPath, d.inProgressSuffix())+invalidSuffix)
+}
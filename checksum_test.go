@@ -0,0 +1,89 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseChecksum(t *testing.T) {
+	sum := sha256.Sum256([]byte("content"))
+	expected := "sha256:" + hex.EncodeToString(sum[:])
+
+	valid := []string{
+		"sha256:" + hex.EncodeToString(sum[:]),
+		"SHA256:" + hex.EncodeToString(sum[:]),
+		"sha256-" + base64.StdEncoding.EncodeToString(sum[:]),
+		"sha256:" + base64.RawURLEncoding.EncodeToString(sum[:]),
+	}
+	for _, raw := range valid {
+		c, err := parseChecksum(raw)
+		if err != nil {
+			t.Errorf("parseChecksum(%s): %s", raw, err)
+			continue
+		}
+		if c.String() != expected {
+			t.Errorf("parseChecksum(%s): expect %s, got %s", raw, expected, c)
+		}
+	}
+
+	invalid := []string{
+		hex.EncodeToString(sum[:]),
+		"crc32:deadbeef",
+		"sha256:deadbeef",
+		"md5:" + hex.EncodeToString(sum[:]),
+	}
+	for _, raw := range invalid {
+		if _, err := parseChecksum(raw); err == nil {
+			t.Errorf("parseChecksum(%s): expect an error", raw)
+		}
+	}
+}
+
+func TestExpectedChecksum(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	sum := sha256.Sum256(content)
+	filePath := filepath.Join(t.TempDir(), "file.mp4")
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:         filePath,
+		TmpDir:           t.TempDir(),
+		ExpectedChecksum: "sha256:" + hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrong := sha256.Sum256([]byte("other"))
+	for _, keepInvalid := range []bool{false, true} {
+		filePath := filepath.Join(t.TempDir(), "file.mp4")
+		_, err := Download(server.URL+"/file.mp4", &Config{
+			FilePath:         filePath,
+			TmpDir:           t.TempDir(),
+			ExpectedChecksum: "sha256:" + hex.EncodeToString(wrong[:]),
+			KeepInvalid:      keepInvalid,
+		})
+		if !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("expected a checksum mismatch, got %v", err)
+		}
+
+		if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+			t.Error("expected the mismatched file not to be saved")
+		}
+
+		data, err := os.ReadFile(filePath + ".invalid")
+		if keepInvalid && !bytes.Equal(data, content) {
+			t.Errorf("expected the mismatched file to be kept as .invalid: %v", err)
+		}
+		if !keepInvalid && !os.IsNotExist(err) {
+			t.Error("expected the mismatched file to be removed")
+		}
+	}
+}
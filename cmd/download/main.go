@@ -0,0 +1,205 @@
+// Command download downloads a file with parallel ranges.
+//
+// Ctrl+C (SIGINT) and SIGTERM stop the workers cleanly and keep the parts,
+// running the same command again resumes the download.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/go-zoox/download"
+)
+
+const (
+	// ExitFailure means the download failed
+	ExitFailure = 1
+	// ExitUsage means the arguments are invalid
+	ExitUsage = 2
+	// ExitInterrupted means the download was interrupted by a signal and can be resumed
+	ExitInterrupted = 130
+)
+
+func main() {
+	output := flag.String("o", "", "output file path or cloud object (s3://bucket/key, gs://bucket/key), defaults to the url file name in the current directory, the output directory with -i")
+	segmentSize := flag.Int64("segment-size", 0, "size of each segment in bytes, defaults to 10 Mb")
+	tmpDir := flag.String("tmp-dir", "", "directory to store the parts, defaults to the system temp directory")
+	noRanges := flag.Bool("no-ranges", false, "download with a single request")
+	proxy := flag.String("proxy", "", "proxy url, http:// or socks5://")
+	input := flag.String("i", "", "download every url of a manifest, a local file or url: one url per line, csv (url,output,checksum) or json")
+	pipe := flag.String("pipe", "", "feed the file in order to the stdin of a command instead of writing it, e.g. \"ffmpeg -i - out.mkv\"")
+	byteRange := flag.String("range", "", "download only the bytes start-end (inclusive) or start- to the end of the file")
+	wait := flag.Duration("wait", 0, "minimum delay between two requests to the same host, e.g. 500ms, to mirror politely with -i")
+	robots := flag.Bool("robots", false, "respect the robots.txt of the hosts, the disallowed urls are skipped")
+	sequential := flag.Bool("sequential", false, "download the segments in order, so the file.download can be played while it downloads")
+	partial := flag.String("partial", "", "resume from the partial file of another tool, e.g. file.crdownload, checking its last 64Kb against the server")
+	suffix := flag.String("suffix", "", "suffix of the file while it downloads, e.g. .part or .crdownload, defaults to .download")
+	serve := flag.String("serve", "", "serve the file over http at this address while it downloads, e.g. 127.0.0.1:8080, for a video player")
+	auto := flag.Bool("auto", false, "tune the parallel connections from the measured throughput, up to 16")
+	hedge := flag.Bool("hedge", false, "request the slowest parts left twice at the end of the download, keeping the first complete")
+	cachingProxy := flag.String("caching-proxy", "", "run a caching range proxy at this address instead, e.g. 127.0.0.1:3128, the segments cached in -tmp-dir")
+	cachingProxyAuth := flag.String("caching-proxy-auth", "", "user:password the clients of the caching proxy must send, to share it beyond the loopback")
+	cachingProxyHosts := flag.String("caching-proxy-hosts", "", "comma separated hosts the caching proxy fetches from, e.g. example.com,*.cdn.example.com, any public host by default")
+	cachingProxySize := flag.Int64("caching-proxy-size", 0, "bytes cached by the caching proxy, the files used the least recently evicted, defaults to 10 Gb")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <url>\n       %s [flags] -i <manifest>\n       %s -caching-proxy <address>\n", os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *cachingProxy != "" {
+		dir := *tmpDir
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "download-proxy")
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		handler := download.NewCachingProxy(dir, &download.Config{SegmentSize: *segmentSize, Proxy: *proxy})
+		handler.MaxSize = *cachingProxySize
+		if *cachingProxyHosts != "" {
+			handler.AllowedHosts = strings.Split(*cachingProxyHosts, ",")
+		}
+		if *cachingProxyAuth != "" {
+			credentials := strings.SplitN(*cachingProxyAuth, ":", 2)
+			if len(credentials) != 2 {
+				fmt.Fprintln(os.Stderr, "invalid -caching-proxy-auth, expect user:password")
+				os.Exit(ExitUsage)
+			}
+			handler.Authorize = download.BasicAuth(credentials[0], credentials[1])
+		}
+		if err := runCachingProxy(ctx, *cachingProxy, dir, handler); err != nil {
+			fmt.Fprintln(os.Stderr, "caching proxy failed:", err)
+			os.Exit(ExitFailure)
+		}
+		return
+	}
+
+	if (*input == "" && flag.NArg() != 1) || (*input != "" && flag.NArg() != 0) {
+		flag.Usage()
+		os.Exit(ExitUsage)
+	}
+
+	var r *download.Range
+	if *byteRange != "" {
+		var err error
+		if r, err = parseRange(*byteRange); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(ExitUsage)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *input != "" {
+		result, err := download.DownloadBatchManifest(ctx, *input, &download.Config{
+			Dir:              *output,
+			SegmentSize:      *segmentSize,
+			TmpDir:           *tmpDir,
+			IsRangesDisabled: *noRanges,
+			Proxy:            *proxy,
+			Range:            r,
+			PolitenessDelay:  *wait,
+			Robots:           *robots,
+			StagingSuffix:    *suffix,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid manifest:", err)
+			os.Exit(ExitFailure)
+		}
+
+		fmt.Print(result.Summary())
+		if ctx.Err() != nil {
+			os.Exit(ExitInterrupted)
+		}
+		if result.Err() != nil {
+			os.Exit(ExitFailure)
+		}
+		return
+	}
+
+	config := &download.Config{
+		FilePath:         *output,
+		SegmentSize:      *segmentSize,
+		TmpDir:           *tmpDir,
+		IsRangesDisabled: *noRanges,
+		Proxy:            *proxy,
+		Range:            r,
+		Pipe:             *pipe,
+		PolitenessDelay:  *wait,
+		Robots:           *robots,
+		Sequential:       *sequential,
+		Serve:            *serve,
+		StagingSuffix:    *suffix,
+		PartialPath:      *partial,
+		AutoConcurrency:  *auto,
+		HedgeRequests:    *hedge,
+	}
+	if *partial != "" {
+		config.ResumeVerifySize = 64 * 1024
+	}
+	if strings.HasPrefix(*output, "s3://") || strings.HasPrefix(*output, "gs://") {
+		config.FilePath = ""
+		config.Object = *output
+	}
+
+	_, err := download.DownloadWithContext(ctx, flag.Arg(0), config)
+	if errors.Is(err, download.ErrInterrupted) {
+		fmt.Fprintln(os.Stderr, "interrupted, run the same command again to resume")
+		os.Exit(ExitInterrupted)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "download failed:", err)
+		os.Exit(ExitFailure)
+	}
+}
+
+// runCachingProxy serves the caching proxy at addr until ctx is done
+func runCachingProxy(ctx context.Context, addr, dir string, handler *download.CachingProxy) error {
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	fmt.Fprintf(os.Stderr, "caching proxy listening on %s, caching in %s\n", addr, dir)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// parseRange parses a range like curl --range, start-end or start-
+func parseRange(raw string) (*download.Range, error) {
+	bounds := strings.Split(raw, "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid range: %s", raw)
+	}
+
+	start, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil || start < 0 {
+		return nil, fmt.Errorf("invalid range: %s", raw)
+	}
+
+	if bounds[1] == "" {
+		return &download.Range{Start: start, End: -1}, nil
+	}
+
+	end, err := strconv.ParseInt(bounds[1], 10, 64)
+	if err != nil || end < start {
+		return nil, fmt.Errorf("invalid range: %s", raw)
+	}
+
+	return &download.Range{Start: start, End: end}, nil
+}
@@ -0,0 +1,191 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultMaxConcurrency caps the parts downloaded at the same time by Config.AutoConcurrency
+var DefaultMaxConcurrency = 16
+
+// DefaultConcurrencyInterval is how often Config.AutoConcurrency measures the throughput and adjusts the parts
+// downloaded at the same time
+var DefaultConcurrencyInterval = time.Second
+
+// DefaultConcurrencyGain is the share of throughput a new connection must add for AutoConcurrency to keep it
+var DefaultConcurrencyGain = 0.1
+
+// DefaultConcurrencyHold is the number of intervals AutoConcurrency keeps the connections it settled on
+// before it tries one more again
+var DefaultConcurrencyHold = 5
+
+// rttSlack is the latency over twice the lowest one taken as the requests queueing, not as jitter
+const rttSlack = 5 * time.Millisecond
+
+// concurrencyTuner adjusts the parts downloaded at the same time, a connection is added while it adds
+// throughput, removed when it did not or the latency grows, the connections queueing in the network.
+type concurrencyTuner struct {
+	mu     sync.Mutex
+	limit  int
+	max    int
+	active int
+	// changed is closed when a connection is freed or added
+	changed chan struct{}
+	// rate is the throughput of the last interval, grew is true if a connection was added for this one
+	rate float64
+	grew bool
+	hold int
+	// minRTT is the lowest latency seen, rtt and samples sum the latencies of the interval
+	minRTT  time.Duration
+	rtt     time.Duration
+	samples int
+}
+
+func newConcurrencyTuner(limit, max int) *concurrencyTuner {
+	if max < limit {
+		max = limit
+	}
+
+	return &concurrencyTuner{limit: limit, max: max, changed: make(chan struct{})}
+}
+
+// maxConcurrency returns the cap of AutoConcurrency
+func (d *Downloader) maxConcurrency() int {
+	if d.MaxConcurrency > 0 {
+		return d.MaxConcurrency
+	}
+
+	return DefaultMaxConcurrency
+}
+
+// acquire waits for a connection under the limit, a nil tuner has no limit
+func (t *concurrencyTuner) acquire(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	for {
+		t.mu.Lock()
+		if t.active < t.limit {
+			t.active++
+			t.mu.Unlock()
+			return nil
+		}
+		changed := t.changed
+		t.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release frees the connection taken by acquire
+func (t *concurrencyTuner) release() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.active--
+	t.notify()
+}
+
+func (t *concurrencyTuner) notify() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// observe records the latency of a request, from sent to the response headers
+func (t *concurrencyTuner) observe(rtt time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.minRTT == 0 || rtt < t.minRTT {
+		t.minRTT = rtt
+	}
+	t.rtt += rtt
+	t.samples++
+}
+
+// adjust updates the limit from the throughput of the last interval, returning it
+func (t *concurrencyTuner) adjust(rate float64) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var rtt time.Duration
+	if t.samples > 0 {
+		rtt = t.rtt / time.Duration(t.samples)
+	}
+	t.rtt, t.samples = 0, 0
+
+	switch {
+	case rtt > 2*t.minRTT+rttSlack && t.limit > 1:
+		// the requests queue, fewer connections get the same throughput
+		t.limit--
+		t.grew, t.hold = false, DefaultConcurrencyHold
+	case t.grew && rate < t.rate*(1+DefaultConcurrencyGain):
+		// the last connection did not pay
+		t.limit--
+		t.grew, t.hold = false, DefaultConcurrencyHold
+	case t.hold > 0:
+		t.hold--
+		t.grew = false
+	case t.limit < t.max:
+		t.limit++
+		t.grew = true
+		t.notify()
+	default:
+		t.grew = false
+	}
+	t.rate = rate
+
+	return t.limit
+}
+
+// tuneConcurrency adjusts the tuner every DefaultConcurrencyInterval from the bytes received,
+// until the returned func is called
+func (d *Downloader) tuneConcurrency(t *concurrencyTuner) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(DefaultConcurrencyInterval)
+		defer ticker.Stop()
+
+		last, lastAt := d.progress.transferredBytes(), time.Now()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				transferred := d.progress.transferredBytes()
+				rate := float64(transferred-last) / now.Sub(lastAt).Seconds()
+				last, lastAt = transferred, now
+
+				limit := t.adjust(rate)
+				d.setProgressConnections(limit)
+				if os.Getenv("DEBUG") == "true" {
+					fmt.Printf("concurrency: %.0f bytes/s, %d connections\n", rate, limit)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
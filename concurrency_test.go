@@ -0,0 +1,96 @@
+package download
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAutoConcurrency(t *testing.T) {
+	interval := DefaultConcurrencyInterval
+	DefaultConcurrencyInterval = 50 * time.Millisecond
+	defer func() { DefaultConcurrencyInterval = interval }()
+
+	content := bytes.Repeat([]byte("0123456789"), 2000)
+	var inFlight, maxInFlight int32
+	// each connection is throttled, the throughput grows with the connections
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" && r.Header.Get("Range") != "bytes=0-1" && r.Method == http.MethodGet {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	var connections int
+	d := New(server.URL+"/file.mp4", &Config{
+		Dir:             t.TempDir(),
+		TmpDir:          t.TempDir(),
+		SegmentSize:     100,
+		AutoConcurrency: true,
+		MaxConcurrency:  8,
+		OnProgress: func(p Progress) {
+			if p.Connections > connections {
+				connections = p.Connections
+			}
+		},
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(d.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("unexpected content")
+	}
+
+	if max := atomic.LoadInt32(&maxInFlight); max <= int32(DefaultConcurrency) || max > 8 {
+		t.Errorf("expected more than %d and at most 8 parts in flight, got %d", DefaultConcurrency, max)
+	}
+	if connections <= DefaultConcurrency {
+		t.Errorf("expected the connections to grow past %d, got %d", DefaultConcurrency, connections)
+	}
+}
+
+func TestConcurrencyTuner(t *testing.T) {
+	tuner := newConcurrencyTuner(2, 4)
+	if limit := tuner.adjust(100); limit != 3 {
+		t.Fatalf("expected a connection added, got %d", limit)
+	}
+
+	// the added connection did not raise the throughput
+	if limit := tuner.adjust(105); limit != 2 {
+		t.Fatalf("expected the connection removed, got %d", limit)
+	}
+	for i := 0; i < DefaultConcurrencyHold; i++ {
+		if limit := tuner.adjust(105); limit != 2 {
+			t.Fatalf("expected the connections held, got %d", limit)
+		}
+	}
+	if limit := tuner.adjust(105); limit != 3 {
+		t.Fatalf("expected a connection tried again, got %d", limit)
+	}
+
+	// the requests queue
+	tuner.observe(10 * time.Millisecond)
+	tuner.adjust(200)
+	tuner.observe(50 * time.Millisecond)
+	if limit := tuner.adjust(300); limit != 3 {
+		t.Fatalf("expected a connection removed as the latency grew, got %d", limit)
+	}
+}
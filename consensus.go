@@ -0,0 +1,117 @@
+package download
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// hashFile returns the hex encoded sha1 of the file
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// isConsensusEnabled returns true if the pieces are cross-checked between the sources,
+// it is useless when the expected piece hashes are known.
+func (d *Downloader) isConsensusEnabled() bool {
+	return d.Consensus >= 2 && len(d.PieceHashes) == 0 && d.sources != nil && len(d.sources.list) >= 2
+}
+
+// checkConsensus downloads the part from other sources until Consensus of them agree on its hash,
+// the sources that disagree with the majority are flagged down, as tampered or corrupted.
+func (d *Downloader) checkConsensus(ctx context.Context, part *FilePart, url string) error {
+	hash, err := hashFile(part.Path)
+	if err != nil {
+		return err
+	}
+
+	paths := map[string]string{hash: part.Path}
+	votes := map[string][]string{hash: {url}}
+	tried := map[string]bool{url: true}
+	defer func() {
+		for _, path := range paths {
+			if path != part.Path {
+				os.Remove(path)
+			}
+		}
+	}()
+
+	for i := 1; ; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		for hash, urls := range votes {
+			if len(urls) < d.Consensus {
+				continue
+			}
+
+			if paths[hash] != part.Path {
+				if err := os.Rename(paths[hash], part.Path); err != nil {
+					return err
+				}
+				paths[hash] = part.Path
+			}
+
+			for other, urls := range votes {
+				if other != hash {
+					d.sources.flag(urls...)
+				}
+			}
+
+			return nil
+		}
+
+		src, checkURL := d.sources.pick(tried)
+		if src == nil {
+			return newChecksumError(fmt.Errorf("piece %d: no consensus between %d sources", part.Index, len(tried)))
+		}
+		tried[checkURL] = true
+
+		check := &FilePart{
+			Path:       fmt.Sprintf("%s.check.%d", part.Path, i),
+			Index:      part.Index,
+			RangeStart: part.RangeStart,
+			RangeEnd:   part.RangeEnd,
+		}
+
+		startedAt := time.Now()
+		err := d.downloadFilePartFrom(ctx, checkURL, check)
+		d.sources.done(src, part.RangeEnd-part.RangeStart+1, time.Since(startedAt), err)
+		if err != nil {
+			os.Remove(check.Path)
+			continue
+		}
+
+		checkHash, err := hashFile(check.Path)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := paths[checkHash]; ok {
+			os.Remove(check.Path)
+		} else {
+			paths[checkHash] = check.Path
+		}
+		votes[checkHash] = append(votes[checkHash], checkURL)
+
+		if os.Getenv("DEBUG") == "true" && checkHash != hash {
+			fmt.Println("sources disagree on part:", part.Index, url, checkURL)
+		}
+	}
+}
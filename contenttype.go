@@ -0,0 +1,120 @@
+package download
+
+import (
+	"mime"
+	"strings"
+)
+
+// contentTypeExts maps the content types to the extensions of the files
+var contentTypeExts = map[string]string{
+	// video
+	"video/mp4":        "mp4",
+	"video/webm":       "webm",
+	"video/ogg":        "ogg",
+	"video/x-flv":      "flv",
+	"video/x-ms-wmv":   "wmv",
+	"video/x-msvideo":  "avi",
+	"video/x-matroska": "mkv",
+	"video/mpeg":       "mpg",
+	"video/quicktime":  "mov",
+	"video/x-ms-asf":   "asf",
+	"video/x-ms-wm":    "wm",
+	"video/x-ms-wmx":   "wmx",
+	"video/x-ms-wvx":   "wvx",
+	"video/x-ms-wax":   "wax",
+	"video/mp2t":       "ts",
+	"video/3gpp":       "3gp",
+
+	// audio
+	"audio/mpeg":     "mp3",
+	"audio/x-ms-wma": "wma",
+	"audio/mp4":      "m4a",
+	"audio/aac":      "aac",
+	"audio/ogg":      "ogg",
+	"audio/opus":     "opus",
+	"audio/flac":     "flac",
+	"audio/wav":      "wav",
+	"audio/x-wav":    "wav",
+	"audio/webm":     "weba",
+	"audio/aiff":     "aiff",
+	"audio/midi":     "mid",
+
+	// images
+	"image/png":     "png",
+	"image/jpeg":    "jpg",
+	"image/gif":     "gif",
+	"image/webp":    "webp",
+	"image/bmp":     "bmp",
+	"image/svg+xml": "svg",
+	"image/tiff":    "tiff",
+	"image/x-icon":  "ico",
+	"image/avif":    "avif",
+	"image/heic":    "heic",
+
+	// documents
+	"application/pdf": "pdf",
+	"application/rtf": "rtf",
+	"text/plain":      "txt",
+	"text/html":       "html",
+	"text/css":        "css",
+	"text/csv":        "csv",
+	"text/markdown":   "md",
+	"text/xml":        "xml",
+
+	// office documents
+	"application/msword":                      "doc",
+	"application/vnd.ms-excel":                "xls",
+	"application/vnd.ms-powerpoint":           "ppt",
+	"application/vnd.oasis.opendocument.text": "odt",
+	"application/epub+zip":                    "epub",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   "docx",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         "xlsx",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": "pptx",
+
+	// fonts
+	"font/woff":  "woff",
+	"font/woff2": "woff2",
+	"font/ttf":   "ttf",
+	"font/otf":   "otf",
+
+	// archives
+	"application/zip":              "zip",
+	"application/x-zip-compressed": "zip",
+	"application/gzip":             "gz",
+	"application/x-gzip":           "gz",
+	"application/x-tar":            "tar",
+	"application/x-gtar":           "tar.gz",
+	"application/x-compressed-tar": "tar.gz",
+	"application/x-bzip2":          "bz2",
+	"application/x-xz":             "xz",
+	"application/zstd":             "zst",
+	"application/x-7z-compressed":  "7z",
+	"application/vnd.rar":          "rar",
+	"application/x-rar-compressed": "rar",
+	"application/x-iso9660-image":  "iso",
+
+	// applications
+	"application/json":                        "json",
+	"application/xml":                         "xml",
+	"application/javascript":                  "js",
+	"text/javascript":                         "js",
+	"application/wasm":                        "wasm",
+	"application/vnd.android.package-archive": "apk",
+	"application/java-archive":                "jar",
+	"application/x-msdownload":                "exe",
+	"application/x-msi":                       "msi",
+	"application/x-apple-diskimage":           "dmg",
+	"application/vnd.debian.binary-package":   "deb",
+	"application/x-rpm":                       "rpm",
+	"application/x-bittorrent":                "torrent",
+}
+
+// contentTypeExt returns the extension of the content type, empty if unknown
+func contentTypeExt(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+
+	return contentTypeExts[mediaType]
+}
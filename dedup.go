@@ -0,0 +1,213 @@
+package download
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// dedupIndexMu serializes the updates of the dedup indexes of the process,
+// the processes sharing an index serialize them with its .lock file.
+var dedupIndexMu sync.Mutex
+
+// DedupIndex represents the files already downloaded, by url, see Config.DedupIndex
+type DedupIndex struct {
+	Entries map[string]*DedupEntry
+}
+
+// DedupEntry represents a downloaded file and the version of the remote file it is
+type DedupEntry struct {
+	Path string
+	// ETag and LastModified are the validators of the remote file, at least one is set
+	ETag         string
+	LastModified string
+	// Size and ModTime detect the local file changed since it was downloaded
+	Size    int64
+	ModTime time.Time
+}
+
+// isFresh returns true if the remote file is still the recorded version and the local file is untouched
+func (e *DedupEntry) isFresh(info *FileInfo) bool {
+	if e.ETag != "" {
+		if info.ETag != e.ETag {
+			return false
+		}
+	} else if e.LastModified == "" || info.LastModified != e.LastModified {
+		return false
+	}
+
+	if info.Size >= 0 && info.Size != e.Size {
+		return false
+	}
+
+	stat, err := os.Stat(e.Path)
+	if err != nil {
+		return false
+	}
+
+	return stat.Mode().IsRegular() && stat.Size() == e.Size && stat.ModTime().Equal(e.ModTime)
+}
+
+func readDedupIndex(path string) (*DedupIndex, error) {
+	index := &DedupIndex{
+		Entries: map[string]*DedupEntry{},
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("invalid dedup index %s: %s", path, err)
+	}
+	if index.Entries == nil {
+		index.Entries = map[string]*DedupEntry{}
+	}
+
+	return index, nil
+}
+
+// writeDedupIndex writes the index atomically, the other processes never read a torn index
+func writeDedupIndex(path string, index *DedupIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmpPath := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// downloadFromDedupIndex reuses the file recorded in DedupIndex when the remote file did not change,
+// linked or copied to the file path when it was downloaded elsewhere. It returns false to download the file.
+func (d *Downloader) downloadFromDedupIndex() (bool, error) {
+	// a range is not the file
+	if d.DedupIndex == "" || d.Range != nil {
+		return false, nil
+	}
+
+	// the download reports the errors of the probe
+	info, err := d.probe()
+	if err != nil || info.StatusCode >= 400 {
+		return false, nil
+	}
+	// the validators are recorded once downloaded, even by a direct download
+	d.ETag, d.LastModified = info.ETag, info.LastModified
+
+	dedupIndexMu.Lock()
+	index, err := readDedupIndex(d.DedupIndex)
+	dedupIndexMu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	entry, ok := index.Entries[d.URL]
+	if !ok || !entry.isFresh(info) {
+		return false, nil
+	}
+
+	if err := d.adoptFile(entry.Path); err != nil {
+		return false, err
+	}
+
+	if os.Getenv("DEBUG") == "true" {
+		fmt.Println("dedup: unchanged, reusing:", entry.Path)
+	}
+
+	d.IsDeduplicated = true
+	return true, nil
+}
+
+// adoptFile links or copies the file downloaded at src to the file path, named as the download would,
+// direct downloads keep the url name.
+func (d *Downloader) adoptFile(src string) error {
+	if !d.IsRangesDisabled {
+		if err := d.parseContentInfo(); err != nil {
+			return err
+		}
+		if err := d.parseFileInfo(); err != nil {
+			return err
+		}
+	}
+
+	if filePath, _ := filepath.Abs(d.getFilePath()); filePath == src {
+		return nil
+	}
+
+	if err := d.ensureFileDir(); err != nil {
+		return err
+	}
+
+	if err := d.reserveFilePath(); err != nil {
+		return err
+	}
+
+	filePath := d.getFilePath()
+	os.Remove(filePath)
+	if err := os.Link(src, filePath); err != nil {
+		return copyFile(src, filePath, d.inProgressSuffix(), d.fileMode())
+	}
+
+	return nil
+}
+
+// recordDedupIndex records the downloaded file in DedupIndex where it landed, ContentStore included,
+// remote files without validators are not recorded
+func (d *Downloader) recordDedupIndex() error {
+	if d.DedupIndex == "" || d.Range != nil || d.IsDeduplicated || (d.ETag == "" && d.LastModified == "") {
+		return nil
+	}
+
+	// where the file landed, moved to ContentStore when set
+	filePath, err := filepath.Abs(d.FilePath)
+	if err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+
+	dedupIndexMu.Lock()
+	defer dedupIndexMu.Unlock()
+
+	// another process may update the index meanwhile, its entries would be lost
+	lock, _, err := lockFile(d.getContext(), d.DedupIndex+".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	index, err := readDedupIndex(d.DedupIndex)
+	if err != nil {
+		return err
+	}
+
+	index.Entries[d.URL] = &DedupEntry{
+		Path:         filePath,
+		ETag:         d.ETag,
+		LastModified: d.LastModified,
+		Size:         stat.Size(),
+		ModTime:      stat.ModTime(),
+	}
+
+	return writeDedupIndex(d.DedupIndex, index)
+}
@@ -0,0 +1,160 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDedupIndex(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	etag := `"v1"`
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&gets, 1)
+		}
+
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	index := filepath.Join(t.TempDir(), "index.json")
+	dir := t.TempDir()
+	download := func(disabled bool, name string) *Downloader {
+		d := New(server.URL+"/file.mp4", &Config{
+			FilePath:         filepath.Join(dir, name),
+			TmpDir:           t.TempDir(),
+			IsRangesDisabled: disabled,
+			DedupIndex:       index,
+		})
+		if err := d.Download(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(d.FilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Errorf("unexpected content of %s", d.FilePath)
+		}
+
+		return d
+	}
+
+	for _, disabled := range []bool{false, true} {
+		atomic.StoreInt32(&gets, 0)
+		os.Remove(index)
+
+		if d := download(disabled, "a.mp4"); d.IsDeduplicated || atomic.LoadInt32(&gets) == 0 {
+			t.Fatal("expected the first download to download the file")
+		}
+
+		atomic.StoreInt32(&gets, 0)
+		if d := download(disabled, "a.mp4"); !d.IsDeduplicated || atomic.LoadInt32(&gets) != 0 {
+			t.Errorf("expected the unchanged file reused, %d GET sent", atomic.LoadInt32(&gets))
+		}
+
+		if d := download(disabled, "b.mp4"); !d.IsDeduplicated || atomic.LoadInt32(&gets) != 0 {
+			t.Errorf("expected the unchanged file reused to another path, %d GET sent", atomic.LoadInt32(&gets))
+		}
+	}
+
+	// the local file changed
+	os.WriteFile(filepath.Join(dir, "a.mp4"), []byte("modified"), 0644)
+	if d := download(true, "a.mp4"); d.IsDeduplicated {
+		t.Error("expected a modified file downloaded again")
+	}
+
+	// the remote file changed
+	etag = `"v2"`
+	if d := download(true, "a.mp4"); d.IsDeduplicated {
+		t.Error("expected a changed remote file downloaded again")
+	}
+}
+
+func TestDedupIndexShared(t *testing.T) {
+	server := newRangeServer(bytes.Repeat([]byte("0123456789"), 10))
+	defer server.Close()
+
+	index := filepath.Join(t.TempDir(), "index.json")
+	d := New(server.URL+"/file.mp4", &Config{
+		FilePath:         filepath.Join(t.TempDir(), "file.mp4"),
+		IsRangesDisabled: true,
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	d.DedupIndex = index
+	d.ETag = `"v1"`
+
+	// another process holds the index while it records its own download
+	if err := os.WriteFile(index+".lock", []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	recorded := make(chan error, 1)
+	go func() {
+		recorded <- d.recordDedupIndex()
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	other := &DedupIndex{Entries: map[string]*DedupEntry{"http://other.example/file": {Path: "/other", ETag: `"v1"`}}}
+	if err := writeDedupIndex(index, other); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(index + ".lock")
+
+	if err := <-recorded; err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readDedupIndex(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries.Entries) != 2 || entries.Entries[d.URL] == nil {
+		t.Errorf("expected the entries of both processes kept, got %d", len(entries.Entries))
+	}
+}
+
+func TestDedupIndexContentStore(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// the file moved to the store is recorded there
+	index := filepath.Join(t.TempDir(), "index.json")
+	store := t.TempDir()
+	dir := t.TempDir()
+	for _, isDeduplicated := range []bool{false, true} {
+		d := New(server.URL+"/file.mp4", &Config{
+			Dir:          dir,
+			TmpDir:       t.TempDir(),
+			ContentStore: store,
+			DedupIndex:   index,
+		})
+		if err := d.Download(); err != nil {
+			t.Fatal(err)
+		}
+		if d.IsDeduplicated != isDeduplicated {
+			t.Errorf("expected deduplicated %v", isDeduplicated)
+		}
+	}
+
+	entries, err := readDedupIndex(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := entries.Entries[server.URL+"/file.mp4"]; e == nil || filepath.Dir(filepath.Dir(filepath.Dir(e.Path))) != store {
+		t.Errorf("expected the file in the store recorded, got %+v", e)
+	}
+}
@@ -0,0 +1,46 @@
+package download
+
+import (
+	"fmt"
+	"os"
+)
+
+// DefaultFileMode is the permission of the downloaded files
+var DefaultFileMode os.FileMode = 0644
+
+func (d *Downloader) fileMode() os.FileMode {
+	if d.FileMode != 0 {
+		return d.FileMode
+	}
+
+	return DefaultFileMode
+}
+
+// dirMode is the permission of the created directories, the file mode with search (x) wherever read (r) is set
+func (d *Downloader) dirMode() os.FileMode {
+	mode := d.fileMode().Perm()
+	return mode | (mode&0444)>>2
+}
+
+// ensureFileDir creates the missing directories of the file before it is written,
+// unless IsCreateDirDisabled, where a missing directory fails the download early.
+func (d *Downloader) ensureFileDir() error {
+	dir := fixLongPath(d.FileDir)
+	info, err := os.Stat(dir)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("not a directory: %s", d.FileDir)
+		}
+
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	if d.IsCreateDirDisabled {
+		return fmt.Errorf("directory does not exist: %s", d.FileDir)
+	}
+
+	return os.MkdirAll(dir, d.dirMode())
+}
@@ -0,0 +1,52 @@
+package download
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCreateFileDir(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	filePath := filepath.Join(t.TempDir(), "a", "b", "c", "clip.mp4")
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:    filePath,
+		TmpDir:      t.TempDir(),
+		SegmentSize: 32,
+		FileMode:    0600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("unexpected content: %s", data)
+	}
+
+	if runtime.GOOS != "windows" {
+		if info, _ := os.Stat(filePath); info.Mode().Perm() != 0600 {
+			t.Errorf("expected file mode 0600, got %s", info.Mode())
+		}
+		if info, _ := os.Stat(filepath.Dir(filePath)); info.Mode().Perm() != 0700 {
+			t.Errorf("expected dir mode 0700, got %s", info.Mode())
+		}
+	}
+
+	_, err = Download(server.URL+"/file.mp4", &Config{
+		FilePath:            filepath.Join(t.TempDir(), "missing", "clip.mp4"),
+		TmpDir:              t.TempDir(),
+		IsCreateDirDisabled: true,
+	})
+	if err == nil {
+		t.Errorf("expected a missing directory error")
+	}
+}
@@ -0,0 +1,185 @@
+package download
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"unsafe"
+)
+
+// directAlignment is the buffer and offset alignment of direct io, the logical block size of most disks
+const directAlignment = 4096
+
+// DefaultDirectBufferSize is the size of the aligned buffer of direct io writes
+var DefaultDirectBufferSize = 1024 * 1024
+
+// directWriter writes through an aligned buffer in aligned blocks, as direct io requires,
+// the unaligned tail is written with direct io turned off.
+type directWriter struct {
+	f   *os.File
+	buf []byte
+	n   int
+}
+
+func newDirectWriter(f *os.File) *directWriter {
+	size := DefaultDirectBufferSize - DefaultDirectBufferSize%directAlignment
+	if size < directAlignment {
+		size = directAlignment
+	}
+
+	raw := make([]byte, size+directAlignment)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&raw[0])) % directAlignment); rem != 0 {
+		offset = directAlignment - rem
+	}
+
+	return &directWriter{
+		f:   f,
+		buf: raw[offset : offset+size],
+	}
+}
+
+func (w *directWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[w.n:], p)
+		w.n += n
+		written += n
+		p = p[n:]
+
+		if w.n == len(w.buf) {
+			if _, err := w.f.Write(w.buf); err != nil {
+				return written, err
+			}
+			w.n = 0
+		}
+	}
+
+	return written, nil
+}
+
+func (w *directWriter) flush() error {
+	aligned := w.n - w.n%directAlignment
+	if aligned > 0 {
+		if _, err := w.f.Write(w.buf[:aligned]); err != nil {
+			return err
+		}
+	}
+
+	if tail := w.buf[aligned:w.n]; len(tail) > 0 {
+		if err := disableDirect(w.f); err != nil {
+			return err
+		}
+		if _, err := w.f.Write(tail); err != nil {
+			return err
+		}
+	}
+
+	w.n = 0
+	return nil
+}
+
+// fileWriter is a file being written, throttled or with direct io
+type fileWriter struct {
+	io.Writer
+	file *os.File
+	// flush writes the data buffered by direct io
+	flush func() error
+	sync  bool
+}
+
+// Close flushes and closes the file, fsyncing it first if Sync is enabled
+func (w *fileWriter) Close() error {
+	if w.flush != nil {
+		if err := w.flush(); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+
+	if w.sync {
+		if err := w.file.Sync(); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+
+	return w.file.Close()
+}
+
+// createFile creates or truncates the file at path, with direct io if enabled
+// and throttled by the disk write limits.
+func (d *Downloader) createFile(path string) (io.WriteCloser, error) {
+	w := &fileWriter{
+		sync: d.Sync,
+	}
+
+	if d.DirectIO {
+		f, err := openDirect(path, d.fileMode())
+		if err != nil {
+			// tmpfs and some network filesystems do not support direct io
+			if os.Getenv("DEBUG") == "true" {
+				fmt.Println("direct io unavailable, falling back to buffered io:", path, err)
+			}
+		} else {
+			dw := newDirectWriter(f)
+			w.Writer, w.file, w.flush = dw, f, dw.flush
+		}
+	}
+
+	if w.file == nil {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, d.fileMode())
+		if err != nil {
+			return nil, err
+		}
+		w.Writer, w.file = f, f
+	}
+
+	d.throttleFile(w)
+	return w, nil
+}
+
+// appendToFile opens the file at path to write after its end, throttled by the disk write limits,
+// without direct io as the end of the file is not aligned.
+func (d *Downloader) appendToFile(path string) (io.WriteCloser, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, d.fileMode())
+	if err != nil {
+		return nil, err
+	}
+
+	w := &fileWriter{
+		Writer: f,
+		file:   f,
+		sync:   d.Sync,
+	}
+	d.throttleFile(w)
+	return w, nil
+}
+
+// throttleFile applies the disk write limits to the file
+func (d *Downloader) throttleFile(w *fileWriter) {
+	if limiter := d.diskLimiter(); limiter != nil {
+		w.Writer = &throttledWriter{
+			ctx:     d.getContext(),
+			w:       w.Writer,
+			limiter: limiter,
+		}
+	}
+}
+
+// syncDir fsyncs the directory, so a rename in it survives a power loss
+func syncDir(path string) error {
+	// directories can't be fsynced on windows, renames are journaled by ntfs
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
@@ -0,0 +1,26 @@
+package download
+
+import (
+	"os"
+	"syscall"
+)
+
+// openDirect opens the file for writing bypassing the page cache (O_DIRECT)
+func openDirect(path string, mode os.FileMode) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|syscall.O_DIRECT, mode)
+}
+
+// disableDirect turns O_DIRECT off, for the unaligned tail of the file
+func disableDirect(f *os.File) error {
+	fd := f.Fd()
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_GETFL, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_SETFL, flags&^syscall.O_DIRECT); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
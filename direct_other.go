@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package download
+
+import (
+	"errors"
+	"os"
+)
+
+// openDirect is not supported out of linux, the file is written with buffered io
+func openDirect(path string, mode os.FileMode) (*os.File, error) {
+	return nil, errors.New("direct io is only supported on linux")
+}
+
+func disableDirect(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,68 @@
+package download
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestDirectIO(t *testing.T) {
+	// parts and output both end with an unaligned tail
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	content = append(content, "tail"...)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	filePath := t.TempDir() + "/direct.mp4"
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:    filePath,
+		TmpDir:      t.TempDir(),
+		SegmentSize: 5000,
+		DirectIO:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("expected %d bytes, got %d", len(content), len(data))
+	}
+}
+
+func TestSync(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	dir := t.TempDir()
+	filePath := dir + "/synced.mp4"
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:    filePath,
+		TmpDir:      t.TempDir(),
+		SegmentSize: 32,
+		Sync:        true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("unexpected content: %s", data)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the downloaded file, got %d entries", len(entries))
+	}
+}
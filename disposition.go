@@ -0,0 +1,122 @@
+package download
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseDispositionFileName returns the file name of a Content-Disposition header, empty if none,
+// filename* (RFC 5987, the charset, language and percent-encoded name) is preferred over filename.
+func parseDispositionFileName(header string) string {
+	var name, extName string
+	for _, param := range splitDispositionParams(header) {
+		i := strings.Index(param, "=")
+		if i == -1 {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimSpace(param[:i]))
+		value := strings.TrimSpace(param[i+1:])
+		switch key {
+		case "filename":
+			name = cleanFileName(baseFileName(unquoteDispositionValue(value)))
+		case "filename*":
+			if decoded, ok := decodeExtValue(unquoteDispositionValue(value)); ok {
+				// already percent-decoded, cleanFileName would decode it again
+				extName = sanitizeReservedName(sanitizeFileName(strings.TrimSpace(composeNFC(baseFileName(decoded)))))
+			}
+		}
+	}
+
+	if extName != "" {
+		return extName
+	}
+
+	return name
+}
+
+// splitDispositionParams splits the header on the semicolons outside of quoted strings
+func splitDispositionParams(header string) []string {
+	params := []string{}
+	start := 0
+	quoted := false
+	for i := 0; i < len(header); i++ {
+		switch header[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ';':
+			if !quoted {
+				params = append(params, header[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(params, header[start:])
+}
+
+// unquoteDispositionValue removes the quotes and backslash escapes of a quoted string
+func unquoteDispositionValue(value string) string {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+
+	var b strings.Builder
+	value = value[1 : len(value)-1]
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+		}
+		b.WriteByte(value[i])
+	}
+
+	return b.String()
+}
+
+// decodeExtValue decodes a RFC 5987 ext-value, charset'language'percent-encoded,
+// UTF-8 and ISO-8859-1 are the charsets required by the rfc, the language is ignored.
+func decodeExtValue(value string) (string, bool) {
+	parts := strings.SplitN(value, "'", 3)
+	if len(parts) != 3 {
+		return "", false
+	}
+
+	raw, err := url.PathUnescape(parts[2])
+	if err != nil {
+		return "", false
+	}
+
+	switch strings.ToUpper(parts[0]) {
+	case "UTF-8", "US-ASCII":
+		if !utf8.ValidString(raw) {
+			return "", false
+		}
+
+		return raw, true
+	case "ISO-8859-1":
+		// latin-1 bytes are the first 256 code points
+		runes := make([]rune, len(raw))
+		for i := 0; i < len(raw); i++ {
+			runes[i] = rune(raw[i])
+		}
+
+		return string(runes), true
+	}
+
+	return "", false
+}
+
+// baseFileName drops the directories some servers send along the name,
+// both / and \ since the name may come from a windows server.
+func baseFileName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i != -1 {
+		return name[i+1:]
+	}
+
+	return name
+}
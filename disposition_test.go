@@ -0,0 +1,65 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseDispositionFileName(t *testing.T) {
+	cases := map[string]string{
+		`attachment; filename="report.pdf"`:                                   "report.pdf",
+		`attachment; filename=report.pdf`:                                     "report.pdf",
+		`attachment; filename="a \"quoted\" name.txt"`:                        `a "quoted" name.txt`,
+		`attachment; filename="semi;colon.txt"`:                               "semi;colon.txt",
+		`attachment; filename*=UTF-8''na%C3%AFve%20file.txt`:                  "naïve file.txt",
+		`attachment; filename="fallback.txt"; filename*=UTF-8''%E4%B8%AD.txt`: "中.txt",
+		`attachment; filename*=UTF-8''%E4%B8%AD.txt; filename="fallback.txt"`: "中.txt",
+		`attachment; filename*=utf-8'zh-CN'%E4%B8%AD.txt`:                     "中.txt",
+		`attachment; filename*=ISO-8859-1'en'caf%E9.txt`:                      "café.txt",
+		`attachment; filename*=UTF-8''100%2525.txt`:                           "100%25.txt",
+		`attachment; filename*=KOI8-R''%C1.txt; filename="fallback.txt"`:      "fallback.txt",
+		`attachment; filename*=UTF-8''%FF.txt; filename="fallback.txt"`:       "fallback.txt",
+		`attachment; filename="../../etc/passwd"`:                             "passwd",
+		`attachment; filename*=UTF-8''..%2F..%2F.bashrc`:                      "bashrc",
+		`attachment; filename="C:\\Users\\me\\file.txt"`:                      "file.txt",
+		`inline`: "",
+		``:       "",
+	}
+
+	for header, expected := range cases {
+		if name := parseDispositionFileName(header); name != expected {
+			t.Errorf("parseDispositionFileName(%s): expect %q, got %q", header, expected, name)
+		}
+	}
+}
+
+func TestContentDispositionFileName(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="report.bin"; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	d := New(server.URL+"/download?id=1", &Config{Dir: dir, TmpDir: t.TempDir()})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(dir, "résumé.pdf"); d.FilePath != expected {
+		t.Errorf("expect %s, got %s", expected, d.FilePath)
+	}
+
+	// the supplied name wins
+	d = New(server.URL+"/download?id=1", &Config{Dir: dir, Name: "mine.pdf", TmpDir: t.TempDir()})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(dir, "mine.pdf"); d.FilePath != expected {
+		t.Errorf("expect %s, got %s", expected, d.FilePath)
+	}
+}
@@ -1,48 +1,216 @@
 package download
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/go-zoox/cocurrent"
 	"github.com/go-zoox/crypto/md5"
-	"github.com/go-zoox/fetch"
 	"github.com/go-zoox/fs"
 )
 
+// ErrForbidden means the server answered 403, such as an expired pre-signed url
+var ErrForbidden = errors.New("forbidden")
+
 // DefaultSegmentSize stands for the default segment size (10 Mb)
+//
 //	if the segment size is not set, the default segment size is used
-var DefaultSegmentSize = 10 * 1024 * 1024
+var DefaultSegmentSize int64 = 10 * 1024 * 1024
 
 // Downloader is the downloader
 type Downloader struct {
 	// URL is the url to download
 	URL string
+	// PageURL is the original page url when URL is resolved by an extractor
+	PageURL string
 	// FileDir represents the directory to store the downloaded file
 	FileDir string
 	// FileName represents the file name
 	FileName string
 	// FileExt represents the file extension
 	FileExt string
+	// FilePath represents the absolute path of the downloaded file, set once the download succeeds
+	FilePath string
 	// HeadHeaders represents the headers of the head file response
 	HeadHeaders http.Header
 	// ContentType represents the content type of the file
 	ContentType string
 	// ContentLength represents the content length of the file
 	ContentLength int64
+	// ETag represents the etag of the file, used with If-Range when resuming
+	ETag string
+	// LastModified represents the last modified date of the file, used with If-Range when there is no strong etag
+	LastModified string
 	// Hash represents the file info hash, use for temp dir
 	Hash string
+	// Mirrors represents the other http sources of the same file, such as web seeds
+	Mirrors []string
+	// PieceHashes represents the hex encoded sha1 hash of each piece (BEP-19 web seeding)
+	PieceHashes []string
+	// Consensus represents the number of sources that must agree on each piece
+	Consensus int
+	// MirrorStrategy represents how parts are spread over the url and mirrors
+	MirrorStrategy string
+	// MirrorRegions represents the country or region of the url and mirrors
+	MirrorRegions map[string]string
+	// Region represents the country or region hint of the nearest mirror strategy
+	Region string
+	// RefreshURL represents the hook to re-sign an expired url
+	RefreshURL func(old string) (string, error) `json:"-"`
+	// ConnectTo represents the endpoints to connect to instead of the url host:port
+	ConnectTo map[string]string
+	// Host represents the Host header override
+	Host string
+	// ServerName represents the TLS SNI override
+	ServerName string
+	// LocalAddr represents the source ip outgoing connections are bound to
+	LocalAddr string
+	// Interface represents the network interface outgoing connections are bound to
+	Interface string
+	// Proxy represents the proxy url
+	Proxy string
+	// ProxyChain represents the proxies to go through in order after Proxy
+	ProxyChain []string
+	// RangeBatch represents the number of parts requested at once with a multi-range request
+	RangeBatch int
+	// RetryPolicy represents how failed parts are retried
+	RetryPolicy *RetryPolicy `json:"-"`
+	// Timeouts represents the fine-grained request timeouts
+	Timeouts *TimeoutConfig
+	// Transport represents the low-level connection tuning
+	Transport *TransportConfig
+	// DiskWriteRate represents the cap of bytes written to disk per second
+	DiskWriteRate int64
+	// DiskWriteIOPS represents the cap of writes to disk per second
+	DiskWriteIOPS int
+	// DirectIO represents if the part and output files bypass the page cache
+	DirectIO bool
+	// Sync represents if the part and output files are fsynced on completion
+	Sync bool
+	// IfExists represents what to do when the file already exists, overwrite, rename or resume
+	IfExists string
+	// FileMode represents the permission of the downloaded file
+	FileMode os.FileMode
+	// IsCreateDirDisabled represents if missing directories of the file are not created
+	IsCreateDirDisabled bool
+	// NoExtensionGuess represents if the extension is never guessed from the content type or magic bytes
+	NoExtensionGuess bool
+	// QuarantineDir represents the directory the file stays in until it is verified
+	QuarantineDir string
+	// Scan represents the content scan of the file before it is promoted
+	Scan func(path string) error `json:"-"`
+	// Quota represents the bounds of the job: max time, redirects and retries
+	Quota *QuotaConfig
+	// ExpectContentType represents the content type the server must return
+	ExpectContentType string
+	// ExpectedChecksum represents the checksum the file must match, algo:digest
+	ExpectedChecksum string
+	// KeepInvalid represents if the files failing the verification are kept with the .invalid suffix
+	KeepInvalid bool
+	// ExpectedSize represents the size the file must have
+	ExpectedSize int64
+	// Client represents the injected http client, used instead of the downloader transport
+	Client *http.Client `json:"-"`
+	// Range represents the bytes of the remote file to download, nil means the whole file
+	Range *Range
+	// DedupIndex represents the path of the index of the downloaded files, by url
+	DedupIndex string
+	// IsDeduplicated is true when the file was found unchanged in DedupIndex, nothing was downloaded
+	IsDeduplicated bool
+	// ContentStore represents the directory storing the files by digest
+	ContentStore string
+	// ContentStoreAlgo represents the digest algorithm of ContentStore
+	ContentStoreAlgo string
+	// Digest represents the digest of the file stored in ContentStore, as algo:hex
+	Digest string
+	// Cache represents the http cache of the downloaded files
+	Cache Cache `json:"-"`
+	// CacheStatus represents how Cache served the file, miss, hit or revalidated, empty without Cache
+	CacheStatus string
+	// LockFile represents if the downloads of the same url on the machine are coordinated by a lock file
+	LockFile bool
+	// Object represents the cloud object the file is uploaded to, s3://bucket/key or gs://bucket/key
+	Object string
+	// ObjectStore represents the store uploading Object
+	ObjectStore ObjectStore `json:"-"`
+	// Sink represents where the file lands instead of the file path
+	Sink Sink `json:"-"`
+	// Pipe represents the command line the file is piped to instead of the file path
+	Pipe string
+	// OnCheckpoint represents the callback fired once each part is written
+	OnCheckpoint func(part FilePart, bytesTotal int64) `json:"-"`
+	// OnProgress represents the callback reporting the progress during the download
+	OnProgress func(progress Progress) `json:"-"`
+	// SpeedWindow represents the window the transfer rate is sampled over
+	SpeedWindow time.Duration
+	// SpeedSmoothing represents the weight of each new sample of the transfer rate
+	SpeedSmoothing float64
+	// ETA represents the estimator of the time left
+	ETA ETAEstimator `json:"-"`
+	// Registry represents the registry listing the download while in flight
+	Registry *Registry `json:"-"`
+	// History represents the jsonl file a record of the download is appended to
+	History string
+	// OnHistory represents the callback receiving the record of the download
+	OnHistory func(record HistoryRecord) `json:"-"`
+	// IsPacingDisabled represents if the requests to a throttled host are not paced
+	IsPacingDisabled bool
+	// PolitenessDelay represents the minimum delay between two requests to the same host
+	PolitenessDelay time.Duration
+	// Robots represents if the robots.txt of the host is respected
+	Robots bool
+	// WorkerPool represents the pool of segment workers shared with other downloads
+	WorkerPool *WorkerPool `json:"-"`
+	// Priority represents the order of the parts waiting for the workers of WorkerPool
+	Priority int
+	// Sequential represents if the segments are written in order into the growing file
+	Sequential bool
+	// Lookahead represents the number of segments downloaded ahead of the read position
+	Lookahead int
+	// PartOrder represents the order the parts are downloaded in
+	PartOrder string
+	// Serve represents the address of the local http server serving the file while it downloads
+	Serve string
+	// ServeHoles represents how the bytes not downloaded yet are served
+	ServeHoles string
+	// StagingSuffix represents the suffix of the file while it downloads
+	StagingSuffix string
+	// Xattrs represents if the file is tagged with its provenance in extended attributes
+	Xattrs bool
+	// Sidecar represents if the metadata of the file is written next to it
+	Sidecar bool
+	// PartialPath represents the partial file of another tool the download resumes from
+	PartialPath string
+	// ResumeVerifySize represents the size of the end of a resumed file compared with the remote
+	ResumeVerifySize int
+	// AutoConcurrency represents if the parts downloaded at the same time are tuned from the throughput
+	AutoConcurrency bool
+	// MaxConcurrency represents the cap of the parts downloaded at the same time by AutoConcurrency
+	MaxConcurrency int
+	// HedgeRequests represents if the slowest parts left are requested twice at the end of the download
+	HedgeRequests bool
+	// ProbeTimeout represents the time limit of the requests probing the file
+	ProbeTimeout time.Duration
+	// IsProbeDisabled represents if the file is downloaded without probing it first
+	IsProbeDisabled bool
+	// SegmentTimeout represents the time limit of a request of a part
+	SegmentTimeout time.Duration
 	// IsSupportRange represents if the server supports the range header
 	IsSupportRange bool
 	// SegmentSize represents the size of each segment, default is 10 Mb
-	SegmentSize int
+	SegmentSize int64
 	// Ranges represents the ranges of the file
 	Ranges []*Range
 	// FileParts represents the file parts by ranges
@@ -51,12 +219,69 @@ type Downloader struct {
 	TmpDir string
 	//
 	IsRangesDisabled bool
+	// Capture represents the live stream capture config, used by rtmp:// and rtsp:// urls
+	Capture *CaptureConfig
+	// IsExtractorsDisabled disables resolving page urls with the registered extractors
+	IsExtractorsDisabled bool
+	//
+	sources       *sourcePool
+	refreshMu     sync.Mutex
+	transportOnce sync.Once
+	httpTransport *http.Transport
+	transportErr  error
+	ctx           context.Context
+	isRestarted   bool
+	diskOnce      sync.Once
+	disk          *diskLimiter
+	reservedPath  string
+	// targetPath is the path of the file before IfExistsRename renamed it
+	targetPath string
+	// retries counts the retries of all parts against Quota.MaxRetries
+	retries int32
+	// retried counts the retries of all parts, for the result
+	retried int32
+	// lastResult is the result of the last completed download
+	lastResult *Result
+	// isFileNameSupplied is true when the file name comes from the config, not the url
+	isFileNameSupplied bool
+	// isMultiRangeUnsupported stops batching once the server answered a multi-range request with the whole file
+	isMultiRangeUnsupported int32
+	// directValidator is the validator of the remote file the staging file of a direct download is written from
+	directValidator string
+	// resumeOffset is the size of the existing file resumed by IfExistsResume or PartialPath
+	resumeOffset int64
+	// storedPath is the path of the file in ContentStore
+	storedPath string
+	// sinkLocation is the location of the file committed to the sink
+	sinkLocation string
+	// sinkSHA256 is the sha256 of the file committed to the sink, hex encoded
+	sinkSHA256 string
+	// checkpointed are the parts counted in checkpointBytes, by index
+	checkpointMu    sync.Mutex
+	checkpointed    map[int]bool
+	checkpointBytes int64
+	progress        *progressCounter
+	// tuner tunes the parts downloaded at the same time by AutoConcurrency, nil otherwise
+	tuner *concurrencyTuner
+	// hedges are the requests of the parts in flight hedged by HedgeRequests, nil otherwise
+	hedges *hedgePool
+	// responseHeaders are the headers of the response of a single request download
+	responseHeaders http.Header
+	// serveURL is the url of the file served by Serve
+	serveMu  sync.Mutex
+	serveURL string
+	// partialErr is the error of the last download some parts of which failed, for RetryFailed
+	partialErr *PartialError
+	// isRetryingFailed is true while RetryFailed downloads the missing parts
+	isRetryingFailed bool
+	// isPrivateRejected refuses to dial the loopback, private and link-local addresses, for CachingProxy
+	isPrivateRejected bool
 }
 
 // Range represents the range of the file
 type Range struct {
-	Start int
-	End   int
+	Start int64
+	End   int64
 }
 
 // FilePart represents a part of a file.
@@ -71,20 +296,251 @@ type FilePart struct {
 	FileName   string
 	FileExt    string
 	Index      int
-	RangeStart int
-	RangeEnd   int
+	RangeStart int64
+	RangeEnd   int64
 }
 
 // Config represents the download config
 type Config struct {
-	// FileName
+	// FilePath is the path of the downloaded file, it takes precedence over Dir and Name
 	FilePath string
+	// Dir is the directory of the downloaded file, default is the current directory
+	Dir string
+	// Name is the name of the downloaded file, default is derived from the url and content type
+	Name string
 	// SegmentSize
-	SegmentSize int
+	SegmentSize int64
 	// TmpDir
 	TmpDir string
 	//
 	IsRangesDisabled bool
+	// Capture enables recording rtmp:// and rtsp:// live streams
+	Capture *CaptureConfig
+	// IsExtractorsDisabled disables the registered extractors
+	IsExtractorsDisabled bool
+	// Mirrors represents the other http sources of the same file, such as web seeds
+	Mirrors []string
+	// PieceLength is the piece length of PieceHashes, it overrides SegmentSize
+	PieceLength int64
+	// PieceHashes is the hex encoded sha1 hash of each piece (from a torrent or metalink),
+	// every piece is verified and refetched from another source on mismatch
+	PieceHashes []string
+	// Consensus is the number of sources that must serve the same content for each piece, e.g. 2,
+	// the pieces are fetched again from other sources until they agree and the sources that disagree are flagged down,
+	// protecting against a tampered or corrupted mirror when there are no PieceHashes
+	Consensus int
+	// MirrorStrategy decides how parts are spread over the sources, fastest (default), race, failover or nearest
+	MirrorStrategy string
+	// MirrorRegions maps the url and mirrors to their country or region, used by the nearest strategy
+	MirrorRegions map[string]string
+	// Region is the country or region hint, sources in the same region are preferred by the nearest strategy
+	Region string
+	// RefreshURL is invoked when a part request fails with 403, such as an expired pre-signed url (S3/CloudFront),
+	// the download continues with the returned url
+	RefreshURL func(old string) (string, error)
+	// ConnectTo maps the host:port of the url to the host:port actually connected to,
+	// like curl --resolve / --connect-to, e.g. {"cdn.example.com:443": "10.0.0.1:443"}
+	ConnectTo map[string]string
+	// Host overrides the Host header, useful to reach an origin by ip behind a shared load balancer
+	Host string
+	// ServerName overrides the TLS SNI and the verified certificate name, defaults to Host
+	ServerName string
+	// LocalAddr binds outgoing connections to the source ip, for multi-homed hosts (VPN + WAN, multiple uplinks)
+	LocalAddr string
+	// Interface binds outgoing connections to the first address of the network interface, e.g. eth1
+	Interface string
+	// Proxy is the proxy url, http://, socks5:// or socks5h://, with optional user:password,
+	// defaults to the HTTP_PROXY / HTTPS_PROXY environment
+	Proxy string
+	// ProxyChain is an ordered chain of proxies tunnelled through after Proxy,
+	// e.g. a bastion then tor
+	ProxyChain []string
+	// RangeBatch batches up to RangeBatch small parts into one multi-range request (multipart/byteranges),
+	// cutting the request overhead, zero or one requests the parts one by one
+	RangeBatch int
+	// RetryPolicy decides how failed parts are retried, defaults to DefaultRetryPolicy
+	RetryPolicy *RetryPolicy
+	// Timeouts sets the connect, tls handshake, response header and body read timeouts,
+	// on top of the limit of the request, which Timeouts.Total replaces when set
+	Timeouts *TimeoutConfig
+	// Transport tunes the connections: buffer sizes, socket buffers, Nagle and idle connections
+	Transport *TransportConfig
+	// DiskWriteRate caps the bytes written to the disk per second, zero means no limit,
+	// so bulk downloads to shared NAS or SD card storage don't starve other workloads
+	DiskWriteRate int64
+	// DiskWriteIOPS caps the writes to the disk per second, zero means no limit
+	DiskWriteIOPS int
+	// DirectIO writes the part and output files with O_DIRECT on linux, bypassing the page cache,
+	// for files far larger than the memory, buffered io is used where unsupported
+	DirectIO bool
+	// Sync fsyncs the part files on completion, and the output file and its directory after the rename,
+	// so a power loss right after a successful download can't leave a torn file
+	Sync bool
+	// IfExists decides what to do when the file already exists, overwrite (default), rename or resume,
+	// rename downloads to file (1).ext, file (2).ext and so on, safe with concurrent downloads,
+	// resume keeps a shorter file and downloads only the rest, like curl -C -
+	IfExists string
+	// FileMode is the permission of the downloaded file, default is DefaultFileMode (0644),
+	// the missing directories are created with it plus x wherever r is set (0755)
+	FileMode os.FileMode
+	// IsCreateDirDisabled fails the download when the directory of FilePath does not exist,
+	// instead of creating it, for strict environments
+	IsCreateDirDisabled bool
+	// NoExtensionGuess keeps the name exactly as supplied, or the url basename,
+	// without appending an extension guessed from the content type or magic bytes, for byte-exact mirroring
+	NoExtensionGuess bool
+	// QuarantineDir keeps the completed file in this directory until every verification passes,
+	// only then it is moved to FilePath, a file failing it is removed and never reaches the destination
+	QuarantineDir string
+	// Scan is called on the complete file before it is moved to FilePath, e.g. to call clamd or an ICAP service,
+	// an error removes the file and fails the download with a *ScanError
+	Scan func(path string) error
+	// Quota bounds the whole job with a wall-clock budget, a redirect cap and a total retry cap,
+	// exceeding one fails the download with a *QuotaError
+	Quota *QuotaConfig
+	// ExpectContentType aborts the download before anything is written when the server returns another content type,
+	// e.g. video/mp4 for an exact match or video/ for any video, catching error pages saved as the file
+	ExpectContentType string
+	// ExpectedChecksum is the checksum the file must match for the download to succeed, as algo:digest,
+	// e.g. sha256:<hex> or sha256-<base64>, md5, sha1, sha256 and sha512 are supported,
+	// a mismatched file is removed and the download fails with ErrChecksumMismatch
+	ExpectedChecksum string
+	// KeepInvalid keeps the files failing the verification with the .invalid suffix instead of removing them
+	KeepInvalid bool
+	// ExpectedSize is the size the file must have, a different Content-Length aborts before the transfer,
+	// a different final size fails the download with ErrSizeMismatch
+	ExpectedSize int64
+	// Client sends the requests instead of the downloader own client, e.g. httptest.Server.Client(),
+	// the connection options (Proxy, ConnectTo, LocalAddr, Interface, Transport, ...) are then ignored
+	Client *http.Client
+	// Range downloads only the bytes from Start to End (inclusive) of the remote file,
+	// e.g. one member of a large archive, an End before Start means up to the end of the file
+	Range *Range
+	// DedupIndex is the path of a json index of the downloaded files (url, validators and path),
+	// a file downloaded again is reused when the remote ETag or Last-Modified and the local file did not change,
+	// linked or copied when the file path differs, so repeated downloads of the same dependencies are immediate,
+	// the processes sharing it update it in turn, holding its .lock file
+	DedupIndex string
+	// ContentStore is a directory storing the downloaded files by digest, <store>/sha256/ab/abcd...,
+	// instead of the file path, Downloader.Digest and Downloader.FilePath are set once stored,
+	// identical files are stored once across downloads
+	ContentStore string
+	// ContentStoreAlgo is the digest algorithm of ContentStore, md5, sha1, sha256 (default) or sha512
+	ContentStoreAlgo string
+	// Cache is a http cache of the downloaded files, e.g. NewDirCache(dir), a fresh file (Cache-Control, Expires)
+	// is used without request and a stale one is revalidated with its ETag or Last-Modified, a 304 saving the transfer
+	Cache Cache
+	// LockFile coordinates the processes of the machine downloading the same url with a lock file in TmpDir,
+	// only one downloads it while the others wait, then link or copy the downloaded file
+	LockFile bool
+	// Object uploads the file to a cloud object instead of the file path, s3://bucket/key or gs://bucket/key,
+	// the segments are multipart uploaded as they arrive, so no local disk space is needed, each part retried with RetryPolicy,
+	// a failed download aborts the upload, Downloader.FilePath is then the object url
+	Object string
+	// ObjectStore uploads Object, default is NewS3Store with the AWS_* environment variables,
+	// or the S3 compatible api of Google Cloud Storage with GCS_ACCESS_KEY_ID and GCS_SECRET_ACCESS_KEY for gs://
+	ObjectStore ObjectStore
+	// Sink receives the file instead of the file path, e.g. NewMemorySink(), NewWebDAVSink(url, nil),
+	// NewObjectSink(store, "s3://bucket/dir/") or a custom one, the file is streamed to it in order and verified
+	// before it is committed, Downloader.FilePath is then the location of the file in the sink
+	Sink Sink
+	// Pipe feeds the file to the stdin of the command line, run by the shell, e.g. "ffmpeg -i - out.mkv",
+	// the segments are downloaded in parallel ahead of the read position, see NewShellSink
+	Pipe string
+	// OnCheckpoint is called once each part of a ranges download is written (and fsynced with Sync), in turn,
+	// bytesTotal counting the bytes of the file on disk so far, the parts resumed from a previous run included,
+	// so an orchestrator can record restart points or track the transfer
+	OnCheckpoint func(part FilePart, bytesTotal int64)
+	// OnProgress is called every DefaultProgressInterval during the download and once at the end,
+	// the bytes are counted as they stream into each part, see Downloader.Progress
+	OnProgress func(progress Progress)
+	// SpeedWindow is the window Progress.Speed is sampled over, default is DefaultSpeedWindow (1s)
+	SpeedWindow time.Duration
+	// SpeedSmoothing is the weight (0 to 1) of each new sample in Progress.Speed, an exponentially weighted
+	// moving average, 1 reports the last sample as is (jumpy), lower is smoother, default is DefaultSpeedSmoothing (0.3)
+	SpeedSmoothing float64
+	// ETA estimates Progress.ETA, NewEWMAETA (default), NewAverageETA, NewRegressionETA or a custom one,
+	// an estimator keeps the samples of a single download
+	ETA ETAEstimator
+	// Registry lists the download while in flight, with its progress, default is DefaultRegistry
+	Registry *Registry
+	// History is a jsonl file a HistoryRecord is appended to once the download completes or fails
+	// (url, path, size, duration, average speed, checksum, error), for auditing and bandwidth accounting
+	History string
+	// OnHistory receives the HistoryRecord of the download once it completes or fails
+	OnHistory func(record HistoryRecord)
+	// IsPacingDisabled keeps the concurrency of a host answering 429 or 503, by default the requests in flight
+	// to the host are halved on each of them and raised by one every DefaultPacingRecovery successes,
+	// for the rest of the process, shared by the downloads
+	IsPacingDisabled bool
+	// PolitenessDelay is the minimum delay between two requests to the same host, the range requests
+	// and the other downloads of the process included, e.g. for DownloadBatch mirroring jobs, zero means none
+	PolitenessDelay time.Duration
+	// Robots fetches the robots.txt of the host and fails the download with ErrRobotsDisallowed when its rules
+	// for the user agent disallow the url, a robots.txt failing with a server error disallows it too, a missing
+	// one allows it, an unreachable one fails the download, its crawl delay raises PolitenessDelay.
+	// DownloadBatch reports the disallowed files as skipped.
+	Robots bool
+	// WorkerPool is shared by downloads to bound the parts they download at the same time, all together,
+	// each download still runs at most DefaultConcurrency of them, DownloadBatch shares one of
+	// DefaultBatchWorkers workers by default
+	WorkerPool *WorkerPool
+	// Priority orders the parts waiting for a worker of WorkerPool, higher first, so an interactive download
+	// gets the workers before the background ones as their parts in flight complete, default is 0
+	Priority int
+	// Sequential downloads the segments in order, Lookahead of them ahead, and appends them to the file
+	// with the StagingSuffix as they complete, so a media player can play it while it downloads,
+	// trading the peak throughput for a partial file without holes, it is renamed once complete
+	Sequential bool
+	// Lookahead is the number of segments downloaded ahead of the read position by Sequential and Open,
+	// default is DefaultConcurrency
+	Lookahead int
+	// PartOrder is the order the parts are downloaded in, PartOrderSequential (default) or PartOrderFirstLast,
+	// the head and the tail first, so OnCheckpoint can hand them to a preview or a metadata probe long before
+	// the download finishes
+	PartOrder string
+	// Serve is the address (host:port) of a local http server serving the file while it downloads, with ranges,
+	// so a video player or another tool can read it before it completes, see Handler and ServeURL
+	Serve string
+	// ServeHoles is how a request of bytes not downloaded yet is served, ServeHolesBlock (default) waits for them,
+	// ServeHolesUnavailable answers 503, or the bytes downloaded from the start of a range
+	ServeHoles string
+	// StagingSuffix is appended to the file name while it downloads, renamed once complete, so a user or
+	// a file watcher tells the complete files apart, default is .download, e.g. .part or .crdownload
+	StagingSuffix string
+	// Xattrs tags the downloaded file with its source url, without its credentials and query, etag, download time
+	// and checksum in extended attributes (user.xdg.origin.url ...), for later audits, on the file systems
+	// supporting them, see ReadXattrs
+	Xattrs bool
+	// Sidecar writes the metadata of the downloaded file next to it as <file>.meta.json, its url, headers of
+	// interest, size, checksums, timing and mirrors, for the pipelines without extended attributes, see Sidecar
+	Sidecar bool
+	// PartialPath is a partial file left by another tool, wget, curl -C or a browser .crdownload, copied as
+	// the start of the file, the download resumes from its size, see ResumeVerifySize. It is left untouched.
+	PartialPath string
+	// ResumeVerifySize is the size of the end of a resumed file compared with the remote by a ranged request
+	// before resuming, a file which differs is downloaded again from the start, 0 does not compare
+	ResumeVerifySize int
+	// AutoConcurrency tunes the parts downloaded at the same time during the download, from DefaultConcurrency:
+	// a connection is added while it raises the throughput, removed when it did not or the latency of the requests
+	// grows, checked every DefaultConcurrencyInterval, see Progress.Connections
+	AutoConcurrency bool
+	// MaxConcurrency caps the parts downloaded at the same time by AutoConcurrency, default is DefaultMaxConcurrency
+	MaxConcurrency int
+	// HedgeRequests duplicates the request of the slowest part left to another mirror, or connection, once every
+	// part is started and it ran for DefaultHedgeDelay, the first copy complete is kept, the other cancelled
+	HedgeRequests bool
+	// ProbeTimeout limits each request probing the file before the download, the HEAD and the range request
+	// checking the range support, a probe cut by it fails with a timeout, zero means the request timeout
+	ProbeTimeout time.Duration
+	// IsProbeDisabled skips the HEAD request for the servers where it hangs or fails, the file is downloaded
+	// in ranges from ExpectedSize, without the validators nor the name of the response, or with a single request
+	// with IsRangesDisabled
+	IsProbeDisabled bool
+	// SegmentTimeout limits each request of a part, one attempt the retry policy may follow with another, while
+	// Quota.MaxTime limits the whole download; zero scales it with the size of the part, DefaultSegmentTimeout plus
+	// its transfer at DefaultSegmentMinSpeed. Timeouts.Total replaces it.
+	SegmentTimeout time.Duration
 }
 
 // New returns a new downloader
@@ -98,19 +554,39 @@ func New(url string, config *Config) *Downloader {
 	if config.SegmentSize > 0 {
 		SegmentSize = config.SegmentSize
 	}
+	if len(config.PieceHashes) > 0 && config.PieceLength > 0 {
+		SegmentSize = config.PieceLength
+	}
 	if config.TmpDir != "" {
 		TmpDir = config.TmpDir
 	}
+	// FilePath takes precedence over Dir and Name
 	if config.FilePath != "" {
-		FileDir = fs.DirName(config.FilePath)
-		paths := strings.Split(config.FilePath, "/")
-		last := paths[len(paths)-1]
-		exts := strings.Split(last, ".")
-		if len(exts) > 1 {
-			FileName = strings.Join(exts[:len(exts)-1], ".")
-			FileExt = exts[len(exts)-1]
-		} else {
-			FileName = last
+		FileDir = filepath.Dir(config.FilePath)
+		FileName, FileExt = splitFileName(filepath.Base(config.FilePath))
+	} else {
+		if config.Dir != "" {
+			FileDir = config.Dir
+		}
+		if config.Name != "" {
+			FileName, FileExt = splitFileName(config.Name)
+		}
+	}
+	// relative paths are relative to the current directory when the downloader is created
+	if abs, err := filepath.Abs(FileDir); err == nil {
+		FileDir = abs
+	}
+
+	QuarantineDir := config.QuarantineDir
+	if QuarantineDir != "" {
+		if abs, err := filepath.Abs(QuarantineDir); err == nil {
+			QuarantineDir = abs
+		}
+	}
+	ContentStore := config.ContentStore
+	if ContentStore != "" {
+		if abs, err := filepath.Abs(ContentStore); err == nil {
+			ContentStore = abs
 		}
 	}
 	if config.IsRangesDisabled {
@@ -118,14 +594,99 @@ func New(url string, config *Config) *Downloader {
 	}
 
 	return &Downloader{
-		URL:              url,
-		SegmentSize:      SegmentSize,
-		TmpDir:           TmpDir,
-		FileDir:          FileDir,
-		FileName:         FileName,
-		FileExt:          FileExt,
-		IsRangesDisabled: IsRangesDisabled,
+		URL:                  url,
+		SegmentSize:          SegmentSize,
+		TmpDir:               TmpDir,
+		FileDir:              FileDir,
+		FileName:             FileName,
+		FileExt:              FileExt,
+		IsRangesDisabled:     IsRangesDisabled,
+		Capture:              config.Capture,
+		IsExtractorsDisabled: config.IsExtractorsDisabled,
+		Mirrors:              config.Mirrors,
+		PieceHashes:          config.PieceHashes,
+		Consensus:            config.Consensus,
+		MirrorStrategy:       config.MirrorStrategy,
+		MirrorRegions:        config.MirrorRegions,
+		Region:               config.Region,
+		RefreshURL:           config.RefreshURL,
+		ConnectTo:            config.ConnectTo,
+		Host:                 config.Host,
+		ServerName:           config.ServerName,
+		LocalAddr:            config.LocalAddr,
+		Interface:            config.Interface,
+		Proxy:                config.Proxy,
+		ProxyChain:           config.ProxyChain,
+		RangeBatch:           config.RangeBatch,
+		RetryPolicy:          config.RetryPolicy,
+		Timeouts:             config.Timeouts,
+		Transport:            config.Transport,
+		DiskWriteRate:        config.DiskWriteRate,
+		DiskWriteIOPS:        config.DiskWriteIOPS,
+		DirectIO:             config.DirectIO,
+		Sync:                 config.Sync,
+		IfExists:             config.IfExists,
+		FileMode:             config.FileMode,
+		IsCreateDirDisabled:  config.IsCreateDirDisabled,
+		NoExtensionGuess:     config.NoExtensionGuess,
+		QuarantineDir:        QuarantineDir,
+		Scan:                 config.Scan,
+		Quota:                config.Quota,
+		ExpectContentType:    config.ExpectContentType,
+		ExpectedChecksum:     config.ExpectedChecksum,
+		KeepInvalid:          config.KeepInvalid,
+		ExpectedSize:         config.ExpectedSize,
+		Client:               config.Client,
+		Range:                config.Range,
+		DedupIndex:           config.DedupIndex,
+		ContentStore:         ContentStore,
+		ContentStoreAlgo:     config.ContentStoreAlgo,
+		Cache:                config.Cache,
+		LockFile:             config.LockFile,
+		Object:               config.Object,
+		ObjectStore:          config.ObjectStore,
+		Sink:                 config.Sink,
+		Pipe:                 config.Pipe,
+		OnCheckpoint:         config.OnCheckpoint,
+		OnProgress:           config.OnProgress,
+		SpeedWindow:          config.SpeedWindow,
+		SpeedSmoothing:       config.SpeedSmoothing,
+		ETA:                  config.ETA,
+		Registry:             config.Registry,
+		History:              config.History,
+		OnHistory:            config.OnHistory,
+		IsPacingDisabled:     config.IsPacingDisabled,
+		PolitenessDelay:      config.PolitenessDelay,
+		Robots:               config.Robots,
+		WorkerPool:           config.WorkerPool,
+		Priority:             config.Priority,
+		Sequential:           config.Sequential,
+		Lookahead:            config.Lookahead,
+		PartOrder:            config.PartOrder,
+		Serve:                config.Serve,
+		ServeHoles:           config.ServeHoles,
+		StagingSuffix:        config.StagingSuffix,
+		Xattrs:               config.Xattrs,
+		Sidecar:              config.Sidecar,
+		PartialPath:          config.PartialPath,
+		ResumeVerifySize:     config.ResumeVerifySize,
+		AutoConcurrency:      config.AutoConcurrency,
+		MaxConcurrency:       config.MaxConcurrency,
+		HedgeRequests:        config.HedgeRequests,
+		ProbeTimeout:         config.ProbeTimeout,
+		IsProbeDisabled:      config.IsProbeDisabled,
+		SegmentTimeout:       config.SegmentTimeout,
+		progress:             newProgressCounter(config.SpeedWindow, config.SpeedSmoothing, config.ETA),
+		isFileNameSupplied:   FileName != "",
+	}
+}
+
+func (d *Downloader) getContext() context.Context {
+	if d.ctx == nil {
+		return context.Background()
 	}
+
+	return d.ctx
 }
 
 func (d *Downloader) jsonify(i interface{}) (string, error) {
@@ -146,7 +707,18 @@ func (d *Downloader) getFilePath() string {
 		return ""
 	}
 
-	return fmt.Sprintf("%s/%s.%s", d.FileDir, d.FileName, d.FileExt)
+	name := d.FileName
+	if d.FileExt != "" {
+		name += "." + d.FileExt
+	}
+
+	return fixLongPath(filepath.Join(d.FileDir, name))
+}
+
+// splitFileName splits a file name into its name and extension without the dot, e.g. a.tar.gz into a.tar and gz
+func splitFileName(base string) (string, string) {
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext), strings.TrimPrefix(ext, ".")
 }
 
 func (d *Downloader) parseURL(u string) error {
@@ -156,15 +728,16 @@ func (d *Downloader) parseURL(u string) error {
 	}
 
 	if d.FileName == "" {
-		paths := strings.Split(parsedURL.Path, "/")
-		last := paths[len(paths)-1]
-		exts := strings.Split(last, ".")
-		if len(exts) > 1 {
-			d.FileName = strings.Join(exts[:len(exts)-1], ".")
-			d.FileExt = exts[len(exts)-1]
-		} else {
-			d.FileName = last
+		// url paths are always separated by /, whatever the os
+		base := path.Base(parsedURL.Path)
+		if base == "/" || base == "." {
+			base = ""
+		}
+		name := cleanFileName(base)
+		if name == "" {
+			name = DefaultFileName
 		}
+		d.FileName, d.FileExt = splitFileName(name)
 	}
 
 	return nil
@@ -176,22 +749,61 @@ func (d *Downloader) parseContentInfo() error {
 	// 1. content type
 	d.ContentType = headers.Get("Content-Type")
 
-	// 2. content length
-	contentLengthRaw := headers.Get("Content-Length")
-	contentLengthInt, _ := strconv.Atoi(contentLengthRaw)
-	if contentLengthInt > 0 {
-		d.ContentLength = int64(contentLengthInt)
+	// 2. content length, unknown when absent
+	contentLength, err := parseContentLength(headers)
+	if err != nil {
+		return newProtocolError(err)
+	}
+	if contentLength > 0 {
+		d.ContentLength = contentLength
+	}
+
+	// 3. validators
+	d.ETag = headers.Get("ETag")
+	d.LastModified = headers.Get("Last-Modified")
+
+	// 4. file name, the server name replaces the url one, not the supplied or extracted one
+	if !d.isFileNameSupplied && !d.NoExtensionGuess && d.PageURL == "" {
+		if name := parseDispositionFileName(headers.Get("Content-Disposition")); name != "" {
+			d.FileName, d.FileExt = splitFileName(name)
+		}
 	}
 
 	return nil
 }
 
+// parseContentLength returns the Content-Length of headers, -1 when absent; the values repeated by a proxy
+// (42, 42 or the header sent twice) are taken as one when they agree (RFC 9110 8.6), an invalid or
+// conflicting value is an error, never a size of 0.
+func parseContentLength(headers http.Header) (int64, error) {
+	values := headers.Values("Content-Length")
+	length := int64(-1)
+	for _, value := range values {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			n, err := strconv.ParseInt(field, 10, 64)
+			if err != nil || n < 0 || field[0] == '+' {
+				return -1, fmt.Errorf("invalid content length: %q", value)
+			}
+			if length >= 0 && n != length {
+				return -1, fmt.Errorf("conflicting content lengths: %q", strings.Join(values, ", "))
+			}
+			length = n
+		}
+	}
+
+	return length, nil
+}
+
 func (d *Downloader) parseRanges() error {
 	// 3. ranges
 	if d.ContentLength > 0 {
-		start := 0
-		end := int(d.ContentLength - 1)
-		for {
+		start, end, err := d.byteRange()
+		if err != nil {
+			return err
+		}
+
+		for start <= end {
 			if start+d.SegmentSize > end {
 				d.Ranges = append(d.Ranges, &Range{
 					Start: start,
@@ -213,6 +825,11 @@ func (d *Downloader) parseRanges() error {
 	return nil
 }
 
+// partName returns the name of the part file at index of the range start-end
+func partName(index int, start, end int64) string {
+	return fmt.Sprintf("part.%d.%d.%d", index, start, end)
+}
+
 func (d *Downloader) parseFileParts() error {
 	if len(d.Ranges) == 0 {
 		return nil
@@ -220,8 +837,8 @@ func (d *Downloader) parseFileParts() error {
 
 	for i, r := range d.Ranges {
 		// Name := fmt.Sprintf("%s.%s.part.%d.%d.%d", d.FileName, d.FileExt, i, r.Start, r.End)
-		Name := fmt.Sprintf("part.%d.%d.%d", i, r.Start, r.End)
-		Path := fs.JoinPath(d.TmpDir, d.Hash, Name)
+		Name := partName(i, r.Start, r.End)
+		Path := fixLongPath(filepath.Join(d.TmpDir, d.Hash, Name))
 		filePart := &FilePart{
 			Name:       Name,
 			Path:       Path,
@@ -239,42 +856,27 @@ func (d *Downloader) parseFileParts() error {
 }
 
 func (d *Downloader) parseFileInfo() error {
-	if d.FileExt == "" {
-		if d.ContentType == "video/mp4" {
-			d.FileExt = "mp4"
-		} else if d.ContentType == "video/webm" {
-			d.FileExt = "webm"
-		} else if d.ContentType == "video/ogg" {
-			d.FileExt = "ogg"
-		} else if d.ContentType == "video/x-flv" {
-			d.FileExt = "flv"
-		} else if d.ContentType == "video/x-ms-wmv" {
-			d.FileExt = "wmv"
-		} else if d.ContentType == "video/x-msvideo" {
-			d.FileExt = "avi"
-		} else if d.ContentType == "video/x-matroska" {
-			d.FileExt = "mkv"
-		} else if d.ContentType == "video/mpeg" {
-			d.FileExt = "mpg"
-		} else if d.ContentType == "video/quicktime" {
-			d.FileExt = "mov"
-		} else if d.ContentType == "video/x-ms-asf" {
-			d.FileExt = "asf"
-		} else if d.ContentType == "video/x-ms-wm" {
-			d.FileExt = "wm"
-		} else if d.ContentType == "video/x-ms-wmx" {
-			d.FileExt = "wmx"
-		} else if d.ContentType == "video/x-ms-wvx" {
-			d.FileExt = "wvx"
-		} else if d.ContentType == "video/x-ms-wax" {
-			d.FileExt = "wax"
-		} else if d.ContentType == "audio/mpeg" {
-			d.FileExt = "mp3"
-		} else if d.ContentType == "audio/x-ms-wma" {
-			d.FileExt = "wma"
-		} else {
-			return errors.New("unsupported content type: " + d.ContentType)
+	if d.NoExtensionGuess {
+		return nil
+	}
+
+	// the headers are useless, guess from the magic bytes
+	if d.FileExt == "" && isGenericContentType(d.ContentType) {
+		ext, err := d.sniffFileExt()
+		if err != nil {
+			return err
 		}
+
+		d.FileExt = ext
+	}
+
+	if d.FileExt == "" {
+		d.FileExt = contentTypeExt(d.ContentType)
+	}
+
+	// the name supplied by the user is kept as is, rather than failing
+	if d.FileExt == "" && !d.isFileNameSupplied {
+		return errors.New("unsupported content type: " + d.ContentType)
 	}
 
 	return nil
@@ -288,6 +890,10 @@ func (d *Downloader) parseHash() error {
 		// d.FileName,
 		// d.FileExt,
 	}
+	// a range is another file, its parts must not be mixed with the parts of the whole file
+	if d.Range != nil {
+		data = append(data, d.rangeHeader())
+	}
 
 	d.Hash = md5.Md5(strings.Join(data, "-"))
 	return nil
@@ -298,6 +904,14 @@ func (d *Downloader) parse() error {
 		return err
 	}
 
+	if err := d.checkContentType(d.ContentType); err != nil {
+		return err
+	}
+
+	if err := d.checkExpectedSize(d.targetLength()); err != nil {
+		return err
+	}
+
 	if err := d.parseRanges(); err != nil {
 		return err
 	}
@@ -314,75 +928,235 @@ func (d *Downloader) parse() error {
 		return err
 	}
 
+	if err := d.parseSources(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func (d *Downloader) checkSupportRange() (bool, error) {
-	response, err := fetch.Head(d.URL)
+	if _, err := d.probe(); err != nil {
+		return d.IsSupportRange, err
+	}
+
+	return d.IsSupportRange, nil
+}
+
+// probeSupportRange checks range support with a real range request when HEAD does not tell
+func (d *Downloader) probeSupportRange(ctx context.Context, headHeaders http.Header) (bool, error) {
+	response, err := d.fetchGet(d.URL, &requestConfig{
+		Headers: map[string]string{
+			"Range": "bytes=0-1",
+		},
+		Context: ctx,
+	})
 	if err != nil {
 		return d.IsSupportRange, err
 	}
 
-	if response.Headers.Get("Accept-Ranges") == "bytes" {
-		d.IsSupportRange = true
-		d.HeadHeaders = response.Headers.Clone()
+	if response.Status != http.StatusPartialContent {
 		return d.IsSupportRange, nil
 	}
 
+	// Content-Range: bytes 0-1/35519965
+	total := int64(0)
+	if i := strings.LastIndex(response.Headers.Get("Content-Range"), "/"); i != -1 {
+		total, _ = strconv.ParseInt(response.Headers.Get("Content-Range")[i+1:], 10, 64)
+	}
+	if total <= 0 {
+		return d.IsSupportRange, nil
+	}
+
+	d.IsSupportRange = true
+	d.HeadHeaders = headHeaders.Clone()
+	// the total size is authoritative, HEAD may omit it or be rejected
+	d.HeadHeaders.Set("Content-Length", strconv.FormatInt(total, 10))
+	if d.HeadHeaders.Get("Content-Type") == "" {
+		d.HeadHeaders.Set("Content-Type", response.Headers.Get("Content-Type"))
+	}
+	if d.HeadHeaders.Get("ETag") == "" {
+		d.HeadHeaders.Set("ETag", response.Headers.Get("ETag"))
+	}
+	if d.HeadHeaders.Get("Last-Modified") == "" {
+		d.HeadHeaders.Set("Last-Modified", response.Headers.Get("Last-Modified"))
+	}
+	if d.HeadHeaders.Get("Content-Disposition") == "" {
+		d.HeadHeaders.Set("Content-Disposition", response.Headers.Get("Content-Disposition"))
+	}
+
 	return d.IsSupportRange, nil
 }
 
+// isFilePartDone returns true if the part is complete on disk and matches its piece hash
+func (d *Downloader) isFilePartDone(part *FilePart) bool {
+	return fs.IsExist(part.Path) && fs.Size(part.Path) == part.RangeEnd-part.RangeStart+1 && d.verifyFilePart(part) == nil
+}
+
 func (d *Downloader) downloadFilePart(part *FilePart) error {
+	return d.downloadFilePartExcluding(d.getContext(), part, nil, nil)
+}
+
+// downloadFilePartExcluding downloads the part from the best source not in excluded, with ctx,
+// the urls requested are recorded in used unless it is nil.
+func (d *Downloader) downloadFilePartExcluding(ctx context.Context, part *FilePart, excluded, used map[string]bool) error {
 	// 1. check file part
 	if fs.IsExist(part.Path) {
-		if fs.Size(part.Path) == int64(part.RangeEnd-part.RangeStart+1) {
+		if d.isFilePartDone(part) {
 			return nil
 		}
+
+		if err := os.Remove(part.Path); err != nil {
+			return err
+		}
 	}
+	d.setPartState(part, PartDownloading)
+	d.countPartAttempt(part)
 
 	//
-	dirPath := fs.DirName(part.Path)
+	dirPath := filepath.Dir(part.Path)
 	if !fs.IsExist(dirPath) {
 		if err := fs.Mkdir(dirPath); err != nil {
 			return err
 		}
 	}
 
-	// 2. download file part
-	response, err := fetch.Download(d.URL, part.Path, &fetch.Config{
-		Headers: map[string]string{
-			"Range": fmt.Sprintf("bytes=%d-%d", part.RangeStart, part.RangeEnd),
-		},
-		Timeout: 120 * time.Second,
+	// 2. download file part from the fastest source
+	tried := map[string]bool{}
+	for url := range excluded {
+		tried[url] = true
+	}
+	isRefreshed := false
+	var lastErr error
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		src, url := d.sources.pick(tried)
+		if src == nil {
+			if lastErr == nil {
+				lastErr = errors.New("no source available")
+			}
+			return lastErr
+		}
+		tried[url] = true
+		if used != nil {
+			used[url] = true
+		}
+
+		startedAt := time.Now()
+		err := d.downloadFilePartFrom(ctx, url, part)
+		if err == nil {
+			err = d.verifyFilePart(part)
+		}
+		if err != nil {
+			err = annotate(err, url, part, 0)
+		}
+		d.sources.done(src, part.RangeEnd-part.RangeStart+1, time.Since(startedAt), err)
+		if err == nil {
+			if !d.isConsensusEnabled() {
+				return nil
+			}
+
+			// an unconfirmed part is never reused
+			if err := d.checkConsensus(ctx, part, url); err != nil {
+				os.Remove(part.Path)
+				return err
+			}
+
+			return nil
+		}
+
+		if os.Getenv("DEBUG") == "true" {
+			fmt.Println("failed to download part:", part.Index, "from", url, err)
+		}
+
+		lastErr = err
+		if fs.IsExist(part.Path) {
+			if err := os.Remove(part.Path); err != nil {
+				return err
+			}
+		}
+
+		// the status is not worth another mirror
+		switch d.retryPolicy().statusAction(err) {
+		case StatusActionRetry, StatusActionFatal:
+			return err
+		}
+
+		// pre-signed url expired, retry the same source with a re-signed url
+		if errors.Is(err, ErrForbidden) && d.RefreshURL != nil && !isRefreshed {
+			if err := d.refreshSource(src, url); err != nil {
+				return err
+			}
+
+			isRefreshed = true
+		}
+	}
+}
+
+func (d *Downloader) downloadFilePartFrom(ctx context.Context, url string, part *FilePart) error {
+	// the request of a hedged part has a context of its own, cancelled if the hedge wins
+	if h := d.startHedgeable(ctx, part, url); h != nil {
+		return d.hedges.finish(h, d.fetchFilePart(h.ctx, url, part))
+	}
+
+	return d.fetchFilePart(ctx, url, part)
+}
+
+// fetchFilePart requests the part from url, with ctx instead of the context of the downloader unless it is nil
+func (d *Downloader) fetchFilePart(ctx context.Context, url string, part *FilePart) error {
+	headers := map[string]string{
+		"Range": fmt.Sprintf("bytes=%d-%d", part.RangeStart, part.RangeEnd),
+	}
+	// a changed file is sent whole (200) instead of being spliced onto the other parts
+	validator := d.validator()
+	if validator != "" {
+		headers["If-Range"] = validator
+	}
+
+	response, err := d.fetchDownload(url, part.Path, &requestConfig{
+		Headers: headers,
+		Timeout: d.segmentTimeout(part.RangeEnd - part.RangeStart + 1),
+		Context: ctx,
 	})
 	if err != nil {
-		return err
+		return classify(err)
+	}
+
+	if response.Status == http.StatusOK && validator != "" {
+		return newRemoteChangedError(validator, response.Headers)
+	}
+
+	if response.Status != http.StatusPartialContent {
+		return newStatusError(response.Status)
 	}
 
 	// Valid
 	// Content-Range: bytes 0-10485759/35519965
 	contentRangeRaw := response.Headers.Get("Content-Range")
 	if contentRangeRaw == "" {
-		return errors.New("no content range")
+		return newProtocolError(errors.New("no content range"))
 	}
 	contentRangeParts := strings.Split(contentRangeRaw, " ")
 	if len(contentRangeParts) != 2 {
-		return errors.New("invalid content range (1): bytes")
+		return newProtocolError(errors.New("invalid content range (1): bytes"))
 	}
 	contentRangeParts = strings.Split(contentRangeParts[1], "/")
 	if len(contentRangeParts) != 2 {
-		return errors.New("invalid content range (2): range/total")
+		return newProtocolError(errors.New("invalid content range (2): range/total"))
 	}
 	if contentRangeParts[0] != fmt.Sprintf("%d-%d", part.RangeStart, part.RangeEnd) {
-		return errors.New("invalid content range (3): range error")
+		return newProtocolError(errors.New("invalid content range (3): range error"))
 	}
 	// Content-Length: 35519965
-	contentLength, err := strconv.Atoi(response.Headers.Get("Content-Length"))
+	contentLength, err := parseContentLength(response.Headers)
 	if err != nil {
-		return err
+		return newProtocolError(err)
 	}
 	if contentLength != part.RangeEnd-part.RangeStart+1 {
-		return errors.New("invalid content length")
+		return newProtocolError(errors.New("invalid content length"))
 	}
 
 	// d.printJSON(map[string]interface{}{
@@ -392,10 +1166,6 @@ func (d *Downloader) downloadFilePart(part *FilePart) error {
 	// d.printJSON(response.Headers)
 	// os.Exit(1)
 
-	if response.Status != http.StatusPartialContent {
-		return fmt.Errorf("invalid status: %d", response.Status)
-	}
-
 	// if err := fs.WriteFile(part.Path, response.Body); err != nil {
 	// 	return err
 	// }
@@ -403,42 +1173,58 @@ func (d *Downloader) downloadFilePart(part *FilePart) error {
 	return nil
 }
 
-func (d *Downloader) downloadFileParts() (err error) {
-	co := cocurrent.New(3)
-
-	for _, part := range d.FileParts {
-		co.Add(func(args ...interface{}) {
-			part := args[0].(*FilePart)
+func (d *Downloader) mergeFileParts() error {
+	parts := append([]*FilePart{}, d.FileParts...)
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].Index < parts[j].Index
+	})
 
-			if os.Getenv("DEBUG") == "true" {
-				fmt.Println("downloading part:", part.Index, part.Path)
-			}
+	// merge to the staging file, renamed by finalize, so the file is never seen torn
+	stagingPath := d.stagingPath()
+	create := d.createFile
+	if d.resumeOffset > 0 {
+		create = d.appendToFile
+	}
+	w, err := create(stagingPath)
+	if err != nil {
+		return err
+	}
 
-			if err := d.downloadFilePart(part); err != nil {
-				panic(err)
-			}
+	for _, part := range parts {
+		if err := appendFile(w, part.Path); err != nil {
+			w.Close()
+			d.discardMerge(stagingPath)
+			return err
+		}
+	}
 
-			// time.Sleep(1 * time.Second)
-		}, part)
+	if err := w.Close(); err != nil {
+		d.discardMerge(stagingPath)
+		return err
 	}
 
-	co.Wait()
-	return
+	return nil
 }
 
-func (d *Downloader) mergeFileParts() error {
-	parts := d.FileParts
-	filePath := d.getFilePath()
+// discardMerge removes a failed merge, keeping the bytes of a resumed file
+func (d *Downloader) discardMerge(stagingPath string) {
+	if d.resumeOffset > 0 {
+		os.Truncate(stagingPath, d.resumeOffset)
+		return
+	}
 
-	_parts := make([]*fs.FilePart, 0)
-	for _, part := range parts {
-		_parts = append(_parts, &fs.FilePart{
-			Path:  part.Path,
-			Index: part.Index,
-		})
+	os.Remove(stagingPath)
+}
+
+func appendFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	return fs.Merge(filePath, _parts)
+	_, err = io.Copy(w, f)
+	return err
 }
 
 func (d *Downloader) downloadByRanges() error {
@@ -449,6 +1235,10 @@ func (d *Downloader) downloadByRanges() error {
 	}
 
 	if !isSupportRange {
+		if d.IsProbeDisabled {
+			return errors.New("the size is needed to download in ranges without probing the file, set ExpectedSize or IsRangesDisabled")
+		}
+
 		return errors.New("server does not support range")
 	}
 
@@ -458,12 +1248,92 @@ func (d *Downloader) downloadByRanges() error {
 		return err
 	}
 
+	if err := d.ensureFileDir(); err != nil {
+		return err
+	}
+
+	if err := d.reserveFilePath(); err != nil {
+		return err
+	}
+
+	if err := d.ensureQuarantineDir(); err != nil {
+		return err
+	}
+
+	if err := d.prepareResume(d.ContentLength); err != nil {
+		return err
+	}
+
+	if d.resumeOffset > 0 {
+		// only the rest of the file is split in parts, appended to the existing bytes once downloaded
+		d.Ranges, d.FileParts = nil, nil
+		if err := d.parseRanges(); err != nil {
+			return err
+		}
+
+		if err := d.parseFileParts(); err != nil {
+			return err
+		}
+	}
+
 	if os.Getenv("DEBUG") == "true" {
 		d.printJSON(d)
 	}
 
 	// 2. Download file.
+	if len(d.Mirrors) > 0 {
+		d.checkSourcesHealth()
+
+		switch d.MirrorStrategy {
+		case MirrorStrategyRace:
+			if err := d.race(); err != nil {
+				return err
+			}
+		case MirrorStrategyNearest:
+			d.probeSources()
+		}
+	}
+
+	return d.downloadParts()
+}
+
+// downloadParts downloads the parts missing on disk and merges them into the staging file
+func (d *Downloader) downloadParts() error {
+	if err := d.checkRemoteChanged(); err != nil {
+		return err
+	}
+
+	if err := d.flushManifest(); err != nil {
+		return err
+	}
+
 	if err := d.downloadFileParts(); err != nil {
+		if ctxErr := d.getContext().Err(); ctxErr != nil {
+			return d.interrupted(ctxErr)
+		}
+
+		// the file changed during the download, start over once with the new file
+		if errors.Is(err, ErrRemoteChanged) && !d.isRestarted {
+			if os.Getenv("DEBUG") == "true" {
+				fmt.Println("remote file changed, restarting:", err)
+			}
+
+			d.isRestarted = true
+			if err := d.resetParts(); err != nil {
+				return err
+			}
+
+			return d.downloadByRanges()
+		}
+
+		return err
+	}
+
+	if err := d.repairParts(); err != nil {
+		if ctxErr := d.getContext().Err(); ctxErr != nil {
+			return d.interrupted(ctxErr)
+		}
+
 		return err
 	}
 
@@ -474,14 +1344,69 @@ func (d *Downloader) downloadByRanges() error {
 	return nil
 }
 
+// prepareDirect prepares the directories and the file path of a single request download
+func (d *Downloader) prepareDirect() error {
+	if err := d.ensureFileDir(); err != nil {
+		return err
+	}
+
+	if err := d.reserveFilePath(); err != nil {
+		return err
+	}
+
+	return d.ensureQuarantineDir()
+}
+
 func (d *Downloader) downloadByDirect() error {
-	response, err := fetch.Download(d.URL, d.getFilePath())
+	// the staging file is kept on failure, continued by the next attempt or run
+	if d.Range == nil {
+		if err := d.prepareDirectResume(); err != nil {
+			return err
+		}
+
+		return d.downloadByAppend()
+	}
+
+	err := d.do(http.MethodGet, d.URL, d.directRequestConfig(), func(resp *http.Response, body io.Reader) error {
+		if err := d.checkDirectResponse(resp); err != nil {
+			return err
+		}
+
+		return d.writeFile(d.stagingPath(), body)
+	})
 	if err != nil {
+		return classify(err)
+	}
+
+	return nil
+}
+
+// checkDirectResponse checks the response of a direct download before the body is written
+func (d *Downloader) checkDirectResponse(resp *http.Response) error {
+	if d.Range != nil {
+		if err := d.checkRangeResponse(resp); err != nil {
+			return err
+		}
+	} else if resp.StatusCode != http.StatusOK {
+		return newStatusError(resp.StatusCode)
+	}
+
+	if err := d.checkContentType(resp.Header.Get("Content-Type")); err != nil {
 		return err
 	}
 
-	if response.Status != http.StatusOK {
-		return fmt.Errorf("invalid status: %d", response.Status)
+	if err := d.checkExpectedSize(resp.ContentLength); err != nil {
+		return err
+	}
+
+	if d.Range == nil && resp.ContentLength > 0 {
+		d.ContentLength = resp.ContentLength
+	}
+	d.responseHeaders = resp.Header
+	if d.Range == nil && resp.ContentLength < 0 {
+		d.setProgressTotal(-1)
+	} else {
+		d.setProgressTotal(d.targetLength())
 	}
 
 	return nil
@@ -489,28 +1414,213 @@ func (d *Downloader) downloadByDirect() error {
 
 // Download downloads the file
 func (d *Downloader) Download() error {
+	return d.DownloadWithContext(context.Background())
+}
+
+// DownloadWithContext downloads the file, cancelling ctx aborts the in-flight requests immediately
+func (d *Downloader) DownloadWithContext(ctx context.Context) (err error) {
+	jobCtx, cancel := d.withMaxTime(ctx)
+	defer cancel()
+	d.ctx = jobCtx
+
+	startedAt := time.Now()
+	defer func() {
+		d.recordHistory(startedAt, err)
+	}()
+	defer d.registry().register(d)()
+	defer d.reportProgress()()
+
+	if d.Serve != "" {
+		stop, err := d.serve()
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+	defer func() {
+		servedPath := ""
+		if err == nil && d.sinkLocation == "" {
+			servedPath = d.FilePath
+		}
+		d.progress.finishServed(servedPath, err)
+	}()
+
+	download := d.download
+	if d.LockFile {
+		download = func() error {
+			return d.downloadWithLock(jobCtx)
+		}
+	}
+
+	if err := d.checkMaxTime(ctx, download()); err != nil {
+		// a failed download leaves no empty file behind
+		d.releaseFilePath()
+		return err
+	}
+
+	if d.sinkLocation != "" {
+		d.FilePath = d.sinkLocation
+		d.lastResult = d.result(startedAt)
+		return nil
+	}
+
+	d.FilePath = d.getFilePath()
+	if d.storedPath != "" {
+		d.FilePath = d.storedPath
+	}
+	d.lastResult = d.result(startedAt)
+
+	if d.Xattrs {
+		if err := d.writeXattrs(d.lastResult); err != nil && os.Getenv("DEBUG") == "true" {
+			fmt.Println("xattrs: failed to tag the file:", err)
+		}
+	}
+
+	if d.Sidecar {
+		if err := d.writeSidecar(d.lastResult, startedAt); err != nil && os.Getenv("DEBUG") == "true" {
+			fmt.Println("sidecar: failed to write:", err)
+		}
+	}
+
+	if err := d.recordDedupIndex(); err != nil && os.Getenv("DEBUG") == "true" {
+		fmt.Println("dedup: failed to record:", err)
+	}
+
+	if err := d.storeCache(); err != nil && os.Getenv("DEBUG") == "true" {
+		fmt.Println("cache: failed to store:", err)
+	}
+
+	return nil
+}
+
+func (d *Downloader) download() error {
+	// the probe and the parts of the failed download are kept
+	if d.isRetryingFailed {
+		if err := d.retryFailedParts(); err != nil {
+			return err
+		}
+
+		return d.finalize()
+	}
+
+	if err := d.retryPolicy().validate(); err != nil {
+		return err
+	}
+
+	if _, err := d.expectedChecksum(); err != nil {
+		return err
+	}
+
+	if err := d.validateRange(); err != nil {
+		return err
+	}
+
+	if err := d.validatePartOrder(); err != nil {
+		return err
+	}
+
+	if err := d.validateServeHoles(); err != nil {
+		return err
+	}
+
+	if err := d.validateStagingSuffix(); err != nil {
+		return err
+	}
+
+	if d.ContentStore != "" {
+		if _, _, err := d.contentStoreHash(); err != nil {
+			return err
+		}
+	}
+
+	// resolve page url to media url
+	if err := d.extract(); err != nil {
+		return err
+	}
+
 	// parse url get file info
 	err := d.parseURL(d.URL)
 	if err != nil {
 		return err
 	}
 
-	// download directory
+	if d.Robots {
+		if err := d.checkRobots(); err != nil {
+			return err
+		}
+	}
+
+	// capture live stream
+	if isStreamURL(d.URL) {
+		return d.downloadByCapture()
+	}
+
+	// stream to the sink, e.g. a cloud object
+	if sink := d.sink(); sink != nil {
+		return d.downloadToSink(sink)
+	}
+
+	// already downloaded and unchanged
+	if ok, err := d.downloadFromDedupIndex(); ok || err != nil {
+		return err
+	}
+
+	// cached and fresh, or not modified
+	if ok, err := d.downloadFromCache(); ok || err != nil {
+		return err
+	}
+
+	// in order, for the players reading the file while it downloads
+	if d.Sequential && !d.IsRangesDisabled {
+		return d.downloadSequential()
+	}
+
+	// download directory, the whole download is only retried with an explicit retry policy
 	if d.IsRangesDisabled {
-		return d.downloadByDirect()
+		if err := d.prepareDirect(); err != nil {
+			return err
+		}
+
+		if err := d.prepareResume(-1); err != nil {
+			return err
+		}
+
+		if d.RetryPolicy != nil {
+			err = d.retry(nil, d.downloadByDirect)
+		} else {
+			err = d.downloadByDirect()
+		}
+		if err != nil {
+			return err
+		}
+
+		return d.finalize()
 	}
 
 	// download with ranges
-	return d.downloadByRanges()
+	if err := d.downloadByRanges(); err != nil {
+		return err
+	}
+
+	return d.finalize()
+}
+
+// Download downloads the file by url and config, the result tells where the file landed
+func Download(url string, cfg ...*Config) (*Result, error) {
+	return DownloadWithContext(context.Background(), url, cfg...)
 }
 
-// Download downloads the file by url and config
-func Download(url string, cfg ...*Config) error {
+// DownloadWithContext downloads the file by url and config, cancelling ctx aborts the download
+func DownloadWithContext(ctx context.Context, url string, cfg ...*Config) (*Result, error) {
 	configX := &Config{}
 	if len(cfg) > 0 {
 		configX = cfg[0]
 	}
 
 	d := New(url, configX)
-	return d.Download()
+	if err := d.DownloadWithContext(ctx); err != nil {
+		return nil, err
+	}
+
+	return d.Result(), nil
 }
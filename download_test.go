@@ -1,14 +1,175 @@
 package download
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
 
 func TestDownload(t *testing.T) {
-	url := "https://cdn-transcode.jingdaka.com/video/2020/09/12/c8067037-f083-4c63-bb80-b09ce9e5ae20.mp4"
-	fileName := "test.mp4"
-	err := Download(url, &Config{
-		FilePath: fileName,
+	content := bytes.Repeat([]byte("0123456789"), 100000)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	filePath := t.TempDir() + "/test.mp4"
+	_, err := Download(server.URL+"/test.mp4", &Config{
+		FilePath: filePath,
+		TmpDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("unexpected content")
+	}
+}
+
+func newRangeServer(content []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func TestDownloadWithContextCancel(t *testing.T) {
+	// the server sends a few bytes of every range, then stalls
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Type", "video/mp4")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "1000")
+			return
+		}
+
+		var start, end int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/1000", start, end))
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("0123456789"))
+		w.(http.Flusher).Flush()
+
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	startedAt := time.Now()
+	d := New(server.URL+"/file.mp4", &Config{
+		FilePath:    t.TempDir() + "/cancel.mp4",
+		TmpDir:      t.TempDir(),
+		SegmentSize: 100,
+	})
+	err := d.DownloadWithContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrInterrupted) {
+		t.Errorf("expected interrupted by deadline, got %v", err)
+	}
+	if elapsed := time.Since(startedAt); elapsed > time.Second {
+		t.Errorf("expected in-flight reads to be aborted, took %s", elapsed)
+	}
+
+	data, err := os.ReadFile(d.manifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Parts) != 10 || manifest.Parts[0].IsDone {
+		t.Errorf("unexpected manifest: %s", data)
+	}
+}
+
+// noAcceptRangesWriter hides range support, like servers that never advertise Accept-Ranges
+type noAcceptRangesWriter struct {
+	http.ResponseWriter
+	isHead bool
+}
+
+func (w noAcceptRangesWriter) WriteHeader(code int) {
+	w.Header().Del("Accept-Ranges")
+	if w.isHead {
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func TestProbeSupportRange(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	var ranges int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt32(&ranges, 1)
+		}
+		w = noAcceptRangesWriter{w, r.Method == http.MethodHead}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	filePath := t.TempDir() + "/probed.mp4"
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:    filePath,
+		TmpDir:      t.TempDir(),
+		SegmentSize: 30,
 	})
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("expected %q, got %q", content, data)
+	}
+	// the probe and 4 parts
+	if ranges != 5 {
+		t.Errorf("expected 5 range requests, got %d", ranges)
+	}
+}
+
+func TestParseContentLength(t *testing.T) {
+	cases := []struct {
+		values   []string
+		expected int64
+		isErr    bool
+	}{
+		{nil, -1, false},
+		{[]string{"42"}, 42, false},
+		{[]string{"6442450944"}, 6442450944, false},
+		{[]string{"42, 42"}, 42, false},
+		{[]string{"42", "42"}, 42, false},
+		{[]string{"42", "43"}, -1, true},
+		{[]string{"42, 43"}, -1, true},
+		{[]string{"abc"}, -1, true},
+		{[]string{"-1"}, -1, true},
+		{[]string{"+42"}, -1, true},
+		{[]string{""}, -1, true},
+		{[]string{"99999999999999999999"}, -1, true},
+	}
+
+	for _, c := range cases {
+		headers := http.Header{"Content-Length": c.values}
+		length, err := parseContentLength(headers)
+		if (err != nil) != c.isErr || length != c.expected {
+			t.Errorf("%q: expected %d (error %v), got %d (%v)", c.values, c.expected, c.isErr, length, err)
+		}
 	}
 }
@@ -0,0 +1,99 @@
+// Package downloadtest provides an in-memory fake of download.Interface,
+// to unit test the code embedding the downloader without network access.
+package downloadtest
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/go-zoox/download"
+)
+
+// Fake is a configurable in-memory download.Interface, safe for concurrent use
+type Fake struct {
+	sync.Mutex
+	// Content is the content of the fake remote file
+	Content []byte
+	// Info is returned by Probe, derived from Content when nil
+	Info *download.FileInfo
+	// Err fails every call when set
+	Err error
+	// FilePath is where Download writes Content, nothing is written when empty
+	FilePath string
+	// ProbeCalls, DownloadCalls and DownloadToCalls count the calls
+	ProbeCalls      int
+	DownloadCalls   int
+	DownloadToCalls int
+}
+
+var _ download.Interface = (*Fake)(nil)
+
+// NewFake returns a fake serving content
+func NewFake(content []byte) *Fake {
+	return &Fake{
+		Content: content,
+	}
+}
+
+// Probe returns Info, or the info of Content
+func (f *Fake) Probe() (*download.FileInfo, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.ProbeCalls++
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	if f.Info != nil {
+		return f.Info, nil
+	}
+
+	headers := http.Header{}
+	headers.Set("Accept-Ranges", "bytes")
+	headers.Set("Content-Length", strconv.Itoa(len(f.Content)))
+	headers.Set("Content-Type", http.DetectContentType(f.Content))
+	return &download.FileInfo{
+		StatusCode:     http.StatusOK,
+		Size:           int64(len(f.Content)),
+		ContentType:    headers.Get("Content-Type"),
+		AcceptRanges:   "bytes",
+		IsSupportRange: true,
+		Headers:        headers,
+	}, nil
+}
+
+// Download writes Content to FilePath
+func (f *Fake) Download() error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.DownloadCalls++
+	if f.Err != nil {
+		return f.Err
+	}
+
+	if f.FilePath == "" {
+		return nil
+	}
+
+	return os.WriteFile(f.FilePath, f.Content, 0644)
+}
+
+// DownloadTo writes Content to w
+func (f *Fake) DownloadTo(w io.Writer) error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.DownloadToCalls++
+	if f.Err != nil {
+		return f.Err
+	}
+
+	_, err := io.Copy(w, bytes.NewReader(f.Content))
+	return err
+}
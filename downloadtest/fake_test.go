@@ -0,0 +1,59 @@
+package downloadtest
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-zoox/download"
+)
+
+// fetchReport is the kind of application code the fake is meant for
+func fetchReport(d download.Interface) ([]byte, error) {
+	info, err := d.Probe()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size > 1024 {
+		return nil, errors.New("too large")
+	}
+
+	var buf bytes.Buffer
+	if err := d.DownloadTo(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func TestFake(t *testing.T) {
+	f := NewFake([]byte("report"))
+	data, err := fetchReport(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "report" || f.ProbeCalls != 1 || f.DownloadToCalls != 1 {
+		t.Errorf("unexpected result %q, calls %+v", data, f)
+	}
+
+	f.Content = bytes.Repeat([]byte("0"), 2048)
+	if _, err := fetchReport(f); err == nil || f.DownloadToCalls != 1 {
+		t.Errorf("expected a too large error without download, got %v", err)
+	}
+
+	f.Err = download.ErrForbidden
+	if _, err := fetchReport(f); !errors.Is(err, download.ErrForbidden) {
+		t.Errorf("expected the configured error, got %v", err)
+	}
+
+	f = NewFake([]byte("file"))
+	f.FilePath = filepath.Join(t.TempDir(), "file.txt")
+	if err := f.Download(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(f.FilePath); string(data) != "file" {
+		t.Errorf("unexpected file content: %q", data)
+	}
+}
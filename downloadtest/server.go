@@ -0,0 +1,150 @@
+package downloadtest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// ServerConfig simulates the behaviours of real origins
+type ServerConfig struct {
+	// Name is the served file name, its extension sets the content type
+	Name string
+	// ContentType overrides the content type guessed from Name
+	ContentType string
+	// IgnoreRanges answers range requests with the whole file (200) while advertising Accept-Ranges: bytes,
+	// as misconfigured origins and proxies
+	IgnoreRanges bool
+	// Latency delays every response
+	Latency time.Duration
+	// Rate caps the bytes sent per second by every response, zero means no limit
+	Rate int
+	// TruncateAfter aborts the connection after this many bytes of a GET body, zero means never
+	TruncateAfter int
+	// Truncations is the number of GET bodies truncated, zero means all of them
+	Truncations int
+}
+
+// Server is a httptest.Server serving a file
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	config      ServerConfig
+	content     []byte
+	requests    int
+	truncations int
+}
+
+// NewServer starts a server serving content, config may be nil for a well-behaved server
+func NewServer(content []byte, config *ServerConfig) *Server {
+	s := newServer(content, config)
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// NewTLSServer is NewServer over https, use its Client() as Config.Client
+func NewTLSServer(content []byte, config *ServerConfig) *Server {
+	s := newServer(content, config)
+	s.Server = httptest.NewTLSServer(s)
+	return s
+}
+
+func newServer(content []byte, config *ServerConfig) *Server {
+	s := &Server{content: content}
+	if config != nil {
+		s.config = *config
+	}
+	if s.config.Name == "" {
+		s.config.Name = "file"
+	}
+
+	return s
+}
+
+// Requests returns the number of requests served
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests
+}
+
+// ServeHTTP serves the file according to the config
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	truncateAfter := 0
+	if r.Method == http.MethodGet && s.config.TruncateAfter > 0 &&
+		(s.config.Truncations == 0 || s.truncations < s.config.Truncations) {
+		s.truncations++
+		truncateAfter = s.config.TruncateAfter
+	}
+	s.mu.Unlock()
+
+	if s.config.Latency > 0 {
+		time.Sleep(s.config.Latency)
+	}
+
+	if s.config.IgnoreRanges {
+		r.Header.Del("Range")
+	}
+	if s.config.ContentType != "" {
+		w.Header().Set("Content-Type", s.config.ContentType)
+	}
+
+	if s.config.Rate > 0 || truncateAfter > 0 {
+		w = &faultyWriter{ResponseWriter: w, rate: s.config.Rate, truncateAfter: truncateAfter}
+	}
+
+	http.ServeContent(w, r, s.config.Name, time.Time{}, bytes.NewReader(s.content))
+}
+
+// faultyWriter throttles and truncates the body
+type faultyWriter struct {
+	http.ResponseWriter
+	rate          int
+	truncateAfter int
+	written       int
+}
+
+func (w *faultyWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p
+		if w.rate > 0 && len(chunk) > w.rate/10+1 {
+			// 100ms worth of bytes at a time
+			chunk = chunk[:w.rate/10+1]
+		}
+		if w.truncateAfter > 0 && w.written+len(chunk) > w.truncateAfter {
+			chunk = chunk[:w.truncateAfter-w.written]
+		}
+
+		m, err := w.ResponseWriter.Write(chunk)
+		n += m
+		w.written += m
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+
+		if w.truncateAfter > 0 && w.written >= w.truncateAfter {
+			// abort the connection, the client gets an unexpected EOF
+			if f, ok := w.ResponseWriter.(http.Flusher); ok {
+				f.Flush()
+			}
+			panic(http.ErrAbortHandler)
+		}
+
+		if w.rate > 0 {
+			if f, ok := w.ResponseWriter.(http.Flusher); ok {
+				f.Flush()
+			}
+			time.Sleep(time.Duration(m) * time.Second / time.Duration(w.rate))
+		}
+	}
+
+	return n, nil
+}
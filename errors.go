@@ -0,0 +1,207 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+)
+
+// ErrorKind classifies the cause of a download error
+type ErrorKind int
+
+const (
+	// ErrorKindUnknown is an unclassified error, such as a local file system error, not retried
+	ErrorKindUnknown ErrorKind = iota
+	// ErrorKindTimeout is a connect, TLS or read timeout, retried
+	ErrorKindTimeout
+	// ErrorKindConnection is a connection reset, refused or closed early, retried
+	ErrorKindConnection
+	// ErrorKindStatus is an unexpected http status, 5xx, 408 and 429 are retried, other 4xx are fatal
+	ErrorKindStatus
+	// ErrorKindProtocol is a malformed response, such as a wrong Content-Range, retried
+	ErrorKindProtocol
+	// ErrorKindChecksum is a size or hash mismatch of the downloaded data, retried
+	ErrorKindChecksum
+	// ErrorKindCanceled is a cancelled or expired context, not retried
+	ErrorKindCanceled
+	// ErrorKindPanic is a panic recovered in a worker, not retried
+	ErrorKindPanic
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorKindTimeout:
+		return "timeout"
+	case ErrorKindConnection:
+		return "connection"
+	case ErrorKindStatus:
+		return "status"
+	case ErrorKindProtocol:
+		return "protocol"
+	case ErrorKindChecksum:
+		return "checksum"
+	case ErrorKindCanceled:
+		return "canceled"
+	case ErrorKindPanic:
+		return "panic"
+	}
+
+	return "unknown"
+}
+
+// Error is a classified download error, use errors.As to inspect it
+type Error struct {
+	// Kind is the cause of the error
+	Kind ErrorKind
+	// StatusCode is the http status of ErrorKindStatus errors
+	StatusCode int
+	// Retryable tells whether trying again may succeed
+	Retryable bool
+	// URL is the url of the failed request, the mirror the part was downloaded from
+	URL string
+	// Part is the failed part, with its index and byte range, nil when the whole download failed
+	Part *FilePart
+	// Attempt is the number of attempts made before giving up, zero outside of the retry policy
+	Attempt int
+	// Err is the underlying error
+	Err error
+}
+
+func (e *Error) Error() string {
+	message := e.Err.Error()
+	if e.Attempt > 0 {
+		message = fmt.Sprintf("attempt %d: %s", e.Attempt, message)
+	}
+	if e.Part != nil {
+		message = fmt.Sprintf("part %d (bytes=%d-%d): %s", e.Part.Index, e.Part.RangeStart, e.Part.RangeEnd, message)
+	}
+	if e.URL != "" {
+		message = e.URL + ": " + message
+	}
+
+	return message
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether trying again may succeed,
+// unclassified errors are considered fatal.
+func IsRetryable(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Retryable
+	}
+
+	return classify(err).Retryable
+}
+
+// isRetryableStatus returns true for the statuses worth retrying
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	}
+
+	return status >= 500
+}
+
+func newStatusError(status int) *Error {
+	err := fmt.Errorf("invalid status: %d", status)
+	if status == http.StatusForbidden {
+		err = fmt.Errorf("invalid status: %d: %w", status, ErrForbidden)
+	}
+
+	return &Error{
+		Kind:       ErrorKindStatus,
+		StatusCode: status,
+		Retryable:  isRetryableStatus(status),
+		Err:        err,
+	}
+}
+
+func newProtocolError(err error) *Error {
+	return &Error{
+		Kind:      ErrorKindProtocol,
+		Retryable: true,
+		Err:       err,
+	}
+}
+
+func newChecksumError(err error) *Error {
+	return &Error{
+		Kind:      ErrorKindChecksum,
+		Retryable: true,
+		Err:       err,
+	}
+}
+
+// annotate returns err classified, with the url, part and attempt it failed at, the ones already set are kept,
+// so the url of the mirror is not replaced by the url of the download.
+func annotate(err error, url string, part *FilePart, attempt int) *Error {
+	e := classify(err)
+
+	annotated := *e
+	if e != err {
+		// the wrapping context is kept, the inner error tells what it already knows
+		annotated = Error{Kind: e.Kind, StatusCode: e.StatusCode, Retryable: e.Retryable, Err: err}
+	}
+	if e.URL == "" {
+		annotated.URL = url
+	}
+	if e.Part == nil && part != nil {
+		p := *part
+		annotated.Part = &p
+	}
+	if e.Attempt == 0 {
+		annotated.Attempt = attempt
+	}
+
+	return &annotated
+}
+
+// classify wraps err into an *Error, keeping it as is if already classified
+func classify(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	var panicErr *PanicError
+	var netErr net.Error
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &urlErr) && urlErr.Timeout() && urlErr.Err != context.DeadlineExceeded && !errors.Is(err, context.Canceled):
+		// the time limit of the request, such as Config.SegmentTimeout, the deadline of the context of the download,
+		// e.g. Quota.MaxTime, is the context error itself and stops the retries
+		return &Error{Kind: ErrorKindTimeout, Retryable: true, Err: err}
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return &Error{Kind: ErrorKindCanceled, Err: err}
+	case errors.As(err, &panicErr):
+		return &Error{Kind: ErrorKindPanic, Err: err}
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return &Error{Kind: ErrorKindTimeout, Retryable: true, Err: err}
+	case errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, io.EOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EPIPE):
+		return &Error{Kind: ErrorKindConnection, Retryable: true, Err: err}
+	}
+
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) {
+		return &Error{Kind: ErrorKindConnection, Retryable: true, Err: err}
+	}
+
+	return &Error{Kind: ErrorKindUnknown, Err: err}
+}
@@ -0,0 +1,104 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		err       error
+		kind      ErrorKind
+		retryable bool
+	}{
+		{newStatusError(http.StatusServiceUnavailable), ErrorKindStatus, true},
+		{newStatusError(http.StatusTooManyRequests), ErrorKindStatus, true},
+		{newStatusError(http.StatusNotFound), ErrorKindStatus, false},
+		{fmt.Errorf("read body: %w", io.ErrUnexpectedEOF), ErrorKindConnection, true},
+		{fmt.Errorf("part 1: %w", context.Canceled), ErrorKindCanceled, false},
+		{&PanicError{Value: "boom"}, ErrorKindPanic, false},
+		{os.ErrPermission, ErrorKindUnknown, false},
+	}
+
+	for _, c := range cases {
+		e := classify(c.err)
+		if e.Kind != c.kind || e.Retryable != c.retryable || IsRetryable(c.err) != c.retryable {
+			t.Errorf("%v: expected %s (retryable %v), got %s (retryable %v)", c.err, c.kind, c.retryable, e.Kind, e.Retryable)
+		}
+	}
+
+	if !errors.Is(newStatusError(http.StatusForbidden), ErrForbidden) {
+		t.Errorf("expected 403 to be ErrForbidden")
+	}
+}
+
+func TestFatalStatusIsNotRetried(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Type", "video/mp4")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "100")
+			return
+		}
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := DownloadWithContext(ctx, server.URL+"/file.mp4", &Config{
+		FilePath: t.TempDir() + "/fatal.mp4",
+		TmpDir:   t.TempDir(),
+	})
+
+	var e *Error
+	if !errors.As(err, &e) || e.Kind != ErrorKindStatus || e.StatusCode != http.StatusNotFound || e.Retryable {
+		t.Fatalf("expected a fatal 404 error, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("expected 404 not to be retried, got %d requests", requests)
+	}
+}
+
+func TestErrorIsAnnotated(t *testing.T) {
+	content := []byte("0123456789")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=5-9" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:    t.TempDir() + "/annotated.mp4",
+		TmpDir:      t.TempDir(),
+		SegmentSize: 5,
+		RetryPolicy: &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+	})
+
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected a 503 error, got %v", err)
+	}
+	if e.URL != server.URL+"/file.mp4" || e.Part == nil || e.Part.Index != 1 || e.Part.RangeStart != 5 || e.Part.RangeEnd != 9 || e.Attempt != 2 {
+		t.Errorf("unexpected annotations: %+v", e)
+	}
+
+	expected := fmt.Sprintf("%s/file.mp4: part 1 (bytes=5-9): attempt 2: invalid status: 503", server.URL)
+	if err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
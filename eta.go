@@ -0,0 +1,126 @@
+package download
+
+import (
+	"time"
+)
+
+// DefaultETARegressionWindow is the window of the samples fitted by NewRegressionETA
+var DefaultETARegressionWindow = 30 * time.Second
+
+// ETAEstimator estimates the time left of a download, see Config.ETA.
+// An estimator may keep the past samples, one is used by a single download.
+type ETAEstimator interface {
+	// Estimate returns the time left from the progress at now, -1 when unknown,
+	// it is called with each progress, never concurrently.
+	Estimate(now time.Time, progress Progress) time.Duration
+}
+
+// remaining returns the time to download the rest of the file at rate bytes per second, -1 when unknown
+func remaining(progress Progress, rate float64) time.Duration {
+	if progress.Total < 0 {
+		return -1
+	}
+
+	left := progress.Total - progress.Downloaded
+	if left <= 0 {
+		return 0
+	}
+
+	if rate <= 0 {
+		return -1
+	}
+
+	return time.Duration(float64(left) / rate * float64(time.Second))
+}
+
+// ewmaETA divides the bytes left by the smoothed speed
+type ewmaETA struct{}
+
+// NewEWMAETA returns the default estimator, the bytes left over Progress.Speed, an exponentially weighted
+// moving average tuned by Config.SpeedWindow and SpeedSmoothing, reactive to changes of the rate.
+func NewEWMAETA() ETAEstimator {
+	return ewmaETA{}
+}
+
+func (ewmaETA) Estimate(now time.Time, progress Progress) time.Duration {
+	return remaining(progress, progress.Speed)
+}
+
+// averageETA divides the bytes left by the average rate since the first estimate
+type averageETA struct {
+	startedAt  time.Time
+	downloaded int64
+}
+
+// NewAverageETA returns an estimator dividing the bytes left by the average rate since the download started,
+// stable on bursty servers, slow to follow a lasting change of the rate.
+func NewAverageETA() ETAEstimator {
+	return &averageETA{}
+}
+
+func (e *averageETA) Estimate(now time.Time, progress Progress) time.Duration {
+	if e.startedAt.IsZero() {
+		// the bytes resumed from a previous run are not part of the rate
+		e.startedAt, e.downloaded = now, progress.Downloaded
+		return -1
+	}
+
+	elapsed := now.Sub(e.startedAt).Seconds()
+	if elapsed <= 0 {
+		return -1
+	}
+
+	return remaining(progress, float64(progress.Downloaded-e.downloaded)/elapsed)
+}
+
+// etaSample is a progress sample of regressionETA
+type etaSample struct {
+	at         time.Time
+	downloaded int64
+}
+
+// regressionETA fits a line through the recent samples, its slope is the rate
+type regressionETA struct {
+	window  time.Duration
+	samples []etaSample
+}
+
+// NewRegressionETA returns an estimator fitting a least squares line through the samples of the last window,
+// DefaultETARegressionWindow when 0, steady on mirrors with a constant rate and robust to a single slow sample.
+func NewRegressionETA(window time.Duration) ETAEstimator {
+	if window <= 0 {
+		window = DefaultETARegressionWindow
+	}
+
+	return &regressionETA{window: window}
+}
+
+func (e *regressionETA) Estimate(now time.Time, progress Progress) time.Duration {
+	e.samples = append(e.samples, etaSample{at: now, downloaded: progress.Downloaded})
+	for len(e.samples) > 2 && now.Sub(e.samples[0].at) > e.window {
+		e.samples = e.samples[1:]
+	}
+	if len(e.samples) < 2 {
+		return -1
+	}
+
+	// x is the time since the first sample in seconds, y the bytes downloaded
+	var sumX, sumY, sumXY, sumXX float64
+	origin := e.samples[0].at
+	for _, s := range e.samples {
+		x := s.at.Sub(origin).Seconds()
+		y := float64(s.downloaded)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+
+	n := float64(len(e.samples))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return -1
+	}
+
+	return remaining(progress, (n*sumXY-sumX*sumY)/denominator)
+}
@@ -0,0 +1,49 @@
+package download
+
+import (
+	"testing"
+	"time"
+)
+
+func TestETAEstimators(t *testing.T) {
+	start := time.Now()
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+
+	// unknown size, complete file
+	if eta := NewEWMAETA().Estimate(start, Progress{Total: -1, Speed: 100}); eta != -1 {
+		t.Errorf("expected an unknown eta, got %v", eta)
+	}
+	if eta := NewEWMAETA().Estimate(start, Progress{Total: 100, Downloaded: 100}); eta != 0 {
+		t.Errorf("expected no time left, got %v", eta)
+	}
+
+	if eta := NewEWMAETA().Estimate(start, Progress{Total: 1000, Downloaded: 400, Speed: 100}); eta != 6*time.Second {
+		t.Errorf("ewma: expected 6s, got %v", eta)
+	}
+
+	// the 500 resumed bytes are not part of the rate
+	average := NewAverageETA()
+	if eta := average.Estimate(at(0), Progress{Total: 2000, Downloaded: 500}); eta != -1 {
+		t.Errorf("average: expected an unknown eta on the first sample, got %v", eta)
+	}
+	if eta := average.Estimate(at(10), Progress{Total: 2000, Downloaded: 1000}); eta != 20*time.Second {
+		t.Errorf("average: expected 20s, got %v", eta)
+	}
+
+	// the rate is the slope of the samples, the samples out of the window are dropped
+	regression := NewRegressionETA(5 * time.Second)
+	for i, downloaded := range []int64{0, 100, 200, 300, 400} {
+		regression.Estimate(at(i), Progress{Total: 10000, Downloaded: downloaded})
+	}
+	if eta := regression.Estimate(at(5), Progress{Total: 10000, Downloaded: 500}); eta != 95*time.Second {
+		t.Errorf("regression: expected 95s, got %v", eta)
+	}
+	for i := 6; i < 20; i++ {
+		regression.Estimate(at(i), Progress{Total: 10000, Downloaded: 500 + int64(i-5)*1000})
+	}
+	if eta := regression.Estimate(at(20), Progress{Total: 20000, Downloaded: 15500}); eta != 4500*time.Millisecond {
+		t.Errorf("regression: expected 4.5s once the old samples left the window, got %v", eta)
+	}
+}
@@ -0,0 +1,57 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// ErrSizeMismatch means the size of the file is not Config.ExpectedSize
+var ErrSizeMismatch = errors.New("size mismatch")
+
+// ErrUnexpectedContentType means the server returned another content type than Config.ExpectContentType
+var ErrUnexpectedContentType = errors.New("unexpected content type")
+
+// matchContentType returns true if contentType matches expected,
+// an expected type ending with / or /* (e.g. video/) matches any subtype, otherwise the match is exact,
+// parameters such as charset and the case are ignored.
+func matchContentType(expected, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+
+	expected = strings.ToLower(strings.TrimSpace(expected))
+	if strings.HasSuffix(expected, "/*") || strings.HasSuffix(expected, "/") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(expected, "*"))
+	}
+
+	return mediaType == expected
+}
+
+// checkContentType aborts the download when the content type is not the expected one,
+// e.g. the text/html error page of an expired signed url, before anything is written.
+func (d *Downloader) checkContentType(contentType string) error {
+	if d.ExpectContentType == "" || matchContentType(d.ExpectContentType, contentType) {
+		return nil
+	}
+
+	return &Error{
+		Kind: ErrorKindProtocol,
+		Err:  fmt.Errorf("%w: expect %s, got %s", ErrUnexpectedContentType, d.ExpectContentType, contentType),
+	}
+}
+
+// checkExpectedSize aborts the download when the size announced by the server is not the expected one,
+// before anything is transferred, an unknown size (negative or zero) is checked once downloaded.
+func (d *Downloader) checkExpectedSize(contentLength int64) error {
+	if d.ExpectedSize <= 0 || contentLength <= 0 || contentLength == d.ExpectedSize {
+		return nil
+	}
+
+	return &Error{
+		Kind: ErrorKindChecksum,
+		Err:  fmt.Errorf("%w: expect %d, server announced %d", ErrSizeMismatch, d.ExpectedSize, contentLength),
+	}
+}
@@ -0,0 +1,119 @@
+package download
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMatchContentType(t *testing.T) {
+	cases := []struct {
+		expected    string
+		contentType string
+		match       bool
+	}{
+		{"video/mp4", "video/mp4", true},
+		{"video/mp4", "Video/MP4; codecs=avc1", true},
+		{"video/mp4", "video/webm", false},
+		{"video/", "video/webm", true},
+		{"video/*", "video/x-matroska", true},
+		{"video/", "text/html; charset=utf-8", false},
+		{"video/mp4", "", false},
+	}
+
+	for _, c := range cases {
+		if match := matchContentType(c.expected, c.contentType); match != c.match {
+			t.Errorf("matchContentType(%s, %s): expect %v, got %v", c.expected, c.contentType, c.match, match)
+		}
+	}
+}
+
+func TestExpectContentType(t *testing.T) {
+	// the signed url expired, the origin answers with an error page
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte("<html>expired</html>")))
+	}))
+	defer server.Close()
+
+	for _, isRangesDisabled := range []bool{false, true} {
+		filePath := filepath.Join(t.TempDir(), "video.mp4")
+		_, err := Download(server.URL+"/video.mp4", &Config{
+			FilePath:          filePath,
+			TmpDir:            t.TempDir(),
+			IsRangesDisabled:  isRangesDisabled,
+			ExpectContentType: "video/",
+		})
+		if !errors.Is(err, ErrUnexpectedContentType) || IsRetryable(err) {
+			t.Fatalf("expected a fatal unexpected content type error, got %v", err)
+		}
+
+		if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+			t.Error("expected the error page not to be saved")
+		}
+	}
+}
+
+func TestExpectedSize(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&gets, 1)
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// the Content-Length mismatch aborts before the transfer
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:     filepath.Join(t.TempDir(), "file.mp4"),
+		TmpDir:       t.TempDir(),
+		ExpectedSize: 999,
+	})
+	if !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("expected a size mismatch, got %v", err)
+	}
+	if n := atomic.LoadInt32(&gets); n != 0 {
+		t.Errorf("expected no transfer, got %d requests", n)
+	}
+
+	_, err = Download(server.URL+"/file.mp4", &Config{
+		FilePath:     filepath.Join(t.TempDir(), "file.mp4"),
+		TmpDir:       t.TempDir(),
+		ExpectedSize: int64(len(content)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpectedSizeOfUnknownLength(t *testing.T) {
+	// chunked, the size is only known once downloaded
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+
+	filePath := filepath.Join(t.TempDir(), "file.mp4")
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:         filePath,
+		TmpDir:           t.TempDir(),
+		IsRangesDisabled: true,
+		ExpectedSize:     10,
+	})
+	if !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("expected a size mismatch, got %v", err)
+	}
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Error("expected the mismatched file not to be saved")
+	}
+}
@@ -0,0 +1,130 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNoExtractor means no registered extractor matches the url
+var ErrNoExtractor = errors.New("no extractor matches the url")
+
+// Media represents a direct media resolved by an extractor
+type Media struct {
+	// URL is the direct url of the media
+	URL string
+	// Title is the human readable title of the media
+	Title string
+	// FileName is the suggested file name, without extension
+	FileName string
+	// FileExt is the suggested file extension
+	FileExt string
+	// ContentType is the content type of the media, if known
+	ContentType string
+	// Metadata holds extractor specific information, such as author or quality
+	Metadata map[string]string
+}
+
+// Extractor turns a page url into one or more direct media urls (youtube-dl style)
+type Extractor interface {
+	// Name returns the name of the extractor
+	Name() string
+	// Match returns true if the extractor can handle the url
+	Match(url string) bool
+	// Extract resolves the page url into direct media
+	Extract(ctx context.Context, url string) ([]*Media, error)
+}
+
+var extractors = struct {
+	sync.RWMutex
+	list []Extractor
+}{}
+
+// RegisterExtractor registers a site specific extractor,
+//
+//	extractors registered later take precedence over earlier ones.
+func RegisterExtractor(e Extractor) {
+	extractors.Lock()
+	defer extractors.Unlock()
+
+	extractors.list = append(extractors.list, e)
+}
+
+// UnregisterExtractor removes the extractor by name
+func UnregisterExtractor(name string) {
+	extractors.Lock()
+	defer extractors.Unlock()
+
+	list := make([]Extractor, 0, len(extractors.list))
+	for _, e := range extractors.list {
+		if e.Name() != name {
+			list = append(list, e)
+		}
+	}
+	extractors.list = list
+}
+
+// Extractors returns the registered extractors
+func Extractors() []Extractor {
+	extractors.RLock()
+	defer extractors.RUnlock()
+
+	list := make([]Extractor, len(extractors.list))
+	copy(list, extractors.list)
+	return list
+}
+
+func findExtractor(url string) Extractor {
+	extractors.RLock()
+	defer extractors.RUnlock()
+
+	for i := len(extractors.list) - 1; i >= 0; i-- {
+		if extractors.list[i].Match(url) {
+			return extractors.list[i]
+		}
+	}
+
+	return nil
+}
+
+// Extract resolves the page url into direct media with the matching extractor
+func Extract(ctx context.Context, url string) ([]*Media, error) {
+	e := findExtractor(url)
+	if e == nil {
+		return nil, ErrNoExtractor
+	}
+
+	media, err := e.Extract(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("extractor %s: %s", e.Name(), err)
+	}
+
+	if len(media) == 0 {
+		return nil, fmt.Errorf("extractor %s: no media found in %s", e.Name(), url)
+	}
+
+	return media, nil
+}
+
+func (d *Downloader) extract() error {
+	if d.IsExtractorsDisabled || findExtractor(d.URL) == nil {
+		return nil
+	}
+
+	media, err := Extract(d.getContext(), d.URL)
+	if err != nil {
+		return err
+	}
+
+	// the downloader handles a single file, use Extract to get all media
+	m := media[0]
+	d.PageURL = d.URL
+	d.URL = m.URL
+	if d.FileName == "" {
+		d.FileName = cleanFileName(m.FileName)
+		d.FileExt = m.FileExt
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package download
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+type testExtractor struct {
+	target string
+}
+
+func (e *testExtractor) Name() string {
+	return "test"
+}
+
+func (e *testExtractor) Match(url string) bool {
+	return strings.HasPrefix(url, "https://page.example/watch")
+}
+
+func (e *testExtractor) Extract(ctx context.Context, url string) ([]*Media, error) {
+	return []*Media{
+		{URL: e.target, FileName: "extracted", FileExt: "mp4"},
+	}, nil
+}
+
+func TestExtractor(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("media"))
+	}))
+	defer server.Close()
+
+	RegisterExtractor(&testExtractor{target: server.URL + "/media.mp4"})
+	defer UnregisterExtractor("test")
+
+	if _, err := Extract(context.Background(), "https://other.example/watch"); err != ErrNoExtractor {
+		t.Fatalf("expected ErrNoExtractor, got %v", err)
+	}
+
+	dir := t.TempDir()
+	d := New("https://page.example/watch?v=1", &Config{
+		IsRangesDisabled: true,
+	})
+	d.FileDir = dir
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.PageURL != "https://page.example/watch?v=1" {
+		t.Errorf("unexpected page url: %s", d.PageURL)
+	}
+
+	data, err := os.ReadFile(dir + "/extracted.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "media" {
+		t.Errorf("unexpected content: %s", data)
+	}
+}
@@ -0,0 +1,32 @@
+package download
+
+import (
+	"time"
+
+	"github.com/go-zoox/fetch"
+)
+
+// The requests used to be sent with go-zoox/fetch, its global settings
+// (fetch.SetUserAgent and fetch.SetTimeout) are still honoured, for backward compatibility.
+
+// DefaultUserAgent is the User-Agent of the requests, empty means the go-zoox/fetch one
+var DefaultUserAgent = ""
+
+// DefaultTimeout is the timeout of a request, zero means the go-zoox/fetch one (60s)
+var DefaultTimeout time.Duration
+
+func userAgent() string {
+	if DefaultUserAgent != "" {
+		return DefaultUserAgent
+	}
+
+	return fetch.DefaultUserAgent()
+}
+
+func requestTimeout() time.Duration {
+	if DefaultTimeout > 0 {
+		return DefaultTimeout
+	}
+
+	return fetch.Timeout
+}
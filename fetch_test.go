@@ -0,0 +1,45 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/fetch"
+)
+
+func TestUserAgent(t *testing.T) {
+	var mu sync.Mutex
+	agents := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents[r.UserAgent()] = true
+		mu.Unlock()
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader([]byte("content")))
+	}))
+	defer server.Close()
+
+	// the go-zoox/fetch global setting is honoured
+	fetchAgent := fetch.UserAgent
+	defer fetch.SetUserAgent(fetchAgent)
+	fetch.SetUserAgent("legacy/1.0")
+	if _, err := Download(server.URL+"/file.mp4", &Config{FilePath: t.TempDir() + "/file.mp4", TmpDir: t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 1 || !agents["legacy/1.0"] {
+		t.Errorf("expected the fetch user agent, got %v", agents)
+	}
+
+	defer func() { DefaultUserAgent = "" }()
+	DefaultUserAgent = "downloader/2.0"
+	agents = map[string]bool{}
+	if _, err := Download(server.URL+"/file.mp4", &Config{FilePath: t.TempDir() + "/file.mp4", TmpDir: t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 1 || !agents["downloader/2.0"] {
+		t.Errorf("expected the default user agent, got %v", agents)
+	}
+}
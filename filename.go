@@ -0,0 +1,129 @@
+package download
+
+import (
+	"net/url"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultFileName is the file name used when none can be derived from the url
+var DefaultFileName = "download"
+
+// isWindows enables the windows file name rules, whatever the os in tests
+var isWindows = runtime.GOOS == "windows"
+
+// reservedNames are the windows device names, a file can't be named after them, even with an extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeReservedName prefixes windows device names with _, e.g. nul.txt becomes _nul.txt
+func sanitizeReservedName(name string) string {
+	if !isWindows {
+		return name
+	}
+
+	stem := name
+	if i := strings.Index(name, "."); i != -1 {
+		stem = name[:i]
+	}
+
+	if reservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		return "_" + name
+	}
+
+	return name
+}
+
+// cleanFileName turns a server-provided file name (url path, extractor or Content-Disposition)
+// into a safe and readable file name, empty if nothing is left.
+func cleanFileName(name string) string {
+	return sanitizeReservedName(sanitizeFileName(normalizeFileName(name)))
+}
+
+// sanitizeFileName replaces path separators and strips null bytes, control characters and leading dots,
+// so an untrusted name can't traverse out of the directory or be unreadable,
+// the characters forbidden by windows and its trailing dots and spaces are also replaced there.
+func sanitizeFileName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r == '/' || r == '\\':
+			b.WriteRune('_')
+		case r < 0x20 || r == 0x7f || r == utf8.RuneError:
+		case isWindows && strings.ContainsRune(`<>:"|?*`, r):
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	name = strings.TrimLeft(strings.TrimSpace(b.String()), ".")
+	if isWindows {
+		name = strings.TrimRight(name, ". ")
+	}
+
+	return name
+}
+
+// normalizeFileName makes a file name derived from a url human readable,
+// percent-encoded sequences left after the url parsing (e.g. double encoded %2520) are decoded,
+// + is kept as is since it only means a space in query strings,
+// and decomposed unicode (NFD, as sent by macOS clients) is composed (NFC).
+func normalizeFileName(name string) string {
+	if strings.Contains(name, "%") {
+		if decoded, err := url.PathUnescape(name); err == nil {
+			name = decoded
+		}
+	}
+
+	return strings.TrimSpace(composeNFC(name))
+}
+
+// hangul syllables are composed algorithmically, from a leading consonant, a vowel and an optional trailing consonant
+const (
+	hangulBase   = 0xAC00
+	hangulLBase  = 0x1100
+	hangulVBase  = 0x1161
+	hangulTBase  = 0x11A7
+	hangulLCount = 19
+	hangulVCount = 21
+	hangulTCount = 28
+	hangulCount  = hangulLCount * hangulVCount * hangulTCount
+)
+
+//go:generate go run gen_normalize.go -version 14.0.0
+
+// composeNFC composes the decomposed sequences of s, such as e followed by U+0301 into é,
+// marks without composition are kept as is, the compositions being generated from the Unicode character database.
+func composeNFC(s string) string {
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		if n := len(out); n > 0 {
+			last := out[n-1]
+			if c, ok := compositions[[2]rune{last, r}]; ok {
+				out[n-1] = c
+				continue
+			}
+
+			// hangul leading consonant + vowel
+			if last >= hangulLBase && last < hangulLBase+hangulLCount && r >= hangulVBase && r < hangulVBase+hangulVCount {
+				out[n-1] = hangulBase + ((last-hangulLBase)*hangulVCount+(r-hangulVBase))*hangulTCount
+				continue
+			}
+
+			// hangul syllable without trailing consonant + trailing consonant
+			if last >= hangulBase && last < hangulBase+hangulCount && (last-hangulBase)%hangulTCount == 0 && r > hangulTBase && r < hangulTBase+hangulTCount {
+				out[n-1] = last + (r - hangulTBase)
+				continue
+			}
+		}
+
+		out = append(out, r)
+	}
+
+	return string(out)
+}
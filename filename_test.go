@@ -0,0 +1,69 @@
+package download
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestNormalizeFileName(t *testing.T) {
+	cases := map[string]string{
+		"my%20video":         "my video",
+		"%E4%B8%AD%E6%96%87": "中文",
+		"a+b":                "a+b",
+		"100%":               "100%",
+		"café":              "café",
+		"Tiệng Việt":     "Tiệng Việt",
+		"がく":                "がく",
+		"한글":             "한글",
+	}
+
+	for name, expected := range cases {
+		if actual := normalizeFileName(name); actual != expected {
+			t.Errorf("%q: expected %q, got %q", name, expected, actual)
+		}
+	}
+
+	d := New("http://example.com/videos/my%2520clip%E2%80%941.mp4", &Config{})
+	if err := d.parseURL(d.URL); err != nil {
+		t.Fatal(err)
+	}
+	if d.FileName != "my clip—1" || d.FileExt != "mp4" {
+		t.Errorf("expected a decoded file name, got %s.%s", d.FileName, d.FileExt)
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	cases := map[string]string{
+		"../../etc/passwd":   "_.._etc_passwd",
+		"..\\boot.ini":       "_boot.ini",
+		".htaccess":          "htaccess",
+		"evil\x00.mp4":       "evil.mp4",
+		"line\nbreak\t.mp4":  "linebreak.mp4",
+		"  clip.mp4 ":        "clip.mp4",
+		"what?.mp4":          "what?.mp4",
+		"..":                 "",
+		"my%2F..%2Fclip.mp4": "my_.._clip.mp4",
+	}
+
+	for name, expected := range cases {
+		if actual := cleanFileName(name); actual != expected {
+			t.Errorf("%q: expected %q, got %q", name, expected, actual)
+		}
+	}
+
+	isWindows = true
+	defer func() {
+		isWindows = runtime.GOOS == "windows"
+	}()
+	if actual := cleanFileName(`what?<"quoted">.mp4. `); actual != "what___quoted__.mp4" {
+		t.Errorf("expected the windows forbidden characters to be replaced, got %q", actual)
+	}
+
+	d := New("http://example.com/..%2F..%2F", &Config{})
+	if err := d.parseURL(d.URL); err != nil {
+		t.Fatal(err)
+	}
+	if d.FileName != DefaultFileName {
+		t.Errorf("expected the default file name, got %q", d.FileName)
+	}
+}
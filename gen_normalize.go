@@ -0,0 +1,152 @@
+//go:build ignore
+// +build ignore
+
+// gen_normalize generates normalize_table.go, the canonical compositions (NFC) of composeNFC,
+// from the Unicode character database. Run it with go generate when the Unicode version changes:
+//
+//	go run gen_normalize.go -version 15.0.0
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// composition is a primary composite and its canonical decomposition into a starter and a combining mark
+type composition struct {
+	Starter rune
+	Mark    rune
+	Code    rune
+	Name    string
+}
+
+func main() {
+	version := flag.String("version", unicode.Version, "version of the Unicode character database")
+	ucd := flag.String("ucd", "", "url of the Unicode character database, defaults to https://www.unicode.org/Public/<version>/ucd/")
+	output := flag.String("output", "normalize_table.go", "file to generate")
+	flag.Parse()
+
+	if *ucd == "" {
+		*ucd = "https://www.unicode.org/Public/" + *version + "/ucd/"
+	}
+
+	exclusions, err := fetch(*ucd + "CompositionExclusions.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	excluded := map[rune]bool{}
+	for _, fields := range parse(exclusions) {
+		excluded[parseRune(fields[0])] = true
+	}
+
+	data, err := fetch(*ucd + "UnicodeData.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	records := parse(data)
+	combiningClass := map[rune]string{}
+	for _, fields := range records {
+		combiningClass[parseRune(fields[0])] = fields[3]
+	}
+
+	var compositions []composition
+	for _, fields := range records {
+		code := parseRune(fields[0])
+		decomposition := strings.Fields(fields[5])
+		// compatibility decompositions are tagged, <font>, <compat>..., and singletons never compose
+		if len(decomposition) != 2 || strings.HasPrefix(decomposition[0], "<") || excluded[code] {
+			continue
+		}
+
+		// the non-starter decompositions are excluded from the composition too
+		starter, mark := parseRune(decomposition[0]), parseRune(decomposition[1])
+		if combiningClass[code] != "0" || combiningClass[starter] != "0" {
+			continue
+		}
+
+		compositions = append(compositions, composition{starter, mark, code, strings.ToLower(fields[1])})
+	}
+	sort.Slice(compositions, func(i, j int) bool {
+		return compositions[i].Code < compositions[j].Code
+	})
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by gen_normalize.go from the Unicode %s character database. DO NOT EDIT.\n\n", *version)
+	fmt.Fprintf(&b, "package download\n\n")
+	fmt.Fprintf(&b, "// compositions maps a starter and a combining mark to their primary composite (NFC),\n")
+	fmt.Fprintf(&b, "// the hangul syllables excepted, which are composed algorithmically.\n")
+	fmt.Fprintf(&b, "var compositions = map[[2]rune]rune{\n")
+	for _, c := range compositions {
+		fmt.Fprintf(&b, "{0x%04X, 0x%04X}: 0x%04X, // %s\n", c.Starter, c.Mark, c.Code, c.Name)
+	}
+	fmt.Fprintf(&b, "}\n")
+
+	source, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*output, source, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// fetch returns the body of url
+func fetch(url string) ([]byte, error) {
+	response, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s: %s", url, response.Status)
+	}
+
+	return ioutil.ReadAll(response.Body)
+}
+
+// parse returns the semicolon separated fields of the lines of a database file, without the comments
+func parse(data []byte) [][]string {
+	var records [][]string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Split(line, ";")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		records = append(records, fields)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	return records
+}
+
+// parseRune parses a code point written in hex, as 00E9
+func parseRune(hex string) rune {
+	r, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return rune(r)
+}
@@ -0,0 +1,76 @@
+package download
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// DownloadHead returns the first n bytes of the file, e.g. to detect its type by the magic bytes
+// or to read the metadata at the start of a media file, nothing is written to the disk.
+// A single range request is sent, the stream is cut after n bytes when the server ignores the range.
+func (d *Downloader) DownloadHead(ctx context.Context, n int64) ([]byte, error) {
+	jobCtx, cancel := d.withMaxTime(ctx)
+	defer cancel()
+	d.ctx = jobCtx
+
+	data, err := d.downloadHead(n)
+	if err := d.checkMaxTime(ctx, err); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (d *Downloader) downloadHead(n int64) ([]byte, error) {
+	if n <= 0 {
+		return []byte{}, nil
+	}
+
+	if err := d.extract(); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	err := d.do(http.MethodGet, d.URL, &requestConfig{
+		Headers: map[string]string{
+			"Range": "bytes=0-" + strconv.FormatInt(n-1, 10),
+		},
+	}, func(resp *http.Response, body io.Reader) error {
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusPartialContent:
+		case http.StatusRequestedRangeNotSatisfiable:
+			// an empty file has no first byte
+			data = []byte{}
+			return nil
+		default:
+			return newStatusError(resp.StatusCode)
+		}
+
+		if err := d.checkContentType(resp.Header.Get("Content-Type")); err != nil {
+			return err
+		}
+
+		var err error
+		data, err = ioutil.ReadAll(io.LimitReader(body, n))
+		return err
+	})
+	if err != nil {
+		return nil, classify(err)
+	}
+
+	return data, nil
+}
+
+// DownloadHead returns the first n bytes of the file by url and config
+func DownloadHead(ctx context.Context, url string, n int64, cfg ...*Config) ([]byte, error) {
+	configX := &Config{}
+	if len(cfg) > 0 {
+		configX = cfg[0]
+	}
+
+	d := New(url, configX)
+	return d.DownloadHead(ctx, n)
+}
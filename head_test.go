@@ -0,0 +1,64 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDownloadHead(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	data, err := DownloadHead(context.Background(), server.URL+"/file.mp4", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content[:16]) {
+		t.Errorf("unexpected head: %s", data)
+	}
+
+	data, err = DownloadHead(context.Background(), server.URL+"/file.mp4", 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("expected the whole file when n is beyond the size, got %d bytes", len(data))
+	}
+}
+
+func TestDownloadHeadRangeIgnored(t *testing.T) {
+	// 64MB, far more than the socket buffers
+	chunk := bytes.Repeat([]byte("0123456789"), 6400)
+	chunks := 1000
+	var written int64
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		for i := 0; i < chunks; i++ {
+			n, err := w.Write(chunk)
+			atomic.AddInt64(&written, int64(n))
+			if err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	data, err := DownloadHead(context.Background(), server.URL+"/file.mp4", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, chunk[:16]) {
+		t.Errorf("unexpected head: %s", data)
+	}
+
+	<-done
+	if atomic.LoadInt64(&written) >= int64(len(chunk)*chunks) {
+		t.Error("expected the stream to be cut after the head")
+	}
+}
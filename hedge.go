@@ -0,0 +1,227 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultHedgeDelay is how long the request of a part runs before a worker left idle at the end of the download
+// duplicates it, see Config.HedgeRequests
+var DefaultHedgeDelay = 2 * time.Second
+
+// hedgeSuffix is the suffix of the copy of a hedged part
+const hedgeSuffix = ".hedge"
+
+// hedgePool tracks the requests of the parts in flight, for the idle workers to hedge the stragglers
+type hedgePool struct {
+	mu sync.Mutex
+	// parts are the requests in flight, by part path
+	parts map[string]*hedgedPart
+}
+
+// hedgedPart is the request of a part in flight, and its hedge once started
+type hedgedPart struct {
+	part      *FilePart
+	url       string
+	startedAt time.Time
+	// ctx is the context of the request, cancelled once the hedge won
+	ctx    context.Context
+	cancel context.CancelFunc
+	// hedgeCtx is the context of the hedge, cancelled once the request won, nil while not hedged
+	hedgeCtx    context.Context
+	cancelHedge context.CancelFunc
+	// isDone is set once the request succeeded first, isHedgeWon once the hedge did
+	isDone     bool
+	isHedgeWon bool
+	// returned is closed once the request returned, hedged receives the result of the hedge,
+	// nil once its copy replaced the part
+	returned chan struct{}
+	hedged   chan error
+}
+
+func newHedgePool() *hedgePool {
+	return &hedgePool{parts: map[string]*hedgedPart{}}
+}
+
+// startHedgeable registers the request of the part to url with ctx, nil when the parts are not hedged
+func (d *Downloader) startHedgeable(ctx context.Context, part *FilePart, url string) *hedgedPart {
+	p := d.hedges
+	if p == nil || !d.progress.isPart(part.Path) {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	h := &hedgedPart{
+		part:      part,
+		url:       url,
+		startedAt: time.Now(),
+		ctx:       ctx,
+		cancel:    cancel,
+		returned:  make(chan struct{}),
+		hedged:    make(chan error, 1),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.parts[part.Path] = h
+	return h
+}
+
+// finish returns the result of the part once its request returned with err, the one of the hedge
+// when the request was beaten or failed.
+func (p *hedgePool) finish(h *hedgedPart, err error) error {
+	defer h.cancel()
+
+	p.mu.Lock()
+	delete(p.parts, h.part.Path)
+	if h.hedgeCtx == nil {
+		p.mu.Unlock()
+		return err
+	}
+
+	if err == nil && !h.isHedgeWon {
+		h.isDone = true
+		p.mu.Unlock()
+		h.cancelHedge()
+		return nil
+	}
+	p.mu.Unlock()
+
+	// the copy of the hedge replaces the part once the request returned
+	close(h.returned)
+	if hedgeErr := <-h.hedged; hedgeErr == nil {
+		return nil
+	}
+
+	return err
+}
+
+// straggler starts the hedge of the part in flight for DefaultHedgeDelay with the most bytes left,
+// nil if none is, false once no part is in flight, the hedge is cancelled with ctx.
+func (d *Downloader) straggler(ctx context.Context) (*hedgedPart, bool) {
+	p := d.hedges
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.parts) == 0 {
+		return nil, false
+	}
+
+	var slowest *hedgedPart
+	var slowestLeft int64
+	for _, h := range p.parts {
+		if h.hedgeCtx != nil || time.Since(h.startedAt) < DefaultHedgeDelay {
+			continue
+		}
+
+		left := h.part.RangeEnd - h.part.RangeStart + 1 - d.progress.written(h.part.Path)
+		if slowest == nil || left > slowestLeft {
+			slowest, slowestLeft = h, left
+		}
+	}
+
+	if slowest != nil {
+		slowest.hedgeCtx, slowest.cancelHedge = context.WithCancel(ctx)
+	}
+
+	return slowest, true
+}
+
+// hedgeStragglers hedges the stragglers one at a time, until no part is in flight, each hedge takes a worker
+// of the WorkerPool and the AutoConcurrency limit as the requests of the parts do.
+func (d *Downloader) hedgeStragglers(ctx context.Context) {
+	for {
+		select {
+		case <-time.After(DefaultHedgeDelay / 4):
+		case <-ctx.Done():
+			return
+		}
+
+		if err := d.tuner.acquire(ctx); err != nil {
+			return
+		}
+		if err := d.WorkerPool.acquire(ctx, d.Priority); err != nil {
+			d.tuner.release()
+			return
+		}
+
+		h, ok := d.straggler(ctx)
+		if h != nil {
+			d.hedge(ctx, h)
+		}
+
+		d.WorkerPool.release()
+		d.tuner.release()
+		if !ok {
+			return
+		}
+	}
+}
+
+// hedge downloads a copy of the part from another source, or connection, which replaces the part
+// if it is complete first, ctx is the context of the parts
+func (d *Downloader) hedge(ctx context.Context, h *hedgedPart) {
+	defer h.cancelHedge()
+
+	exclude := map[string]bool{h.url: true}
+	if !d.sources.hasOther(exclude) {
+		exclude = nil
+	}
+
+	src, url := d.sources.pick(exclude)
+	if src == nil {
+		h.hedged <- fmt.Errorf("no source to hedge part %d", h.part.Index)
+		return
+	}
+
+	if os.Getenv("DEBUG") == "true" {
+		fmt.Println("hedging part:", h.part.Index, "from", url)
+	}
+
+	hedgeCopy := *h.part
+	hedgeCopy.Path = h.part.Path + hedgeSuffix
+	d.progress.addHedge(hedgeCopy.Path)
+
+	size := h.part.RangeEnd - h.part.RangeStart + 1
+	startedAt := time.Now()
+	err := safeRun(func() error {
+		if err := d.fetchFilePart(h.hedgeCtx, url, &hedgeCopy); err != nil {
+			return err
+		}
+
+		return d.verifyFilePart(&hedgeCopy)
+	})
+	if err != nil && h.hedgeCtx.Err() != nil && ctx.Err() == nil {
+		// beaten by the request, neither a failure nor a transfer of the source
+		d.sources.abandon(src)
+	} else {
+		d.sources.done(src, size, time.Since(startedAt), err)
+	}
+
+	p := d.hedges
+	p.mu.Lock()
+	isWon := err == nil && !h.isDone
+	h.isHedgeWon = isWon
+	p.mu.Unlock()
+
+	if !isWon {
+		os.Remove(hedgeCopy.Path)
+		h.hedged <- err
+		return
+	}
+
+	h.cancel()
+	<-h.returned
+	if err := os.Rename(hedgeCopy.Path, h.part.Path); err != nil {
+		os.Remove(hedgeCopy.Path)
+		h.hedged <- err
+		return
+	}
+
+	d.progress.setWritten(h.part.Path, size)
+	h.hedged <- nil
+}
@@ -0,0 +1,80 @@
+package download
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedgeRequests(t *testing.T) {
+	delay := DefaultHedgeDelay
+	DefaultHedgeDelay = 50 * time.Millisecond
+	defer func() { DefaultHedgeDelay = delay }()
+
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	// the first request of the part at 500 stalls, as a dead connection, until cancelled
+	var stalls, isCancelled int32
+	pool := NewWorkerPool(2)
+	var hedgeWorkers int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=500-599" {
+			if atomic.AddInt32(&stalls, 1) == 1 {
+				select {
+				case <-r.Context().Done():
+					atomic.StoreInt32(&isCancelled, 1)
+				case <-time.After(10 * time.Second):
+				}
+				return
+			}
+
+			// the hedge holds a worker of the pool, as the stalled request
+			pool.mu.Lock()
+			atomic.StoreInt32(&hedgeWorkers, int32(pool.busy))
+			pool.mu.Unlock()
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	d := New(server.URL+"/file.mp4", &Config{
+		Dir:           t.TempDir(),
+		TmpDir:        t.TempDir(),
+		SegmentSize:   100,
+		HedgeRequests: true,
+		WorkerPool:    pool,
+	})
+	startedAt := time.Now()
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(startedAt); elapsed > 5*time.Second {
+		t.Errorf("expected the stalled part hedged, took %s", elapsed)
+	}
+
+	data, err := ioutil.ReadFile(d.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("unexpected content")
+	}
+
+	if n := atomic.LoadInt32(&stalls); n != 2 {
+		t.Errorf("expected the part requested twice, got %d", n)
+	}
+	if n := atomic.LoadInt32(&hedgeWorkers); n != 2 {
+		t.Errorf("expected the hedge and the stalled request to hold the 2 workers, got %d", n)
+	}
+	// the server sees the beaten request cancelled once the client closed it
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&isCancelled) != 1 {
+		t.Error("expected the stalled request cancelled")
+	}
+	if p := d.Progress(); p.Downloaded != int64(len(content)) {
+		t.Errorf("expected %d bytes downloaded, got %d", len(content), p.Downloaded)
+	}
+}
@@ -0,0 +1,115 @@
+package download_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/download"
+	"github.com/go-zoox/download/downloadtest"
+)
+
+var content = bytes.Repeat([]byte("0123456789"), 10000)
+
+func fetch(t *testing.T, url string, config *download.Config) ([]byte, error) {
+	config.FilePath = filepath.Join(t.TempDir(), "file.mp4")
+	config.TmpDir = t.TempDir()
+	if config.SegmentSize == 0 {
+		config.SegmentSize = 16 * 1024
+	}
+
+	if _, err := download.Download(url, config); err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(config.FilePath)
+}
+
+func TestTLSClient(t *testing.T) {
+	server := downloadtest.NewTLSServer(content, &downloadtest.ServerConfig{Name: "file.mp4"})
+	defer server.Close()
+
+	data, err := fetch(t, server.URL+"/file.mp4", &download.Config{
+		Client: server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("unexpected content")
+	}
+}
+
+func TestRangeIgnored(t *testing.T) {
+	server := downloadtest.NewServer(content, &downloadtest.ServerConfig{Name: "file.mp4", IgnoreRanges: true})
+	defer server.Close()
+
+	if _, err := fetch(t, server.URL+"/file.mp4", &download.Config{}); err == nil {
+		t.Fatal("expected the whole file answered to a range request to fail the segmented download")
+	}
+
+	data, err := fetch(t, server.URL+"/file.mp4", &download.Config{IsRangesDisabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("unexpected content")
+	}
+}
+
+func TestSlowServer(t *testing.T) {
+	server := downloadtest.NewServer(content, &downloadtest.ServerConfig{Name: "file.mp4", Rate: 100 * 1024})
+	defer server.Close()
+
+	startedAt := time.Now()
+	data, err := fetch(t, server.URL+"/file.mp4", &download.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("unexpected content")
+	}
+
+	// 7 parts at 100KB/s each in parallel, a single stream would take 1s
+	if elapsed := time.Since(startedAt); elapsed > 700*time.Millisecond {
+		t.Errorf("expected the parts to be downloaded in parallel, took %s", elapsed)
+	}
+}
+
+func TestTruncation(t *testing.T) {
+	server := downloadtest.NewServer(content, &downloadtest.ServerConfig{Name: "file.mp4", TruncateAfter: 1000, Truncations: 3})
+	defer server.Close()
+
+	data, err := fetch(t, server.URL+"/file.mp4", &download.Config{
+		RetryPolicy: &download.RetryPolicy{
+			Delay: func(retry int, err error) time.Duration {
+				return time.Millisecond
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("unexpected content")
+	}
+
+	server = downloadtest.NewServer(content, &downloadtest.ServerConfig{Name: "file.mp4", TruncateAfter: 1000})
+	defer server.Close()
+
+	_, err = fetch(t, server.URL+"/file.mp4", &download.Config{
+		RetryPolicy: &download.RetryPolicy{
+			MaxAttempts: 2,
+			Delay: func(retry int, err error) time.Duration {
+				return time.Millisecond
+			},
+		},
+	})
+	var e *download.Error
+	if !errors.As(err, &e) || !e.Retryable {
+		t.Fatalf("expected a retryable error, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package download
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// historyMu serializes the writes to the history files of the process
+var historyMu sync.Mutex
+
+// HistoryRecord represents a completed or failed download, see Config.History
+type HistoryRecord struct {
+	URL string `json:"url"`
+	// Path is where the file landed, empty when the download failed
+	Path string `json:"path,omitempty"`
+	// Size is the size of the file, the bytes resumed from a previous run included
+	Size int64 `json:"size"`
+	// Transferred is the bytes received by this download, the retried ones included
+	Transferred int64         `json:"transferred"`
+	StartedAt   time.Time     `json:"started_at"`
+	Duration    time.Duration `json:"duration"`
+	// Speed is the average transfer rate in bytes per second
+	Speed float64 `json:"speed"`
+	// Checksum is the expected checksum, or the digest of the file in ContentStore
+	Checksum string `json:"checksum,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// recordHistory appends the record of the download to History and passes it to OnHistory,
+// the failures to write it are only logged, the download is done.
+func (d *Downloader) recordHistory(startedAt time.Time, err error) {
+	if d.History == "" && d.OnHistory == nil {
+		return
+	}
+
+	record := &HistoryRecord{
+		URL:         d.URL,
+		Size:        d.Progress().Downloaded,
+		Transferred: d.progress.transferredBytes(),
+		StartedAt:   startedAt,
+		Duration:    time.Since(startedAt),
+		Checksum:    d.ExpectedChecksum,
+	}
+	if record.Checksum == "" {
+		record.Checksum = d.Digest
+	}
+	if seconds := record.Duration.Seconds(); seconds > 0 {
+		record.Speed = float64(record.Transferred) / seconds
+	}
+	if err != nil {
+		record.Error = err.Error()
+	} else if result := d.lastResult; result != nil {
+		record.Path, record.Size, record.Duration, record.Speed = result.FinalPath, result.Size, result.Duration, result.Speed
+		if record.Path == "" {
+			record.Path = result.FilePath
+		}
+	}
+
+	if d.OnHistory != nil {
+		if err := safeRun(func() error {
+			d.OnHistory(*record)
+			return nil
+		}); err != nil && os.Getenv("DEBUG") == "true" {
+			fmt.Println("history: callback failed:", err)
+		}
+	}
+
+	if d.History != "" {
+		if err := appendHistory(d.History, record); err != nil && os.Getenv("DEBUG") == "true" {
+			fmt.Println("history: failed to write:", err)
+		}
+	}
+}
+
+// appendHistory appends the record to the jsonl file at path, as a single write,
+// so the lines of concurrent processes are not interleaved.
+func appendHistory(path string, record *HistoryRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,72 @@
+package download
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	dir := t.TempDir()
+	history := filepath.Join(dir, "history.jsonl")
+	var records []HistoryRecord
+	config := &Config{
+		Dir:     dir,
+		TmpDir:  t.TempDir(),
+		History: history,
+		OnHistory: func(record HistoryRecord) {
+			records = append(records, record)
+		},
+	}
+
+	if _, err := Download(server.URL+"/file.mp4", config); err != nil {
+		t.Fatal(err)
+	}
+
+	config.ExpectedChecksum = "sha256:" + hex.EncodeToString(make([]byte, 32))
+	if _, err := Download(server.URL+"/other.mp4", config); err == nil {
+		t.Fatal("expected a checksum mismatch")
+	}
+
+	f, err := os.Open(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	lines := []HistoryRecord{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record HistoryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, record)
+	}
+
+	if len(lines) != 2 || len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d lines and %d callbacks", len(lines), len(records))
+	}
+
+	ok := lines[0]
+	if ok.URL != server.URL+"/file.mp4" || ok.Path != filepath.Join(dir, "file.mp4") || ok.Size != int64(len(content)) ||
+		ok.Transferred != int64(len(content)) || ok.Error != "" || ok.Speed <= 0 || ok.StartedAt.IsZero() {
+		t.Errorf("unexpected record: %+v", ok)
+	}
+
+	failed := lines[1]
+	if failed.Error == "" || failed.Path != "" || failed.Checksum != config.ExpectedChecksum {
+		t.Errorf("unexpected record: %+v", failed)
+	}
+	if records[1].Error != failed.Error {
+		t.Errorf("expected the callback to get the same record, got %+v", records[1])
+	}
+}
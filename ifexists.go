@@ -0,0 +1,79 @@
+package download
+
+import (
+	"fmt"
+	"os"
+)
+
+// What to do when the file already exists, see Config.IfExists
+const (
+	// IfExistsOverwrite replaces the existing file
+	IfExistsOverwrite = "overwrite"
+	// IfExistsRename downloads to a new name, file (1).ext, file (2).ext and so on
+	IfExistsRename = "rename"
+	// IfExistsResume continues a shorter existing file, appending the rest of the remote file (curl -C -)
+	IfExistsResume = "resume"
+)
+
+// DefaultMaxRenames is the number of names tried by IfExistsRename
+var DefaultMaxRenames = 10000
+
+// reserveFilePath applies IfExists before the file is written,
+// with rename the first free name is created exclusively (O_EXCL),
+// so concurrent downloads of identically named files never get the same one.
+func (d *Downloader) reserveFilePath() error {
+	// the file is named by its digest
+	if d.ContentStore != "" {
+		return nil
+	}
+
+	switch d.IfExists {
+	case "", IfExistsOverwrite, IfExistsResume:
+		return nil
+	case IfExistsRename:
+	default:
+		return fmt.Errorf("unsupported if exists: %s", d.IfExists)
+	}
+
+	// already reserved, by a restart
+	if d.reservedPath != "" {
+		return nil
+	}
+
+	name := d.FileName
+	d.targetPath = d.getFilePath()
+	for i := 0; i <= DefaultMaxRenames; i++ {
+		if i > 0 {
+			d.FileName = fmt.Sprintf("%s (%d)", name, i)
+		}
+
+		path := d.getFilePath()
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, d.fileMode())
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			d.FileName = name
+			return err
+		}
+		f.Close()
+
+		d.reservedPath = path
+		return nil
+	}
+
+	d.FileName = name
+	return fmt.Errorf("no free file name for %s after %d renames", d.getFilePath(), DefaultMaxRenames)
+}
+
+// releaseFilePath removes the reserved file of a failed download
+func (d *Downloader) releaseFilePath() {
+	if d.reservedPath == "" {
+		return
+	}
+
+	if info, err := os.Stat(d.reservedPath); err == nil && info.Size() == 0 {
+		os.Remove(d.reservedPath)
+	}
+	d.reservedPath = ""
+}
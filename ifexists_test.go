@@ -0,0 +1,74 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIfExistsRename(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content := bytes.Repeat([]byte(filepath.Dir(r.URL.Path)), 20)
+		http.ServeContent(w, r, "clip.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "clip.mp4"), []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := Download(fmt.Sprintf("%s/%d/clip.mp4", server.URL, i), &Config{
+				FilePath:    filepath.Join(dir, "clip.mp4"),
+				TmpDir:      t.TempDir(),
+				SegmentSize: 16,
+				IfExists:    IfExistsRename,
+			})
+			if err == nil && (result.FilePath != filepath.Join(dir, "clip.mp4") || result.FinalPath == result.FilePath) {
+				err = fmt.Errorf("expected the renamed file apart from the path asked for, got %s %s", result.FilePath, result.FinalPath)
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	contents := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+		data, _ := os.ReadFile(filepath.Join(dir, entry.Name()))
+		contents = append(contents, string(data[:2]))
+	}
+	sort.Strings(contents)
+
+	expected := []string{"clip (1).mp4", "clip (2).mp4", "clip (3).mp4", "clip.mp4"}
+	if fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+	if fmt.Sprint(contents) != "[/0 /1 /2 ex]" {
+		t.Errorf("expected each download in its own file, got %v", contents)
+	}
+}
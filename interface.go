@@ -0,0 +1,87 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Interface is implemented by Downloader, depend on it rather than on *Downloader,
+// so the download can be replaced by downloadtest.Fake in unit tests.
+type Interface interface {
+	// Probe returns the info of the remote file without downloading it
+	Probe() (*FileInfo, error)
+	// Download downloads the file to its file path
+	Download() error
+	// DownloadTo downloads the file to w instead of a file
+	DownloadTo(w io.Writer) error
+}
+
+var _ Interface = (*Downloader)(nil)
+
+// DownloadTo downloads the file to w with a single request, nothing is written to the disk,
+// the response is checked before anything is written to w, the size and checksum once written.
+func (d *Downloader) DownloadTo(w io.Writer) error {
+	return d.DownloadToWithContext(context.Background(), w)
+}
+
+// DownloadToWithContext is DownloadTo with a context, cancelling ctx aborts the download
+func (d *Downloader) DownloadToWithContext(ctx context.Context, w io.Writer) error {
+	jobCtx, cancel := d.withMaxTime(ctx)
+	defer cancel()
+	d.ctx = jobCtx
+	defer d.registry().register(d)()
+
+	return d.checkMaxTime(ctx, d.downloadTo(w))
+}
+
+func (d *Downloader) downloadTo(w io.Writer) error {
+	expected, err := d.expectedChecksum()
+	if err != nil {
+		return err
+	}
+
+	if err := d.validateRange(); err != nil {
+		return err
+	}
+
+	if err := d.extract(); err != nil {
+		return err
+	}
+
+	var written int64
+	var sum []byte
+	err = d.do(http.MethodGet, d.URL, d.directRequestConfig(), func(resp *http.Response, body io.Reader) error {
+		if err := d.checkDirectResponse(resp); err != nil {
+			return err
+		}
+
+		if expected == nil {
+			written, err = io.Copy(w, body)
+			return err
+		}
+
+		h := expected.newHash()
+		written, err = io.Copy(io.MultiWriter(w, h), body)
+		sum = h.Sum(nil)
+		return err
+	})
+	if err != nil {
+		return classify(err)
+	}
+
+	if length := d.targetLength(); length > 0 && written != length {
+		return newChecksumError(fmt.Errorf("%w: expect %d, got %d", ErrSizeMismatch, length, written))
+	}
+
+	if d.ExpectedSize > 0 && written != d.ExpectedSize {
+		return newChecksumError(fmt.Errorf("%w: expect %d, got %d", ErrSizeMismatch, d.ExpectedSize, written))
+	}
+
+	if expected != nil {
+		return expected.check(sum)
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestDownloadTo(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	sum := sha256.Sum256(content)
+	var buf bytes.Buffer
+	d := New(server.URL+"/file.mp4", &Config{
+		TmpDir:           t.TempDir(),
+		ExpectedChecksum: "sha256:" + hex.EncodeToString(sum[:]),
+	})
+	if err := d.DownloadTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Errorf("unexpected content: %s", buf.Bytes())
+	}
+
+	wrong := sha256.Sum256([]byte("other"))
+	d = New(server.URL+"/file.mp4", &Config{
+		TmpDir:           t.TempDir(),
+		ExpectedChecksum: "sha256:" + hex.EncodeToString(wrong[:]),
+	})
+	if err := d.DownloadTo(&bytes.Buffer{}); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected a checksum mismatch, got %v", err)
+	}
+}
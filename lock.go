@@ -0,0 +1,265 @@
+package download
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-zoox/crypto/md5"
+)
+
+// DefaultLockStale is the age after which a lock file not refreshed by its holder is taken over,
+// the holder crashed, the holder refreshes it every third of it.
+var DefaultLockStale = 30 * time.Second
+
+// DefaultLockPollInterval is how often a waiting download checks the lock file
+var DefaultLockPollInterval = 100 * time.Millisecond
+
+// fileLock is a lock file held by the download, refreshed until released
+type fileLock struct {
+	path string
+	stop chan struct{}
+	done chan struct{}
+}
+
+// lockResult is written next to the lock file by the holder once the file is downloaded,
+// for the downloads waiting on the lock.
+type lockResult struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	// UpdatedAt is when the download completed
+	UpdatedAt time.Time
+}
+
+// lockPath returns the path of the lock file of the url, in TmpDir, shared by the processes of the machine
+func (d *Downloader) lockPath(ext string) string {
+	key := d.URL
+	if d.Range != nil {
+		key += " " + d.rangeHeader()
+	}
+
+	return fixLongPath(filepath.Join(d.TmpDir, md5.Md5(key)+ext))
+}
+
+// acquireLock takes the lock file of the url, waiting while another download holds it.
+// It returns true if it had to wait.
+func (d *Downloader) acquireLock(ctx context.Context) (*fileLock, bool, error) {
+	return lockFile(ctx, d.lockPath(".lock"))
+}
+
+// lockFile takes the lock file at path, created with O_EXCL, waiting while another download or process holds it,
+// a stale one is taken over. It returns true if it had to wait.
+func lockFile(ctx context.Context, path string) (*fileLock, bool, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, false, err
+	}
+
+	isWaited := false
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+
+			lock := &fileLock{
+				path: path,
+				stop: make(chan struct{}),
+				done: make(chan struct{}),
+			}
+			go lock.refresh()
+			return lock, isWaited, nil
+		}
+		if !os.IsExist(err) {
+			return nil, isWaited, err
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > DefaultLockStale {
+			if ok, err := takeOverLock(path); err != nil {
+				return nil, isWaited, err
+			} else if ok {
+				continue
+			}
+		}
+
+		if !isWaited && os.Getenv("DEBUG") == "true" {
+			fmt.Println("lock: waiting for another process:", path)
+		}
+		isWaited = true
+
+		select {
+		case <-ctx.Done():
+			return nil, isWaited, ctx.Err()
+		case <-time.After(DefaultLockPollInterval):
+		}
+	}
+}
+
+// takeOverLock removes the stale lock file at path holding its .takeover lock file, created with O_EXCL,
+// and checks it is still stale once held, so two processes finding it stale don't both take it over.
+// It returns true if removed.
+func takeOverLock(path string) (bool, error) {
+	guardPath := path + ".takeover"
+	f, err := os.OpenFile(guardPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		// another process is taking it over, or crashed doing so
+		if info, err := os.Stat(guardPath); err == nil && time.Since(info.ModTime()) > DefaultLockStale {
+			os.Remove(guardPath)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	f.Close()
+	defer os.Remove(guardPath)
+
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) <= DefaultLockStale {
+		// taken over by another process meanwhile
+		return false, nil
+	}
+
+	if os.Getenv("DEBUG") == "true" {
+		fmt.Println("lock: taking over the stale lock:", path)
+	}
+
+	return true, removeIfExists(path)
+}
+
+// refresh touches the lock file until released, so it is not taken over as stale
+func (l *fileLock) refresh() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(DefaultLockStale / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			os.Chtimes(l.path, now, now)
+		}
+	}
+}
+
+// release removes the lock file
+func (l *fileLock) release() {
+	close(l.stop)
+	<-l.done
+	os.Remove(l.path)
+}
+
+// downloadWithLock downloads the file holding the lock file of the url, so the processes downloading
+// the same url on the machine download it once, the others wait and reuse the downloaded file.
+func (d *Downloader) downloadWithLock(ctx context.Context) error {
+	startedAt := time.Now()
+	lock, isWaited, err := d.acquireLock(ctx)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	if isWaited {
+		if ok, err := d.downloadFromLockResult(startedAt); ok || err != nil {
+			return err
+		}
+	}
+
+	if err := d.download(); err != nil {
+		return err
+	}
+
+	// the waiting downloads download the file again
+	if err := d.writeLockResult(); err != nil && os.Getenv("DEBUG") == "true" {
+		fmt.Println("lock: failed to write the result:", err)
+	}
+
+	return nil
+}
+
+// downloadFromLockResult reuses the file downloaded by the holder of the lock since startedAt,
+// it returns false to download the file.
+func (d *Downloader) downloadFromLockResult(startedAt time.Time) (bool, error) {
+	data, err := ioutil.ReadFile(d.lockPath(".result"))
+	if err != nil {
+		return false, nil
+	}
+
+	result := &lockResult{}
+	if err := json.Unmarshal(data, result); err != nil || result.UpdatedAt.Before(startedAt) {
+		return false, nil
+	}
+
+	info, err := os.Stat(result.Path)
+	if err != nil || info.Size() != result.Size || !info.ModTime().Equal(result.ModTime) {
+		return false, nil
+	}
+
+	if d.ContentStore != "" {
+		d.storedPath = result.Path
+		return true, nil
+	}
+
+	if err := d.extract(); err != nil {
+		return false, err
+	}
+
+	if err := d.parseURL(d.URL); err != nil {
+		return false, err
+	}
+
+	if !d.IsRangesDisabled {
+		if _, err := d.probe(); err != nil {
+			return false, err
+		}
+	}
+
+	if err := d.adoptFile(result.Path); err != nil {
+		return false, err
+	}
+
+	if os.Getenv("DEBUG") == "true" {
+		fmt.Println("lock: reusing the file downloaded by another process:", result.Path)
+	}
+
+	return true, nil
+}
+
+// writeLockResult records the downloaded file for the downloads waiting on the lock
+func (d *Downloader) writeLockResult() error {
+	filePath, err := filepath.Abs(d.getFilePath())
+	if err != nil {
+		return err
+	}
+	if d.storedPath != "" {
+		filePath = d.storedPath
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(&lockResult{
+		Path:      filePath,
+		Size:      info.Size(),
+		ModTime:   info.ModTime(),
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	path := d.lockPath(".result")
+	tmpPath := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
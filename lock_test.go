@@ -0,0 +1,149 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLockFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&gets, 1)
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	dir := t.TempDir()
+	for _, disabled := range []bool{false, true} {
+		atomic.StoreInt32(&gets, 0)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 3)
+		for _, name := range []string{"a.mp4", "b.mp4", "c.mp4"} {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				_, err := Download(server.URL+"/file.mp4", &Config{
+					FilePath:         filepath.Join(dir, name),
+					TmpDir:           tmpDir,
+					IsRangesDisabled: disabled,
+					LockFile:         true,
+				})
+				errs <- err
+			}(name)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if n := atomic.LoadInt32(&gets); n != 1 {
+			t.Errorf("expected the file downloaded once, got %d GET", n)
+		}
+
+		for _, name := range []string{"a.mp4", "b.mp4", "c.mp4"} {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, content) {
+				t.Errorf("unexpected content of %s", name)
+			}
+			os.Remove(filepath.Join(dir, name))
+		}
+	}
+}
+
+func TestLockFileStale(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	d := New(server.URL+"/file.mp4", &Config{
+		FilePath: filepath.Join(t.TempDir(), "file.mp4"),
+		TmpDir:   tmpDir,
+		LockFile: true,
+	})
+
+	// left by a crashed process
+	lockPath := d.lockPath(".lock")
+	if err := os.WriteFile(lockPath, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-2 * DefaultLockStale)
+	os.Chtimes(lockPath, stale, stale)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Download()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stale lock taken over")
+	}
+
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Error("expected the lock released")
+	}
+}
+
+func TestLockFileStaleTakeover(t *testing.T) {
+	d := New("https://example.com/file.mp4", &Config{
+		TmpDir:   t.TempDir(),
+		LockFile: true,
+	})
+
+	lockPath := d.lockPath(".lock")
+	if err := os.WriteFile(lockPath, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-2 * DefaultLockStale)
+	os.Chtimes(lockPath, stale, stale)
+
+	// the downloads finding the lock stale together, one takes it over
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	var holders int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock, _, err := d.acquireLock(ctx)
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&holders, 1)
+			<-ctx.Done()
+			lock.release()
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&holders); n != 1 {
+		t.Errorf("expected one download to take the stale lock over, got %d", n)
+	}
+}
@@ -0,0 +1,157 @@
+package download
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-zoox/fs"
+)
+
+// ErrInterrupted means the download was stopped before completion,
+// the parts and the manifest are kept so running it again resumes.
+var ErrInterrupted = errors.New("download interrupted, resumable")
+
+// InterruptedError is returned when the download is cancelled,
+// errors.Is(err, ErrInterrupted) and errors.Is(err, context.Canceled) both hold.
+type InterruptedError struct {
+	// Err is the cancellation cause, context.Canceled or context.DeadlineExceeded
+	Err error
+	// Manifest is the path of the flushed resume manifest
+	Manifest string
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrInterrupted, e.Err)
+}
+
+// Unwrap returns the cancellation cause
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports the error as ErrInterrupted
+func (e *InterruptedError) Is(target error) bool {
+	return target == ErrInterrupted
+}
+
+// Manifest represents the resume state of a segmented download, stored next to the parts
+type Manifest struct {
+	URL           string
+	FileName      string
+	FileExt       string
+	ContentType   string
+	ContentLength int64
+	ETag          string
+	LastModified  string
+	SegmentSize   int64
+	Parts         []*ManifestPart
+	UpdatedAt     time.Time
+}
+
+// ManifestPart represents the state of a part in the manifest
+type ManifestPart struct {
+	Index      int
+	RangeStart int64
+	RangeEnd   int64
+	// Size is the number of bytes on disk
+	Size   int64
+	IsDone bool
+	// SHA256 is the hex digest of the Size bytes of the part, set by ExportState
+	SHA256 string `json:",omitempty"`
+}
+
+func (d *Downloader) manifestPath() string {
+	return fixLongPath(filepath.Join(d.TmpDir, d.Hash, "manifest.json"))
+}
+
+// flushManifest writes the manifest of the parts on disk
+func (d *Downloader) flushManifest() error {
+	return d.writeManifest(d.manifest())
+}
+
+// manifest returns the resume state of the parts on disk
+func (d *Downloader) manifest() *Manifest {
+	manifest := &Manifest{
+		URL:           d.URL,
+		FileName:      d.FileName,
+		FileExt:       d.FileExt,
+		ContentType:   d.ContentType,
+		ContentLength: d.ContentLength,
+		ETag:          d.ETag,
+		LastModified:  d.LastModified,
+		SegmentSize:   d.SegmentSize,
+		UpdatedAt:     time.Now(),
+	}
+	for _, part := range d.FileParts {
+		size := fs.Size(part.Path)
+		manifest.Parts = append(manifest.Parts, &ManifestPart{
+			Index:      part.Index,
+			RangeStart: part.RangeStart,
+			RangeEnd:   part.RangeEnd,
+			Size:       size,
+			IsDone:     size == part.RangeEnd-part.RangeStart+1,
+		})
+	}
+
+	return manifest
+}
+
+// writeManifest writes the manifest atomically, so an interruption while
+// flushing never leaves a torn manifest behind.
+func (d *Downloader) writeManifest(manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := d.manifestPath()
+	dirPath := filepath.Dir(path)
+	if !fs.IsExist(dirPath) {
+		if err := fs.Mkdirp(dirPath); err != nil {
+			return err
+		}
+	}
+
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// readManifest reads the manifest left by a previous run, nil if there is none
+func (d *Downloader) readManifest() (*Manifest, error) {
+	data, err := ioutil.ReadFile(d.manifestPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// interrupted flushes the manifest and wraps the cancellation cause
+func (d *Downloader) interrupted(cause error) error {
+	if err := d.flushManifest(); err != nil {
+		return fmt.Errorf("%s, failed to flush manifest: %s", cause, err)
+	}
+
+	return &InterruptedError{
+		Err:      cause,
+		Manifest: d.manifestPath(),
+	}
+}
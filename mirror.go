@@ -0,0 +1,404 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zoox/fs"
+)
+
+const (
+	// MirrorStrategyFastest picks the fastest measured source for every part (default)
+	MirrorStrategyFastest = "fastest"
+	// MirrorStrategyRace downloads the first part from every source simultaneously,
+	// then drops the dead and slow sources, cancelling their requests, before downloading the remaining parts
+	MirrorStrategyRace = "race"
+	// MirrorStrategyFailover uses the sources in order, switching to the next one
+	// when the active source is down
+	MirrorStrategyFailover = "failover"
+	// MirrorStrategyNearest probes every source (RTT and a tiny ranged GET) before downloading,
+	// then uses the best one, falling back to the next best ones like failover
+	MirrorStrategyNearest = "nearest"
+)
+
+// DefaultMirrorProbeSize is the size of the ranged GET used to probe the sources for the nearest strategy
+var DefaultMirrorProbeSize = 64 * 1024
+
+// DefaultMirrorRegionBias divides the probe cost of the sources in the same region as Config.Region
+var DefaultMirrorRegionBias = 2.0
+
+// DefaultMirrorMaxFailures is the number of consecutive failures after which a source is marked down
+var DefaultMirrorMaxFailures = 3
+
+// DefaultRaceSlowRatio is the ratio of the winner speed below which a mirror is dropped as slow
+var DefaultRaceSlowRatio = 0.25
+
+// DefaultRaceGrace is the multiple of the winner elapsed time the other mirrors have to finish the race
+var DefaultRaceGrace = 4
+
+type raceResult struct {
+	src     *source
+	path    string
+	elapsed time.Duration
+	err     error
+}
+
+// drop removes the source from the pool
+func (p *sourcePool) drop(s *source) {
+	p.Lock()
+	defer p.Unlock()
+
+	list := make([]*source, 0, len(p.list))
+	for _, one := range p.list {
+		if one != s {
+			list = append(list, one)
+		}
+	}
+	p.list = list
+}
+
+// measure records a transfer made outside of pick/done
+func (p *sourcePool) measure(s *source, bytes int64, elapsed time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+
+	s.bytes += bytes
+	s.elapsed += elapsed
+}
+
+// race downloads the first part from every source simultaneously,
+// keeps the winner's copy and drops dead or slow sources.
+func (d *Downloader) race() error {
+	// the health check marked the dead sources down
+	d.sources.Lock()
+	var srcs, dead []*source
+	for _, src := range d.sources.list {
+		if src.down {
+			dead = append(dead, src)
+			continue
+		}
+
+		srcs = append(srcs, src)
+	}
+	d.sources.Unlock()
+
+	if len(d.FileParts) == 0 || len(srcs)+len(dead) < 2 {
+		return nil
+	}
+
+	part := d.FileParts[0]
+	size := part.RangeEnd - part.RangeStart + 1
+	if fs.IsExist(part.Path) && fs.Size(part.Path) == size {
+		// already downloaded by a previous run, nothing to measure
+		return nil
+	}
+
+	dirPath := filepath.Dir(part.Path)
+	if !fs.IsExist(dirPath) {
+		if err := fs.Mkdir(dirPath); err != nil {
+			return err
+		}
+	}
+
+	for _, src := range dead {
+		d.sources.drop(src)
+	}
+	if len(srcs) < 2 {
+		return nil
+	}
+
+	// the racers still running once the race is decided are cancelled, they lost
+	ctx, cancel := context.WithCancel(d.getContext())
+	defer cancel()
+
+	results := make(chan *raceResult, len(srcs))
+	for i, src := range srcs {
+		go func(i int, src *source) {
+			racePart := *part
+			racePart.Path = fmt.Sprintf("%s.race.%d", part.Path, i)
+
+			startedAt := time.Now()
+			err := safeRun(func() error {
+				if err := d.fetchFilePart(ctx, src.URL, &racePart); err != nil {
+					return err
+				}
+
+				return d.verifyFilePart(&racePart)
+			})
+
+			results <- &raceResult{
+				src:     src,
+				path:    racePart.Path,
+				elapsed: time.Since(startedAt),
+				err:     err,
+			}
+		}(i, src)
+	}
+
+	var winner *raceResult
+	var lastErr error
+	var deadline <-chan time.Time
+	finished := map[*source]*raceResult{}
+	pending := len(srcs)
+
+wait:
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			finished[r.src] = r
+			if r.err != nil {
+				lastErr = r.err
+				os.Remove(r.path)
+				continue
+			}
+
+			if winner == nil {
+				winner = r
+				deadline = time.After(r.elapsed * time.Duration(DefaultRaceGrace))
+				continue
+			}
+
+			os.Remove(r.path)
+		case <-deadline:
+			break wait
+		}
+	}
+
+	// cancel the mirrors still racing after the deadline and clean up their copies
+	cancel()
+	go func(pending int) {
+		for ; pending > 0; pending-- {
+			os.Remove((<-results).path)
+		}
+	}(pending)
+
+	if winner == nil {
+		return fmt.Errorf("all %d sources failed the race: %s", len(srcs), lastErr)
+	}
+
+	winnerSpeed := float64(size) / winner.elapsed.Seconds()
+	for _, src := range srcs {
+		r, ok := finished[src]
+		if !ok || r.err != nil {
+			if os.Getenv("DEBUG") == "true" {
+				fmt.Println("race: drop dead or slow source:", src.URL)
+			}
+
+			d.sources.drop(src)
+			continue
+		}
+
+		if speed := float64(size) / r.elapsed.Seconds(); speed < winnerSpeed*DefaultRaceSlowRatio {
+			if os.Getenv("DEBUG") == "true" {
+				fmt.Println("race: drop slow source:", src.URL)
+			}
+
+			d.sources.drop(src)
+			continue
+		}
+
+		d.sources.measure(src, size, r.elapsed)
+	}
+
+	return os.Rename(winner.path, part.Path)
+}
+
+// MirrorHealth represents the health of a source
+type MirrorHealth struct {
+	URL string
+	// Latency is the HEAD latency measured by the health check
+	Latency time.Duration
+	// Speed is the average download speed in bytes per second
+	Speed float64
+	//
+	Requests int
+	Failures int
+	// ErrorRate is Failures / Requests
+	ErrorRate float64
+	// IsDown is true when the source is considered unhealthy
+	IsDown bool
+	// Region is the region of the source, from Config.MirrorRegions
+	Region string
+	// Disagreements is the number of pieces the source served differently from the other sources
+	Disagreements int
+}
+
+// MirrorsHealth returns the health of the url and its mirrors
+func (d *Downloader) MirrorsHealth() []*MirrorHealth {
+	if d.sources == nil {
+		return nil
+	}
+
+	d.sources.Lock()
+	defer d.sources.Unlock()
+
+	list := make([]*MirrorHealth, 0, len(d.sources.list))
+	for _, s := range d.sources.list {
+		h := &MirrorHealth{
+			URL:           s.URL,
+			Latency:       s.latency,
+			Requests:      s.requests,
+			Failures:      s.failures,
+			IsDown:        s.down,
+			Region:        d.MirrorRegions[s.URL],
+			Disagreements: s.disagreements,
+		}
+		if s.elapsed > 0 {
+			h.Speed = float64(s.bytes) / s.elapsed.Seconds()
+		}
+		if s.requests > 0 {
+			h.ErrorRate = float64(s.failures) / float64(s.requests)
+		}
+
+		list = append(list, h)
+	}
+
+	return list
+}
+
+// checkSourcesHealth sends a HEAD request to every source, the ones failing
+// or serving a different file are marked down.
+func (d *Downloader) checkSourcesHealth() {
+	var wg sync.WaitGroup
+	for _, src := range d.sources.list {
+		wg.Add(1)
+		go func(src *source) {
+			defer wg.Done()
+
+			startedAt := time.Now()
+			err := safeRun(func() error {
+				return d.checkSourceHealth(src.URL)
+			})
+			latency := time.Since(startedAt)
+
+			d.sources.Lock()
+			defer d.sources.Unlock()
+			src.latency = latency
+			if err != nil {
+				if os.Getenv("DEBUG") == "true" {
+					fmt.Println("health check: source is down:", src.URL, err)
+				}
+
+				src.down = true
+			}
+		}(src)
+	}
+
+	wg.Wait()
+}
+
+func (d *Downloader) checkSourceHealth(url string) error {
+	response, err := d.fetchHead(url)
+	if err != nil {
+		return err
+	}
+
+	if response.Status != http.StatusOK {
+		return fmt.Errorf("invalid status: %d", response.Status)
+	}
+
+	contentLength, err := parseContentLength(response.Headers)
+	if err != nil {
+		return err
+	}
+	if d.ContentLength > 0 && contentLength != d.ContentLength {
+		return fmt.Errorf("content length mismatch: expect %d, got %d", d.ContentLength, contentLength)
+	}
+
+	return nil
+}
+
+// probeSources measures the time to fetch a tiny range from every healthy source
+// and sorts the sources by it, the best first.
+func (d *Downloader) probeSources() {
+	costs := map[*source]time.Duration{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, src := range d.sources.list {
+		if src.down {
+			continue
+		}
+
+		wg.Add(1)
+		go func(src *source) {
+			defer wg.Done()
+
+			startedAt := time.Now()
+			err := safeRun(func() error {
+				return d.probeSource(src.URL)
+			})
+			if err != nil {
+				if os.Getenv("DEBUG") == "true" {
+					fmt.Println("probe: source is down:", src.URL, err)
+				}
+
+				d.sources.Lock()
+				src.down = true
+				d.sources.Unlock()
+				return
+			}
+
+			mu.Lock()
+			costs[src] = src.latency + time.Since(startedAt)
+			mu.Unlock()
+		}(src)
+	}
+	wg.Wait()
+
+	d.sources.Lock()
+	defer d.sources.Unlock()
+	rankSources(d.sources.list, costs, d.MirrorRegions, d.Region)
+}
+
+func (d *Downloader) probeSource(url string) error {
+	end := DefaultMirrorProbeSize - 1
+	if d.ContentLength > 0 && int64(end) >= d.ContentLength {
+		end = int(d.ContentLength - 1)
+	}
+
+	response, err := d.fetchGet(url, &requestConfig{
+		Headers: map[string]string{
+			"Range": fmt.Sprintf("bytes=0-%d", end),
+		},
+		Timeout: 10 * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+
+	if response.Status != http.StatusPartialContent {
+		return fmt.Errorf("invalid status: %d", response.Status)
+	}
+
+	return nil
+}
+
+// rankSources sorts the sources by probe cost, biased by region, unprobed sources last.
+func rankSources(list []*source, costs map[*source]time.Duration, regions map[string]string, region string) {
+	score := func(s *source) float64 {
+		cost, ok := costs[s]
+		if !ok {
+			return math.MaxFloat64
+		}
+
+		c := float64(cost)
+		if region != "" && strings.EqualFold(regions[s.URL], region) {
+			c /= DefaultMirrorRegionBias
+		}
+
+		return c
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return score(list[i]) < score(list[j])
+	})
+}
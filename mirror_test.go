@@ -0,0 +1,252 @@
+package download
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMirrorRace(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+
+	fast := newRangeServer(content)
+	defer fast.Close()
+	var isCancelled int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			atomic.StoreInt32(&isCancelled, 1)
+			return
+		case <-time.After(300 * time.Millisecond):
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer slow.Close()
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer dead.Close()
+
+	filePath := t.TempDir() + "/race.mp4"
+	d := New(fast.URL+"/file.mp4", &Config{
+		FilePath:       filePath,
+		TmpDir:         t.TempDir(),
+		SegmentSize:    32,
+		Mirrors:        []string{slow.URL + "/file.mp4", dead.URL + "/file.mp4"},
+		MirrorStrategy: MirrorStrategyRace,
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.sources.list) != 1 || d.sources.list[0].URL != fast.URL+"/file.mp4" {
+		for _, s := range d.sources.list {
+			t.Log("source:", s.URL)
+		}
+		t.Errorf("expected only the fast source to be kept")
+	}
+	// the server sees the lost request cancelled once the client closed it
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&isCancelled) != 1 {
+		t.Error("expected the slow request cancelled once the race was decided")
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("unexpected content: %s", data)
+	}
+}
+
+func TestMirrorFailover(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+
+	// primary fails every range but the first one, as if it broke mid-download, once the first one is served
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "bytes=0-31" {
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer primary.Close()
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer dead.Close()
+	next := newRangeServer(content)
+	defer next.Close()
+	last := newRangeServer(content)
+	defer last.Close()
+
+	filePath := t.TempDir() + "/failover.mp4"
+	d := New(primary.URL+"/file.mp4", &Config{
+		FilePath:       filePath,
+		TmpDir:         t.TempDir(),
+		SegmentSize:    32,
+		Mirrors:        []string{dead.URL + "/file.mp4", next.URL + "/file.mp4", last.URL + "/file.mp4"},
+		MirrorStrategy: MirrorStrategyFailover,
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	health := d.MirrorsHealth()
+	if !health[0].IsDown || health[0].Failures != 3 {
+		t.Errorf("expected primary to be down after 3 failures, got %+v", health[0])
+	}
+	if !health[1].IsDown || health[1].Requests != 0 {
+		t.Errorf("expected dead mirror to be skipped, got %+v", health[1])
+	}
+	if health[2].Requests != 3 || health[3].Requests != 0 {
+		t.Errorf("expected the next mirror to take over, got %+v %+v", health[2], health[3])
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("unexpected content: %s", data)
+	}
+}
+
+func TestMirrorRecovers(t *testing.T) {
+	p := newSourcePool("https://primary.example/file", "https://mirror.example/file")
+	p.strategy = MirrorStrategyFailover
+	primary := p.list[0]
+	for i := 0; i < DefaultMirrorMaxFailures; i++ {
+		s, _ := p.pick(nil)
+		p.done(s, 0, 0, errors.New("bad gateway"))
+	}
+	if !primary.down {
+		t.Fatal("expected primary down")
+	}
+
+	// tried only when excluding the others, it serves a request again
+	s, _ := p.pick(map[string]bool{"https://mirror.example/file": true})
+	p.done(s, 32, time.Millisecond, nil)
+	if s, _ := p.pick(nil); primary.down || s != primary {
+		t.Errorf("expected primary back once it served a request")
+	}
+}
+
+func TestMirrorRotation(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+
+	// primary keeps throttling one range, retried on the same source until the budget runs out
+	var primaryRequests, mirrorRequests int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=32-63" {
+			atomic.AddInt32(&primaryRequests, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer primary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&mirrorRequests, 1)
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer mirror.Close()
+
+	filePath := t.TempDir() + "/rotation.mp4"
+	d := New(primary.URL+"/file.mp4", &Config{
+		FilePath:       filePath,
+		TmpDir:         t.TempDir(),
+		SegmentSize:    32,
+		Mirrors:        []string{mirror.URL + "/file.mp4"},
+		MirrorStrategy: MirrorStrategyFailover,
+		RetryPolicy: &RetryPolicy{
+			MaxAttempts:   2,
+			BaseDelay:     time.Millisecond,
+			StatusActions: map[int]string{http.StatusServiceUnavailable: StatusActionRetry},
+		},
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt32(&primaryRequests); n != 2 {
+		t.Errorf("expected the 2 attempts of the budget on primary, got %d", n)
+	}
+	if n := atomic.LoadInt32(&mirrorRequests); n != 1 {
+		t.Errorf("expected the range rotated to the mirror once, got %d requests", n)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("unexpected content: %s", data)
+	}
+}
+
+func TestMirrorNearest(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			time.Sleep(100 * time.Millisecond)
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer slow.Close()
+	near := newRangeServer(content)
+	defer near.Close()
+
+	filePath := t.TempDir() + "/nearest.mp4"
+	d := New(slow.URL+"/file.mp4", &Config{
+		FilePath:       filePath,
+		TmpDir:         t.TempDir(),
+		SegmentSize:    32,
+		Mirrors:        []string{near.URL + "/file.mp4"},
+		MirrorStrategy: MirrorStrategyNearest,
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	health := d.MirrorsHealth()
+	if health[0].URL != near.URL+"/file.mp4" || health[0].Requests != 4 {
+		t.Errorf("expected the nearest mirror to be used, got %+v", health[0])
+	}
+}
+
+func TestRankSources(t *testing.T) {
+	us := &source{URL: "https://us.example/file"}
+	eu := &source{URL: "https://eu.example/file"}
+	down := &source{URL: "https://down.example/file"}
+	list := []*source{down, us, eu}
+
+	costs := map[*source]time.Duration{
+		us: 100 * time.Millisecond,
+		eu: 150 * time.Millisecond,
+	}
+	regions := map[string]string{
+		us.URL: "us",
+		eu.URL: "eu",
+	}
+
+	rankSources(list, costs, regions, "")
+	if list[0] != us || list[1] != eu || list[2] != down {
+		t.Errorf("expected us, eu, down without region hint")
+	}
+
+	rankSources(list, costs, regions, "EU")
+	if list[0] != eu || list[1] != us {
+		t.Errorf("expected eu to be preferred with region hint")
+	}
+}
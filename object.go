@@ -0,0 +1,262 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// DefaultUploadPartSize is the size of the parts uploaded to Object, S3 allows at most 10000 parts,
+// so it bounds the file to about 160GB, a larger file uses larger parts.
+var DefaultUploadPartSize = 16 * 1024 * 1024
+
+// maxUploadParts is the number of parts of a S3 multipart upload
+const maxUploadParts = 10000
+
+// ObjectStore uploads an object in parts, S3 multipart upload semantics, see Config.Object
+type ObjectStore interface {
+	// CreateMultipartUpload starts the upload of the object, returning its id
+	CreateMultipartUpload(ctx context.Context, bucket, key string) (string, error)
+	// UploadPart uploads the part number (from 1) of the upload, returning its etag
+	UploadPart(ctx context.Context, bucket, key, uploadID string, number int, data []byte) (string, error)
+	// CompleteMultipartUpload assembles the object from the etags of the parts, in order
+	CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, etags []string) error
+	// AbortMultipartUpload discards the uploaded parts
+	AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error
+}
+
+// parseObjectURL returns the scheme, bucket and key of s3://bucket/key or gs://bucket/key,
+// a key ending with / (or none) is a prefix the file name is appended to.
+func parseObjectURL(raw string) (string, string, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid object url %s: %s", raw, err)
+	}
+
+	if u.Scheme != "s3" && u.Scheme != "gs" {
+		return "", "", "", fmt.Errorf("invalid object url %s: unsupported scheme, expect s3:// or gs://", raw)
+	}
+
+	if u.Host == "" {
+		return "", "", "", fmt.Errorf("invalid object url %s: expect %s://bucket/key", raw, u.Scheme)
+	}
+
+	return u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// defaultObjectStore returns the store of the scheme configured from the environment,
+// Google Cloud Storage is used through its S3 compatible api with HMAC keys.
+func defaultObjectStore(scheme string) ObjectStore {
+	if scheme == "gs" {
+		return NewS3Store(&S3Config{
+			Endpoint:        "https://storage.googleapis.com",
+			Region:          "auto",
+			AccessKeyID:     os.Getenv("GCS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("GCS_SECRET_ACCESS_KEY"),
+		})
+	}
+
+	return NewS3Store(S3ConfigFromEnv())
+}
+
+// uploadPartSize returns the part size fitting a file of size in maxUploadParts parts
+func uploadPartSize(size int64) int {
+	partSize := DefaultUploadPartSize
+	if size > int64(partSize)*maxUploadParts {
+		partSize = int((size + maxUploadParts - 1) / maxUploadParts)
+	}
+
+	return partSize
+}
+
+// objectSink is a Sink multipart uploading the files to a bucket
+type objectSink struct {
+	store    ObjectStore
+	location string
+	// retry calls the upload of a part according to the retry policy of the download, once when nil
+	retry func(part *FilePart, fn func() error) error
+}
+
+// NewObjectSink returns a Sink uploading the files to location, s3://bucket/key or gs://bucket/key,
+// a location ending with / is a prefix the file name is appended to. A nil store is configured from
+// the environment, see Config.ObjectStore.
+func NewObjectSink(store ObjectStore, location string) Sink {
+	return &objectSink{store: store, location: location}
+}
+
+func (s *objectSink) Create(ctx context.Context, name string, size int64) (SinkWriter, error) {
+	scheme, bucket, key, err := parseObjectURL(s.location)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" || strings.HasSuffix(key, "/") {
+		key += name
+	}
+
+	store := s.store
+	if store == nil {
+		store = defaultObjectStore(scheme)
+	}
+
+	uploadID, err := store.CreateMultipartUpload(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	location := scheme + "://" + bucket + "/" + key
+	if os.Getenv("DEBUG") == "true" {
+		fmt.Println("object: uploading to:", location, uploadID)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &objectWriter{
+		ctx:      ctx,
+		cancel:   cancel,
+		store:    store,
+		location: location,
+		bucket:   bucket,
+		key:      key,
+		uploadID: uploadID,
+		partSize: uploadPartSize(size),
+		slots:    make(chan struct{}, DefaultConcurrency),
+		retry:    s.retry,
+	}, nil
+}
+
+// objectWriter uploads the written bytes in parts, at most DefaultConcurrency of them in flight
+type objectWriter struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	store    ObjectStore
+	location string
+	bucket   string
+	key      string
+	uploadID string
+	partSize int
+	buf      []byte
+	slots    chan struct{}
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	etags    []string
+	err      error
+	retry    func(part *FilePart, fn func() error) error
+}
+
+func (w *objectWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := w.partSize - len(w.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(w.buf) == w.partSize {
+			if err := w.upload(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// upload uploads the buffered part in the background
+func (w *objectWriter) upload() error {
+	select {
+	case w.slots <- struct{}{}:
+	case <-w.ctx.Done():
+		// a part failed, or the download was cancelled
+		if err := w.failed(); err != nil {
+			return err
+		}
+		return w.ctx.Err()
+	}
+
+	w.mu.Lock()
+	w.etags = append(w.etags, "")
+	number := len(w.etags)
+	w.mu.Unlock()
+
+	data := w.buf
+	w.buf = nil
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer func() { <-w.slots }()
+
+		// the part uploaded, the one RetryPolicy.OnRetry is called with
+		start := int64(number-1) * int64(w.partSize)
+		part := &FilePart{Index: number - 1, RangeStart: start, RangeEnd: start + int64(len(data)) - 1}
+
+		var etag string
+		err := w.uploadPart(part, func() (err error) {
+			etag, err = w.store.UploadPart(w.ctx, w.bucket, w.key, w.uploadID, number, data)
+			return err
+		})
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if err != nil {
+			if w.err == nil {
+				w.err = err
+				w.cancel()
+			}
+			return
+		}
+		w.etags[number-1] = etag
+	}()
+
+	return nil
+}
+
+// uploadPart calls upload of the part, retried as the parts of the download when the sink belongs to one
+func (w *objectWriter) uploadPart(part *FilePart, upload func() error) error {
+	if w.retry == nil {
+		return upload()
+	}
+
+	return w.retry(part, upload)
+}
+
+func (w *objectWriter) failed() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Commit uploads the last part, an empty file is uploaded as an empty part, and completes the upload
+func (w *objectWriter) Commit() error {
+	defer w.cancel()
+
+	if len(w.buf) > 0 || len(w.etags) == 0 {
+		if err := w.upload(); err != nil {
+			return err
+		}
+	}
+
+	w.wg.Wait()
+	if err := w.failed(); err != nil {
+		return err
+	}
+
+	return w.store.CompleteMultipartUpload(w.ctx, w.bucket, w.key, w.uploadID, w.etags)
+}
+
+// Abort discards the uploaded parts, even when the download is cancelled
+func (w *objectWriter) Abort() error {
+	w.cancel()
+	w.wg.Wait()
+
+	return w.store.AbortMultipartUpload(context.Background(), w.bucket, w.key, w.uploadID)
+}
+
+func (w *objectWriter) Location() string {
+	return w.location
+}
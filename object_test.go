@@ -0,0 +1,230 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore is an ObjectStore recording the uploads
+type memoryStore struct {
+	mu       sync.Mutex
+	parts    map[int][]byte
+	object   []byte
+	aborted  bool
+	maxPart  int
+	failPart int
+	// throttlePart is throttled (503) once, throttled counts its uploads
+	throttlePart int
+	throttled    int
+}
+
+func (s *memoryStore) CreateMultipartUpload(ctx context.Context, bucket, key string) (string, error) {
+	s.parts = map[int][]byte{}
+	return "upload", nil
+}
+
+func (s *memoryStore) UploadPart(ctx context.Context, bucket, key, uploadID string, number int, data []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if number == s.failPart {
+		return "", errors.New("upload failed")
+	}
+	if number == s.throttlePart {
+		if s.throttled++; s.throttled == 1 {
+			return "", newStatusError(http.StatusServiceUnavailable)
+		}
+	}
+	if len(data) > s.maxPart {
+		s.maxPart = len(data)
+	}
+	s.parts[number] = append([]byte{}, data...)
+	return fmt.Sprint(number), nil
+}
+
+func (s *memoryStore) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, etags []string) error {
+	for i, etag := range etags {
+		if etag != fmt.Sprint(i+1) {
+			return fmt.Errorf("unexpected etag of part %d: %s", i+1, etag)
+		}
+		s.object = append(s.object, s.parts[i+1]...)
+	}
+	return nil
+}
+
+func (s *memoryStore) AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error {
+	s.aborted = true
+	return nil
+}
+
+func TestDownloadToObject(t *testing.T) {
+	partSize := DefaultUploadPartSize
+	DefaultUploadPartSize = 1000
+	defer func() { DefaultUploadPartSize = partSize }()
+
+	content := bytes.Repeat([]byte("0123456789"), 550)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	sum := sha256.Sum256(content)
+	for _, disabled := range []bool{false, true} {
+		store := &memoryStore{}
+		d := New(server.URL+"/file.mp4", &Config{
+			SegmentSize:      700,
+			IsRangesDisabled: disabled,
+			Object:           "s3://bucket/file.mp4",
+			ObjectStore:      store,
+			ExpectedChecksum: "sha256:" + hex.EncodeToString(sum[:]),
+		})
+		if err := d.Download(); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(store.object, content) {
+			t.Errorf("unexpected object of %d bytes", len(store.object))
+		}
+		if store.maxPart != 1000 || len(store.parts) != 6 {
+			t.Errorf("expected 6 parts of at most 1000 bytes, got %d parts of at most %d", len(store.parts), store.maxPart)
+		}
+		if d.FilePath != "s3://bucket/file.mp4" {
+			t.Errorf("unexpected file path: %s", d.FilePath)
+		}
+	}
+}
+
+func TestDownloadToObjectAbort(t *testing.T) {
+	partSize := DefaultUploadPartSize
+	DefaultUploadPartSize = 1000
+	defer func() { DefaultUploadPartSize = partSize }()
+
+	content := bytes.Repeat([]byte("0123456789"), 550)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	// a mismatched checksum aborts the upload
+	store := &memoryStore{}
+	err := New(server.URL+"/file.mp4", &Config{
+		Object:           "s3://bucket/file.mp4",
+		ObjectStore:      store,
+		ExpectedChecksum: "sha256:" + hex.EncodeToString(make([]byte, 32)),
+	}).Download()
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if !store.aborted || store.object != nil {
+		t.Error("expected the upload aborted")
+	}
+
+	// so does a failed part
+	store = &memoryStore{failPart: 3}
+	err = New(server.URL+"/file.mp4", &Config{
+		Object:      "s3://bucket/file.mp4",
+		ObjectStore: store,
+	}).Download()
+	if err == nil || !store.aborted {
+		t.Errorf("expected the upload aborted, got %v", err)
+	}
+
+	if err := New(server.URL+"/file.mp4", &Config{Object: "ftp://bucket/file"}).Download(); err == nil {
+		t.Error("expected an invalid object url error")
+	}
+}
+
+func TestDownloadToObjectRetry(t *testing.T) {
+	partSize := DefaultUploadPartSize
+	DefaultUploadPartSize = 1000
+	defer func() { DefaultUploadPartSize = partSize }()
+
+	content := bytes.Repeat([]byte("0123456789"), 550)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	// a throttled part is uploaded again with the retry policy of the download
+	var retries int
+	var retried FilePart
+	store := &memoryStore{throttlePart: 3}
+	err := New(server.URL+"/file.mp4", &Config{
+		Object:      "s3://bucket/file.mp4",
+		ObjectStore: store,
+		RetryPolicy: &RetryPolicy{
+			BaseDelay: time.Millisecond,
+			OnRetry: func(part *FilePart, attempt int, err error) {
+				retries++
+				retried = *part
+			},
+		},
+	}).Download()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if store.throttled != 2 || retries != 1 {
+		t.Errorf("expected the throttled part uploaded twice, got %d uploads, %d retries", store.throttled, retries)
+	}
+	// OnRetry is called with the uploaded part
+	if retried.Index != 2 || retried.RangeStart != 2000 || retried.RangeEnd != 2999 {
+		t.Errorf("expected OnRetry with the part 2 of the upload, got %+v", retried)
+	}
+	if !bytes.Equal(store.object, content) {
+		t.Errorf("unexpected object of %d bytes", len(store.object))
+	}
+
+	// so is one of a sink given as is
+	store = &memoryStore{throttlePart: 2}
+	if err := New(server.URL+"/file.mp4", &Config{
+		Sink:        NewObjectSink(store, "s3://bucket/"),
+		RetryPolicy: &RetryPolicy{BaseDelay: time.Millisecond},
+	}).Download(); err != nil {
+		t.Fatal(err)
+	}
+	if store.throttled != 2 {
+		t.Errorf("expected the throttled part uploaded twice, got %d uploads", store.throttled)
+	}
+}
+
+func TestDownloadToObjectEmpty(t *testing.T) {
+	server := newRangeServer([]byte{})
+	defer server.Close()
+
+	store := &memoryStore{}
+	d := New(server.URL+"/file.mp4", &Config{
+		Object:      "gs://bucket/empty",
+		ObjectStore: store,
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.parts) != 1 || len(store.object) != 0 {
+		t.Errorf("expected one empty part, got %d parts", len(store.parts))
+	}
+}
+
+func TestDownloadToS3(t *testing.T) {
+	s3, objects := newS3Server(t)
+	defer s3.Close()
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	err := New(server.URL+"/file.mp4", &Config{
+		Object:      "s3://bucket/files/file.mp4",
+		ObjectStore: NewS3Store(&S3Config{Endpoint: s3.URL, AccessKeyID: "key", SecretAccessKey: "secret"}),
+	}).Download()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(objects["/bucket/files/file.mp4"], content) {
+		t.Error("unexpected object")
+	}
+}
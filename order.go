@@ -0,0 +1,31 @@
+package download
+
+import "fmt"
+
+// The orders the parts are downloaded in, see Config.PartOrder
+const (
+	// PartOrderSequential downloads the parts from the start of the file to its end, the default
+	PartOrderSequential = "sequential"
+	// PartOrderFirstLast downloads the first and the last parts first, where the mp4 moov atom,
+	// the zip central directory or the ID3 tags live, then the others in order
+	PartOrderFirstLast = "first-last"
+)
+
+func (d *Downloader) validatePartOrder() error {
+	switch d.PartOrder {
+	case "", PartOrderSequential, PartOrderFirstLast:
+		return nil
+	}
+
+	return fmt.Errorf("unsupported part order: %s", d.PartOrder)
+}
+
+// orderBatches returns the batches of parts in the order they are downloaded
+func (d *Downloader) orderBatches(batches [][]*FilePart) [][]*FilePart {
+	if d.PartOrder != PartOrderFirstLast || len(batches) < 3 {
+		return batches
+	}
+
+	ordered := [][]*FilePart{batches[0], batches[len(batches)-1]}
+	return append(ordered, batches[1:len(batches)-1]...)
+}
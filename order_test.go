@@ -0,0 +1,57 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPartOrderFirstLast(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 50)
+	var mu sync.Mutex
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rng := r.Header.Get("Range"); r.Method == http.MethodGet && rng != "bytes=0-1" {
+			mu.Lock()
+			ranges = append(ranges, strings.TrimPrefix(rng, "bytes="))
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	var checkpoints []int
+	if _, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:    t.TempDir() + "/file.mp4",
+		TmpDir:      t.TempDir(),
+		SegmentSize: 100,
+		PartOrder:   PartOrderFirstLast,
+		// a single worker downloads the parts in turn
+		WorkerPool: NewWorkerPool(1),
+		OnCheckpoint: func(part FilePart, bytesTotal int64) {
+			checkpoints = append(checkpoints, part.Index)
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "0-99 400-499 100-199 200-299 300-399"
+	if got := strings.Join(ranges, " "); got != expected {
+		t.Errorf("expected the ranges %s, got %s", expected, got)
+	}
+	if len(checkpoints) != 5 || checkpoints[0] != 0 || checkpoints[1] != 4 {
+		t.Errorf("expected the head and the tail first, got %v", checkpoints)
+	}
+
+	if _, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:  t.TempDir() + "/file.mp4",
+		TmpDir:    t.TempDir(),
+		PartOrder: "random",
+	}); err == nil || err.Error() != "unsupported part order: random" {
+		t.Errorf("expected an unsupported part order, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultPacingRecovery is the number of successful requests raising the concurrency of a throttled host by one
+var DefaultPacingRecovery = 10
+
+// hostPacing paces the hosts answering 429 or 503, shared by the downloads of the process,
+// see Config.IsPacingDisabled.
+var hostPacing = &pacing{hosts: map[string]*hostPace{}}
+
+// hostVisits are the times the next request to each host is allowed at, see Config.PolitenessDelay,
+// shared by the downloads of the process, so the files of a batch are spaced as well.
+var hostVisits = &visits{next: map[string]time.Time{}}
+
+// visits spaces the requests to each host
+type visits struct {
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+// wait waits for the turn of a request to host, at least delay after the previous one
+func (v *visits) wait(ctx context.Context, host string, delay time.Duration) error {
+	v.mu.Lock()
+	at := v.next[host]
+	if now := time.Now(); at.Before(now) {
+		at = now
+	}
+	v.next[host] = at.Add(delay)
+	v.mu.Unlock()
+
+	wait := time.Until(at)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pacing limits the requests in flight to each throttled host, additive increase, multiplicative decrease
+type pacing struct {
+	mu    sync.Mutex
+	hosts map[string]*hostPace
+}
+
+// hostPace is the pace of a host
+type hostPace struct {
+	// limit is the number of requests in flight allowed, zero until the host throttles
+	limit int
+	// peak is the number of requests in flight when the host first throttled, the limit recovers up to it
+	peak      int
+	active    int
+	successes int
+	// changed is closed once a request is done or the limit changes
+	changed chan struct{}
+}
+
+func (p *pacing) host(host string) *hostPace {
+	h, ok := p.hosts[host]
+	if !ok {
+		h = &hostPace{changed: make(chan struct{})}
+		p.hosts[host] = h
+	}
+
+	return h
+}
+
+// notify wakes the requests waiting for the host
+func (h *hostPace) notify() {
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// acquire waits for a request to host to be allowed, the returned func releases it with the response status,
+// zero when the request failed.
+func (p *pacing) acquire(ctx context.Context, host string) (func(status int), error) {
+	for {
+		p.mu.Lock()
+		h := p.host(host)
+		if h.limit == 0 || h.active < h.limit {
+			h.active++
+			p.mu.Unlock()
+
+			var once sync.Once
+			return func(status int) {
+				once.Do(func() { p.release(host, status) })
+			}, nil
+		}
+		changed := h.changed
+		p.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// release ends a request to host, halving the limit if the host throttled it,
+// raising it by one every DefaultPacingRecovery successes.
+func (p *pacing) release(host string, status int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h := p.host(host)
+	h.active--
+	defer h.notify()
+
+	switch {
+	case status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable:
+		if h.limit == 0 {
+			h.limit = h.active + 1
+			h.peak = h.limit
+		}
+		h.limit /= 2
+		if h.limit < 1 {
+			h.limit = 1
+		}
+		h.successes = 0
+
+		if os.Getenv("DEBUG") == "true" {
+			fmt.Println("pacing: throttled by", host, "concurrency:", h.limit)
+		}
+	case status > 0 && status < 400 && h.limit > 0:
+		h.successes++
+		if h.successes < DefaultPacingRecovery {
+			return
+		}
+
+		h.limit++
+		h.successes = 0
+		// recovered, the host is not paced anymore
+		if h.limit > h.peak {
+			h.limit = 0
+		}
+	}
+}
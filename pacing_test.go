@@ -0,0 +1,112 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPacing(t *testing.T) {
+	p := &pacing{hosts: map[string]*hostPace{}}
+	ctx := context.Background()
+
+	var releases []func(status int)
+	for i := 0; i < 4; i++ {
+		release, err := p.acquire(ctx, "cdn")
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+
+	// 4 requests in flight, throttled, halved to 2
+	releases[0](http.StatusTooManyRequests)
+	releases[0](http.StatusTooManyRequests)
+	if h := p.hosts["cdn"]; h.limit != 2 || h.active != 3 {
+		t.Fatalf("expected the limit to be halved once, got %+v", h)
+	}
+
+	// the other hosts are not paced
+	if _, err := p.acquire(ctx, "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.acquire(timeout, "cdn"); err == nil {
+		t.Fatalf("expected the request to wait while 3 are in flight")
+	}
+
+	// a done request lets the next one in once under the limit
+	acquired := make(chan func(status int))
+	go func() {
+		release, _ := p.acquire(ctx, "cdn")
+		acquired <- release
+	}()
+	releases[1](http.StatusOK)
+	releases[2](http.StatusOK)
+	release := <-acquired
+
+	// recovers by one every DefaultPacingRecovery successes, up to the concurrency it was throttled at
+	release(http.StatusOK)
+	releases[3](http.StatusOK)
+	for i := 0; i < 2*DefaultPacingRecovery; i++ {
+		release, _ := p.acquire(ctx, "cdn")
+		release(http.StatusOK)
+	}
+	if h := p.hosts["cdn"]; h.limit != 4 {
+		t.Fatalf("expected the limit to recover to 4, got %+v", h)
+	}
+
+	for i := 0; i < DefaultPacingRecovery; i++ {
+		release, _ := p.acquire(ctx, "cdn")
+		release(http.StatusOK)
+	}
+	if h := p.hosts["cdn"]; h.limit != 0 {
+		t.Errorf("expected the host not to be paced anymore, got %+v", h)
+	}
+}
+
+func TestPolitenessDelay(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	var mu sync.Mutex
+	var requests []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, time.Now())
+		mu.Unlock()
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	delay := 20 * time.Millisecond
+	result := DownloadBatch(context.Background(), []*BatchEntry{
+		{URL: server.URL + "/a.mp4"},
+		{URL: server.URL + "/b.mp4"},
+	}, &Config{
+		Dir:             t.TempDir(),
+		TmpDir:          t.TempDir(),
+		SegmentSize:     25,
+		PolitenessDelay: delay,
+	})
+	if err := result.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Before(requests[j]) })
+	// the range requests of both files, spaced
+	if len(requests) < 10 {
+		t.Fatalf("expected the range requests of both files, got %d", len(requests))
+	}
+	// the requests leave on time, they may arrive a little early or late
+	if span := requests[len(requests)-1].Sub(requests[0]); span < time.Duration(len(requests)-2)*delay {
+		t.Errorf("expected the %d requests to be %s apart, got them in %s", len(requests), delay, span)
+	}
+}
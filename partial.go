@@ -0,0 +1,85 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNoFailedParts means RetryFailed was called without parts failed by the last download
+var ErrNoFailedParts = errors.New("no failed parts to retry")
+
+// PartialError is the error of a ranges download some parts of which failed once their retries ran out,
+// the other parts are kept, see RetryFailed
+type PartialError struct {
+	// Failed are the inclusive byte ranges of the failed parts, in order
+	Failed []Range
+	// Err is the error of the first failed part
+	Err error
+}
+
+func (e *PartialError) Error() string {
+	if len(e.Failed) > 1 {
+		return fmt.Sprintf("%s (and %d more parts failed)", e.Err, len(e.Failed)-1)
+	}
+
+	return e.Err.Error()
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// RetryFailed downloads again the parts of the last download which failed with a *PartialError, and the ones
+// it left pending, keeping the downloaded parts, e.g. once the network is back, then completes the file as
+// DownloadWithContext does. The remote file is not probed again, a file changed meanwhile is still detected
+// by the requests of the parts.
+func (d *Downloader) RetryFailed(ctx context.Context) error {
+	if d.partialErr == nil {
+		return ErrNoFailedParts
+	}
+
+	d.isRetryingFailed = true
+	defer func() {
+		d.isRetryingFailed = false
+	}()
+
+	return d.DownloadWithContext(ctx)
+}
+
+// retryFailedParts reserves the file path again and downloads the parts missing after a *PartialError
+func (d *Downloader) retryFailedParts() error {
+	if err := d.ensureFileDir(); err != nil {
+		return err
+	}
+
+	if err := d.reserveFilePath(); err != nil {
+		return err
+	}
+
+	if err := d.ensureQuarantineDir(); err != nil {
+		return err
+	}
+
+	return d.downloadParts()
+}
+
+// partialError returns the *PartialError of the parts failed with err, err itself when no part failed for good
+func (d *Downloader) partialError(err error) error {
+	var failed []Range
+	for _, status := range d.Parts() {
+		if status.State == PartFailed {
+			failed = append(failed, Range{Start: status.RangeStart, End: status.RangeEnd})
+		}
+	}
+
+	if len(failed) == 0 {
+		return err
+	}
+
+	d.partialErr = &PartialError{
+		Failed: failed,
+		Err:    err,
+	}
+	return d.partialErr
+}
@@ -0,0 +1,94 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryFailed(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	// the parts at 300 and 700 fail until published
+	var isPublished int32
+	var mu sync.Mutex
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		byteRange := r.Header.Get("Range")
+		if atomic.LoadInt32(&isPublished) == 0 {
+			if byteRange == "bytes=300-399" || byteRange == "bytes=700-799" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		} else {
+			mu.Lock()
+			requested = append(requested, r.Method+" "+byteRange)
+			mu.Unlock()
+		}
+
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	filePath := filepath.Join(t.TempDir(), "file.mp4")
+	d := New(server.URL+"/file.mp4", &Config{
+		FilePath:    filePath,
+		TmpDir:      t.TempDir(),
+		SegmentSize: 100,
+		RetryPolicy: &RetryPolicy{MaxAttempts: 1},
+	})
+	if err := d.RetryFailed(context.Background()); !errors.Is(err, ErrNoFailedParts) {
+		t.Fatalf("expected ErrNoFailedParts before a download, got %v", err)
+	}
+
+	err := d.Download()
+	var partialErr *PartialError
+	if !errors.As(err, &partialErr) {
+		t.Fatalf("expected a partial error, got %v", err)
+	}
+	expected := []Range{{Start: 300, End: 399}, {Start: 700, End: 799}}
+	if !reflect.DeepEqual(partialErr.Failed, expected) {
+		t.Errorf("expected the failed ranges %v, got %v", expected, partialErr.Failed)
+	}
+	var downloadErr *Error
+	if !errors.As(err, &downloadErr) || downloadErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected the error of the failed part, got %v", err)
+	}
+
+	// only the failed parts are requested again
+	atomic.StoreInt32(&isPublished, 1)
+	if err := d.RetryFailed(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("unexpected content")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requested) != 2 || requested[0] == requested[1] {
+		t.Fatalf("expected the 2 failed parts requested, got %v", requested)
+	}
+	for _, request := range requested {
+		if request != "GET bytes=300-399" && request != "GET bytes=700-799" {
+			t.Errorf("unexpected request %s", request)
+		}
+	}
+
+	if err := d.RetryFailed(context.Background()); !errors.Is(err, ErrNoFailedParts) {
+		t.Errorf("expected ErrNoFailedParts once complete, got %v", err)
+	}
+}
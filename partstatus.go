@@ -0,0 +1,119 @@
+package download
+
+import "github.com/go-zoox/fs"
+
+const (
+	// PartPending is a part not downloaded yet
+	PartPending = "pending"
+	// PartDownloading is a part being downloaded, retried included
+	PartDownloading = "downloading"
+	// PartDone is a part downloaded, or found complete on disk
+	PartDone = "done"
+	// PartFailed is a part which failed once its retries ran out
+	PartFailed = "failed"
+)
+
+// PartStatus represents the status of a part of a ranges download, see Parts
+type PartStatus struct {
+	Index int
+	// RangeStart and RangeEnd are the inclusive bounds of the part in the remote file
+	RangeStart int64
+	RangeEnd   int64
+	// State is PartPending, PartDownloading, PartDone or PartFailed
+	State string
+	// Written is the bytes of the part written so far
+	Written int64
+	// Attempts is the number of requests of the part, the retries included
+	Attempts int
+}
+
+// Parts returns the status of each part of a ranges download, in order, nil for a single request download,
+// it is safe to call during the download, e.g. to render the block map of the segments.
+func (d *Downloader) Parts() []PartStatus {
+	p := d.progress
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.statuses == nil {
+		return nil
+	}
+
+	parts := make([]PartStatus, len(p.statuses))
+	for i, status := range p.statuses {
+		status.Written = p.parts[i]
+		parts[i] = status
+	}
+
+	return parts
+}
+
+// initPartStatuses lists the parts, the ones complete on disk done, called with the progress locked
+func (p *progressCounter) initPartStatuses(parts []*FilePart) {
+	p.statuses = make([]PartStatus, len(parts))
+	for i, part := range parts {
+		state := PartPending
+		if fs.IsExist(part.Path) && fs.Size(part.Path) == part.RangeEnd-part.RangeStart+1 {
+			state = PartDone
+		}
+
+		p.statuses[i] = PartStatus{
+			Index:      part.Index,
+			RangeStart: part.RangeStart,
+			RangeEnd:   part.RangeEnd,
+			State:      state,
+		}
+	}
+}
+
+// setPartState sets the state of the part, the parts which are not of the download are ignored
+func (d *Downloader) setPartState(part *FilePart, state string) {
+	p := d.progress
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if i, ok := p.partIndex[part.Path]; ok && p.statuses != nil {
+		p.statuses[i].State = state
+	}
+}
+
+// countPartAttempt counts a request of the part
+func (d *Downloader) countPartAttempt(part *FilePart) {
+	p := d.progress
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if i, ok := p.partIndex[part.Path]; ok && p.statuses != nil {
+		p.statuses[i].Attempts++
+	}
+}
+
+// isPart returns true if path is a part of the download
+func (p *progressCounter) isPart(path string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.partIndex[path]
+	return ok
+}
+
+// keepPartSum keeps the sha256 of the part at path as downloaded
+func (p *progressCounter) keepPartSum(path string, sum []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if i, ok := p.partIndex[path]; ok && p.sums != nil {
+		p.sums[i] = sum
+	}
+}
+
+// partSum returns the sha256 of the part at path as downloaded, nil when unknown
+func (p *progressCounter) partSum(path string) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if i, ok := p.partIndex[path]; ok && p.sums != nil {
+		return p.sums[i]
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParts(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	gate := make(chan struct{})
+	var failed int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Range") {
+		case "bytes=200-299":
+			<-gate
+		case "bytes=300-399":
+			// the first request of the part fails
+			if atomic.AddInt32(&failed, 1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	d := New(server.URL+"/file.mp4", &Config{
+		FilePath:    t.TempDir() + "/file.mp4",
+		TmpDir:      t.TempDir(),
+		SegmentSize: 100,
+		RetryPolicy: &RetryPolicy{BaseDelay: time.Millisecond},
+	})
+	if d.Parts() != nil {
+		t.Error("expected no parts before the download")
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- d.Download()
+	}()
+
+	// the gated part is downloading while the first ones are done
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		parts := d.Parts()
+		if len(parts) == 10 && parts[0].State == PartDone && parts[1].State == PartDone && parts[2].State == PartDownloading {
+			if parts[0].Written != 100 || parts[2].Written != 0 || parts[2].RangeStart != 200 || parts[2].RangeEnd != 299 {
+				t.Errorf("unexpected parts: %+v", parts[:3])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the part 2 downloading, got %+v", parts)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(gate)
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, part := range d.Parts() {
+		if part.State != PartDone || part.Written != 100 {
+			t.Errorf("expected the part %d done, got %+v", part.Index, part)
+		}
+		attempts := 1
+		if part.Index == 3 {
+			attempts = 2
+		}
+		if part.Attempts != attempts {
+			t.Errorf("expected %d attempts of the part %d, got %d", attempts, part.Index, part.Attempts)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package download
+
+// fixLongPath returns the path as is, only windows limits the path length
+func fixLongPath(path string) string {
+	return path
+}
@@ -0,0 +1,120 @@
+package download
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestFilePath(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		filePath string
+		name     string
+		ext      string
+	}{
+		{filepath.Join(dir, "clip.mp4"), "clip", "mp4"},
+		{filepath.Join(dir, "sub", "archive.tar.gz"), "archive.tar", "gz"},
+		{filepath.Join(dir, "README"), "README", ""},
+	}
+
+	for _, c := range cases {
+		d := New("http://example.com/video", &Config{FilePath: c.filePath})
+		if d.FileName != c.name || d.FileExt != c.ext {
+			t.Errorf("%s: expected %s and %s, got %s and %s", c.filePath, c.name, c.ext, d.FileName, d.FileExt)
+		}
+		if d.getFilePath() != c.filePath {
+			t.Errorf("expected %s, got %s", c.filePath, d.getFilePath())
+		}
+	}
+
+	d := New("http://example.com/videos/clip.mp4?token=1", &Config{})
+	if err := d.parseURL(d.URL); err != nil {
+		t.Fatal(err)
+	}
+	if d.FileName != "clip" || d.FileExt != "mp4" {
+		t.Errorf("expected clip.mp4 from the url, got %s.%s", d.FileName, d.FileExt)
+	}
+}
+
+func TestReservedFileName(t *testing.T) {
+	isWindows = true
+	defer func() {
+		isWindows = runtime.GOOS == "windows"
+	}()
+
+	cases := map[string]string{
+		"http://example.com/con":         "_con",
+		"http://example.com/NUL.txt":     "_NUL.txt",
+		"http://example.com/aux.tar.gz":  "_aux.tar.gz",
+		"http://example.com/com1":        "_com1",
+		"http://example.com/console.log": "console.log",
+		"http://example.com/clip.mp4":    "clip.mp4",
+	}
+
+	for u, expected := range cases {
+		d := New(u, &Config{})
+		if err := d.parseURL(u); err != nil {
+			t.Fatal(err)
+		}
+
+		name := d.FileName
+		if d.FileExt != "" {
+			name += "." + d.FileExt
+		}
+		if name != expected {
+			t.Errorf("%s: expected %s, got %s", u, expected, name)
+		}
+	}
+}
+
+func TestDirAndName(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	d := New(server.URL+"/file.mp4", &Config{
+		Dir:         "out",
+		Name:        "clip.mp4",
+		TmpDir:      t.TempDir(),
+		SegmentSize: 32,
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	// compared with Abs as the temp dir may be behind a symlink (macOS /var)
+	abs, _ := filepath.Abs(filepath.Join("out", "clip.mp4"))
+	if d.FilePath != abs || !filepath.IsAbs(d.FilePath) {
+		t.Errorf("expected the absolute path %s, got %s", abs, d.FilePath)
+	}
+
+	data, err := os.ReadFile(d.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("unexpected content: %s", data)
+	}
+
+	d = New(server.URL+"/file.mp4", &Config{
+		FilePath: filepath.Join(dir, "other.mp4"),
+		Dir:      "ignored",
+		Name:     "ignored.mp4",
+	})
+	if d.getFilePath() != filepath.Join(dir, "other.mp4") {
+		t.Errorf("expected FilePath to take precedence, got %s", d.getFilePath())
+	}
+}
@@ -0,0 +1,30 @@
+package download
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// longPathPrefix lifts the MAX_PATH (260 characters) limit of the windows api
+const longPathPrefix = `\\?\`
+
+// fixLongPath returns the path prefixed with \\?\ when it is too long for the windows api,
+// relative paths are made absolute first, as the prefix disables the path normalization.
+func fixLongPath(path string) string {
+	// 248 is the limit of directories, which leaves room for an 8.3 file name
+	if len(path) < 248 || strings.HasPrefix(path, longPathPrefix) {
+		return path
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+
+	// \\server\share\dir becomes \\?\UNC\server\share\dir
+	if strings.HasPrefix(abs, `\\`) {
+		return longPathPrefix + `UNC\` + abs[2:]
+	}
+
+	return longPathPrefix + abs
+}
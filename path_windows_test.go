@@ -0,0 +1,44 @@
+package download
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWindowsFilePath(t *testing.T) {
+	d := New("http://example.com/clip.mp4", &Config{
+		FilePath: `C:\Users\me\Videos\clip.mp4`,
+		TmpDir:   `C:\Users\me\AppData\Local\Temp`,
+	})
+	if d.FileDir != `C:\Users\me\Videos` || d.FileName != "clip" || d.FileExt != "mp4" {
+		t.Errorf("unexpected file info: %s %s %s", d.FileDir, d.FileName, d.FileExt)
+	}
+	if d.getFilePath() != `C:\Users\me\Videos\clip.mp4` {
+		t.Errorf("unexpected file path: %s", d.getFilePath())
+	}
+
+	d.Hash = "hash"
+	d.Ranges = []*Range{{Start: 0, End: 9}}
+	if err := d.parseFileParts(); err != nil {
+		t.Fatal(err)
+	}
+	if d.FileParts[0].Path != `C:\Users\me\AppData\Local\Temp\hash\part.0.0.9` {
+		t.Errorf("unexpected part path: %s", d.FileParts[0].Path)
+	}
+}
+
+func TestFixLongPath(t *testing.T) {
+	long := `C:\` + strings.Repeat(`deep\`, 60) + "clip.mp4"
+	if fixLongPath(long) != `\\?\`+long {
+		t.Errorf("expected the long path prefix, got %s", fixLongPath(long))
+	}
+
+	unc := `\\nas\share\` + strings.Repeat(`deep\`, 60) + "clip.mp4"
+	if fixLongPath(unc) != `\\?\UNC\`+unc[2:] {
+		t.Errorf("expected the long unc path prefix, got %s", fixLongPath(unc))
+	}
+
+	if fixLongPath(`C:\clip.mp4`) != `C:\clip.mp4` {
+		t.Errorf("expected short paths as is")
+	}
+}
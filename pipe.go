@@ -0,0 +1,111 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// CommandSink is a Sink feeding each file to the stdin of a command, e.g. to transcode while downloading,
+// the file is streamed in order while the next segments are downloaded ahead. The command gets the file name
+// in the DOWNLOAD_NAME environment variable, a failed download kills it.
+type CommandSink struct {
+	Name string
+	Args []string
+	// Stdout and Stderr are the outputs of the command, the ones of the process by default
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// NewCommandSink returns a Sink running the command name with args for each file
+func NewCommandSink(name string, args ...string) *CommandSink {
+	return &CommandSink{
+		Name:   name,
+		Args:   args,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+}
+
+// NewShellSink returns a Sink running the command line by the shell for each file, e.g. "ffmpeg -i - out.mkv",
+// sh -c or cmd /C on windows
+func NewShellSink(command string) *CommandSink {
+	if runtime.GOOS == "windows" {
+		return NewCommandSink("cmd", "/C", command)
+	}
+
+	return NewCommandSink("sh", "-c", command)
+}
+
+// Create starts the command for the file name, its stdin fed by the writer
+func (s *CommandSink) Create(ctx context.Context, name string, size int64) (SinkWriter, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := exec.CommandContext(ctx, s.Name, s.Args...)
+	cmd.Stdout = s.Stdout
+	cmd.Stderr = s.Stderr
+	cmd.Env = append(os.Environ(), "DOWNLOAD_NAME="+name)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("pipe: %w", err)
+	}
+
+	return &commandWriter{
+		cmd:     cmd,
+		stdin:   stdin,
+		cancel:  cancel,
+		command: strings.Join(append([]string{s.Name}, s.Args...), " "),
+	}, nil
+}
+
+// commandWriter writes the file to the stdin of the command
+type commandWriter struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	cancel  context.CancelFunc
+	command string
+}
+
+func (w *commandWriter) Write(p []byte) (int, error) {
+	n, err := w.stdin.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("pipe: the command stopped reading: %w", err)
+	}
+
+	return n, nil
+}
+
+// Commit closes the stdin of the command and waits for it to exit
+func (w *commandWriter) Commit() error {
+	defer w.cancel()
+
+	w.stdin.Close()
+	if err := w.cmd.Wait(); err != nil {
+		return fmt.Errorf("pipe: %s: %w", w.command, err)
+	}
+
+	return nil
+}
+
+// Abort kills the command, so it does not process a truncated file as a whole one
+func (w *commandWriter) Abort() error {
+	w.cancel()
+	w.stdin.Close()
+	w.cmd.Wait()
+
+	return nil
+}
+
+func (w *commandWriter) Location() string {
+	return "pipe:" + w.command
+}
@@ -0,0 +1,48 @@
+package download
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestPipe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	content := bytes.Repeat([]byte("0123456789"), 550)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	dir := t.TempDir()
+	d := New(server.URL+"/file.mp4", &Config{
+		SegmentSize: 700,
+		Pipe:        `cat > "` + dir + `/$DOWNLOAD_NAME"`,
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "file.mp4")); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("unexpected piped file: %v", err)
+	}
+
+	// a failing command fails the download
+	if err := New(server.URL+"/file.mp4", &Config{Pipe: "cat > /dev/null; exit 3"}).Download(); err == nil {
+		t.Error("expected the exit status error")
+	}
+
+	// a failed verification kills the command before its input ends
+	err := New(server.URL+"/file.mp4", &Config{
+		Pipe:             "cat > /dev/null",
+		ExpectedChecksum: "sha256:" + hex.EncodeToString(make([]byte, 32)),
+	}).Download()
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package download
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultBatchWorkers is the number of parts DownloadBatch downloads at the same time, all files together,
+// when Config.WorkerPool is not set
+var DefaultBatchWorkers = 8
+
+// WorkerPool bounds the parts downloaded at the same time by several downloads, see Config.WorkerPool,
+// the parts waiting for a worker get one by Config.Priority, then in the order they asked, so a small file
+// does not wait for all the parts of a huge one.
+//
+// The priority only orders the parts waiting: a download gives its worker back after each part, so a download
+// of higher priority gets the workers as the parts in flight complete, those are not paused nor cancelled.
+type WorkerPool struct {
+	mu      sync.Mutex
+	workers int
+	busy    int
+	// waiting are the parts waiting for a worker, by priority then arrival
+	waiting []*poolWaiter
+}
+
+// poolWaiter is a part waiting for a worker, ready is closed once it got one
+type poolWaiter struct {
+	priority int
+	ready    chan struct{}
+}
+
+// NewWorkerPool returns a pool of workers segment workers, at least 1
+func NewWorkerPool(workers int) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &WorkerPool{workers: workers}
+}
+
+// Workers returns the size of the pool
+func (p *WorkerPool) Workers() int {
+	return p.workers
+}
+
+// Waiting returns the number of parts waiting for a worker
+func (p *WorkerPool) Waiting() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.waiting)
+}
+
+// acquire waits for a free worker, nil pools have as many as asked for
+func (p *WorkerPool) acquire(ctx context.Context, priority int) error {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	if p.busy < p.workers && len(p.waiting) == 0 {
+		p.busy++
+		p.mu.Unlock()
+		return nil
+	}
+
+	w := &poolWaiter{priority: priority, ready: make(chan struct{})}
+	i := len(p.waiting)
+	for i > 0 && p.waiting[i-1].priority < priority {
+		i--
+	}
+	p.waiting = append(p.waiting, nil)
+	copy(p.waiting[i+1:], p.waiting[i:])
+	p.waiting[i] = w
+	p.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, waiting := range p.waiting {
+		if waiting == w {
+			p.waiting = append(p.waiting[:i], p.waiting[i+1:]...)
+			return ctx.Err()
+		}
+	}
+
+	// got the worker while cancelled, give it to the next one
+	p.busy--
+	p.next()
+	return ctx.Err()
+}
+
+// release frees the worker taken by acquire
+func (p *WorkerPool) release() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.busy--
+	p.next()
+}
+
+// next gives the free workers to the first parts waiting
+func (p *WorkerPool) next() {
+	for p.busy < p.workers && len(p.waiting) > 0 {
+		w := p.waiting[0]
+		p.waiting = p.waiting[1:]
+		p.busy++
+		close(w.ready)
+	}
+}
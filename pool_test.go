@@ -0,0 +1,132 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the parts, not the probes
+		if r.Header.Get("Range") != "" && r.Header.Get("Range") != "bytes=0-1" && r.Method == http.MethodGet {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	pool := NewWorkerPool(2)
+	result := DownloadBatch(context.Background(), []*BatchEntry{
+		{URL: server.URL + "/a.mp4"},
+		{URL: server.URL + "/b.mp4"},
+		{URL: server.URL + "/c.mp4"},
+	}, &Config{
+		Dir:         t.TempDir(),
+		TmpDir:      t.TempDir(),
+		SegmentSize: 100,
+		WorkerPool:  pool,
+	})
+	if err := result.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if maxInFlight > 2 {
+		t.Errorf("expected at most 2 parts in flight, got %d", maxInFlight)
+	}
+	if pool.Workers() != 2 || pool.busy != 0 {
+		t.Errorf("expected the workers to be released, got %d busy", pool.busy)
+	}
+}
+
+func TestWorkerPoolPriority(t *testing.T) {
+	pool := NewWorkerPool(1)
+	ctx := context.Background()
+	if err := pool.acquire(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan string, 3)
+	wait := func(name string, priority int, waiting int) {
+		go func() {
+			if err := pool.acquire(ctx, priority); err == nil {
+				order <- name
+				pool.release()
+			}
+		}()
+		for pool.Waiting() < waiting {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wait("low", 0, 1)
+	wait("high", 5, 2)
+
+	// a cancelled waiter leaves its place
+	cancelled, cancel := context.WithCancel(ctx)
+	go pool.acquire(cancelled, 10)
+	for pool.Waiting() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	for pool.Waiting() > 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	pool.release()
+	if first, second := <-order, <-order; first != "high" || second != "low" {
+		t.Errorf("expected the high priority part first, got %s then %s", first, second)
+	}
+}
+
+func TestWorkerPoolNextPart(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			time.Sleep(10 * time.Millisecond)
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	pool := NewWorkerPool(1)
+	done := make(chan string, 2)
+	download := func(name string, priority int) {
+		_, err := Download(server.URL+"/"+name+".mp4", &Config{
+			FilePath:    t.TempDir() + "/" + name + ".mp4",
+			TmpDir:      t.TempDir(),
+			SegmentSize: 10,
+			WorkerPool:  pool,
+			Priority:    priority,
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- name
+	}
+
+	go download("background", 0)
+	// the background download holds the worker
+	for pool.Waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go download("interactive", 1)
+
+	if first := <-done; first != "interactive" {
+		t.Errorf("expected the interactive download to get the worker at the next part, got %s first", first)
+	}
+	<-done
+}
@@ -0,0 +1,213 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+)
+
+// FileInfo represents the remote file, as probed before the download
+type FileInfo struct {
+	// URL is the final url, after the redirects
+	URL string
+	// Redirects are the urls redirected from, in order, starting with the requested url
+	Redirects []string
+	// StatusCode is the status of the HEAD request
+	StatusCode int
+	// Size is the size of the file, -1 if unknown
+	Size int64
+	// ContentType is the content type of the file
+	ContentType string
+	// ETag is the entity tag of the file, empty if not sent
+	ETag string
+	// LastModified is the last modified date of the file, empty if not sent
+	LastModified string
+	// AcceptRanges is the Accept-Ranges header as sent, empty when the support was probed with a range request
+	AcceptRanges string
+	// IsSupportRange is true if the file can be downloaded in parallel ranges
+	IsSupportRange bool
+	// Server is the Server header
+	Server string
+	// FileName is the name the file would be saved as, with its extension
+	FileName string
+	// Headers are the response headers
+	Headers http.Header
+}
+
+// Probe returns the info of the remote file without downloading it,
+// so callers can make policy decisions before committing to the download.
+func (d *Downloader) Probe() (*FileInfo, error) {
+	return d.ProbeWithContext(context.Background())
+}
+
+// ProbeWithContext is Probe with a context, cancelling ctx or Quota.MaxTime aborts the probe
+func (d *Downloader) ProbeWithContext(ctx context.Context) (*FileInfo, error) {
+	jobCtx, cancel := d.withMaxTime(ctx)
+	defer cancel()
+	d.ctx = jobCtx
+
+	info, err := d.probeFile()
+	if err := d.checkMaxTime(ctx, err); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+func (d *Downloader) probeFile() (*FileInfo, error) {
+	if err := d.extract(); err != nil {
+		return nil, err
+	}
+
+	if err := d.parseURL(d.URL); err != nil {
+		return nil, err
+	}
+
+	info, err := d.probe()
+	if err != nil {
+		return nil, err
+	}
+
+	// a failed HEAD is fine as long as the range request succeeded
+	if info.StatusCode >= 400 && !info.IsSupportRange {
+		return nil, newStatusError(info.StatusCode)
+	}
+
+	if err := d.parseContentInfo(); err != nil {
+		return nil, err
+	}
+	// an unknown content type is only fatal to the download
+	d.parseFileInfo()
+
+	info.FileName = filepath.Base(d.getFilePath())
+	return info, nil
+}
+
+// TargetPath returns the absolute path the file is saved at, as resolved by Probe from the url,
+// the Content-Disposition and the content type, empty before the name is known or with a sink.
+// IfExistsRename and ContentStore may still move the file, see Result.FinalPath.
+func (d *Downloader) TargetPath() string {
+	if d.sink() != nil {
+		return ""
+	}
+
+	filePath := d.getFilePath()
+	if filePath == "" {
+		return ""
+	}
+
+	if abs, err := filepath.Abs(filePath); err == nil {
+		return abs
+	}
+
+	return filePath
+}
+
+// probe sends the HEAD request, checking the range support with a range request when HEAD does not tell
+func (d *Downloader) probe() (*FileInfo, error) {
+	if d.IsProbeDisabled {
+		return d.assumedInfo(), nil
+	}
+
+	ctx, cancel := d.probeContext()
+	defer cancel()
+
+	info := &FileInfo{URL: d.URL, Size: -1}
+	var headers http.Header
+	err := d.do(http.MethodHead, d.URL, &requestConfig{Context: ctx}, func(resp *http.Response, body io.Reader) error {
+		info.URL = resp.Request.URL.String()
+		info.Redirects = redirectChain(resp.Request)
+		info.StatusCode = resp.StatusCode
+		headers = resp.Header.Clone()
+		return nil
+	})
+	if err != nil {
+		return nil, d.probeError(ctx, err)
+	}
+
+	info.AcceptRanges = headers.Get("Accept-Ranges")
+	switch info.AcceptRanges {
+	case "bytes":
+		d.IsSupportRange = true
+		d.HeadHeaders = headers
+	case "none":
+	default:
+		// many servers support ranges without advertising it, ask for the first 2 bytes
+		if _, err := d.probeSupportRange(ctx, headers); err != nil {
+			return nil, d.probeError(ctx, err)
+		}
+	}
+	if d.HeadHeaders == nil {
+		d.HeadHeaders = headers
+	}
+
+	info.IsSupportRange = d.IsSupportRange
+	info.Headers = d.HeadHeaders
+	info.ContentType = d.HeadHeaders.Get("Content-Type")
+	info.ETag = d.HeadHeaders.Get("ETag")
+	info.LastModified = d.HeadHeaders.Get("Last-Modified")
+	info.Server = d.HeadHeaders.Get("Server")
+	if size, err := parseContentLength(d.HeadHeaders); err == nil && size >= 0 && (d.IsSupportRange || info.StatusCode < 400) {
+		info.Size = size
+	}
+
+	return info, nil
+}
+
+// probeContext returns the context of the requests probing the file, limited by ProbeTimeout
+func (d *Downloader) probeContext() (context.Context, context.CancelFunc) {
+	if d.ProbeTimeout <= 0 {
+		return d.getContext(), func() {}
+	}
+
+	return context.WithTimeout(d.getContext(), d.ProbeTimeout)
+}
+
+// probeError classifies the error of the probe, cut by ProbeTimeout it is a timeout
+func (d *Downloader) probeError(ctx context.Context, err error) error {
+	if d.getContext().Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &Error{Kind: ErrorKindTimeout, Retryable: true, URL: d.URL, Err: fmt.Errorf("probe timeout after %s: %w", d.ProbeTimeout, err)}
+	}
+
+	return classify(err)
+}
+
+// assumedInfo returns the info of the file without sending any request, IsProbeDisabled:
+// ExpectedSize is its size, with which the file is downloaded in ranges.
+func (d *Downloader) assumedInfo() *FileInfo {
+	info := &FileInfo{URL: d.URL, Size: -1, Headers: http.Header{}}
+	if d.ExpectedSize > 0 {
+		info.Size = d.ExpectedSize
+		info.Headers.Set("Content-Length", strconv.FormatInt(d.ExpectedSize, 10))
+	}
+
+	d.IsSupportRange = info.Size > 0
+	d.HeadHeaders = info.Headers
+	info.IsSupportRange = d.IsSupportRange
+	return info
+}
+
+// redirectChain returns the urls redirected from to get req, in order
+func redirectChain(req *http.Request) []string {
+	chain := []string{}
+	for r := req; r.Response != nil && r.Response.Request != nil; r = r.Response.Request {
+		chain = append([]string{r.Response.Request.URL.String()}, chain...)
+	}
+
+	return chain
+}
+
+// Probe returns the info of the remote file by url and config without downloading it
+func Probe(url string, cfg ...*Config) (*FileInfo, error) {
+	configX := &Config{}
+	if len(cfg) > 0 {
+		configX = cfg[0]
+	}
+
+	d := New(url, configX)
+	return d.Probe()
+}
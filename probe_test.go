@@ -0,0 +1,216 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	modTime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/files/video.mp4", http.StatusFound)
+	})
+	mux.HandleFunc("/files/video.mp4", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "test")
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "video.mp4", modTime, bytes.NewReader(content))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	info, err := Probe(server.URL+"/old", &Config{TmpDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.URL != server.URL+"/files/video.mp4" {
+		t.Errorf("unexpected final url: %s", info.URL)
+	}
+	if len(info.Redirects) != 2 || info.Redirects[0] != server.URL+"/old" || info.Redirects[1] != server.URL+"/new" {
+		t.Errorf("unexpected redirects: %v", info.Redirects)
+	}
+	if info.Size != int64(len(content)) || info.ContentType != "video/mp4" || info.ETag != `"v1"` {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	if info.LastModified != modTime.Format(http.TimeFormat) || info.Server != "test" {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	if !info.IsSupportRange || info.AcceptRanges != "bytes" {
+		t.Errorf("expected range support, got %+v", info)
+	}
+	if info.FileName != "old.mp4" {
+		t.Errorf("unexpected file name: %s", info.FileName)
+	}
+}
+
+func TestProbeWithoutHead(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		http.ServeContent(w, r, "video.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	info, err := Probe(server.URL+"/video.mp4", &Config{TmpDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsSupportRange || info.Size != int64(len(content)) || info.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected the range request to tell the size, got %+v", info)
+	}
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	if _, err := Probe(missing.URL+"/video.mp4", &Config{TmpDir: t.TempDir()}); err == nil {
+		t.Error("expected a status error")
+	}
+}
+
+func TestTargetPath(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="report.pdf"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	d := New(server.URL+"/download?id=1", &Config{Dir: dir, TmpDir: t.TempDir(), IfExists: IfExistsRename})
+	if path := d.TargetPath(); path != "" {
+		t.Errorf("expected no path before the probe, got %s", path)
+	}
+
+	if _, err := d.Probe(); err != nil {
+		t.Fatal(err)
+	}
+	expected := filepath.Join(dir, "report.pdf")
+	if path := d.TargetPath(); path != expected {
+		t.Errorf("expected %s, got %s", expected, path)
+	}
+
+	// the target is taken, the file is renamed
+	if err := os.WriteFile(expected, []byte("taken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	if final := filepath.Join(dir, "report (1).pdf"); d.Result().FinalPath != final || d.TargetPath() != final {
+		t.Errorf("expected the file at %s, got %s", final, d.Result().FinalPath)
+	}
+}
+
+// newHangingHeadServer serves content, except the HEAD requests which hang until cancelled
+func newHangingHeadServer(content []byte, heads *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			atomic.AddInt32(heads, 1)
+			<-r.Context().Done()
+			return
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func TestProbeTimeout(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var heads int32
+	server := newHangingHeadServer(content, &heads)
+	defer server.Close()
+
+	startedAt := time.Now()
+	err := New(server.URL+"/file.mp4", &Config{
+		Dir:          t.TempDir(),
+		TmpDir:       t.TempDir(),
+		ProbeTimeout: 100 * time.Millisecond,
+	}).Download()
+	var e *Error
+	if !errors.As(err, &e) || e.Kind != ErrorKindTimeout {
+		t.Fatalf("expected a probe timeout, got %v", err)
+	}
+	if elapsed := time.Since(startedAt); elapsed > 5*time.Second {
+		t.Errorf("expected the probe cut after 100ms, took %s", elapsed)
+	}
+
+	// the deadline of the caller stops the probe too
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := New(server.URL+"/file.mp4", &Config{}).ProbeWithContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline of the context, got %v", err)
+	}
+}
+
+func TestProbeDisabled(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var heads int32
+	server := newHangingHeadServer(content, &heads)
+	defer server.Close()
+
+	d := New(server.URL+"/file.mp4", &Config{
+		Dir:             t.TempDir(),
+		TmpDir:          t.TempDir(),
+		SegmentSize:     100,
+		ExpectedSize:    int64(len(content)),
+		IsProbeDisabled: true,
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(d.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("unexpected content")
+	}
+	if n := atomic.LoadInt32(&heads); n != 0 {
+		t.Errorf("expected no HEAD request, got %d", n)
+	}
+	if len(d.Parts()) != 10 {
+		t.Errorf("expected the file downloaded in 10 parts, got %d", len(d.Parts()))
+	}
+
+	// without the size, only a single request download works
+	err = New(server.URL+"/file.mp4", &Config{
+		Dir:             t.TempDir(),
+		TmpDir:          t.TempDir(),
+		IsProbeDisabled: true,
+	}).Download()
+	if err == nil {
+		t.Error("expected the ranges download without size rejected")
+	}
+
+	d = New(server.URL+"/file.mp4", &Config{
+		Dir:              t.TempDir(),
+		TmpDir:           t.TempDir(),
+		IsProbeDisabled:  true,
+		IsRangesDisabled: true,
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(d.FilePath); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("unexpected content of the single request download: %v", err)
+	}
+	if n := atomic.LoadInt32(&heads); n != 0 {
+		t.Errorf("expected no HEAD request, got %d", n)
+	}
+}
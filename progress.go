@@ -0,0 +1,317 @@
+package download
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-zoox/fs"
+)
+
+// DefaultProgressInterval is how often Config.OnProgress is called during a download
+var DefaultProgressInterval = 200 * time.Millisecond
+
+// DefaultSpeedWindow is the window the transfer rate is sampled over, see Config.SpeedWindow
+var DefaultSpeedWindow = time.Second
+
+// DefaultSpeedSmoothing is the weight of each new sample of the transfer rate, see Config.SpeedSmoothing
+var DefaultSpeedSmoothing = 0.3
+
+// Progress represents the progress of a download
+type Progress struct {
+	// Total is the size of the file, -1 when unknown
+	Total int64
+	// Downloaded is the bytes of the file written so far, the bytes resumed from a previous run included
+	Downloaded int64
+	// Parts are the bytes written of each part, by index, nil for a single request download
+	Parts []int64
+	// Speed is the transfer rate in bytes per second, smoothed over the samples
+	Speed float64
+	// ETA is the estimated time left, -1 when unknown, see Config.ETA
+	ETA time.Duration
+	// Connections is the number of parts downloaded at the same time, tuned by Config.AutoConcurrency,
+	// 0 for a single request download
+	Connections int
+}
+
+// progressCounter counts the bytes as they are written, a part written again starts over
+type progressCounter struct {
+	mu    sync.Mutex
+	total int64
+	// base are the bytes on disk before the download, resumed by IfExistsResume
+	base int64
+	// direct are the bytes of a single request download
+	direct int64
+	parts  []int64
+	// partIndex are the indexes of the parts by path
+	partIndex map[string]int
+	// transferred are the bytes received, the ones written again by a retry included, for the speed
+	transferred int64
+	window      time.Duration
+	smoothing   float64
+	speed       float64
+	sampledAt   time.Time
+	sampled     int64
+	isSampled   bool
+	eta         ETAEstimator
+	// name is the path of the file, staging the file being written, offsets are the offsets of the parts in the file
+	// and partPaths their paths, served by Handler
+	name      string
+	staging   string
+	offsets   []int64
+	partPaths []string
+	// served is the file once downloaded, done is set when the download ended, failed with doneErr
+	served  string
+	done    bool
+	doneErr error
+	// connections are the parts downloaded at the same time
+	connections int
+	// statuses are the states of the parts, see Parts
+	statuses []PartStatus
+	// sums are the sha256 of the parts as downloaded, nil for the ones not downloaded by this run
+	sums [][]byte
+	// hedges are the paths of the copies of the hedged parts, counted in the speed only
+	hedges map[string]bool
+}
+
+func newProgressCounter(window time.Duration, smoothing float64, eta ETAEstimator) *progressCounter {
+	if window <= 0 {
+		window = DefaultSpeedWindow
+	}
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = DefaultSpeedSmoothing
+	}
+	if eta == nil {
+		eta = NewEWMAETA()
+	}
+
+	return &progressCounter{
+		total:     -1,
+		window:    window,
+		smoothing: smoothing,
+		eta:       eta,
+	}
+}
+
+// start starts sampling the speed
+func (p *progressCounter) start(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sampledAt, p.sampled = now, p.transferred
+	p.speed, p.isSampled = 0, false
+	p.name, p.staging, p.offsets, p.partPaths = "", "", nil, nil
+	p.served, p.done, p.doneErr = "", false, nil
+	p.connections = 0
+	p.statuses = nil
+}
+
+// sample updates the speed once a window elapsed since the last sample, an exponentially weighted
+// moving average of the samples, the first one taken as is.
+func (p *progressCounter) sample(now time.Time) {
+	elapsed := now.Sub(p.sampledAt)
+	if elapsed < p.window {
+		return
+	}
+
+	rate := float64(p.transferred-p.sampled) / elapsed.Seconds()
+	if p.isSampled {
+		p.speed = p.smoothing*rate + (1-p.smoothing)*p.speed
+	} else {
+		p.speed, p.isSampled = rate, true
+	}
+	p.sampledAt, p.sampled = now, p.transferred
+}
+
+// initPartsProgress counts the parts of a ranges download, the parts already on disk included
+func (d *Downloader) initPartsProgress() {
+	p := d.progress
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.total = d.targetLength()
+	p.base = d.resumeOffset
+	p.direct = 0
+	p.parts = make([]int64, len(d.FileParts))
+	p.partIndex = map[string]int{}
+	p.name, p.staging = d.getFilePath(), d.stagingPath()
+	p.offsets = make([]int64, len(d.FileParts))
+	p.partPaths = make([]string, len(d.FileParts))
+	var origin int64
+	if d.Range != nil {
+		origin = d.Range.Start
+	}
+	p.initPartStatuses(d.FileParts)
+	p.sums = make([][]byte, len(d.FileParts))
+	p.hedges = nil
+	for i, part := range d.FileParts {
+		p.partIndex[part.Path] = i
+		p.offsets[i] = part.RangeStart - origin
+		p.partPaths[i] = part.Path
+		if size := part.RangeEnd - part.RangeStart + 1; fs.IsExist(part.Path) && fs.Size(part.Path) <= size {
+			p.parts[i] = fs.Size(part.Path)
+		}
+	}
+}
+
+// setProgressTotal sets the size of the file once known, and the staging file a single request download
+// writes it to, none with a sink
+func (d *Downloader) setProgressTotal(total int64) {
+	staging := ""
+	if d.sink() == nil {
+		staging = d.stagingPath()
+	}
+
+	d.progress.mu.Lock()
+	defer d.progress.mu.Unlock()
+
+	d.progress.total = total
+	d.progress.name, d.progress.staging = d.getFilePath(), staging
+	d.progress.offsets, d.progress.partPaths = nil, nil
+	d.progress.statuses = nil
+	d.progress.sums = nil
+}
+
+// countProgress counts the bytes read from r into the file at path, from offset,
+// the files which are not a part are the file of a single request download.
+func (d *Downloader) countProgress(path string, offset int64, r io.Reader) io.Reader {
+	p := d.progress
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if i, ok := p.partIndex[path]; ok {
+		p.parts[i] = offset
+		return &progressReader{r: r, add: func(n int64) { p.parts[i] += n; p.transferred += n }, mu: &p.mu}
+	}
+
+	if p.hedges[path] {
+		return &progressReader{r: r, add: func(n int64) { p.transferred += n }, mu: &p.mu}
+	}
+
+	p.direct = offset
+	return &progressReader{r: r, add: func(n int64) { p.direct += n; p.transferred += n }, mu: &p.mu}
+}
+
+// addHedge counts the bytes written to path, the copy of a hedged part, in the speed only
+func (p *progressCounter) addHedge(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.hedges == nil {
+		p.hedges = map[string]bool{}
+	}
+	p.hedges[path] = true
+}
+
+// written returns the bytes written of the part at path
+func (p *progressCounter) written(path string) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if i, ok := p.partIndex[path]; ok {
+		return p.parts[i]
+	}
+
+	return 0
+}
+
+// setWritten sets the bytes written of the part at path, replaced by the copy of its hedge
+func (p *progressCounter) setWritten(path string, n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if i, ok := p.partIndex[path]; ok {
+		p.parts[i] = n
+	}
+}
+
+// setProgressConnections sets the parts downloaded at the same time
+func (d *Downloader) setProgressConnections(n int) {
+	d.progress.mu.Lock()
+	defer d.progress.mu.Unlock()
+
+	d.progress.connections = n
+}
+
+// transferredBytes returns the bytes received
+func (p *progressCounter) transferredBytes() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.transferred
+}
+
+// Progress returns the progress of the download, it is safe to call during the download
+func (d *Downloader) Progress() Progress {
+	p := d.progress
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.sample(now)
+	progress := Progress{
+		Total:       p.total,
+		Downloaded:  p.base + p.direct,
+		Speed:       p.speed,
+		Connections: p.connections,
+	}
+	if p.parts != nil {
+		progress.Parts = append([]int64{}, p.parts...)
+		for _, n := range p.parts {
+			progress.Downloaded += n
+		}
+	}
+	progress.ETA = p.eta.Estimate(now, progress)
+
+	return progress
+}
+
+// reportProgress calls OnProgress every DefaultProgressInterval until the returned func is called,
+// which reports the progress a last time.
+func (d *Downloader) reportProgress() func() {
+	d.progress.start(time.Now())
+	if d.OnProgress == nil {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(DefaultProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				d.OnProgress(d.Progress())
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		d.OnProgress(d.Progress())
+	}
+}
+
+// progressReader counts the bytes read
+type progressReader struct {
+	r   io.Reader
+	add func(n int64)
+	mu  *sync.Mutex
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.mu.Lock()
+		r.add(int64(n))
+		r.mu.Unlock()
+	}
+
+	return n, err
+}
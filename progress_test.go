@@ -0,0 +1,127 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedReader blocks the reads past half of the content until the gate is opened
+type gatedReader struct {
+	*bytes.Reader
+	size int64
+	gate chan struct{}
+}
+
+func (r *gatedReader) Read(p []byte) (int, error) {
+	pos := r.size - int64(r.Len())
+	if pos >= r.size/2 {
+		<-r.gate
+	} else if pos+int64(len(p)) > r.size/2 {
+		p = p[:r.size/2-pos]
+	}
+
+	return r.Reader.Read(p)
+}
+
+func TestProgress(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	gate := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.mp4", time.Time{}, &gatedReader{
+			Reader: bytes.NewReader(content),
+			size:   int64(len(content)),
+			gate:   gate,
+		})
+	}))
+	defer server.Close()
+
+	for _, disabled := range []bool{false, true} {
+		gate = make(chan struct{})
+
+		var mu sync.Mutex
+		reports := []Progress{}
+		d := New(server.URL+"/file.mp4", &Config{
+			FilePath:         filepath.Join(t.TempDir(), "file.mp4"),
+			TmpDir:           t.TempDir(),
+			SegmentSize:      int64(len(content)),
+			IsRangesDisabled: disabled,
+			OnProgress: func(progress Progress) {
+				mu.Lock()
+				defer mu.Unlock()
+				reports = append(reports, progress)
+			},
+		})
+
+		done := make(chan error)
+		go func() {
+			done <- d.Download()
+		}()
+
+		// the single part is half written, the progress shows it before the part completes
+		deadline := time.Now().Add(5 * time.Second)
+		for d.Progress().Downloaded < int64(len(content)/2) {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the progress to reach half the file, got %+v", d.Progress())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		progress := d.Progress()
+		if progress.Total != int64(len(content)) || progress.Downloaded != int64(len(content)/2) {
+			t.Errorf("unexpected progress: %d of %d", progress.Downloaded, progress.Total)
+		}
+		if !disabled && (len(progress.Parts) != 1 || progress.Parts[0] != progress.Downloaded) {
+			t.Errorf("unexpected parts progress: %v", progress.Parts)
+		}
+
+		close(gate)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+
+		mu.Lock()
+		last := reports[len(reports)-1]
+		mu.Unlock()
+		if last.Downloaded != int64(len(content)) || last.Total != int64(len(content)) {
+			t.Errorf("expected the last report complete, got %d of %d", last.Downloaded, last.Total)
+		}
+	}
+}
+
+func TestProgressSpeed(t *testing.T) {
+	start := time.Now()
+	for _, c := range []struct {
+		smoothing float64
+		expected  []float64
+	}{
+		// the last sample as is
+		{1, []float64{1000, 3000, 0}},
+		{0.5, []float64{1000, 2000, 1000}},
+	} {
+		p := newProgressCounter(time.Second, c.smoothing, nil)
+		p.start(start)
+
+		at := start
+		for i, transferred := range []int64{1000, 4000, 4000} {
+			// not sampled before the window elapsed
+			p.transferred = transferred
+			p.sample(at.Add(500 * time.Millisecond))
+
+			at = at.Add(time.Second)
+			p.sample(at)
+			if p.speed != c.expected[i] {
+				t.Errorf("smoothing %v, sample %d: expected %v bytes/s, got %v", c.smoothing, i, c.expected[i], p.speed)
+			}
+		}
+	}
+
+	// the defaults apply to invalid values
+	if p := newProgressCounter(0, 2, nil); p.window != DefaultSpeedWindow || p.smoothing != DefaultSpeedSmoothing {
+		t.Errorf("unexpected defaults: %v %v", p.window, p.smoothing)
+	}
+}
@@ -0,0 +1,241 @@
+package download
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxies returns the proxies to go through in order, Proxy first then ProxyChain
+func (d *Downloader) proxies() ([]*url.URL, error) {
+	raws := []string{}
+	if d.Proxy != "" {
+		raws = append(raws, d.Proxy)
+	}
+	raws = append(raws, d.ProxyChain...)
+
+	proxies := make([]*url.URL, 0, len(raws))
+	for _, raw := range raws {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %s: %s", raw, err)
+		}
+
+		switch u.Scheme {
+		case "http", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme: %s", raw)
+		}
+
+		if u.Port() == "" {
+			port := "1080"
+			if u.Scheme == "http" {
+				port = "80"
+			}
+			u.Host = net.JoinHostPort(u.Hostname(), port)
+		}
+
+		proxies = append(proxies, u)
+	}
+
+	return proxies, nil
+}
+
+// dialProxies connects to addr through the proxy chain, each hop tunnels to the next one.
+func dialProxies(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), proxies []*url.URL, addr string) (net.Conn, error) {
+	conn, err := dial(ctx, "tcp", proxies[0].Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// abort the handshakes when the request is cancelled
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	for i, proxy := range proxies {
+		next := addr
+		if i < len(proxies)-1 {
+			next = proxies[i+1].Host
+		}
+
+		if proxy.Scheme == "http" {
+			err = httpConnect(conn, proxy, next)
+		} else {
+			err = socks5Connect(conn, proxy, next)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy %s: %s", proxy.Host, err)
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// httpConnect opens a tunnel to addr with the CONNECT method
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	response, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("connect %s: %s", addr, response.Status)
+	}
+
+	return nil
+}
+
+// socks5Connect opens a tunnel to addr with SOCKS5 (RFC 1928),
+// authenticating with username/password (RFC 1929) when the proxy url has user info.
+func socks5Connect(conn net.Conn, proxy *url.URL, addr string) error {
+	// 1. greeting
+	methods := []byte{0x00}
+	if proxy.User != nil {
+		methods = []byte{0x00, 0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return errors.New("invalid socks version")
+	}
+
+	// 2. authentication
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if proxy.User == nil {
+			return errors.New("socks5 authentication required")
+		}
+
+		username := proxy.User.Username()
+		password, _ := proxy.User.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return errors.New("socks5 username or password too long")
+		}
+
+		msg := []byte{0x01, byte(len(username))}
+		msg = append(msg, username...)
+		msg = append(msg, byte(len(password)))
+		msg = append(msg, password...)
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("socks5 authentication failed")
+		}
+	default:
+		return errors.New("no acceptable socks5 authentication method")
+	}
+
+	// 3. connect
+	host, portRaw, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portRaw)
+	if err != nil {
+		return fmt.Errorf("invalid port: %s", portRaw)
+	}
+
+	msg := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		msg = append(msg, 0x01)
+		msg = append(msg, ip.To4()...)
+	} else if ip != nil {
+		msg = append(msg, 0x04)
+		msg = append(msg, ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return errors.New("socks5 host too long")
+		}
+
+		// the proxy resolves the host, so dns does not leak (like socks5h)
+		msg = append(msg, 0x03, byte(len(host)))
+		msg = append(msg, host...)
+	}
+	msg = append(msg, 0, 0)
+	binary.BigEndian.PutUint16(msg[len(msg)-2:], uint16(port))
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("socks5 connect %s failed: code %d", addr, header[1])
+	}
+
+	// skip the bound address
+	var skip int
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return err
+		}
+		skip = int(size[0])
+	default:
+		return errors.New("invalid socks5 address type")
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip+2)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// isProxyDialed returns true if the proxies are handled by the dialer rather than http.Transport.Proxy,
+// that is socks5 or chained proxies.
+func isProxyDialed(proxies []*url.URL) bool {
+	if len(proxies) == 0 {
+		return false
+	}
+
+	return len(proxies) > 1 || strings.HasPrefix(proxies[0].Scheme, "socks5")
+}
@@ -0,0 +1,168 @@
+package download
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// newSocks5Server starts a minimal SOCKS5 server requiring username/password
+func newSocks5Server(t *testing.T, username, password string, connects *int32) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				buf := make([]byte, 2)
+				io.ReadFull(conn, buf)
+				io.ReadFull(conn, make([]byte, buf[1]))
+				conn.Write([]byte{0x05, 0x02})
+
+				io.ReadFull(conn, buf)
+				u := make([]byte, buf[1])
+				io.ReadFull(conn, u)
+				io.ReadFull(conn, buf[:1])
+				p := make([]byte, buf[0])
+				io.ReadFull(conn, p)
+				if string(u) != username || string(p) != password {
+					conn.Write([]byte{0x01, 0x01})
+					return
+				}
+				conn.Write([]byte{0x01, 0x00})
+
+				header := make([]byte, 4)
+				io.ReadFull(conn, header)
+				var host string
+				switch header[3] {
+				case 0x01:
+					ip := make([]byte, 4)
+					io.ReadFull(conn, ip)
+					host = net.IP(ip).String()
+				case 0x03:
+					io.ReadFull(conn, buf[:1])
+					name := make([]byte, buf[0])
+					io.ReadFull(conn, name)
+					host = string(name)
+				}
+				portRaw := make([]byte, 2)
+				io.ReadFull(conn, portRaw)
+				port := binary.BigEndian.Uint16(portRaw)
+
+				target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+				if err != nil {
+					conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer target.Close()
+				atomic.AddInt32(connects, 1)
+				conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}(conn)
+		}
+	}()
+
+	return l
+}
+
+// newConnectProxy starts a minimal http CONNECT proxy
+func newConnectProxy(t *testing.T, connects *int32) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				defer target.Close()
+				atomic.AddInt32(connects, 1)
+				conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}(conn)
+		}
+	}()
+
+	return l
+}
+
+func TestProxyChain(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	var socksConnects, httpConnects int32
+	socks := newSocks5Server(t, "user", "secret", &socksConnects)
+	defer socks.Close()
+	bastion := newConnectProxy(t, &httpConnects)
+	defer bastion.Close()
+
+	filePath := t.TempDir() + "/proxy.mp4"
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:    filePath,
+		TmpDir:      t.TempDir(),
+		SegmentSize: 32,
+		Proxy:       "http://" + bastion.Addr().String(),
+		ProxyChain:  []string{"socks5://user:secret@" + socks.Addr().String()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if httpConnects == 0 || socksConnects == 0 {
+		t.Errorf("expected requests through both proxies, got http %d socks %d", httpConnects, socksConnects)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("unexpected content: %s", data)
+	}
+
+	_, err = Download(server.URL+"/file.mp4", &Config{
+		FilePath: t.TempDir() + "/proxy.mp4",
+		Proxy:    "socks5://user:wrong@" + socks.Addr().String(),
+	})
+	if err == nil {
+		t.Errorf("expected socks5 authentication to fail")
+	}
+}
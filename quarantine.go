@@ -0,0 +1,156 @@
+package download
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-zoox/crypto/md5"
+)
+
+// stagingSuffix is appended to the file while it is written, it is renamed once verified, see Config.StagingSuffix
+const stagingSuffix = ".download"
+
+// inProgressSuffix returns the suffix of the file while it is written
+func (d *Downloader) inProgressSuffix() string {
+	if d.StagingSuffix != "" {
+		return d.StagingSuffix
+	}
+
+	return stagingSuffix
+}
+
+func (d *Downloader) validateStagingSuffix() error {
+	if strings.ContainsAny(d.StagingSuffix, `/\`) {
+		return fmt.Errorf("invalid staging suffix: %s", d.StagingSuffix)
+	}
+
+	return nil
+}
+
+// stagingPath returns where the file is written before it is verified and promoted,
+// next to the file by default, in QuarantineDir when set.
+func (d *Downloader) stagingPath() string {
+	filePath := d.getFilePath()
+	if d.QuarantineDir == "" {
+		return filePath + d.inProgressSuffix()
+	}
+
+	// prefixed by the destination hash, so files of the same name in different directories don't collide
+	name := md5.Md5(filePath)[:8] + "-" + filepath.Base(filePath) + d.inProgressSuffix()
+	return fixLongPath(filepath.Join(d.QuarantineDir, name))
+}
+
+// ensureQuarantineDir creates the quarantine directory, it is private to the downloader
+func (d *Downloader) ensureQuarantineDir() error {
+	if d.QuarantineDir == "" {
+		return nil
+	}
+
+	return os.MkdirAll(fixLongPath(d.QuarantineDir), 0700)
+}
+
+// verifyFile checks the written file before it is promoted
+func (d *Downloader) verifyFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if length := d.targetLength(); length > 0 && info.Size() != length {
+		return newChecksumError(fmt.Errorf("%w: expect %d, got %d", ErrSizeMismatch, length, info.Size()))
+	}
+
+	if d.ExpectedSize > 0 && info.Size() != d.ExpectedSize {
+		return newChecksumError(fmt.Errorf("%w: expect %d, got %d", ErrSizeMismatch, d.ExpectedSize, info.Size()))
+	}
+
+	expected, err := d.expectedChecksum()
+	if err != nil {
+		return err
+	}
+	if expected != nil {
+		return expected.verify(path)
+	}
+
+	return nil
+}
+
+// finalize verifies the staged file and promotes it to the file path,
+// a file failing the verification is removed, it never reaches the destination.
+func (d *Downloader) finalize() error {
+	stagingPath := d.stagingPath()
+	if err := d.verifyFile(stagingPath); err != nil && !d.repairStaged(stagingPath, err) {
+		d.discardFile(stagingPath)
+		return err
+	}
+
+	if err := d.scanFile(stagingPath); err != nil {
+		os.Remove(stagingPath)
+		return err
+	}
+
+	if d.ContentStore != "" {
+		return d.storeFile(stagingPath)
+	}
+
+	if err := moveFile(stagingPath, d.getFilePath(), d.inProgressSuffix(), d.fileMode()); err != nil {
+		return err
+	}
+
+	if d.Sync {
+		return syncDir(d.FileDir)
+	}
+
+	return nil
+}
+
+// moveFile renames src to dst, copying it when they are on different file systems
+func moveFile(src, dst, suffix string, mode os.FileMode) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	} else if _, statErr := os.Stat(src); statErr != nil {
+		return err
+	}
+
+	if err := copyFile(src, dst, suffix, mode); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// copyFile copies src to dst, written next to dst with the staging suffix then renamed, so dst is never seen torn
+func copyFile(src, dst, suffix string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmpPath := dst + suffix
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, dst); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
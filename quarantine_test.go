@@ -0,0 +1,120 @@
+package download
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/fs"
+)
+
+func TestQuarantine(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	quarantineDir := filepath.Join(t.TempDir(), "quarantine")
+	filePath := filepath.Join(t.TempDir(), "file.mp4")
+	for _, isRangesDisabled := range []bool{false, true} {
+		_, err := Download(server.URL+"/file.mp4", &Config{
+			FilePath:         filePath,
+			TmpDir:           t.TempDir(),
+			QuarantineDir:    quarantineDir,
+			IsRangesDisabled: isRangesDisabled,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Errorf("unexpected content: %s", data)
+		}
+
+		if entries, _ := os.ReadDir(quarantineDir); len(entries) != 0 {
+			t.Errorf("expected the quarantine to be empty, got %d files", len(entries))
+		}
+	}
+}
+
+func TestQuarantineRejectsUnverifiedFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "file.mp4")
+	d := New("http://example.com/file.mp4", &Config{
+		FilePath:      filePath,
+		QuarantineDir: t.TempDir(),
+	})
+	d.ContentLength = 100
+	if err := os.WriteFile(d.stagingPath(), []byte("short"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := d.finalize()
+	var e *Error
+	if !errors.As(err, &e) || e.Kind != ErrorKindChecksum {
+		t.Fatalf("expected a checksum error, got %v", err)
+	}
+
+	if _, err := os.Stat(d.stagingPath()); !os.IsNotExist(err) {
+		t.Error("expected the quarantined file to be removed")
+	}
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Error("expected the file not to be promoted")
+	}
+}
+
+func TestStagingSuffix(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	gate := make(chan struct{})
+	server := newGatedServer(content, gate)
+	defer server.Close()
+
+	filePath := filepath.Join(t.TempDir(), "movie.mp4")
+	errs := make(chan error, 1)
+	go func() {
+		_, err := Download(server.URL+"/movie.mp4", &Config{
+			FilePath:      filePath,
+			TmpDir:        t.TempDir(),
+			SegmentSize:   100,
+			Sequential:    true,
+			StagingSuffix: ".part",
+		})
+		errs <- err
+	}()
+
+	// the file is written as movie.mp4.part until complete
+	deadline := time.Now().Add(5 * time.Second)
+	for !fs.IsExist(filePath + ".part") {
+		if time.Now().After(deadline) {
+			t.Fatal("expected movie.mp4.part while it downloads")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if fs.IsExist(filePath) || fs.IsExist(filePath+stagingSuffix) {
+		t.Error("expected only movie.mp4.part while it downloads")
+	}
+	close(gate)
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filePath); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("expected the downloaded file, got %d bytes, %v", len(data), err)
+	}
+	if fs.IsExist(filePath + ".part") {
+		t.Error("expected movie.mp4.part to be renamed")
+	}
+
+	_, err := Download(server.URL+"/movie.mp4", &Config{
+		FilePath:      filePath,
+		StagingSuffix: "/part",
+	})
+	if err == nil {
+		t.Error("expected an invalid staging suffix to be rejected")
+	}
+}
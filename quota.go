@@ -0,0 +1,100 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQuotaExceeded means the download exceeded one of its quotas
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaConfig bounds a download job, so unattended batch runs never hang on a pathological origin
+type QuotaConfig struct {
+	// MaxTime is the wall-clock budget of the whole download, the parts are kept to resume, zero means no limit
+	MaxTime time.Duration
+	// MaxRedirects is the number of redirects followed by a request, zero means the net/http default (10)
+	MaxRedirects int
+	// MaxRetries is the number of retries of the whole download, all parts together, zero means no limit
+	MaxRetries int
+}
+
+// QuotaError is returned when a quota is exceeded,
+// errors.Is(err, ErrQuotaExceeded) holds, as well as for the error that hit the quota.
+type QuotaError struct {
+	// Quota is the exceeded quota, such as "max time 1m0s"
+	Quota string
+	// Err is the error that hit the quota
+	Err error
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrQuotaExceeded, e.Quota, e.Err)
+}
+
+// Unwrap returns the error that hit the quota
+func (e *QuotaError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports the error as ErrQuotaExceeded
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// withMaxTime bounds ctx by the max time quota
+func (d *Downloader) withMaxTime(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.Quota == nil || d.Quota.MaxTime <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, d.Quota.MaxTime)
+}
+
+// checkMaxTime turns the error of a download cancelled by the max time quota into a *QuotaError
+func (d *Downloader) checkMaxTime(parent context.Context, err error) error {
+	if err == nil || d.Quota == nil || d.Quota.MaxTime <= 0 {
+		return err
+	}
+
+	// cancelled by the caller, not the quota
+	if parent.Err() != nil || !errors.Is(d.getContext().Err(), context.DeadlineExceeded) {
+		return err
+	}
+
+	return &QuotaError{
+		Quota: fmt.Sprintf("max time %s", d.Quota.MaxTime),
+		Err:   err,
+	}
+}
+
+// takeRetry counts a retry against the max retries quota, false when exhausted
+func (d *Downloader) takeRetry() bool {
+	if d.Quota == nil || d.Quota.MaxRetries <= 0 {
+		return true
+	}
+
+	return atomic.AddInt32(&d.retries, 1) <= int32(d.Quota.MaxRetries)
+}
+
+// checkRedirect enforces the max redirects quota, nil keeps the net/http default
+func (d *Downloader) checkRedirect() func(req *http.Request, via []*http.Request) error {
+	if d.Quota == nil || d.Quota.MaxRedirects <= 0 {
+		return nil
+	}
+
+	max := d.Quota.MaxRedirects
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > max {
+			return &QuotaError{
+				Quota: fmt.Sprintf("max %d redirects", max),
+				Err:   fmt.Errorf("redirected to %s", req.URL),
+			}
+		}
+
+		return nil
+	}
+}
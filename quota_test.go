@@ -0,0 +1,95 @@
+package download
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQuotaMaxTime(t *testing.T) {
+	// the server sends the head, then stalls every range
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Type", "video/mp4")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "1000")
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	startedAt := time.Now()
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath: t.TempDir() + "/quota.mp4",
+		TmpDir:   t.TempDir(),
+		Quota:    &QuotaConfig{MaxTime: 200 * time.Millisecond},
+	})
+	if !errors.Is(err, ErrQuotaExceeded) || !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("expected a resumable quota error, got %v", err)
+	}
+	if elapsed := time.Since(startedAt); elapsed > 3*time.Second {
+		t.Errorf("expected the download to stop at its max time, took %s", elapsed)
+	}
+}
+
+func TestQuotaMaxRedirects(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, server.URL+r.URL.Path+"x", http.StatusFound)
+	}))
+	defer server.Close()
+
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:         t.TempDir() + "/quota.mp4",
+		TmpDir:           t.TempDir(),
+		IsRangesDisabled: true,
+		Quota:            &QuotaConfig{MaxRedirects: 2},
+	})
+	var e *QuotaError
+	if !errors.As(err, &e) || e.Quota != "max 2 redirects" {
+		t.Fatalf("expected a redirects quota error, got %v", err)
+	}
+}
+
+func TestQuotaMaxRetries(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Type", "video/mp4")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "1000")
+			return
+		}
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:    t.TempDir() + "/quota.mp4",
+		TmpDir:      t.TempDir(),
+		SegmentSize: 100,
+		RetryPolicy: &RetryPolicy{
+			MaxAttempts: 100,
+			Delay: func(retry int, err error) time.Duration {
+				return time.Millisecond
+			},
+		},
+		Quota: &QuotaConfig{MaxRetries: 5},
+	})
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected a retries quota error, got %v", err)
+	}
+
+	// every part makes a first attempt, only 5 retries are shared between them
+	if n := atomic.LoadInt32(&requests); n > 10+5 {
+		t.Errorf("expected at most 15 requests, got %d", n)
+	}
+}
@@ -0,0 +1,113 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrInvalidRange means Config.Range is not a range of the remote file
+var ErrInvalidRange = errors.New("invalid range")
+
+// validateRange checks the bounds of Config.Range known before the request
+func (d *Downloader) validateRange() error {
+	if d.Range == nil {
+		return nil
+	}
+
+	if d.Range.Start < 0 {
+		return fmt.Errorf("%w: negative start %d", ErrInvalidRange, d.Range.Start)
+	}
+
+	return nil
+}
+
+// byteRange returns the inclusive bounds of the bytes to download, the whole file without Config.Range
+// (or its rest when resumed), end is -1 when it is the end of a file of unknown size.
+func (d *Downloader) byteRange() (start, end int64, err error) {
+	start, end = 0, d.ContentLength-1
+	if d.Range == nil {
+		// the bytes of a resumed file are not downloaded again
+		return d.resumeOffset, end, nil
+	}
+
+	start = d.Range.Start
+	if d.Range.End >= d.Range.Start && (d.ContentLength <= 0 || d.Range.End < d.ContentLength) {
+		end = d.Range.End
+	}
+
+	if d.ContentLength > 0 && start >= d.ContentLength {
+		return 0, 0, fmt.Errorf("%w: start %d beyond the size %d", ErrInvalidRange, start, d.ContentLength)
+	}
+
+	return start, end, nil
+}
+
+// targetLength returns the size of the downloaded file, the range size with Config.Range,
+// zero or negative when unknown.
+func (d *Downloader) targetLength() int64 {
+	if d.Range == nil {
+		return d.ContentLength
+	}
+
+	start, end, err := d.byteRange()
+	if err != nil || end < 0 {
+		return 0
+	}
+
+	return end - start + 1
+}
+
+// rangeHeader returns the Range header of the single request downloads, empty without Config.Range
+func (d *Downloader) rangeHeader() string {
+	if d.Range == nil {
+		return ""
+	}
+
+	if d.Range.End < d.Range.Start {
+		return fmt.Sprintf("bytes=%d-", d.Range.Start)
+	}
+
+	return fmt.Sprintf("bytes=%d-%d", d.Range.Start, d.Range.End)
+}
+
+// directRequestConfig returns the config of the single request downloads, asking for Config.Range if any
+func (d *Downloader) directRequestConfig() *requestConfig {
+	header := d.rangeHeader()
+	if header == "" {
+		return nil
+	}
+
+	return &requestConfig{
+		Headers: map[string]string{
+			"Range": header,
+		},
+	}
+}
+
+// checkRangeResponse checks the response to a single request for Config.Range,
+// the size of the remote file is taken from its Content-Range.
+func (d *Downloader) checkRangeResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return errors.New("server does not support range")
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return newStatusError(resp.StatusCode)
+	}
+
+	start, _, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return newProtocolError(err)
+	}
+
+	if start != d.Range.Start {
+		return newProtocolError(fmt.Errorf("unexpected content range: expect start %d, got %s", d.Range.Start, resp.Header.Get("Content-Range")))
+	}
+
+	if total > 0 {
+		d.ContentLength = total
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package download
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRange(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	cases := []struct {
+		name     string
+		r        *Range
+		disabled bool
+		expected []byte
+	}{
+		{"ranges", &Range{Start: 105, End: 347}, false, content[105:348]},
+		{"ranges to the end", &Range{Start: 900, End: -1}, false, content[900:]},
+		{"ranges beyond the end", &Range{Start: 990, End: 5000}, false, content[990:]},
+		{"direct", &Range{Start: 105, End: 347}, true, content[105:348]},
+		{"direct to the end", &Range{Start: 900, End: -1}, true, content[900:]},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			filePath := t.TempDir() + "/range.mp4"
+			_, err := Download(server.URL+"/file.mp4", &Config{
+				FilePath:         filePath,
+				TmpDir:           t.TempDir(),
+				SegmentSize:      64,
+				IsRangesDisabled: c.disabled,
+				Range:            c.r,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, c.expected) {
+				t.Errorf("unexpected content: %s", data)
+			}
+		})
+	}
+}
+
+func TestRangeDownloadTo(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	var buf bytes.Buffer
+	d := New(server.URL+"/file.mp4", &Config{
+		Range:        &Range{Start: 10, End: 29},
+		ExpectedSize: 20,
+	})
+	if err := d.DownloadTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), content[10:30]) {
+		t.Errorf("unexpected content: %s", buf.Bytes())
+	}
+	if d.ContentLength != int64(len(content)) {
+		t.Errorf("expected the size of the remote file, got %d", d.ContentLength)
+	}
+}
+
+func TestRangeInvalid(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	for _, r := range []*Range{{Start: -1, End: 10}, {Start: 1000, End: 1100}} {
+		_, err := Download(server.URL+"/file.mp4", &Config{
+			FilePath: t.TempDir() + "/range.mp4",
+			TmpDir:   t.TempDir(),
+			Range:    r,
+		})
+		if !errors.Is(err, ErrInvalidRange) {
+			t.Errorf("expected ErrInvalidRange for %+v, got %v", r, err)
+		}
+	}
+}
+
+// syntheticFile is a file of size bytes generated from the offsets, served without being stored
+type syntheticFile struct {
+	size int64
+	off  int64
+}
+
+func syntheticByte(off int64) byte {
+	return byte(off % 251)
+}
+
+func (f *syntheticFile) Read(p []byte) (int, error) {
+	if f.off >= f.size {
+		return 0, io.EOF
+	}
+	if rest := f.size - f.off; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	for i := range p {
+		p[i] = syntheticByte(f.off + int64(i))
+	}
+	f.off += int64(len(p))
+	return len(p), nil
+}
+
+func (f *syntheticFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		f.off = offset
+	case io.SeekCurrent:
+		f.off += offset
+	case io.SeekEnd:
+		f.off = f.size + offset
+	}
+	return f.off, nil
+}
+
+func TestRangeLargeFile(t *testing.T) {
+	const size = 6 << 30
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.mp4", time.Time{}, &syntheticFile{size: size})
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		name     string
+		r        *Range
+		disabled bool
+	}{
+		{"across 4GB", &Range{Start: 4<<30 - 700, End: 4<<30 + 699}, false},
+		{"beyond 4GB", &Range{Start: 5<<30 + 12345, End: 5<<30 + 13344}, false},
+		{"to the end", &Range{Start: size - 1000, End: -1}, false},
+		{"direct", &Range{Start: 4<<30 - 700, End: 4<<30 + 699}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := New(server.URL+"/file.mp4", &Config{
+				FilePath:         t.TempDir() + "/range.mp4",
+				TmpDir:           t.TempDir(),
+				SegmentSize:      500,
+				IsRangesDisabled: c.disabled,
+				Range:            c.r,
+			})
+			if err := d.Download(); err != nil {
+				t.Fatal(err)
+			}
+
+			if d.ContentLength != size {
+				t.Errorf("expected the size %d, got %d", int64(size), d.ContentLength)
+			}
+
+			data, err := os.ReadFile(d.FilePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			end := c.r.End
+			if end < 0 {
+				end = size - 1
+			}
+			if int64(len(data)) != end-c.r.Start+1 {
+				t.Fatalf("expected %d bytes, got %d", end-c.r.Start+1, len(data))
+			}
+			for i, b := range data {
+				if b != syntheticByte(c.r.Start+int64(i)) {
+					t.Fatalf("unexpected byte at %d", c.r.Start+int64(i))
+				}
+			}
+		})
+	}
+}
+
+func TestParseRangesLargeFile(t *testing.T) {
+	d := New("http://example.com/file.mp4", &Config{})
+	d.ContentLength = 5<<30 + 1
+	if err := d.parseRanges(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.Ranges) != 513 {
+		t.Fatalf("expected 513 ranges, got %d", len(d.Ranges))
+	}
+	last := d.Ranges[len(d.Ranges)-1]
+	if last.Start != 5<<30 || last.End != 5<<30 {
+		t.Errorf("expected the last range at 5GB, got %d-%d", last.Start, last.End)
+	}
+}
@@ -0,0 +1,175 @@
+package download
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DefaultBlockSize is the size of the blocks fetched and cached by RemoteFile
+var DefaultBlockSize = 64 * 1024
+
+// DefaultBlockCacheSize is the number of blocks cached by RemoteFile, the least recently used are evicted
+var DefaultBlockCacheSize = 64
+
+// RemoteFile is an io.ReaderAt and io.ReadSeeker over the remote file, backed by range requests,
+// e.g. to open the central directory of a remote zip or probe a video header without downloading the whole file.
+// The blocks read are cached (LRU), ReadAt is safe for concurrent use, Read and Seek are not.
+type RemoteFile struct {
+	d      *Downloader
+	size   int64
+	offset int64
+
+	mu     sync.Mutex
+	blocks map[int64]*list.Element
+	lru    *list.List
+}
+
+type cachedBlock struct {
+	index int64
+	data  []byte
+}
+
+// OpenRemote returns the remote file as a RemoteFile, the server must support ranges
+func (d *Downloader) OpenRemote(ctx context.Context) (*RemoteFile, error) {
+	d.ctx = ctx
+
+	if err := d.extract(); err != nil {
+		return nil, err
+	}
+
+	isSupportRange, err := d.checkSupportRange()
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSupportRange {
+		return nil, errors.New("server does not support range")
+	}
+
+	if err := d.parseContentInfo(); err != nil {
+		return nil, err
+	}
+
+	return &RemoteFile{
+		d:      d,
+		size:   d.ContentLength,
+		blocks: map[int64]*list.Element{},
+		lru:    list.New(),
+	}, nil
+}
+
+// Size returns the size of the remote file
+func (f *RemoteFile) Size() int64 {
+	return f.size
+}
+
+// ReadAt reads len(p) bytes at off, fetching the blocks not cached
+func (f *RemoteFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	n := 0
+	for n < len(p) {
+		if off >= f.size {
+			return n, io.EOF
+		}
+
+		blockSize := int64(DefaultBlockSize)
+		index := off / blockSize
+		data, err := f.block(index, blockSize)
+		if err != nil {
+			return n, err
+		}
+
+		m := copy(p[n:], data[off-index*blockSize:])
+		n += m
+		off += int64(m)
+	}
+
+	return n, nil
+}
+
+// block returns the block at index, from the cache or the server
+func (f *RemoteFile) block(index int64, blockSize int64) ([]byte, error) {
+	f.mu.Lock()
+	if e, ok := f.blocks[index]; ok {
+		f.lru.MoveToFront(e)
+		f.mu.Unlock()
+		return e.Value.(*cachedBlock).data, nil
+	}
+	f.mu.Unlock()
+
+	start := index * blockSize
+	end := start + blockSize - 1
+	if end >= f.size {
+		end = f.size - 1
+	}
+
+	part := &FilePart{Index: int(index), RangeStart: start, RangeEnd: end}
+	var data []byte
+	err := f.d.retry(part, func() (err error) {
+		data, err = f.d.fetchSegment(part)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.blocks[index]; !ok {
+		f.blocks[index] = f.lru.PushFront(&cachedBlock{index: index, data: data})
+		for f.lru.Len() > DefaultBlockCacheSize {
+			oldest := f.lru.Back()
+			f.lru.Remove(oldest)
+			delete(f.blocks, oldest.Value.(*cachedBlock).index)
+		}
+	}
+
+	return data, nil
+}
+
+// Read reads from the current offset
+func (f *RemoteFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+
+	if remaining := f.size - f.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// Seek sets the offset of the next Read
+func (f *RemoteFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	f.offset = offset
+	return offset, nil
+}
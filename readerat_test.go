@@ -0,0 +1,104 @@
+package download
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRemoteFileZip(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(bytes.Repeat([]byte(name), 100000))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var transferred int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &countingWriter{ResponseWriter: w, n: &transferred}
+		http.ServeContent(cw, r, "archive.zip", time.Time{}, bytes.NewReader(archive.Bytes()))
+	}))
+	defer server.Close()
+
+	f, err := New(server.URL+"/archive.zip", &Config{TmpDir: t.TempDir()}).OpenRemote(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(f, f.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 2 || zr.File[1].Name != "b.txt" {
+		t.Fatalf("unexpected entries: %v", zr.File)
+	}
+
+	// only the central directory was read
+	if n := atomic.LoadInt64(&transferred); n > int64(2*DefaultBlockSize) {
+		t.Errorf("expected a few blocks transferred, got %d of %d bytes", n, archive.Len())
+	}
+}
+
+func TestRemoteFileSeek(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 20000)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&requests, 1)
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	f, err := New(server.URL+"/file.mp4", &Config{TmpDir: t.TempDir()}).OpenRemote(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(-15, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	tail, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tail, content[len(content)-15:]) {
+		t.Errorf("unexpected tail: %s", tail)
+	}
+
+	// across two blocks, the second one is cached
+	buf := make([]byte, 20)
+	off := int64(DefaultBlockSize*3 - 10)
+	if _, err := f.ReadAt(buf, off); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, content[off:off+20]) {
+		t.Errorf("unexpected content: %s", buf)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("expected 2 block requests, got %d", n)
+	}
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(w.n, int64(len(p)))
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,42 @@
+package download
+
+import (
+	"fmt"
+	"os"
+)
+
+// refreshSource replaces the expired url of the source with a re-signed one,
+// parts failing at the same time share a single refresh.
+func (d *Downloader) refreshSource(src *source, old string) error {
+	d.refreshMu.Lock()
+	defer d.refreshMu.Unlock()
+
+	d.sources.Lock()
+	current := src.URL
+	d.sources.Unlock()
+	if current != old {
+		// already refreshed by another part
+		return nil
+	}
+
+	url, err := d.RefreshURL(old)
+	if err != nil {
+		return fmt.Errorf("failed to refresh url %s: %s", old, err)
+	}
+
+	if os.Getenv("DEBUG") == "true" {
+		fmt.Println("refreshed url:", old, "=>", url)
+	}
+
+	d.sources.Lock()
+	src.URL = url
+	src.down = false
+	src.consecutiveFailures = 0
+	d.sources.Unlock()
+
+	if d.URL == old {
+		d.URL = url
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshURL(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+
+	// the old signature is only accepted for HEAD, as if it expired right after the probe
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Query().Get("signature") != "new" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	var refreshed int32
+	filePath := t.TempDir() + "/refresh.mp4"
+	_, err := Download(server.URL+"/file.mp4?signature=old", &Config{
+		FilePath:    filePath,
+		TmpDir:      t.TempDir(),
+		SegmentSize: 32,
+		RefreshURL: func(old string) (string, error) {
+			atomic.AddInt32(&refreshed, 1)
+			return strings.Replace(old, "signature=old", "signature=new", 1), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if refreshed != 1 {
+		t.Errorf("expected the url to be refreshed once, got %d", refreshed)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("unexpected content: %s", data)
+	}
+}
@@ -0,0 +1,92 @@
+package download
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRegistry lists the downloads in flight in the process, see Config.Registry
+var DefaultRegistry = NewRegistry()
+
+// downloadIDs numbers the downloads of the process
+var downloadIDs uint64
+
+// Registry lists the downloads in flight, e.g. for a monitoring endpoint
+type Registry struct {
+	mu        sync.Mutex
+	downloads map[uint64]*registryEntry
+}
+
+type registryEntry struct {
+	downloader *Downloader
+	url        string
+	startedAt  time.Time
+}
+
+// ActiveDownload represents a download in flight
+type ActiveDownload struct {
+	// ID identifies the download in the process
+	ID        uint64
+	URL       string
+	StartedAt time.Time
+	Progress  Progress
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		downloads: map[uint64]*registryEntry{},
+	}
+}
+
+// List returns the downloads in flight, the oldest first
+func (r *Registry) List() []ActiveDownload {
+	r.mu.Lock()
+	entries := make(map[uint64]*registryEntry, len(r.downloads))
+	for id, entry := range r.downloads {
+		entries[id] = entry
+	}
+	r.mu.Unlock()
+
+	downloads := make([]ActiveDownload, 0, len(entries))
+	for id, entry := range entries {
+		downloads = append(downloads, ActiveDownload{
+			ID:        id,
+			URL:       entry.url,
+			StartedAt: entry.startedAt,
+			Progress:  entry.downloader.Progress(),
+		})
+	}
+
+	sort.Slice(downloads, func(i, j int) bool {
+		return downloads[i].ID < downloads[j].ID
+	})
+	return downloads
+}
+
+// register adds the download to the registry until the returned func is called
+func (r *Registry) register(d *Downloader) func() {
+	id := atomic.AddUint64(&downloadIDs, 1)
+
+	r.mu.Lock()
+	// the url as requested, the download may resolve it to another
+	r.downloads[id] = &registryEntry{downloader: d, url: d.URL, startedAt: time.Now()}
+	r.mu.Unlock()
+
+	return func() {
+		r.mu.Lock()
+		delete(r.downloads, id)
+		r.mu.Unlock()
+	}
+}
+
+// registry returns Registry, DefaultRegistry by default
+func (d *Downloader) registry() *Registry {
+	if d.Registry != nil {
+		return d.Registry
+	}
+
+	return DefaultRegistry
+}
@@ -0,0 +1,60 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	gate := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.mp4", time.Time{}, &gatedReader{
+			Reader: bytes.NewReader(content),
+			size:   int64(len(content)),
+			gate:   gate,
+		})
+	}))
+	defer server.Close()
+
+	registry := NewRegistry()
+	d := New(server.URL+"/file.mp4", &Config{
+		FilePath:    filepath.Join(t.TempDir(), "file.mp4"),
+		TmpDir:      t.TempDir(),
+		SegmentSize: int64(len(content)),
+		Registry:    registry,
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- d.Download()
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		downloads := registry.List()
+		if len(downloads) == 1 && downloads[0].Progress.Downloaded == int64(len(content)/2) {
+			if downloads[0].URL != server.URL+"/file.mp4" || downloads[0].StartedAt.IsZero() {
+				t.Errorf("unexpected active download: %+v", downloads[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the download listed half done, got %+v", downloads)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	close(gate)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if downloads := registry.List(); len(downloads) != 0 {
+		t.Errorf("expected the completed download removed, got %+v", downloads)
+	}
+}
@@ -0,0 +1,224 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// DefaultRepairRounds is the number of times the parts failing the check before the merge are downloaded again
+var DefaultRepairRounds = 2
+
+// DefaultRepairMaxBytes is the number of bytes fetched again to find the bad region of a file failing its
+// checksum, the repair gives up beyond, e.g. with a wrong expected checksum
+var DefaultRepairMaxBytes int64 = 256 * 1024 * 1024
+
+// checkPart returns why the part can't be merged, nil when it can: it is missing, not of the exact size
+// of its range, or fails its piece hash
+func (d *Downloader) checkPart(part *FilePart) error {
+	info, err := os.Stat(part.Path)
+	if err != nil {
+		return fmt.Errorf("part %d is missing: %w", part.Index, err)
+	}
+
+	if size := part.RangeEnd - part.RangeStart + 1; info.Size() != size {
+		return fmt.Errorf("part %d: expect %d bytes, got %d", part.Index, size, info.Size())
+	}
+
+	return d.verifyFilePart(part)
+}
+
+// repairPlan returns the parts which can't be merged, in order, with the error of each
+func (d *Downloader) repairPlan() ([]*FilePart, []error) {
+	var parts []*FilePart
+	var errs []error
+	for _, part := range d.FileParts {
+		if err := d.checkPart(part); err != nil {
+			parts = append(parts, part)
+			errs = append(errs, err)
+		}
+	}
+
+	return parts, errs
+}
+
+// repairParts checks every part before the merge and downloads again the ones which can't be merged,
+// only them, so a missing or short part never ends up in the file; the parts still bad after
+// DefaultRepairRounds fail the download.
+func (d *Downloader) repairParts() error {
+	for round := 0; ; round++ {
+		parts, errs := d.repairPlan()
+		if len(parts) == 0 {
+			return nil
+		}
+
+		if round == DefaultRepairRounds {
+			return annotate(errs[0], d.URL, parts[0], 0)
+		}
+
+		for i, part := range parts {
+			if os.Getenv("DEBUG") == "true" {
+				fmt.Println("repairing part:", errs[i])
+			}
+
+			if err := removeIfExists(part.Path); err != nil {
+				return err
+			}
+			d.setPartState(part, PartPending)
+
+			if err := d.downloadFilePartWithRetry(d.getContext(), part); err != nil {
+				d.setPartState(part, PartFailed)
+				return annotate(err, d.URL, part, 0)
+			}
+			d.setPartState(part, PartDone)
+		}
+	}
+}
+
+// repairStaged repairs the staged file at path failing its checksum with err, true once repaired
+func (d *Downloader) repairStaged(path string, err error) bool {
+	expected, _ := d.expectedChecksum()
+	if expected == nil || !errors.Is(err, ErrChecksumMismatch) {
+		return false
+	}
+
+	if err := d.repairFile(path, expected); err != nil {
+		if os.Getenv("DEBUG") == "true" {
+			fmt.Println("repair: failed to repair the file:", err)
+		}
+		return false
+	}
+
+	return true
+}
+
+// repairFile patches the merged file at path failing the expected checksum, so a bad region is fetched again
+// instead of the whole file: first the regions which differ from the sums of their parts as downloaded, a part
+// damaged on disk before the merge, then the parts fetched again which differ from the bytes downloaded,
+// a flaky source, the checksum verified again after each patch. The parts fetched again are limited to
+// DefaultRepairMaxBytes, and none is when the remote file changed.
+func (d *Downloader) repairFile(path string, expected *checksum) error {
+	if d.Parts() == nil {
+		return errors.New("not a ranges download")
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var origin int64
+	if d.Range != nil {
+		origin = d.Range.Start
+	}
+
+	patched := false
+	for _, part := range d.FileParts {
+		sum := d.progress.partSum(part.Path)
+		if sum == nil {
+			continue
+		}
+
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(f, part.RangeStart-origin, part.RangeEnd-part.RangeStart+1)); err != nil {
+			return err
+		}
+		if bytes.Equal(h.Sum(nil), sum) {
+			continue
+		}
+
+		if err := d.patchPart(f, part, origin, nil); err != nil {
+			return err
+		}
+		patched = true
+	}
+
+	if patched && expected.verify(path) == nil {
+		return nil
+	}
+
+	if err := d.checkRepairRemote(); err != nil {
+		return err
+	}
+
+	var fetched int64
+	for _, part := range d.FileParts {
+		size := part.RangeEnd - part.RangeStart + 1
+		if fetched += size; fetched > DefaultRepairMaxBytes {
+			return fmt.Errorf("no bad region found in the first %d bytes", fetched-size)
+		}
+
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(f, part.RangeStart-origin, size)); err != nil {
+			return err
+		}
+
+		if err := d.patchPart(f, part, origin, h.Sum(nil)); err == errRegionUnchanged {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if expected.verify(path) == nil {
+			return nil
+		}
+	}
+
+	return errors.New("no bad region found")
+}
+
+// checkRepairRemote fails with ErrRemoteChanged when the validators of the remote file changed since
+// the download, its parts would not repair the file
+func (d *Downloader) checkRepairRemote() error {
+	if d.ETag == "" && d.LastModified == "" {
+		return nil
+	}
+
+	response, err := d.fetchHead(d.URL)
+	if err != nil || response.Status != http.StatusOK {
+		// unknown, the If-Range of the parts still catches the change
+		return nil
+	}
+
+	etag, lastModified := response.Headers.Get("ETag"), response.Headers.Get("Last-Modified")
+	if (d.ETag != "" && etag != d.ETag) || (d.ETag == "" && lastModified != d.LastModified) {
+		return newRemoteChangedError(d.validator(), response.Headers)
+	}
+
+	return nil
+}
+
+// errRegionUnchanged means the part fetched again is the region of the file
+var errRegionUnchanged = errors.New("region unchanged")
+
+// patchPart fetches the part again and writes it at its offset in f,
+// errRegionUnchanged when it is the region of sha256 regionSum, if known
+func (d *Downloader) patchPart(f *os.File, part *FilePart, origin int64, regionSum []byte) error {
+	var data []byte
+	err := d.retry(part, func() (err error) {
+		data, err = d.fetchSegment(part)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if regionSum != nil {
+		sum := sha256.Sum256(data)
+		if bytes.Equal(sum[:], regionSum) {
+			return errRegionUnchanged
+		}
+	}
+
+	if os.Getenv("DEBUG") == "true" {
+		fmt.Println("repair: patching part:", part.Index, part.RangeStart, part.RangeEnd)
+	}
+
+	_, err = f.WriteAt(data, part.RangeStart-origin)
+	return err
+}
@@ -0,0 +1,171 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRepairParts(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var fetched int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=400-499" {
+			atomic.AddInt32(&fetched, 1)
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	d := New(server.URL+"/file.mp4", &Config{
+		FilePath:    t.TempDir() + "/file.mp4",
+		TmpDir:      t.TempDir(),
+		SegmentSize: 100,
+		// a part cut short once written, e.g. by a full disk or another process
+		OnCheckpoint: func(part FilePart, bytesTotal int64) {
+			if part.Index == 4 {
+				os.Truncate(part.Path, 50)
+			}
+		},
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(d.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("unexpected content: %q", data)
+	}
+
+	if n := atomic.LoadInt32(&fetched); n != 2 {
+		t.Errorf("expected the short part fetched again, got %d requests", n)
+	}
+	if parts := d.Parts(); parts[4].State != PartDone || parts[4].Attempts != 2 {
+		t.Errorf("expected the part repaired, got %+v", parts[4])
+	}
+}
+
+func TestRepairFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	sum := sha256.Sum256(content)
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+
+	cases := []struct {
+		name string
+		// damaged is the part damaged on disk once downloaded, flaky the one the server corrupts once
+		damaged, flaky int
+		expected       map[string]int32
+	}{
+		{"damaged on disk", 4, -1, map[string]int32{"bytes=400-499": 2, "bytes=500-599": 1}},
+		{"flaky source", -1, 2, map[string]int32{"bytes=100-199": 2, "bytes=200-299": 2, "bytes=300-399": 1}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var mu sync.Mutex
+			fetched := map[string]int32{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				byteRange := r.Header.Get("Range")
+				mu.Lock()
+				fetched[byteRange]++
+				n := fetched[byteRange]
+				mu.Unlock()
+
+				data := content
+				if c.flaky >= 0 && byteRange == fmt.Sprintf("bytes=%d-%d", c.flaky*100, c.flaky*100+99) && n == 1 {
+					data = append([]byte{}, content...)
+					data[c.flaky*100+10] ^= 0xff
+				}
+				http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(data))
+			}))
+			defer server.Close()
+
+			d := New(server.URL+"/file.mp4", &Config{
+				FilePath:         t.TempDir() + "/file.mp4",
+				TmpDir:           t.TempDir(),
+				SegmentSize:      100,
+				ExpectedChecksum: checksum,
+				OnCheckpoint: func(part FilePart, bytesTotal int64) {
+					if part.Index == c.damaged {
+						data, _ := os.ReadFile(part.Path)
+						data[10] ^= 0xff
+						os.WriteFile(part.Path, data, 0644)
+					}
+				},
+			})
+			if err := d.Download(); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(d.FilePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, content) {
+				t.Errorf("unexpected content: %q", data)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for byteRange, n := range c.expected {
+				if fetched[byteRange] != n {
+					t.Errorf("expected %s fetched %d times, got %d", byteRange, n, fetched[byteRange])
+				}
+			}
+		})
+	}
+}
+
+func TestRepairFileLimits(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	maxBytes := DefaultRepairMaxBytes
+	DefaultRepairMaxBytes = 250
+	defer func() { DefaultRepairMaxBytes = maxBytes }()
+
+	for _, isChanged := range []bool{false, true} {
+		var fetched int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			etag := `"v1"`
+			if r.Method == http.MethodGet {
+				atomic.AddInt32(&fetched, 1)
+			} else if isChanged && atomic.LoadInt32(&fetched) > 0 {
+				etag = `"v2"`
+			}
+			w.Header().Set("ETag", etag)
+			http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+		}))
+
+		// a wrong checksum, no part fetched again fixes it
+		_, err := Download(server.URL+"/file.mp4", &Config{
+			FilePath:         t.TempDir() + "/file.mp4",
+			TmpDir:           t.TempDir(),
+			SegmentSize:      100,
+			ExpectedChecksum: "sha256:" + hex.EncodeToString(make([]byte, sha256.Size)),
+		})
+		server.Close()
+		if !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("expected a checksum mismatch, got %v", err)
+		}
+
+		// the 10 parts, then the first 2 again within the limit, none once the remote changed
+		expected := int32(12)
+		if isChanged {
+			expected = 10
+		}
+		if n := atomic.LoadInt32(&fetched); n != expected {
+			t.Errorf("expected %d requests when the remote changed: %v, got %d", expected, isChanged, n)
+		}
+	}
+}
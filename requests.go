@@ -0,0 +1,90 @@
+package download
+
+import (
+	"context"
+	"sync"
+)
+
+// processRequests bounds the requests in flight of all the downloads of the process, see SetMaxRequests
+var processRequests = &requestLimit{changed: make(chan struct{})}
+
+// requestLimit bounds the requests in flight
+type requestLimit struct {
+	mu sync.Mutex
+	// max is the number of requests in flight allowed, zero for no limit
+	max    int
+	active int
+	// changed is closed once a request is done or the limit changes
+	changed chan struct{}
+}
+
+// SetMaxRequests caps the http requests in flight of all the downloads of the process together, the probes,
+// the parts and the retries of every file, so a service embedding the package stays within its egress
+// connection budget whatever the concurrency of each download; a request holds its slot until its body is
+// closed, the others wait for one. Zero, the default, means no limit. The uploads to a sink or an object store
+// are not counted.
+func SetMaxRequests(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	processRequests.mu.Lock()
+	defer processRequests.mu.Unlock()
+
+	processRequests.max = n
+	processRequests.notify()
+}
+
+// MaxRequests returns the cap set by SetMaxRequests, zero for no limit
+func MaxRequests() int {
+	processRequests.mu.Lock()
+	defer processRequests.mu.Unlock()
+
+	return processRequests.max
+}
+
+// RequestsInFlight returns the http requests in flight of all the downloads of the process
+func RequestsInFlight() int {
+	processRequests.mu.Lock()
+	defer processRequests.mu.Unlock()
+
+	return processRequests.active
+}
+
+// notify wakes the requests waiting for a slot
+func (l *requestLimit) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// acquire waits for a request to be allowed, the returned func releases it
+func (l *requestLimit) acquire(ctx context.Context) (func(), error) {
+	for {
+		l.mu.Lock()
+		if l.max == 0 || l.active < l.max {
+			l.active++
+			l.mu.Unlock()
+
+			var once sync.Once
+			return func() {
+				once.Do(l.release)
+			}, nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (l *requestLimit) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+	l.notify()
+}
@@ -0,0 +1,53 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSetMaxRequests(t *testing.T) {
+	SetMaxRequests(2)
+	defer SetMaxRequests(0)
+
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// the downloads of a batch, each with its own parts in flight
+	result := DownloadBatch(context.Background(), []*BatchEntry{
+		{URL: server.URL + "/a.mp4"},
+		{URL: server.URL + "/b.mp4"},
+		{URL: server.URL + "/c.mp4"},
+	}, &Config{
+		Dir:         t.TempDir(),
+		TmpDir:      t.TempDir(),
+		SegmentSize: 100,
+	})
+	if err := result.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if maxInFlight > 2 {
+		t.Errorf("expected at most 2 requests in flight, got %d", maxInFlight)
+	}
+	if MaxRequests() != 2 || RequestsInFlight() != 0 {
+		t.Errorf("expected the requests to be released, got %d in flight", RequestsInFlight())
+	}
+}
@@ -0,0 +1,91 @@
+package download
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Result represents a completed download
+type Result struct {
+	// FilePath is the absolute path the file was downloaded for, as TargetPath, or its location in the sink
+	FilePath string
+	// FinalPath is the absolute path the file ended up at on disk, FilePath unless IfExistsRename renamed it
+	// or ContentStore stored it by its digest, empty with a sink
+	FinalPath string
+	// Size is the size of the file
+	Size int64
+	// ContentType is the content type announced by the server
+	ContentType string
+	// Duration is the time the download took
+	Duration time.Duration
+	// Speed is the average transfer rate in bytes per second
+	Speed float64
+	// Retries is the number of retried requests, all parts together
+	Retries int
+	// Checksums are the digests of the file by algorithm, hex encoded, its sha256 computed once complete,
+	// the verified ExpectedChecksum and the digest of the file in ContentStore
+	Checksums map[string]string
+}
+
+// Result returns the result of the last completed download, nil before
+func (d *Downloader) Result() *Result {
+	return d.lastResult
+}
+
+// result returns the result of the download started at startedAt
+func (d *Downloader) result(startedAt time.Time) *Result {
+	progress := d.Progress()
+	result := &Result{
+		FilePath:    d.FilePath,
+		Size:        progress.Downloaded,
+		ContentType: d.ContentType,
+		Duration:    time.Since(startedAt),
+		Retries:     int(atomic.LoadInt32(&d.retried)),
+		Checksums:   map[string]string{},
+	}
+	if result.ContentType == "" && d.responseHeaders != nil {
+		result.ContentType = d.responseHeaders.Get("Content-Type")
+	}
+	if seconds := result.Duration.Seconds(); seconds > 0 {
+		result.Speed = float64(d.progress.transferredBytes()) / seconds
+	}
+
+	if d.sinkLocation == "" {
+		targetPath := d.getFilePath()
+		if d.targetPath != "" {
+			targetPath = d.targetPath
+		}
+		if abs, err := filepath.Abs(targetPath); err == nil {
+			result.FilePath = abs
+		}
+		if abs, err := filepath.Abs(d.FilePath); err == nil {
+			result.FinalPath = abs
+		}
+	}
+	// the file may be reused without being downloaded
+	if info, err := os.Stat(result.FinalPath); err == nil && info.Mode().IsRegular() {
+		result.Size = info.Size()
+	}
+
+	if expected, err := d.expectedChecksum(); err == nil && expected != nil {
+		result.Checksums[expected.algo] = hex.EncodeToString(expected.sum)
+	}
+	if i := strings.IndexByte(d.Digest, ':'); i > 0 {
+		result.Checksums[d.Digest[:i]] = d.Digest[i+1:]
+	}
+	if _, ok := result.Checksums[ChecksumSHA256]; !ok {
+		if d.sinkSHA256 != "" {
+			result.Checksums[ChecksumSHA256] = d.sinkSHA256
+		} else if result.FinalPath != "" {
+			if sum, err := sha256File(result.FinalPath); err == nil {
+				result.Checksums[ChecksumSHA256] = sum
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,86 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResult(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	// the first request of the part at 500 fails
+	var failures int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=500-999" && atomic.AddInt32(&failures, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "video/mp4")
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+
+	sum := sha256.Sum256(content)
+	result, err := Download(server.URL+"/file.mp4", &Config{
+		TmpDir:           t.TempDir(),
+		SegmentSize:      500,
+		ExpectedChecksum: "sha256:" + hex.EncodeToString(sum[:]),
+		RetryPolicy:      &RetryPolicy{BaseDelay: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the inferred name, in the current directory
+	expected, _ := filepath.Abs("file.mp4")
+	if result.FilePath != expected {
+		t.Errorf("expected the file at %s, got %s", expected, result.FilePath)
+	}
+	if result.Size != int64(len(content)) || result.ContentType != "video/mp4" || result.Retries != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if result.Duration <= 0 || result.Speed <= 0 {
+		t.Errorf("expected a duration and a speed, got %+v", result)
+	}
+	if result.Checksums["sha256"] != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected checksums: %v", result.Checksums)
+	}
+
+	// a plain download has the sha256 computed, as a download to a sink
+	plain, err := Download(server.URL+"/file.mp4", &Config{FilePath: filepath.Join(dir, "plain.mp4")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed, err := Download(server.URL+"/file.mp4", &Config{Sink: NewMemorySink()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*Result{plain, streamed} {
+		if r.Checksums["sha256"] != hex.EncodeToString(sum[:]) {
+			t.Errorf("expected the sha256 of the file, got %v", r.Checksums)
+		}
+	}
+
+	// a failed download has no result
+	result, err = Download(server.URL+"/other.mp4", &Config{
+		TmpDir:           t.TempDir(),
+		ExpectedChecksum: "sha256:" + hex.EncodeToString(make([]byte, 32)),
+	})
+	if !errors.Is(err, ErrChecksumMismatch) || result != nil {
+		t.Errorf("expected ErrChecksumMismatch and no result, got %v, %+v", err, result)
+	}
+}
@@ -0,0 +1,81 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-zoox/fs"
+)
+
+// ErrRemoteChanged means the remote file changed since the parts were started
+var ErrRemoteChanged = errors.New("remote file changed")
+
+func newRemoteChangedError(validator string, headers http.Header) error {
+	current := headers.Get("ETag")
+	if current == "" {
+		current = headers.Get("Last-Modified")
+	}
+
+	return &Error{
+		Kind: ErrorKindProtocol,
+		Err:  fmt.Errorf("%w: expect %s, got %s", ErrRemoteChanged, validator, current),
+	}
+}
+
+// validator returns the If-Range value, the strong etag or else the last modified date,
+// weak etags can't be used with If-Range.
+func (d *Downloader) validator() string {
+	if d.ETag != "" && !strings.HasPrefix(d.ETag, "W/") {
+		return d.ETag
+	}
+
+	return d.LastModified
+}
+
+// checkRemoteChanged drops the parts of a previous run when the remote file changed since,
+// so they are not spliced with parts of the new file.
+func (d *Downloader) checkRemoteChanged() error {
+	manifest, err := d.readManifest()
+	if err != nil || manifest == nil {
+		// an unreadable manifest is rewritten, the parts are still checked by size and piece hashes
+		return nil
+	}
+
+	if manifest.ETag == d.ETag && manifest.LastModified == d.LastModified {
+		return nil
+	}
+
+	if os.Getenv("DEBUG") == "true" {
+		fmt.Println("remote file changed since the last run, dropping parts:", manifest.ETag, manifest.LastModified)
+	}
+
+	for _, part := range d.FileParts {
+		if fs.IsExist(part.Path) {
+			if err := os.Remove(part.Path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// resetParts removes the downloaded parts and the parsed state, to start over
+func (d *Downloader) resetParts() error {
+	for _, part := range d.FileParts {
+		if fs.IsExist(part.Path) {
+			if err := os.Remove(part.Path); err != nil {
+				return err
+			}
+		}
+	}
+
+	d.IsSupportRange = false
+	d.HeadHeaders = nil
+	d.Ranges = nil
+	d.FileParts = nil
+	return nil
+}
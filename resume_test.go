@@ -0,0 +1,108 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// versionedServer serves content with its etag, the content can be swapped like a re-published file
+type versionedServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	etag    string
+	content []byte
+}
+
+func newVersionedServer(etag string, content []byte, onGet func(s *versionedServer)) *versionedServer {
+	s := &versionedServer{etag: etag, content: content}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		etag, content := s.etag, s.content
+		s.mu.Unlock()
+
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+
+		if r.Method == http.MethodGet && onGet != nil {
+			onGet(s)
+		}
+	}))
+
+	return s
+}
+
+func (s *versionedServer) publish(etag string, content []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.etag, s.content = etag, content
+}
+
+func TestIfRangeRestartsOnRemoteChange(t *testing.T) {
+	v1 := bytes.Repeat([]byte("1"), 100)
+	v2 := bytes.Repeat([]byte("2"), 100)
+
+	var gets int32
+	server := newVersionedServer(`"v1"`, v1, func(s *versionedServer) {
+		// re-published after the first part is served
+		if atomic.AddInt32(&gets, 1) == 1 {
+			s.publish(`"v2"`, v2)
+		}
+	})
+	defer server.Close()
+
+	filePath := t.TempDir() + "/changed.mp4"
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:    filePath,
+		TmpDir:      t.TempDir(),
+		SegmentSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, v2) {
+		t.Errorf("expected the new file only, got %s", data)
+	}
+}
+
+func TestResumeDropsPartsOfChangedFile(t *testing.T) {
+	v1 := bytes.Repeat([]byte("1"), 100)
+	v2 := bytes.Repeat([]byte("2"), 100)
+
+	server := newVersionedServer(`"v1"`, v1, nil)
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	filePath := t.TempDir() + "/resumed.mp4"
+	config := &Config{
+		FilePath:    filePath,
+		TmpDir:      tmpDir,
+		SegmentSize: 10,
+	}
+	if _, err := Download(server.URL+"/file.mp4", config); err != nil {
+		t.Fatal(err)
+	}
+
+	server.publish(`"v2"`, v2)
+	if _, err := Download(server.URL+"/file.mp4", config); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, v2) {
+		t.Errorf("expected the new file only, got %s", data)
+	}
+}
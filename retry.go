@@ -0,0 +1,282 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Backoff strategies of RetryPolicy.Backoff
+const (
+	// BackoffConstant waits BaseDelay before every retry
+	BackoffConstant = "constant"
+	// BackoffLinear waits BaseDelay times the retry number
+	BackoffLinear = "linear"
+	// BackoffExponential doubles the delay on every retry
+	BackoffExponential = "exponential"
+	// BackoffDecorrelatedJitter picks a random delay between BaseDelay and 3 times the previous one,
+	// so clients failing together do not retry together.
+	BackoffDecorrelatedJitter = "decorrelated-jitter"
+)
+
+// What a failed request does on an http status, see RetryPolicy.StatusActions
+const (
+	// StatusActionRetry retries the same source, without trying the mirrors, up to MaxAttempts attempts
+	StatusActionRetry = "retry"
+	// StatusActionFailover tries the next mirror, then retries if the status is retryable, the default
+	StatusActionFailover = "failover"
+	// StatusActionFatal fails the download at once, without trying the mirrors or retrying
+	StatusActionFatal = "fatal"
+)
+
+// DefaultRetryDelay is the delay before retrying a failed part
+var DefaultRetryDelay = time.Second
+
+// DefaultMaxAttempts is the number of attempts per part of a RetryPolicy without MaxAttempts,
+// DefaultRetryPolicy included, it is read on each retry
+var DefaultMaxAttempts = 5
+
+// RetryPolicy decides how failed parts are retried
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts per part, DefaultMaxAttempts when zero, negative means
+	// unlimited, a part failing them all is tried again on the mirrors it did not use before it fails
+	MaxAttempts int
+	// Delay returns the delay before the given retry (1 for the first retry), it takes precedence over Backoff
+	Delay func(retry int, err error) time.Duration
+	// Backoff is the backoff strategy name, default is constant
+	Backoff string
+	// BaseDelay is the delay the backoff starts from, defaults to DefaultRetryDelay
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between two attempts, zero means no cap
+	MaxDelay time.Duration
+	// MaxElapsed caps the total time spent retrying a part, zero means no cap
+	MaxElapsed time.Duration
+	// Statuses overrides whether an http status is retried, e.g. {404: true, 502: false}
+	Statuses map[int]bool
+	// StatusActions overrides what an http status does, e.g. {503: StatusActionRetry, 404: StatusActionFatal},
+	// it takes precedence over Statuses, an unlisted status fails over to the next mirror.
+	StatusActions map[int]string
+	// MaxRetryAfter caps the pause a 429 or 503 asks for with Retry-After, the requests to the host wait
+	// for it, DefaultMaxRetryAfter when zero, negative ignores Retry-After.
+	MaxRetryAfter time.Duration
+	// OnRetry is called before each retry, with the attempt that failed, part is nil when the whole
+	// download is retried, the uploaded part, without Path, when a part of Config.Object is.
+	OnRetry func(part *FilePart, attempt int, err error)
+}
+
+// DefaultRetryPolicy retries retryable errors every DefaultRetryDelay, up to DefaultMaxAttempts attempts per part
+var DefaultRetryPolicy = &RetryPolicy{}
+
+func (p *RetryPolicy) validate() error {
+	for status, action := range p.StatusActions {
+		switch action {
+		case StatusActionRetry, StatusActionFailover, StatusActionFatal:
+		default:
+			return fmt.Errorf("unsupported status action for %d: %s", status, action)
+		}
+	}
+
+	switch p.Backoff {
+	case "", BackoffConstant, BackoffLinear, BackoffExponential, BackoffDecorrelatedJitter:
+		return nil
+	}
+
+	return fmt.Errorf("unsupported backoff: %s", p.Backoff)
+}
+
+// statusAction returns the action of the status err failed with, empty if it is not listed
+func (p *RetryPolicy) statusAction(err error) string {
+	e := classify(err)
+	if e.Kind != ErrorKindStatus {
+		return ""
+	}
+
+	return p.StatusActions[e.StatusCode]
+}
+
+// maxAttempts returns the maximum number of attempts per part, negative if unlimited
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts == 0 {
+		return DefaultMaxAttempts
+	}
+
+	return p.MaxAttempts
+}
+
+// shouldRetry reports whether the part is retried after the failed attempt
+func (p *RetryPolicy) shouldRetry(attempt int, err error) bool {
+	if max := p.maxAttempts(); max > 0 && attempt >= max {
+		return false
+	}
+
+	switch p.statusAction(err) {
+	case StatusActionRetry:
+		return true
+	case StatusActionFatal:
+		return false
+	}
+
+	e := classify(err)
+	if e.Kind == ErrorKindStatus {
+		if retryable, ok := p.Statuses[e.StatusCode]; ok {
+			return retryable
+		}
+	}
+
+	return e.Retryable
+}
+
+// delay returns the delay before the given retry, prev is the previous delay (zero before the first retry)
+func (p *RetryPolicy) delay(retry int, prev time.Duration, err error) time.Duration {
+	if p.Delay != nil {
+		return p.Delay(retry, err)
+	}
+
+	base := p.BaseDelay
+	if base <= 0 {
+		base = DefaultRetryDelay
+	}
+
+	delay := base
+	switch p.Backoff {
+	case BackoffLinear:
+		delay = base * time.Duration(retry)
+	case BackoffExponential:
+		delay = base
+		for i := 1; i < retry && delay < math.MaxInt64/2 && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+			delay *= 2
+		}
+	case BackoffDecorrelatedJitter:
+		if prev < base {
+			prev = base
+		}
+		delay = base + time.Duration(rand.Int63n(int64(prev*3-base)+1))
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay
+}
+
+func (d *Downloader) retryPolicy() *RetryPolicy {
+	if d.RetryPolicy != nil {
+		return d.RetryPolicy
+	}
+
+	return DefaultRetryPolicy
+}
+
+// retry calls fn according to the retry policy,
+// until it succeeds, is cancelled or fails with a fatal error, such as a 404 or a panic.
+func (d *Downloader) retry(part *FilePart, fn func() error) error {
+	return d.retryContext(d.getContext(), part, fn)
+}
+
+// retryContext is retry, cancelled with ctx instead of the context of the downloader
+func (d *Downloader) retryContext(ctx context.Context, part *FilePart, fn func() error) error {
+	policy := d.retryPolicy()
+	start := time.Now()
+	var delay time.Duration
+	for attempt := 1; ; attempt++ {
+		err := safeRun(fn)
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if policy.shouldRetry(attempt, err) {
+			delay = policy.delay(attempt, delay, err)
+			if policy.MaxElapsed > 0 && time.Since(start)+delay > policy.MaxElapsed {
+				return fmt.Errorf("retry time exceeded: %w", annotate(err, d.URL, part, attempt))
+			}
+
+			if !d.takeRetry() {
+				return &QuotaError{
+					Quota: fmt.Sprintf("max %d retries", d.Quota.MaxRetries),
+					Err:   annotate(err, d.URL, part, attempt),
+				}
+			}
+		} else {
+			return annotate(err, d.URL, part, attempt)
+		}
+
+		atomic.AddInt32(&d.retried, 1)
+		if os.Getenv("DEBUG") == "true" {
+			if part != nil {
+				fmt.Println("retrying part:", part.Index, err)
+			} else {
+				fmt.Println("retrying:", d.URL, err)
+			}
+		}
+
+		if policy.OnRetry != nil {
+			policy.OnRetry(part, attempt, err)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// downloadFilePartWithRetry retries the part according to the retry policy, once it gives up the part is
+// retried on the mirrors it did not use, with a new budget, before it fails.
+func (d *Downloader) downloadFilePartWithRetry(ctx context.Context, part *FilePart) error {
+	excluded := map[string]bool{}
+	for {
+		used := map[string]bool{}
+		err := d.retryContext(ctx, part, func() error {
+			return d.downloadFilePartExcluding(ctx, part, excluded, used)
+		})
+		if err == nil || !d.isRotated(ctx, err) {
+			return err
+		}
+
+		for url := range used {
+			excluded[url] = true
+		}
+		if len(used) == 0 || !d.sources.hasOther(excluded) {
+			return err
+		}
+
+		if os.Getenv("DEBUG") == "true" {
+			fmt.Println("rotating part to the next mirror:", part.Index, err)
+		}
+	}
+}
+
+// isRotated reports whether a part which failed with err is tried on the other mirrors,
+// the cancelled, fatal, over quota and local errors are not.
+func (d *Downloader) isRotated(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrRemoteChanged) {
+		return false
+	}
+
+	var quotaErr *QuotaError
+	if errors.As(err, &quotaErr) {
+		return false
+	}
+
+	if d.retryPolicy().statusAction(err) == StatusActionFatal {
+		return false
+	}
+
+	switch classify(err).Kind {
+	case ErrorKindUnknown, ErrorKindCanceled, ErrorKindPanic:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,215 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Type", "video/mp4")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "100")
+			return
+		}
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	var retries []int
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := DownloadWithContext(ctx, server.URL+"/file.mp4", &Config{
+		FilePath: t.TempDir() + "/retry.mp4",
+		TmpDir:   t.TempDir(),
+		RetryPolicy: &RetryPolicy{
+			MaxAttempts: 3,
+			Delay: func(retry int, err error) time.Duration {
+				return time.Millisecond
+			},
+			// the cdn returns 404 until the file is propagated
+			Statuses: map[int]bool{http.StatusNotFound: true},
+			OnRetry: func(part *FilePart, attempt int, err error) {
+				retries = append(retries, attempt)
+			},
+		},
+	})
+
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a 404 error, got %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 attempts, got %d requests", requests)
+	}
+	if len(retries) != 2 || retries[0] != 1 || retries[1] != 2 {
+		t.Errorf("expected OnRetry after attempts 1 and 2, got %v", retries)
+	}
+}
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	p := &RetryPolicy{Statuses: map[int]bool{http.StatusServiceUnavailable: false}}
+	if p.shouldRetry(1, newStatusError(http.StatusServiceUnavailable)) {
+		t.Errorf("expected 503 override not to be retried")
+	}
+	if !p.shouldRetry(1, newStatusError(http.StatusBadGateway)) {
+		t.Errorf("expected 502 to be retried")
+	}
+	if p.shouldRetry(1, newStatusError(http.StatusNotFound)) {
+		t.Errorf("expected 404 not to be retried")
+	}
+
+	if DefaultRetryPolicy.shouldRetry(DefaultMaxAttempts, newStatusError(http.StatusServiceUnavailable)) {
+		t.Errorf("expected the default policy to give up after %d attempts", DefaultMaxAttempts)
+	}
+
+	// DefaultMaxAttempts is read on each retry, for every retryable error of a policy without MaxAttempts
+	defer func(attempts int) { DefaultMaxAttempts = attempts }(DefaultMaxAttempts)
+	DefaultMaxAttempts = 2
+	if DefaultRetryPolicy.shouldRetry(2, newStatusError(http.StatusBadGateway)) {
+		t.Errorf("expected the default policy to follow DefaultMaxAttempts")
+	}
+	if p.shouldRetry(2, io.ErrUnexpectedEOF) {
+		t.Errorf("expected a connection error to give up after DefaultMaxAttempts")
+	}
+	if !(&RetryPolicy{MaxAttempts: -1}).shouldRetry(100, newStatusError(http.StatusBadGateway)) {
+		t.Errorf("expected a negative MaxAttempts to retry without limit")
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	cases := []struct {
+		policy   *RetryPolicy
+		expected []time.Duration
+	}{
+		{&RetryPolicy{BaseDelay: time.Second}, []time.Duration{time.Second, time.Second, time.Second}},
+		{&RetryPolicy{Backoff: BackoffLinear, BaseDelay: time.Second}, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}},
+		{&RetryPolicy{Backoff: BackoffExponential, BaseDelay: time.Second, MaxDelay: 3 * time.Second}, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}},
+	}
+
+	for _, c := range cases {
+		for i, expected := range c.expected {
+			if delay := c.policy.delay(i+1, 0, nil); delay != expected {
+				t.Errorf("%s retry %d: expected %s, got %s", c.policy.Backoff, i+1, expected, delay)
+			}
+		}
+	}
+
+	p := &RetryPolicy{Backoff: BackoffDecorrelatedJitter, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	var delay time.Duration
+	for i := 1; i <= 20; i++ {
+		delay = p.delay(i, delay, nil)
+		if delay < time.Second || delay > 10*time.Second {
+			t.Fatalf("expected jitter between 1s and 10s, got %s", delay)
+		}
+	}
+
+	if err := (&RetryPolicy{Backoff: "fibonacci"}).validate(); err == nil {
+		t.Errorf("expected unknown backoff to be rejected")
+	}
+}
+
+func TestRetryPolicyMaxElapsed(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:         t.TempDir() + "/elapsed.mp4",
+		IsRangesDisabled: true,
+		RetryPolicy: &RetryPolicy{
+			Backoff:    BackoffExponential,
+			BaseDelay:  20 * time.Millisecond,
+			MaxElapsed: 100 * time.Millisecond,
+		},
+	})
+
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected a 503 error, got %v", err)
+	}
+	// 20ms, 40ms then 80ms exceeds the 100ms budget
+	if requests != 3 {
+		t.Errorf("expected 3 attempts, got %d requests", requests)
+	}
+}
+
+func TestRetryPolicyStatusActions(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	var failures, mirrorRequests int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/missing.mp4" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// the first range request is throttled
+		if r.Method == http.MethodGet && atomic.AddInt32(&failures, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer primary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&mirrorRequests, 1)
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer mirror.Close()
+
+	policy := &RetryPolicy{
+		BaseDelay:     time.Millisecond,
+		StatusActions: map[int]string{http.StatusServiceUnavailable: StatusActionRetry, http.StatusNotFound: StatusActionFatal},
+	}
+	_, err := Download(primary.URL+"/missing.mp4", &Config{
+		FilePath:       t.TempDir() + "/missing.mp4",
+		TmpDir:         t.TempDir(),
+		Mirrors:        []string{mirror.URL + "/file.mp4"},
+		MirrorStrategy: MirrorStrategyFailover,
+		RetryPolicy:    policy,
+	})
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusNotFound || e.Attempt != 1 {
+		t.Fatalf("expected a fatal 404 error, got %v", err)
+	}
+
+	if _, err := Download(primary.URL+"/file.mp4", &Config{
+		FilePath:       t.TempDir() + "/file.mp4",
+		TmpDir:         t.TempDir(),
+		SegmentSize:    32,
+		Mirrors:        []string{mirror.URL + "/file.mp4"},
+		MirrorStrategy: MirrorStrategyFailover,
+		RetryPolicy:    policy,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if mirrorRequests != 0 {
+		t.Errorf("expected the statuses not to fail over, got %d mirror requests", mirrorRequests)
+	}
+
+	// a status retried is bounded by DefaultMaxAttempts without MaxAttempts, as any retryable error
+	if policy.shouldRetry(DefaultMaxAttempts, newStatusError(http.StatusServiceUnavailable)) {
+		t.Errorf("expected a retried status to give up after %d attempts", DefaultMaxAttempts)
+	}
+	if !(&RetryPolicy{MaxAttempts: 10, StatusActions: policy.StatusActions}).shouldRetry(DefaultMaxAttempts, newStatusError(http.StatusServiceUnavailable)) {
+		t.Errorf("expected a retried status to follow MaxAttempts")
+	}
+
+	if err := (&RetryPolicy{StatusActions: map[int]string{http.StatusNotFound: "ignore"}}).validate(); err == nil {
+		t.Errorf("expected an unsupported status action to be rejected")
+	}
+}
@@ -0,0 +1,115 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxRetryAfter caps the pause asked by a Retry-After header, see RetryPolicy.MaxRetryAfter
+var DefaultMaxRetryAfter = 5 * time.Minute
+
+// hostPauses are the hosts asking to slow down, shared by the downloads of the process,
+// so the parts and the other downloads from the host wait as well.
+var hostPauses = &pauses{until: map[string]time.Time{}}
+
+// pauses are the times the requests to each host resume at
+type pauses struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// pause holds the requests to host until until, a longer pause already asked for is kept
+func (p *pauses) pause(host string, until time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if until.After(p.until[host]) {
+		p.until[host] = until
+	}
+}
+
+// wait waits until the requests to host resume
+func (p *pauses) wait(ctx context.Context, host string) error {
+	p.mu.Lock()
+	until, ok := p.until[host]
+	if ok && !time.Now().Before(until) {
+		delete(p.until, host)
+	}
+	p.mu.Unlock()
+
+	wait := time.Until(until)
+	if !ok || wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// parseRetryAfter parses a Retry-After header, in seconds or an http date, false if invalid
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := date.Sub(now); wait > 0 {
+		return wait, true
+	}
+
+	return 0, true
+}
+
+// pauseHost pauses the requests to the host of resp when it answers 429 or 503 with a Retry-After,
+// for at most RetryPolicy.MaxRetryAfter.
+func (d *Downloader) pauseHost(resp *http.Response) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+
+	maxWait := d.retryPolicy().MaxRetryAfter
+	if maxWait < 0 {
+		return
+	}
+	if maxWait == 0 {
+		maxWait = DefaultMaxRetryAfter
+	}
+
+	now := time.Now()
+	wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if !ok || wait == 0 {
+		return
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+
+	if os.Getenv("DEBUG") == "true" {
+		fmt.Println("pausing host:", resp.Request.URL.Host, "for", wait)
+	}
+
+	hostPauses.pause(resp.Request.URL.Host, now.Add(wait))
+}
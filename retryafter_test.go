@@ -0,0 +1,100 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := map[string]time.Duration{
+		"120":                           2 * time.Minute,
+		"0":                             0,
+		"Sun, 02 Jan 2022 03:04:35 GMT": 30 * time.Second,
+		"Sun, 02 Jan 2022 03:00:00 GMT": 0,
+	}
+	for value, expected := range cases {
+		if wait, ok := parseRetryAfter(value, now); !ok || wait != expected {
+			t.Errorf("parseRetryAfter(%s): expect %s, got %s %v", value, expected, wait, ok)
+		}
+	}
+
+	for _, value := range []string{"", "-1", "soon"} {
+		if _, ok := parseRetryAfter(value, now); ok {
+			t.Errorf("parseRetryAfter(%s): expect invalid", value)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	var mu sync.Mutex
+	var requests []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			requests = append(requests, time.Now())
+			first := len(requests) == 1
+			mu.Unlock()
+
+			if first {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	if _, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:    t.TempDir() + "/file.mp4",
+		TmpDir:      t.TempDir(),
+		RetryPolicy: &RetryPolicy{BaseDelay: time.Millisecond},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(requests) != 2 || requests[1].Sub(requests[0]) < 900*time.Millisecond {
+		t.Errorf("expected the retry to wait for Retry-After, got %v", requests)
+	}
+}
+
+func TestRetryAfterIsCapped(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	var mu sync.Mutex
+	failed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		first := r.Method == http.MethodGet && !failed
+		if first {
+			failed = true
+		}
+		mu.Unlock()
+
+		if first {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	startedAt := time.Now()
+	if _, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:    t.TempDir() + "/file.mp4",
+		TmpDir:      t.TempDir(),
+		RetryPolicy: &RetryPolicy{BaseDelay: time.Millisecond, MaxRetryAfter: 50 * time.Millisecond},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(startedAt); elapsed > 5*time.Second {
+		t.Errorf("expected the pause to be capped, took %s", elapsed)
+	}
+}
@@ -0,0 +1,304 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRobotsDisallowed is returned when robots.txt disallows the url, see Config.Robots
+var ErrRobotsDisallowed = errors.New("disallowed by robots.txt")
+
+// DefaultRobotsTTL is how long the robots.txt of a host is kept, RFC 9309 asks for at most 24 hours
+var DefaultRobotsTTL = 24 * time.Hour
+
+// maxRobotsSize is the size of robots.txt parsed, the rest is ignored, as RFC 9309 allows
+const maxRobotsSize = 500 * 1024
+
+// robotsRule is an Allow or Disallow line
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// robotsGroup are the rules of a set of user agents
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// robotsTxt is a parsed robots.txt
+type robotsTxt struct {
+	groups []*robotsGroup
+}
+
+// parseRobots parses a robots.txt, the unknown lines are ignored
+func parseRobots(data []byte) *robotsTxt {
+	robots := &robotsTxt{}
+	var group *robotsGroup
+	isAgentLine := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+
+		switch key {
+		case "user-agent":
+			// consecutive user agents share the group
+			if group == nil || !isAgentLine {
+				group = &robotsGroup{}
+				robots.groups = append(robots.groups, group)
+			}
+			group.agents = append(group.agents, strings.ToLower(value))
+			isAgentLine = true
+			continue
+		case "allow", "disallow":
+			// an empty disallow allows everything
+			if group != nil && value != "" {
+				group.rules = append(group.rules, robotsRule{allow: key == "allow", pattern: value})
+			}
+		case "crawl-delay":
+			if seconds, err := strconv.ParseFloat(value, 64); group != nil && err == nil && seconds > 0 {
+				group.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		}
+		isAgentLine = false
+	}
+
+	return robots
+}
+
+// group returns the rules of agent, the groups naming it merged, else the * ones, nil when none applies
+func (r *robotsTxt) group(agent string) *robotsGroup {
+	agent = strings.ToLower(agent)
+	var matched, any *robotsGroup
+	merge := func(into **robotsGroup, g *robotsGroup) {
+		if *into == nil {
+			*into = &robotsGroup{}
+		}
+		(*into).rules = append((*into).rules, g.rules...)
+		if g.crawlDelay > (*into).crawlDelay {
+			(*into).crawlDelay = g.crawlDelay
+		}
+	}
+
+	for _, g := range r.groups {
+		for _, a := range g.agents {
+			if a == agent {
+				merge(&matched, g)
+				break
+			}
+			if a == "*" {
+				merge(&any, g)
+				break
+			}
+		}
+	}
+
+	if matched != nil {
+		return matched
+	}
+
+	return any
+}
+
+// isAllowed tells whether path (with its query) may be fetched, the longest matching rule wins,
+// allow on a tie.
+func (g *robotsGroup) isAllowed(path string) bool {
+	allowed, length := true, -1
+	for _, rule := range g.rules {
+		if !matchRobotsPattern(rule.pattern, path) {
+			continue
+		}
+
+		if n := len(rule.pattern); n > length || (n == length && rule.allow) {
+			allowed, length = rule.allow, n
+		}
+	}
+
+	return allowed
+}
+
+// matchRobotsPattern matches path against a rule, a prefix where * matches any characters and
+// a trailing $ anchors the end.
+func matchRobotsPattern(pattern, path string) bool {
+	isAnchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	chunks := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, chunks[0]) {
+		return false
+	}
+	path = path[len(chunks[0]):]
+
+	for i, chunk := range chunks[1:] {
+		// the last chunk of an anchored pattern ends the path
+		if isAnchored && i == len(chunks)-2 {
+			return strings.HasSuffix(path, chunk)
+		}
+
+		j := strings.Index(path, chunk)
+		if j < 0 {
+			return false
+		}
+		path = path[j+len(chunk):]
+	}
+
+	return !isAnchored || path == ""
+}
+
+// robotsAgent is the product token matched against the user agents of robots.txt, GoFetch by default
+func robotsAgent() string {
+	agent := userAgent()
+	if i := strings.IndexAny(agent, "/ "); i >= 0 {
+		agent = agent[:i]
+	}
+
+	return agent
+}
+
+// robotsCache are the robots.txt of the hosts, by scheme and host, shared by the downloads of the process
+var robotsCache = &robotsEntries{entries: map[string]*robotsEntry{}}
+
+type robotsEntries struct {
+	mu      sync.Mutex
+	entries map[string]*robotsEntry
+}
+
+// robotsEntry is a robots.txt fetched once, the downloads of the host wait for it
+type robotsEntry struct {
+	ready     chan struct{}
+	robots    *robotsTxt
+	err       error
+	fetchedAt time.Time
+}
+
+// checkRobots fails with ErrRobotsDisallowed when the robots.txt of the host disallows the url,
+// its crawl delay raises PolitenessDelay.
+func (d *Downloader) checkRobots() error {
+	u, err := url.Parse(d.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil
+	}
+
+	robots, err := d.robots(u)
+	if err != nil {
+		if ctxErr := d.getContext().Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		// a robots.txt failing with a server error disallows everything, as RFC 9309,
+		// the network errors fail the download, they say nothing of the rules
+		if classify(err).Kind != ErrorKindStatus {
+			return annotate(err, u.Scheme+"://"+u.Host+"/robots.txt", nil, 0)
+		}
+
+		return fmt.Errorf("%w: %s: robots.txt unavailable: %s", ErrRobotsDisallowed, d.URL, err)
+	}
+
+	group := robots.group(robotsAgent())
+	if group == nil {
+		return nil
+	}
+
+	if group.crawlDelay > d.PolitenessDelay {
+		d.PolitenessDelay = group.crawlDelay
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	if !group.isAllowed(path) {
+		return fmt.Errorf("%w: %s", ErrRobotsDisallowed, d.URL)
+	}
+
+	return nil
+}
+
+// robots returns the robots.txt of the host of u, fetched once every DefaultRobotsTTL
+func (d *Downloader) robots(u *url.URL) (*robotsTxt, error) {
+	key := u.Scheme + "://" + u.Host
+
+	robotsCache.mu.Lock()
+	entry, ok := robotsCache.entries[key]
+	if ok {
+		select {
+		case <-entry.ready:
+			if time.Since(entry.fetchedAt) > DefaultRobotsTTL || entry.err != nil {
+				ok = false
+			}
+		default:
+		}
+	}
+	if !ok {
+		entry = &robotsEntry{ready: make(chan struct{})}
+		robotsCache.entries[key] = entry
+	}
+	robotsCache.mu.Unlock()
+
+	if !ok {
+		entry.robots, entry.err = d.fetchRobots(key + "/robots.txt")
+		entry.fetchedAt = time.Now()
+		close(entry.ready)
+	}
+
+	select {
+	case <-entry.ready:
+		if entry.err != nil && classify(entry.err).Kind == ErrorKindCanceled && d.getContext().Err() == nil {
+			// the download which fetched it was cancelled, not this one
+			return d.robots(u)
+		}
+		return entry.robots, entry.err
+	case <-d.getContext().Done():
+		return nil, d.getContext().Err()
+	}
+}
+
+// fetchRobots fetches a robots.txt, a missing one (4xx) allows everything
+func (d *Downloader) fetchRobots(robotsURL string) (*robotsTxt, error) {
+	var robots *robotsTxt
+	err := d.do(http.MethodGet, robotsURL, nil, func(resp *http.Response, body io.Reader) error {
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			data, err := ioutil.ReadAll(io.LimitReader(body, maxRobotsSize))
+			if err != nil {
+				return err
+			}
+			robots = parseRobots(data)
+		case resp.StatusCode >= 400 && resp.StatusCode < 500:
+			robots = &robotsTxt{}
+		default:
+			return newStatusError(resp.StatusCode)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if os.Getenv("DEBUG") == "true" {
+		fmt.Println("robots: fetched:", robotsURL, len(robots.groups), "groups")
+	}
+
+	return robots, nil
+}
@@ -0,0 +1,147 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRobots(t *testing.T) {
+	robots := parseRobots([]byte(`# the crawlers
+User-agent: BadBot
+User-agent: OtherBot
+Disallow: /
+
+user-agent: gofetch # our own rules
+Disallow: /private/
+Allow: /private/public*.mp4$
+Crawl-delay: 0.5
+
+User-agent: *
+Disallow: /*.iso
+Disallow:
+`))
+
+	if g := robots.group("BadBot"); g == nil || g.isAllowed("/file.mp4") {
+		t.Errorf("expected BadBot to be disallowed everything, got %+v", g)
+	}
+
+	g := robots.group("GoFetch")
+	if g == nil || g.crawlDelay != 500*time.Millisecond {
+		t.Fatalf("expected the GoFetch group with its crawl delay, got %+v", g)
+	}
+	cases := map[string]bool{
+		"/file.mp4":                  true,
+		"/image.iso":                 true,
+		"/private/file.mp4":          false,
+		"/private/public-1.mp4":      true,
+		"/private/public-1.mp4?x=1":  false,
+		"/private/public/secret.txt": false,
+	}
+	for path, expected := range cases {
+		if allowed := g.isAllowed(path); allowed != expected {
+			t.Errorf("isAllowed(%s): expect %v, got %v", path, expected, allowed)
+		}
+	}
+
+	if g := robots.group("Unknown"); g == nil || g.isAllowed("/image.iso") || !g.isAllowed("/dir/image.zip") {
+		t.Errorf("expected the * group to apply, got %+v", g)
+	}
+
+	if g := parseRobots([]byte("Disallow: /\n")).group("GoFetch"); g != nil {
+		t.Errorf("expected the rules outside of a group to be ignored, got %+v", g)
+	}
+}
+
+func TestRobots(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	var robotsRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			atomic.AddInt32(&robotsRequests, 1)
+			w.Write([]byte("User-agent: *\nDisallow: /private/\n"))
+			return
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	result := DownloadBatch(context.Background(), []*BatchEntry{
+		{URL: server.URL + "/public/a.mp4"},
+		{URL: server.URL + "/private/b.mp4"},
+		{URL: server.URL + "/public/c.mp4"},
+	}, &Config{
+		Dir:    t.TempDir(),
+		TmpDir: t.TempDir(),
+		Robots: true,
+	})
+
+	if result.Succeeded != 2 || result.Skipped != 1 || result.Failed != 0 || result.Err() != nil {
+		t.Fatalf("expected 2 downloads and 1 skipped, got %s", result.Summary())
+	}
+	if e := result.Entries[1]; !e.Skipped || !errors.Is(e.Err, ErrRobotsDisallowed) {
+		t.Errorf("expected the private file to be skipped, got %+v", e)
+	}
+	if summary := result.Summary(); !strings.Contains(summary, "SKIP "+server.URL+"/private/b.mp4") || !strings.Contains(summary, "1 skipped") {
+		t.Errorf("expected the summary to report the skipped file, got %s", summary)
+	}
+	if robotsRequests != 1 {
+		t.Errorf("expected robots.txt to be fetched once, got %d requests", robotsRequests)
+	}
+}
+
+func TestRobotsUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:    t.TempDir() + "/file.mp4",
+		TmpDir:      t.TempDir(),
+		Robots:      true,
+		RetryPolicy: &RetryPolicy{MaxAttempts: 1},
+	})
+	if !errors.Is(err, ErrRobotsDisallowed) {
+		t.Errorf("expected a robots.txt failing to disallow the download, got %v", err)
+	}
+
+	// a network error or a cancellation is not a disallow
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	_, err = Download(closed.URL+"/file.mp4", &Config{
+		FilePath:    t.TempDir() + "/file.mp4",
+		TmpDir:      t.TempDir(),
+		Robots:      true,
+		RetryPolicy: &RetryPolicy{MaxAttempts: 1},
+	})
+	var e *Error
+	if errors.Is(err, ErrRobotsDisallowed) || !errors.As(err, &e) || e.Kind != ErrorKindConnection {
+		t.Errorf("expected a connection error, got %v", err)
+	}
+
+	// robots.txt stalls until the download is cancelled
+	stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer stalled.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	result := DownloadBatch(ctx, []*BatchEntry{{URL: stalled.URL + "/file.mp4"}}, &Config{
+		Dir:    t.TempDir(),
+		TmpDir: t.TempDir(),
+		Robots: true,
+	})
+	if e := result.Entries[0]; e.Skipped || !errors.Is(e.Err, context.DeadlineExceeded) {
+		t.Errorf("expected the cancelled download not to be skipped, got %+v", e)
+	}
+}
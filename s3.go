@@ -0,0 +1,298 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config represents the connection to a S3 compatible object storage
+type S3Config struct {
+	// Endpoint is the url of the storage, e.g. https://storage.googleapis.com or a MinIO server,
+	// default is the AWS endpoint of the region
+	Endpoint string
+	// Region signs the requests, default is us-east-1
+	Region string
+	// AccessKeyID, SecretAccessKey and SessionToken are the credentials, SessionToken is optional
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// PathStyle puts the bucket in the path instead of the host, it is always used with Endpoint
+	PathStyle bool
+	// Client sends the requests, default is http.DefaultClient
+	Client *http.Client
+}
+
+// S3ConfigFromEnv returns the S3 config from the AWS environment variables,
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, AWS_REGION and AWS_ENDPOINT_URL.
+func S3ConfigFromEnv() *S3Config {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+
+	return &S3Config{
+		Endpoint:        os.Getenv("AWS_ENDPOINT_URL"),
+		Region:          region,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// s3Store is an ObjectStore on the S3 multipart upload api, signed with AWS signature v4
+type s3Store struct {
+	config *S3Config
+}
+
+// NewS3Store returns an ObjectStore uploading to S3, or to a S3 compatible storage such as
+// Google Cloud Storage (with HMAC keys) or MinIO
+func NewS3Store(config *S3Config) ObjectStore {
+	c := *config
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	if c.Client == nil {
+		c.Client = http.DefaultClient
+	}
+
+	return &s3Store{config: &c}
+}
+
+// objectURL returns the url of the object, with the query
+func (s *s3Store) objectURL(bucket, key string, query url.Values) (*url.URL, error) {
+	var u *url.URL
+	var err error
+	if s.config.Endpoint != "" {
+		u, err = url.Parse(strings.TrimSuffix(s.config.Endpoint, "/") + "/" + bucket + "/")
+	} else if s.config.PathStyle {
+		u, err = url.Parse(fmt.Sprintf("https://s3.%s.amazonaws.com/%s/", s.config.Region, bucket))
+	} else {
+		u, err = url.Parse(fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", bucket, s.config.Region))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path += key
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = query.Encode()
+	return u, nil
+}
+
+// do sends the signed request, returning the body of a 2xx response
+func (s *s3Store) do(ctx context.Context, method, bucket, key string, query url.Values, body []byte) (http.Header, []byte, error) {
+	u, err := s.objectURL(bucket, key, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signS3Request(req, body, s.config, time.Now())
+	resp, err := s.config.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// classified by the status, a throttled (503) or failed (500) part is retried
+		e := newStatusError(resp.StatusCode)
+		e.Err = fmt.Errorf("s3 %s %s: %s", method, u.Path, s3ErrorMessage(resp.StatusCode, data))
+		return nil, nil, e
+	}
+
+	// CompleteMultipartUpload may fail after the 200 status is sent
+	if bytes.Contains(data, []byte("<Error>")) {
+		return nil, nil, fmt.Errorf("s3 %s %s: %s", method, u.Path, s3ErrorMessage(resp.StatusCode, data))
+	}
+
+	return resp.Header, data, nil
+}
+
+// s3ErrorMessage returns the code and message of a S3 error response
+func s3ErrorMessage(status int, data []byte) string {
+	var e struct {
+		Code    string
+		Message string
+	}
+	if err := xml.Unmarshal(data, &e); err != nil || e.Code == "" {
+		return fmt.Sprintf("status %d", status)
+	}
+
+	return fmt.Sprintf("status %d: %s: %s", status, e.Code, e.Message)
+}
+
+func (s *s3Store) CreateMultipartUpload(ctx context.Context, bucket, key string) (string, error) {
+	_, data, err := s.do(ctx, http.MethodPost, bucket, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(data, &result); err != nil {
+		return "", fmt.Errorf("s3: invalid create multipart upload response: %s", err)
+	}
+
+	return result.UploadID, nil
+}
+
+func (s *s3Store) UploadPart(ctx context.Context, bucket, key, uploadID string, number int, data []byte) (string, error) {
+	query := url.Values{
+		"partNumber": {fmt.Sprint(number)},
+		"uploadId":   {uploadID},
+	}
+	headers, _, err := s.do(ctx, http.MethodPut, bucket, key, query, data)
+	if err != nil {
+		return "", err
+	}
+
+	return headers.Get("ETag"), nil
+}
+
+func (s *s3Store) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, etags []string) error {
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for i, etag := range etags {
+		complete.Parts = append(complete.Parts, part{PartNumber: i + 1, ETag: etag})
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = s.do(ctx, http.MethodPost, bucket, key, url.Values{"uploadId": {uploadID}}, body)
+	return err
+}
+
+func (s *s3Store) AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error {
+	_, _, err := s.do(ctx, http.MethodDelete, bucket, key, url.Values{"uploadId": {uploadID}}, nil)
+	return err
+}
+
+// signS3Request signs the request with AWS signature version 4, every header set on the request is signed
+func signS3Request(req *http.Request, body []byte, config *S3Config, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", config.SessionToken)
+	}
+
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		canonicalS3Query(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + config.Region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+config.SecretAccessKey), date)
+	key = hmacSHA256(key, config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		config.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+// canonicalS3Query returns the query sorted by name, the names and values encoded as signature v4 expects
+func canonicalS3Query(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := []string{}
+	for _, name := range names {
+		values := append([]string{}, query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(name)+"="+s3Escape(value))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape encodes everything but the unreserved characters, spaces as %20
+func s3Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// s3EscapePath encodes each segment of the path as s3Escape does, keeping the slashes,
+// signature v4 expects every byte but the unreserved characters encoded, such as + ! * ' ( )
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, data)
+	return h.Sum(nil)
+}
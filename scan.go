@@ -0,0 +1,33 @@
+package download
+
+import "fmt"
+
+// ScanError is returned when Config.Scan rejects the downloaded file, the file is removed
+type ScanError struct {
+	// Path is the path of the scanned file, removed since
+	Path string
+	// Err is the error returned by the scan
+	Err error
+}
+
+func (e *ScanError) Error() string {
+	return fmt.Sprintf("scan rejected %s: %s", e.Path, e.Err)
+}
+
+// Unwrap returns the error of the scan
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
+
+// scanFile runs the scan hook on the file, a panicking hook rejects the file
+func (d *Downloader) scanFile(path string) error {
+	if d.Scan == nil {
+		return nil
+	}
+
+	if err := safeRun(func() error { return d.Scan(path) }); err != nil {
+		return &ScanError{Path: path, Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package download
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	infected := errors.New("Eicar-Test-Signature FOUND")
+	scanned := []string{}
+	scan := func(path string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(data, content) {
+			return errors.New("unexpected content")
+		}
+
+		scanned = append(scanned, path)
+		return infected
+	}
+
+	filePath := filepath.Join(t.TempDir(), "file.mp4")
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath: filePath,
+		TmpDir:   t.TempDir(),
+		Scan:     scan,
+	})
+	var scanErr *ScanError
+	if !errors.As(err, &scanErr) || !errors.Is(err, infected) {
+		t.Fatalf("expected a scan error, got %v", err)
+	}
+	if len(scanned) != 1 || scanned[0] == filePath {
+		t.Errorf("expected the file to be scanned before the rename, got %v", scanned)
+	}
+
+	if _, err := os.Stat(scanErr.Path); !os.IsNotExist(err) {
+		t.Error("expected the scanned file to be removed")
+	}
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Error("expected the file not to be promoted")
+	}
+
+	_, err = Download(server.URL+"/file.mp4", &Config{
+		FilePath: filePath,
+		TmpDir:   t.TempDir(),
+		Scan:     func(path string) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		t.Error(err)
+	}
+}
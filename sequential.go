@@ -0,0 +1,35 @@
+package download
+
+// lookahead returns the number of segments downloaded ahead of the read position, see Config.Lookahead
+func (d *Downloader) lookahead() int {
+	if d.Lookahead > 0 {
+		return d.Lookahead
+	}
+
+	return DefaultConcurrency
+}
+
+// downloadSequential writes the segments in order into the staging file while the next ones are downloaded
+// ahead, so the file only grows at its end and a player reading it never hits a hole.
+func (d *Downloader) downloadSequential() error {
+	body, err := d.Open(d.getContext())
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if err := d.parseFileInfo(); err != nil {
+		return err
+	}
+
+	if err := d.prepareDirect(); err != nil {
+		return err
+	}
+
+	d.setProgressTotal(d.targetLength())
+	if err := d.writeFile(d.stagingPath(), body); err != nil {
+		return classify(err)
+	}
+
+	return d.finalize()
+}
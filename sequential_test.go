@@ -0,0 +1,64 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSequential(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	gate := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the third segment is slow, the next ones are done ahead of it
+		if r.Header.Get("Range") == "bytes=200-299" {
+			<-gate
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	filePath := t.TempDir() + "/movie.mp4"
+	errs := make(chan error, 1)
+	go func() {
+		_, err := Download(server.URL+"/movie.mp4", &Config{
+			FilePath:    filePath,
+			TmpDir:      t.TempDir(),
+			SegmentSize: 100,
+			Sequential:  true,
+			Lookahead:   3,
+		})
+		errs <- err
+	}()
+
+	// the growing file holds the first two segments, without a hole
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(filePath + stagingSuffix)
+		if len(data) >= 200 {
+			if len(data) != 200 || !bytes.Equal(data, content[:200]) {
+				t.Errorf("expected the first 200 bytes, got %d bytes", len(data))
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the file to grow, got %d bytes", len(data))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(gate)
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("unexpected content: %d bytes", len(data))
+	}
+}
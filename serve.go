@@ -0,0 +1,419 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ServeHolesBlock holds a response until the bytes it covers are downloaded
+	ServeHolesBlock = "block"
+	// ServeHolesUnavailable answers 503 to a request of bytes not downloaded yet,
+	// a range is cut to the bytes downloaded from its start
+	ServeHolesUnavailable = "unavailable"
+)
+
+// DefaultServeDrainTimeout is how long the responses in flight of Config.Serve get to finish once the download ended
+var DefaultServeDrainTimeout = 5 * time.Second
+
+// servePollInterval is how often a response waiting for a hole checks the progress again
+const servePollInterval = 50 * time.Millisecond
+
+// errRangeNotSatisfiable means the range of a request is beyond the served file
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+func (d *Downloader) validateServeHoles() error {
+	switch d.ServeHoles {
+	case "", ServeHolesBlock, ServeHolesUnavailable:
+		return nil
+	default:
+		return fmt.Errorf("unsupported serve holes: %s", d.ServeHoles)
+	}
+}
+
+// servedRegion is where the bytes at an offset of the file are on disk
+type servedRegion struct {
+	path string
+	// at is the offset in path
+	at int64
+	// size is the bytes available from at, -1 up to the end of path
+	size int64
+}
+
+// servedState is what the served file is known of
+type servedState struct {
+	total   int64
+	name    string
+	started bool
+	done    bool
+	err     error
+}
+
+func (p *progressCounter) servedState() servedState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return servedState{
+		total:   p.total,
+		name:    p.name,
+		started: p.staging != "" || p.served != "",
+		done:    p.done,
+		err:     p.doneErr,
+	}
+}
+
+// locate returns where the bytes at off are, an empty path while they are not downloaded:
+// the downloaded file once done, a part of a ranges download, or the staging file,
+// written in order by a single request download and holding the resumed bytes of a ranges one.
+func (p *progressCounter) locate(off int64) (servedRegion, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.done {
+		return servedRegion{path: p.served, at: off, size: -1}, true
+	}
+
+	for i, start := range p.offsets {
+		if off >= start && off < start+p.parts[i] {
+			return servedRegion{path: p.partPaths[i], at: off - start, size: p.parts[i] - (off - start)}, false
+		}
+	}
+
+	if p.offsets != nil {
+		if off < p.base {
+			return servedRegion{path: p.staging, at: off, size: p.base - off}, false
+		}
+		return servedRegion{}, false
+	}
+
+	return servedRegion{path: p.staging, at: off, size: -1}, false
+}
+
+// finishServed ends the served download, the file is served from path once done without error.
+// A file reused without being downloaded, from the dedup index, the cache or another process,
+// never started: it is known from path then.
+func (p *progressCounter) finishServed(path string, err error) {
+	total := int64(-1)
+	if path != "" {
+		if info, statErr := os.Stat(path); statErr == nil {
+			total = info.Size()
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if path != "" && p.staging == "" {
+		p.name, p.total = path, total
+	}
+	p.served, p.done, p.doneErr = path, true, err
+}
+
+// readServed reads the bytes of the file at off into buf, none while they are not downloaded,
+// io.EOF past the end of the downloaded file, the error of a failed download.
+func (d *Downloader) readServed(buf []byte, off int64) (int, error) {
+	region, done := d.progress.locate(off)
+	if done {
+		if err := d.progress.servedState().err; err != nil {
+			return 0, err
+		}
+	}
+
+	if region.path != "" {
+		if region.size >= 0 && int64(len(buf)) > region.size {
+			buf = buf[:region.size]
+		}
+
+		// the counted bytes may not be written yet, and finalize moves the staging file
+		if f, err := os.Open(region.path); err == nil {
+			n, _ := f.ReadAt(buf, region.at)
+			f.Close()
+			if n > 0 {
+				return n, nil
+			}
+		}
+	}
+
+	if done {
+		return 0, io.EOF
+	}
+
+	return 0, nil
+}
+
+// availableServed returns the bytes downloaded from start, up to end
+func (d *Downloader) availableServed(start, end int64) int64 {
+	off := start
+	for off <= end {
+		region, _ := d.progress.locate(off)
+		if region.path == "" {
+			break
+		}
+
+		size := region.size
+		if size < 0 {
+			info, err := os.Stat(region.path)
+			if err != nil || info.Size() <= region.at {
+				break
+			}
+			size = info.Size() - region.at
+		}
+		off += size
+	}
+
+	if off > end+1 {
+		off = end + 1
+	}
+
+	return off - start
+}
+
+// parseServedRange returns the inclusive bounds of the Range header in a file of total bytes, the whole file
+// without one, partial false; several ranges or an invalid header are answered with the whole file too.
+func parseServedRange(header string, total int64) (start, end int64, partial bool, err error) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if header == "" || spec == header || strings.Contains(spec, ",") {
+		return 0, total - 1, false, nil
+	}
+
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, total - 1, false, nil
+	}
+
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if first == "" {
+		// the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, total - 1, false, nil
+		}
+		if n == 0 || total == 0 {
+			return 0, 0, false, errRangeNotSatisfiable
+		}
+		if n > total {
+			n = total
+		}
+		return total - n, total - 1, true, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, total - 1, false, nil
+	}
+
+	end = total - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, total - 1, false, nil
+		}
+		if end >= total {
+			end = total - 1
+		}
+	}
+
+	if start >= total {
+		return 0, 0, false, errRangeNotSatisfiable
+	}
+
+	return start, end, true, nil
+}
+
+// Handler returns a http.Handler serving the file while it downloads, whatever the path of the request,
+// with ranges, e.g. for a video player. A response waits for the bytes not downloaded yet, or is answered 503
+// with ServeHolesUnavailable, until the download ends; a failed download ends the responses.
+// It is served by Config.Serve, or can be mounted on a server of the application.
+func (d *Downloader) Handler() http.Handler {
+	return http.HandlerFunc(d.serveHTTP)
+}
+
+func (d *Downloader) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// the file is known once the download starts writing it
+	state := d.progress.servedState()
+	for !state.started || (state.done && state.err != nil) {
+		if state.err != nil {
+			http.Error(w, state.err.Error(), http.StatusBadGateway)
+			return
+		}
+		if state.done {
+			// written to a sink
+			http.NotFound(w, r)
+			return
+		}
+		if !d.waitServed(w, r) {
+			return
+		}
+		state = d.progress.servedState()
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(state.name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	if state.total < 0 {
+		// a file of unknown size is streamed to its end
+		if d.ServeHoles == ServeHolesUnavailable && !state.done {
+			d.waitServed(w, r)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			d.copyServed(w, r, 0, -1)
+		}
+		return
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	start, end, partial, err := parseServedRange(r.Header.Get("Range"), state.total)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", state.total))
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	if d.ServeHoles == ServeHolesUnavailable {
+		if available := d.availableServed(start, end); available < end-start+1 {
+			if !partial || available == 0 {
+				d.waitServed(w, r)
+				return
+			}
+			end = start + available - 1
+		}
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	if partial {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, state.total))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if r.Method != http.MethodHead {
+		d.copyServed(w, r, start, end)
+	}
+}
+
+// copyServed writes the bytes from start to end of the file, to its end when end is -1,
+// waiting for the ones not downloaded yet
+func (d *Downloader) copyServed(w http.ResponseWriter, r *http.Request, start, end int64) {
+	buf := make([]byte, 32*1024)
+	for off := start; end < 0 || off <= end; {
+		n := int64(len(buf))
+		if end >= 0 && n > end-off+1 {
+			n = end - off + 1
+		}
+
+		read, err := d.readServed(buf[:n], off)
+		if read > 0 {
+			if _, err := w.Write(buf[:read]); err != nil {
+				return
+			}
+			off += int64(read)
+			continue
+		}
+		if err != nil {
+			if os.Getenv("DEBUG") == "true" && err != io.EOF {
+				fmt.Println("serve: download failed:", err)
+			}
+			return
+		}
+
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		if !sleepServed(r.Context()) {
+			return
+		}
+	}
+}
+
+// waitServed waits for the download to progress, false when the request is gone,
+// with ServeHolesUnavailable it answers 503 instead.
+func (d *Downloader) waitServed(w http.ResponseWriter, r *http.Request) bool {
+	if d.ServeHoles == ServeHolesUnavailable {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "not downloaded yet", http.StatusServiceUnavailable)
+		return false
+	}
+
+	return sleepServed(r.Context())
+}
+
+func sleepServed(ctx context.Context) bool {
+	timer := time.NewTimer(servePollInterval)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// serve serves the file at Config.Serve until the returned func is called with the result of the download,
+// the responses in flight then get DefaultServeDrainTimeout to finish.
+func (d *Downloader) serve() (func(), error) {
+	ln, err := net.Listen("tcp", d.Serve)
+	if err != nil {
+		return nil, fmt.Errorf("serve: %w", err)
+	}
+
+	host, port := "127.0.0.1", d.Serve
+	if addr, ok := ln.Addr().(*net.TCPAddr); ok {
+		if !addr.IP.IsUnspecified() {
+			host = addr.IP.String()
+		}
+		port = strconv.Itoa(addr.Port)
+	}
+
+	d.serveMu.Lock()
+	d.serveURL = "http://" + net.JoinHostPort(host, port) + "/"
+	d.serveMu.Unlock()
+	if os.Getenv("DEBUG") == "true" {
+		fmt.Println("serve: serving at:", d.ServeURL())
+	}
+
+	server := &http.Server{Handler: d.Handler()}
+	go server.Serve(ln)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultServeDrainTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			server.Close()
+		}
+
+		d.serveMu.Lock()
+		d.serveURL = ""
+		d.serveMu.Unlock()
+	}, nil
+}
+
+// ServeURL returns the url the file is served at by Config.Serve during the download, empty otherwise
+func (d *Downloader) ServeURL() string {
+	d.serveMu.Lock()
+	defer d.serveMu.Unlock()
+
+	return d.serveURL
+}
@@ -0,0 +1,225 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newGatedServer returns a server of content holding the range bytes=200-299 until gate is closed
+func newGatedServer(content []byte, gate chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=200-299" {
+			<-gate
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func getRange(url, byteRange string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	return resp, data, err
+}
+
+func TestServe(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	gate := make(chan struct{})
+	server := newGatedServer(content, gate)
+	defer server.Close()
+
+	d := New(server.URL+"/movie.mp4", &Config{
+		FilePath:    t.TempDir() + "/movie.mp4",
+		TmpDir:      t.TempDir(),
+		SegmentSize: 100,
+		Serve:       "127.0.0.1:0",
+	})
+	errs := make(chan error, 1)
+	go func() {
+		errs <- d.Download()
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for d.ServeURL() == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the file to be served")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	url := d.ServeURL()
+
+	// the downloaded bytes are served right away
+	resp, data, err := getRange(url, "bytes=100-199")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusPartialContent || resp.Header.Get("Content-Range") != "bytes 100-199/1000" {
+		t.Errorf("expected 206 bytes 100-199/1000, got %d %s", resp.StatusCode, resp.Header.Get("Content-Range"))
+	}
+	if !bytes.Equal(data, content[100:200]) {
+		t.Errorf("unexpected content: %q", data)
+	}
+
+	// a range over the hole waits for it
+	type result struct {
+		data []byte
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		_, data, err := getRange(url, "bytes=150-349")
+		results <- result{data, err}
+	}()
+
+	select {
+	case r := <-results:
+		t.Fatalf("expected the range to wait for the hole, got %d bytes, %v", len(r.data), r.err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(gate)
+
+	r := <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if !bytes.Equal(r.data, content[150:350]) {
+		t.Errorf("unexpected content: %d bytes", len(r.data))
+	}
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if d.ServeURL() != "" {
+		t.Errorf("expected the server to stop with the download, got %s", d.ServeURL())
+	}
+}
+
+func TestServeHolesUnavailable(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	gate := make(chan struct{})
+	server := newGatedServer(content, gate)
+	defer server.Close()
+
+	d := New(server.URL+"/movie.mp4", &Config{
+		FilePath:    t.TempDir() + "/movie.mp4",
+		TmpDir:      t.TempDir(),
+		SegmentSize: 100,
+		ServeHoles:  ServeHolesUnavailable,
+	})
+	served := httptest.NewServer(d.Handler())
+	defer served.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- d.Download()
+	}()
+
+	// a range over the hole is cut to the bytes downloaded from its start
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, data, err := getRange(served.URL, "bytes=150-349")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode == http.StatusPartialContent && len(data) == 50 {
+			if resp.Header.Get("Content-Range") != "bytes 150-199/1000" || !bytes.Equal(data, content[150:200]) {
+				t.Errorf("unexpected range %s: %q", resp.Header.Get("Content-Range"), data)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the bytes before the hole, got %d, %d bytes", resp.StatusCode, len(data))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for _, byteRange := range []string{"bytes=200-299", ""} {
+		resp, _, err := getRange(served.URL, byteRange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+			t.Errorf("%s: expected 503 with Retry-After, got %d", byteRange, resp.StatusCode)
+		}
+	}
+	close(gate)
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	// the downloaded file is served whole
+	resp, data, err := getRange(served.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(data, content) {
+		t.Errorf("expected the whole file, got %d, %d bytes", resp.StatusCode, len(data))
+	}
+
+	resp, _, err = getRange(served.URL, fmt.Sprintf("bytes=%d-", len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("expected 416, got %d", resp.StatusCode)
+	}
+}
+
+func TestServeReused(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "movie.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	index := filepath.Join(t.TempDir(), "index.json")
+	filePath := filepath.Join(t.TempDir(), "movie.mp4")
+	for _, isDeduplicated := range []bool{false, true} {
+		d := New(server.URL+"/movie.mp4", &Config{
+			FilePath:    filePath,
+			TmpDir:      t.TempDir(),
+			SegmentSize: 100,
+			DedupIndex:  index,
+		})
+		if err := d.Download(); err != nil {
+			t.Fatal(err)
+		}
+		if d.IsDeduplicated != isDeduplicated {
+			t.Fatalf("expected deduplicated %v", isDeduplicated)
+		}
+
+		// the file reused from the dedup index is served as a downloaded one
+		served := httptest.NewServer(d.Handler())
+		resp, data, err := getRange(served.URL, "bytes=100-199")
+		served.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(data, content[100:200]) {
+			t.Errorf("deduplicated %v: expected the range, got %d, %d bytes", isDeduplicated, resp.StatusCode, len(data))
+		}
+		if contentType := resp.Header.Get("Content-Type"); contentType != "video/mp4" {
+			t.Errorf("deduplicated %v: unexpected content type %s", isDeduplicated, contentType)
+		}
+	}
+}
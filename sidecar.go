@@ -0,0 +1,91 @@
+package download
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// sidecarSuffix is appended to the file name of the sidecar metadata, see Config.Sidecar
+const sidecarSuffix = ".meta.json"
+
+// sidecarHeaders are the response headers of interest kept in the sidecar metadata
+var sidecarHeaders = []string{"Content-Type", "Content-Disposition", "Content-Encoding", "ETag", "Last-Modified"}
+
+// Sidecar represents the metadata written next to the downloaded file by Config.Sidecar, as <file>.meta.json
+type Sidecar struct {
+	// URL is the url of the file, without its credentials and query, which may sign it
+	URL string `json:"url"`
+	// PageURL is the page the url was extracted from, without its credentials and query
+	PageURL string `json:"page_url,omitempty"`
+	// Mirrors are the sources which served bytes of the file, the url included, without their credentials and query
+	Mirrors []string `json:"mirrors,omitempty"`
+	// Headers are the response headers of interest, Content-Type, ETag, Last-Modified...
+	Headers map[string]string `json:"headers,omitempty"`
+	Size    int64             `json:"size"`
+	// Checksums are the digests of the file by algorithm, hex encoded, the sha256 when none was verified
+	Checksums  map[string]string `json:"checksums"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	// Speed is the average transfer rate in bytes per second
+	Speed   float64 `json:"speed"`
+	Retries int     `json:"retries"`
+}
+
+// writeSidecar writes the metadata of the downloaded file next to it
+func (d *Downloader) writeSidecar(result *Result, startedAt time.Time) error {
+	if result.FinalPath == "" {
+		return nil
+	}
+
+	sidecar := &Sidecar{
+		URL:        provenanceURL(d.URL),
+		Headers:    map[string]string{},
+		Size:       result.Size,
+		Checksums:  map[string]string{},
+		StartedAt:  startedAt,
+		FinishedAt: startedAt.Add(result.Duration),
+		Speed:      result.Speed,
+		Retries:    result.Retries,
+	}
+	if d.PageURL != "" {
+		sidecar.PageURL = provenanceURL(d.PageURL)
+	}
+
+	for algo, sum := range result.Checksums {
+		sidecar.Checksums[algo] = sum
+	}
+	if len(sidecar.Checksums) == 0 {
+		sum, err := sha256File(result.FinalPath)
+		if err != nil {
+			return err
+		}
+		sidecar.Checksums[ChecksumSHA256] = sum
+	}
+
+	headers := d.responseHeaders
+	if headers == nil {
+		headers = d.HeadHeaders
+	}
+	for _, name := range sidecarHeaders {
+		if value := headers.Get(name); value != "" {
+			sidecar.Headers[name] = value
+		}
+	}
+
+	for _, health := range d.MirrorsHealth() {
+		if health.Speed > 0 {
+			sidecar.Mirrors = append(sidecar.Mirrors, provenanceURL(health.URL))
+		}
+	}
+	if len(sidecar.Mirrors) == 0 {
+		sidecar.Mirrors = []string{sidecar.URL}
+	}
+
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(result.FinalPath+sidecarSuffix, bytes.NewReader(data))
+}
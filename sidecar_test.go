@@ -0,0 +1,54 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestSidecar(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	// a pre-signed url, its signature is not written
+	result, err := Download(server.URL+"/file.mp4?X-Amz-Credential=key&X-Amz-Signature=secret", &Config{
+		FilePath:    t.TempDir() + "/file.mp4",
+		TmpDir:      t.TempDir(),
+		SegmentSize: 100,
+		Sidecar:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(result.FinalPath + ".meta.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sidecar Sidecar
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(content)
+	if sidecar.URL != server.URL+"/file.mp4" || sidecar.Size != 1000 || sidecar.Checksums["sha256"] != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected sidecar: %s", data)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Errorf("expected the query of the url dropped, got %s", data)
+	}
+	if sidecar.Headers["Content-Type"] != "video/mp4" {
+		t.Errorf("expected the content type, got %v", sidecar.Headers)
+	}
+	if len(sidecar.Mirrors) != 1 || sidecar.Mirrors[0] != sidecar.URL {
+		t.Errorf("expected the url as the mirror, got %v", sidecar.Mirrors)
+	}
+	if sidecar.StartedAt.IsZero() || sidecar.FinishedAt.Before(sidecar.StartedAt) {
+		t.Errorf("unexpected timing: %s - %s", sidecar.StartedAt, sidecar.FinishedAt)
+	}
+}
@@ -0,0 +1,188 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSink(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 550)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	for _, disabled := range []bool{false, true} {
+		sink := NewMemorySink()
+		d := New(server.URL+"/file.mp4", &Config{
+			SegmentSize:      700,
+			IsRangesDisabled: disabled,
+			Sink:             sink,
+		})
+		if err := d.Download(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, ok := sink.Get("file.mp4")
+		if !ok || !bytes.Equal(data, content) {
+			t.Errorf("unexpected file in the sink: %d bytes", len(data))
+		}
+		if d.FilePath != "memory:file.mp4" {
+			t.Errorf("unexpected file path: %s", d.FilePath)
+		}
+	}
+
+	// a failed verification aborts the file
+	sink := NewMemorySink()
+	err := New(server.URL+"/file.mp4", &Config{
+		Sink:             sink,
+		ExpectedChecksum: "sha256:" + hex.EncodeToString(make([]byte, 32)),
+	}).Download()
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if _, ok := sink.Get("file.mp4"); ok {
+		t.Error("expected the file not committed")
+	}
+}
+
+func TestDirSink(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	dir := t.TempDir()
+	sum := sha256.Sum256(content)
+	d := New(server.URL+"/file.mp4", &Config{
+		Sink:             NewDirSink(dir),
+		ExpectedChecksum: "sha256:" + hex.EncodeToString(sum[:]),
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "file.mp4")
+	if d.FilePath != path {
+		t.Errorf("unexpected file path: %s", d.FilePath)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("unexpected file: %v", err)
+	}
+	if _, err := os.Stat(path + stagingSuffix); !os.IsNotExist(err) {
+		t.Error("expected the staging file renamed")
+	}
+
+	// the file is staged with the suffix of the download
+	d = New(server.URL+"/file.mp4", &Config{
+		Sink:          NewDirSink(dir),
+		StagingSuffix: ".part",
+	})
+	w, err := d.sink().Create(context.Background(), "other.mp4", int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Abort()
+	if _, err := os.Stat(filepath.Join(dir, "other.mp4.part")); err != nil {
+		t.Errorf("expected the file staged with the .part suffix: %v", err)
+	}
+}
+
+func TestWebDAVSink(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	var mu sync.Mutex
+	files := map[string][]byte{}
+	dav := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			files[r.URL.Path], _ = ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			delete(files, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		case "MOVE":
+			destination, err := url.Parse(r.Header.Get("Destination"))
+			if err != nil || r.Header.Get("Overwrite") != "T" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			files[destination.Path] = files[r.URL.Path]
+			delete(files, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer dav.Close()
+
+	location := "http://user:secret@" + dav.Listener.Addr().String() + "/dir"
+	d := New(server.URL+"/file.mp4", &Config{
+		Sink: NewWebDAVSink(location, nil),
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(files["/dir/file.mp4"], content) || len(files) != 1 {
+		t.Errorf("unexpected uploaded files: %d", len(files))
+	}
+	if d.FilePath != dav.URL+"/dir/file.mp4" {
+		t.Errorf("unexpected file path: %s", d.FilePath)
+	}
+
+	// a rejected upload fails the download
+	err := New(server.URL+"/file.mp4", &Config{
+		Sink: NewWebDAVSink(dav.URL+"/dir", nil),
+	}).Download()
+	if err == nil {
+		t.Error("expected the unauthorized upload to fail")
+	}
+
+	// a failed download leaves the file of the collection untouched
+	files["/dir/file.mp4"] = []byte("previous")
+	err = New(server.URL+"/file.mp4", &Config{
+		Sink:             NewWebDAVSink(location, nil),
+		ExpectedChecksum: "sha256:" + hex.EncodeToString(make([]byte, 32)),
+	}).Download()
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if string(files["/dir/file.mp4"]) != "previous" || len(files) != 1 {
+		t.Errorf("expected the previous file kept, got %d files", len(files))
+	}
+}
+
+func TestObjectSinkPrefix(t *testing.T) {
+	content := []byte("0123456789")
+	server := newRangeServer(content)
+	defer server.Close()
+
+	store := &memoryStore{}
+	d := New(server.URL+"/file.mp4", &Config{
+		Object:      "s3://bucket/dir/",
+		ObjectStore: store,
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.FilePath != "s3://bucket/dir/file.mp4" || !bytes.Equal(store.object, content) {
+		t.Errorf("unexpected object %s: %q", d.FilePath, store.object)
+	}
+}
@@ -0,0 +1,120 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+)
+
+// sniffSize is the number of leading bytes sniffed, as http.DetectContentType
+const sniffSize = 512
+
+// signatures are the magic bytes of common formats, checked before http.DetectContentType
+var signatures = []struct {
+	offset int
+	magic  string
+	ext    string
+}{
+	{4, "ftypqt", "mov"},
+	{4, "ftypM4A", "m4a"},
+	{4, "ftyp", "mp4"},
+	{0, "\x1a\x45\xdf\xa3", "mkv"},
+	{0, "FLV\x01", "flv"},
+	{0, "\x47", "ts"},
+	{0, "OggS", "ogg"},
+	{0, "ID3", "mp3"},
+	{0, "fLaC", "flac"},
+	{0, "\x30\x26\xb2\x75\x8e\x66\xcf\x11", "wmv"},
+	{0, "%PDF-", "pdf"},
+	{0, "PK\x03\x04", "zip"},
+	{0, "\x1f\x8b", "gz"},
+	{0, "BZh", "bz2"},
+	{0, "\xfd7zXZ\x00", "xz"},
+	{0, "\x28\xb5\x2f\xfd", "zst"},
+	{0, "7z\xbc\xaf\x27\x1c", "7z"},
+	{0, "Rar!\x1a\x07", "rar"},
+	{257, "ustar", "tar"},
+	{0, "\x7fELF", "elf"},
+	{0, "MZ", "exe"},
+	{0, "\x89PNG\r\n\x1a\n", "png"},
+	{0, "\xff\xd8\xff", "jpg"},
+	{0, "GIF8", "gif"},
+}
+
+// riffTypes are the formats of the RIFF container, by the type at offset 8
+var riffTypes = map[string]string{
+	"AVI ": "avi",
+	"WAVE": "wav",
+	"WEBP": "webp",
+}
+
+// isGenericContentType returns true if the content type tells nothing about the file
+func isGenericContentType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "", "application/octet-stream", "binary/octet-stream", "application/binary", "application/unknown":
+		return true
+	}
+
+	return false
+}
+
+// sniffExt returns the extension of the file starting with data, empty if unknown
+func sniffExt(data []byte) string {
+	if len(data) >= 12 && string(data[:4]) == "RIFF" {
+		return riffTypes[string(data[8:12])]
+	}
+
+	for _, s := range signatures {
+		if !bytes.HasPrefix(data[min(s.offset, len(data)):], []byte(s.magic)) {
+			continue
+		}
+
+		// a single sync byte is a weak signature, mpeg-ts repeats it every 188 bytes
+		if s.ext == "ts" && (len(data) <= 188 || data[188] != 0x47) {
+			continue
+		}
+
+		return s.ext
+	}
+
+	return contentTypeExt(http.DetectContentType(data))
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// sniffFileExt fetches the first bytes of the file to guess its extension
+func (d *Downloader) sniffFileExt() (string, error) {
+	end := sniffSize - 1
+	if d.ContentLength > 0 && int64(end) >= d.ContentLength {
+		end = int(d.ContentLength - 1)
+	}
+
+	response, err := d.fetchGet(d.URL, &requestConfig{
+		Headers: map[string]string{
+			"Range": fmt.Sprintf("bytes=0-%d", end),
+		},
+	})
+	if err != nil {
+		return "", classify(err)
+	}
+
+	if response.Status != http.StatusPartialContent {
+		return "", newStatusError(response.Status)
+	}
+
+	ext := sniffExt(response.Body)
+	if os.Getenv("DEBUG") == "true" {
+		fmt.Println("sniffed file extension:", ext)
+	}
+
+	return ext, nil
+}
@@ -0,0 +1,127 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSniffExt(t *testing.T) {
+	ts := make([]byte, 376)
+	ts[0], ts[188] = 0x47, 0x47
+
+	cases := []struct {
+		data []byte
+		ext  string
+	}{
+		{[]byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00"), "mp4"},
+		{[]byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81"), "mkv"},
+		{[]byte("RIFF\x24\x00\x00\x00WAVEfmt "), "wav"},
+		{[]byte("RIFF\x24\x00\x00\x00AVI LIST"), "avi"},
+		{[]byte("PK\x03\x04\x14\x00"), "zip"},
+		{[]byte("%PDF-1.7\n"), "pdf"},
+		{[]byte("\x89PNG\r\n\x1a\n\x00\x00"), "png"},
+		{ts, "ts"},
+		{[]byte("\x47 not a transport stream"), "txt"},
+		{[]byte("<!DOCTYPE html><html></html>"), "html"},
+		{[]byte{0x00, 0x01, 0x02, 0x03}, ""},
+	}
+
+	for _, c := range cases {
+		if ext := sniffExt(c.data); ext != c.ext {
+			t.Errorf("sniffExt(%q): expect %q, got %q", c.data, c.ext, ext)
+		}
+	}
+}
+
+func TestSniffFileExt(t *testing.T) {
+	content := append([]byte("PK\x03\x04"), bytes.Repeat([]byte("0123456789"), 100)...)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	d := New(server.URL+"/archive", &Config{
+		Dir:    dir,
+		TmpDir: t.TempDir(),
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := filepath.Join(dir, "archive.zip"); d.FilePath != expected {
+		t.Errorf("expect %s, got %s", expected, d.FilePath)
+	}
+}
+
+func TestContentTypeExt(t *testing.T) {
+	cases := map[string]string{
+		"application/pdf":                         "pdf",
+		"application/zip":                         "zip",
+		"application/x-gtar":                      "tar.gz",
+		"image/png":                               "png",
+		"image/jpeg":                              "jpg",
+		"application/json; charset=utf-8":         "json",
+		"application/x-iso9660-image":             "iso",
+		"application/vnd.android.package-archive": "apk",
+		"Video/MP4":                               "mp4",
+		"application/x-unknown":                   "",
+	}
+
+	for contentType, expected := range cases {
+		if ext := contentTypeExt(contentType); ext != expected {
+			t.Errorf("contentTypeExt(%s): expect %q, got %q", contentType, expected, ext)
+		}
+	}
+}
+
+func TestUnknownContentTypeWithSuppliedName(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-unknown")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	if _, err := Download(server.URL+"/blob", &Config{TmpDir: t.TempDir(), Dir: t.TempDir()}); err == nil {
+		t.Fatal("expect an unsupported content type error")
+	}
+
+	filePath := filepath.Join(t.TempDir(), "blob")
+	d := New(server.URL+"/blob", &Config{FilePath: filePath, TmpDir: t.TempDir()})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.FilePath != filePath {
+		t.Errorf("expect %s, got %s", filePath, d.FilePath)
+	}
+}
+
+func TestNoExtensionGuess(t *testing.T) {
+	content := append([]byte("PK\x03\x04"), bytes.Repeat([]byte("0123456789"), 100)...)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	d := New(server.URL+"/archive", &Config{
+		Dir:              dir,
+		TmpDir:           t.TempDir(),
+		NoExtensionGuess: true,
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := filepath.Join(dir, "archive"); d.FilePath != expected {
+		t.Errorf("expect %s, got %s", expected, d.FilePath)
+	}
+}
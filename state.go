@@ -0,0 +1,183 @@
+package download
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/go-zoox/fs"
+)
+
+// stateVersion is the version of the exported state, a state of another version is rejected
+const stateVersion = 1
+
+// State represents the resume state of a segmented download exported by ExportState, its manifest
+// (the validators and the part inventory with the digests of the parts) and where the parts were
+type State struct {
+	Version int
+	Manifest
+	// Hash names the directory of the parts in TmpDir
+	Hash  string
+	Range *Range `json:",omitempty"`
+	// PartsDir is the directory of the parts on the exporting machine
+	PartsDir string
+}
+
+// ExportState returns the resume state of a segmented download, e.g. once interrupted, so it is resumed
+// on another machine, or after a container is rescheduled, with ResumeFromState. The parts are not in the state,
+// they are found in the TmpDir of the resuming downloader or in the PartsDir, e.g. a shared volume.
+func (d *Downloader) ExportState() ([]byte, error) {
+	if len(d.FileParts) == 0 || d.Hash == "" {
+		return nil, errors.New("no state to export: the parts of the download are not known")
+	}
+
+	manifest := d.manifest()
+	for i, part := range manifest.Parts {
+		if part.Size == 0 {
+			continue
+		}
+
+		sum, err := sha256Prefix(d.FileParts[i].Path, part.Size)
+		if err != nil {
+			return nil, err
+		}
+		part.SHA256 = sum
+	}
+
+	partsDir, err := filepath.Abs(filepath.Join(d.TmpDir, d.Hash))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(&State{
+		Version:  stateVersion,
+		Manifest: *manifest,
+		Hash:     d.Hash,
+		Range:    d.Range,
+		PartsDir: partsDir,
+	}, "", "  ")
+}
+
+// ResumeFromState prepares the downloader to resume the download of an exported state, Download continues it:
+// the parts found in TmpDir or else in the PartsDir of the state are kept when they match their digests,
+// and dropped by the validators of the state if the remote file changed since.
+func (d *Downloader) ResumeFromState(data []byte) error {
+	state := &State{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return fmt.Errorf("invalid state: %s", err)
+	}
+
+	if state.Version != stateVersion {
+		return fmt.Errorf("unsupported state version: %d", state.Version)
+	}
+
+	if state.URL == "" || state.Hash == "" {
+		return errors.New("invalid state: missing url or hash")
+	}
+
+	// the hash names a directory in TmpDir, it must not escape it
+	if !isStateHash(state.Hash) {
+		return fmt.Errorf("invalid state: invalid hash: %q", state.Hash)
+	}
+
+	d.URL = state.URL
+	d.ETag = state.ETag
+	d.LastModified = state.LastModified
+	d.ContentType = state.ContentType
+	d.ContentLength = state.ContentLength
+	d.Range = state.Range
+	d.Hash = state.Hash
+	if state.SegmentSize > 0 {
+		// the same ranges, so the parts are found again
+		d.SegmentSize = state.SegmentSize
+	}
+	if !d.isFileNameSupplied && d.FilePath == "" {
+		d.FileName, d.FileExt = state.FileName, state.FileExt
+	}
+
+	dir := filepath.Dir(d.manifestPath())
+	if err := fs.Mkdirp(dir); err != nil {
+		return err
+	}
+
+	for _, part := range state.Parts {
+		name := partName(part.Index, part.RangeStart, part.RangeEnd)
+		if err := adoptStatePart(filepath.Join(dir, name), filepath.Join(state.PartsDir, name), d.inProgressSuffix(), part); err != nil {
+			return err
+		}
+	}
+
+	// the manifest of the state, checkRemoteChanged drops the parts if the validators changed
+	return d.writeManifest(&state.Manifest)
+}
+
+// isStateHash returns true if hash is a hash of the downloader, the md5 of the download in lower-case hex
+func isStateHash(hash string) bool {
+	if len(hash) != hex.EncodedLen(md5.Size) {
+		return false
+	}
+
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// adoptStatePart keeps the part at path when it matches the state, or else copies the one of the exporting
+// machine at src when reachable, a part which does not match is removed and downloaded again.
+func adoptStatePart(path, src, suffix string, part *ManifestPart) error {
+	if isStatePart(path, part) {
+		return os.Truncate(path, part.Size)
+	}
+
+	if err := removeIfExists(path); err != nil {
+		return err
+	}
+
+	if part.Size == 0 || src == path || !isStatePart(src, part) {
+		return nil
+	}
+
+	if err := copyFile(src, path, suffix, 0644); err != nil {
+		return err
+	}
+
+	return os.Truncate(path, part.Size)
+}
+
+// isStatePart returns true if the first bytes of the file at path are the part of the state
+func isStatePart(path string, part *ManifestPart) bool {
+	if part.Size == 0 || part.SHA256 == "" {
+		return false
+	}
+
+	sum, err := sha256Prefix(path, part.Size)
+	return err == nil && sum == part.SHA256
+}
+
+// sha256Prefix returns the hex sha256 of the first n bytes of the file at path
+func sha256Prefix(path string, n int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if written, err := io.Copy(h, io.LimitReader(f, n)); err != nil {
+		return "", err
+	} else if written != n {
+		return "", fmt.Errorf("%s: expect %d bytes, got %d", path, n, written)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,100 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExportState(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var mu sync.Mutex
+	failing := true
+	fetched := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		byteRange := r.Header.Get("Range")
+		fetched[byteRange]++
+		isFailing := failing && byteRange == "bytes=200-299"
+		mu.Unlock()
+
+		if isFailing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// the first machine downloads all the parts but one
+	d := New(server.URL+"/file.mp4", &Config{
+		FilePath:    t.TempDir() + "/file.mp4",
+		TmpDir:      t.TempDir(),
+		SegmentSize: 100,
+		RetryPolicy: &RetryPolicy{MaxAttempts: 1},
+	})
+	if err := d.Download(); err == nil {
+		t.Fatal("expected the download to fail")
+	}
+
+	state, err := d.ExportState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second machine has a corrupted copy of a part, the others are copied from the parts dir of the state
+	tmpDir := t.TempDir()
+	resumed := New(server.URL+"/file.mp4", &Config{
+		FilePath: t.TempDir() + "/file.mp4",
+		TmpDir:   tmpDir,
+	})
+	corrupted := filepath.Join(tmpDir, d.Hash, partName(1, 100, 199))
+	os.MkdirAll(filepath.Dir(corrupted), 0755)
+	os.WriteFile(corrupted, bytes.Repeat([]byte("x"), 100), 0644)
+
+	if err := resumed.ResumeFromState(state); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	failing = false
+	fetched = map[string]int{}
+	mu.Unlock()
+
+	if err := resumed.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(resumed.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("unexpected content: %d bytes", len(data))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fetched["bytes=200-299"] != 1 || fetched["bytes=0-99"] != 0 || fetched["bytes=100-199"] != 0 {
+		t.Errorf("expected only the missing part downloaded again, got %v", fetched)
+	}
+
+	if err := New(server.URL, &Config{}).ResumeFromState([]byte(`{"Version": 2}`)); err == nil {
+		t.Error("expected a state of another version to be rejected")
+	}
+
+	for _, hash := range []string{"../..", "/etc", strings.ToUpper(d.Hash), d.Hash + "/.."} {
+		escaping := fmt.Sprintf(`{"Version": 1, "URL": %q, "Hash": %q}`, server.URL, hash)
+		if err := New(server.URL, &Config{TmpDir: t.TempDir()}).ResumeFromState([]byte(escaping)); err == nil {
+			t.Errorf("expected the hash %q to be rejected", hash)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package download
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// contentStoreHash returns the hash of ContentStoreAlgo, sha256 by default
+func (d *Downloader) contentStoreHash() (string, func() hash.Hash, error) {
+	algo := d.ContentStoreAlgo
+	if algo == "" {
+		algo = ChecksumSHA256
+	}
+
+	newHash, ok := checksumHashes[algo]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported content store algorithm: %s", algo)
+	}
+
+	return algo, newHash, nil
+}
+
+// storeFile moves the verified file into ContentStore under its digest, <store>/<algo>/<ab>/<abcd...>,
+// the copy already in the store is kept when there is one, so identical files are stored once.
+func (d *Downloader) storeFile(stagingPath string) error {
+	algo, newHash, err := d.contentStoreHash()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(stagingPath)
+	if err != nil {
+		return err
+	}
+
+	h := newHash()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	dirPath := filepath.Join(d.ContentStore, algo, sum[:2])
+	if err := os.MkdirAll(fixLongPath(dirPath), 0755); err != nil {
+		return err
+	}
+
+	path := fixLongPath(filepath.Join(dirPath, sum))
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+		if os.Getenv("DEBUG") == "true" {
+			fmt.Println("content store: already stored:", path)
+		}
+
+		os.Remove(stagingPath)
+	} else if err := moveFile(stagingPath, path, d.inProgressSuffix(), d.fileMode()); err != nil {
+		return err
+	}
+
+	if d.Sync {
+		if err := syncDir(dirPath); err != nil {
+			return err
+		}
+	}
+
+	d.Digest = algo + ":" + sum
+	d.storedPath = path
+	return nil
+}
@@ -0,0 +1,63 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContentStore(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	store := t.TempDir()
+	dir := t.TempDir()
+
+	for _, disabled := range []bool{false, true} {
+		d := New(server.URL+"/file.mp4", &Config{
+			Dir:              dir,
+			TmpDir:           t.TempDir(),
+			IsRangesDisabled: disabled,
+			ContentStore:     store,
+		})
+		if err := d.Download(); err != nil {
+			t.Fatal(err)
+		}
+
+		if d.Digest != "sha256:"+digest {
+			t.Errorf("unexpected digest: %s", d.Digest)
+		}
+
+		expected := filepath.Join(store, "sha256", digest[:2], digest)
+		if d.FilePath != expected {
+			t.Errorf("expected the file stored at %s, got %s", expected, d.FilePath)
+		}
+
+		data, err := os.ReadFile(expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Error("unexpected content")
+		}
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("expected nothing left in the file directory, got %d entries", len(entries))
+	}
+
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		ContentStore:     store,
+		ContentStoreAlgo: "crc32",
+	})
+	if err == nil {
+		t.Error("expected an error for an unsupported algorithm")
+	}
+}
@@ -0,0 +1,212 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Open returns the remote file as an ordered stream, while the next segments are downloaded ahead in parallel,
+// nothing is written to the disk, so the data can be hashed, transcoded or uploaded on the fly.
+// At most Config.Lookahead segments (DefaultConcurrency) are held in memory, the file is streamed with a single request
+// when the server does not support ranges. Closing the stream aborts the download.
+func (d *Downloader) Open(ctx context.Context) (io.ReadCloser, error) {
+	d.ctx = ctx
+
+	if err := d.validateRange(); err != nil {
+		return nil, err
+	}
+
+	if err := d.extract(); err != nil {
+		return nil, err
+	}
+
+	isSupportRange, err := d.checkSupportRange()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.parseContentInfo(); err != nil {
+		return nil, err
+	}
+
+	if err := d.checkContentType(d.ContentType); err != nil {
+		return nil, err
+	}
+
+	if err := d.checkExpectedSize(d.targetLength()); err != nil {
+		return nil, err
+	}
+
+	if !isSupportRange || d.ContentLength <= 0 {
+		return d.openDirect()
+	}
+
+	if err := d.parseRanges(); err != nil {
+		return nil, err
+	}
+
+	return d.openRanges(ctx), nil
+}
+
+// openDirect streams the file with a single request
+func (d *Downloader) openDirect() (io.ReadCloser, error) {
+	resp, body, err := d.open(http.MethodGet, d.URL, d.directRequestConfig())
+	if err != nil {
+		return nil, classify(err)
+	}
+
+	if err := d.checkDirectResponse(resp); err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// segment is a range downloaded ahead of the reader
+type segment struct {
+	data []byte
+	err  error
+}
+
+// rangeStream reads the segments in order, as they are downloaded
+type rangeStream struct {
+	cancel   context.CancelFunc
+	segments []chan *segment
+	// slots bounds the segments downloaded or waiting to be read
+	slots chan struct{}
+	next  int
+	cur   *bytes.Reader
+	err   error
+	wg    sync.WaitGroup
+}
+
+func (d *Downloader) openRanges(ctx context.Context) *rangeStream {
+	ctx, cancel := context.WithCancel(ctx)
+	d.ctx = ctx
+
+	s := &rangeStream{
+		cancel:   cancel,
+		segments: make([]chan *segment, len(d.Ranges)),
+		slots:    make(chan struct{}, d.lookahead()),
+	}
+	for i := range s.segments {
+		s.segments[i] = make(chan *segment, 1)
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for i, r := range d.Ranges {
+			select {
+			case s.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			s.wg.Add(1)
+			go func(i int, r *Range) {
+				defer s.wg.Done()
+
+				part := &FilePart{Index: i, RangeStart: r.Start, RangeEnd: r.End}
+				var data []byte
+				err := d.WorkerPool.acquire(ctx, d.Priority)
+				if err == nil {
+					err = d.retry(part, func() (err error) {
+						data, err = d.fetchSegment(part)
+						return err
+					})
+					d.WorkerPool.release()
+				}
+				s.segments[i] <- &segment{data: data, err: err}
+			}(i, r)
+		}
+	}()
+
+	return s
+}
+
+// fetchSegment downloads the range of the part into the memory
+func (d *Downloader) fetchSegment(part *FilePart) ([]byte, error) {
+	headers := map[string]string{
+		"Range": fmt.Sprintf("bytes=%d-%d", part.RangeStart, part.RangeEnd),
+	}
+	validator := d.validator()
+	if validator != "" {
+		headers["If-Range"] = validator
+	}
+
+	response, err := d.fetchGet(d.URL, &requestConfig{Headers: headers})
+	if err != nil {
+		return nil, classify(err)
+	}
+
+	if response.Status == http.StatusOK && validator != "" {
+		return nil, newRemoteChangedError(validator, response.Headers)
+	}
+
+	if response.Status != http.StatusPartialContent {
+		return nil, newStatusError(response.Status)
+	}
+
+	start, end, _, err := parseContentRange(response.Headers.Get("Content-Range"))
+	if err != nil {
+		return nil, newProtocolError(err)
+	}
+	if start != part.RangeStart || end != part.RangeEnd || int64(len(response.Body)) != part.RangeEnd-part.RangeStart+1 {
+		return nil, newProtocolError(fmt.Errorf("invalid range: expect %d-%d, got %d-%d (%d bytes)", part.RangeStart, part.RangeEnd, start, end, len(response.Body)))
+	}
+
+	return response.Body, nil
+}
+
+func (s *rangeStream) Read(p []byte) (int, error) {
+	for {
+		if s.err != nil {
+			return 0, s.err
+		}
+
+		if s.cur != nil && s.cur.Len() > 0 {
+			return s.cur.Read(p)
+		}
+
+		if s.cur != nil {
+			// the segment is consumed, its slot is free for the next one
+			s.cur = nil
+			<-s.slots
+		}
+
+		if s.next == len(s.segments) {
+			s.err = io.EOF
+			continue
+		}
+
+		seg := <-s.segments[s.next]
+		s.next++
+		if seg.err != nil {
+			s.err = seg.err
+			continue
+		}
+		s.cur = bytes.NewReader(seg.data)
+	}
+}
+
+// Close aborts the segments still downloading
+func (s *rangeStream) Close() error {
+	s.cancel()
+	s.wg.Wait()
+
+	if s.err == nil {
+		s.err = errStreamClosed
+	}
+
+	return nil
+}
+
+var errStreamClosed = errors.New("read on a closed stream")
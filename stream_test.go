@@ -0,0 +1,101 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOpen(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	var inflight, maxInflight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			n := atomic.AddInt32(&inflight, 1)
+			defer atomic.AddInt32(&inflight, -1)
+			for {
+				max := atomic.LoadInt32(&maxInflight)
+				if n <= max || atomic.CompareAndSwapInt32(&maxInflight, max, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	d := New(server.URL+"/file.mp4", &Config{SegmentSize: 4096, TmpDir: t.TempDir()})
+	r, err := d.Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		t.Fatal(err)
+	}
+	if expected := sha256.Sum256(content); !bytes.Equal(h.Sum(nil), expected[:]) {
+		t.Error("unexpected content")
+	}
+
+	if max := atomic.LoadInt32(&maxInflight); max < 2 || max > int32(DefaultConcurrency) {
+		t.Errorf("expected up to %d segments downloaded ahead, got %d", DefaultConcurrency, max)
+	}
+}
+
+func TestOpenWithoutRanges(t *testing.T) {
+	content := []byte("streamed with a single request")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "none")
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	r, err := New(server.URL+"/file.txt", &Config{TmpDir: t.TempDir()}).Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("unexpected content: %s", data)
+	}
+}
+
+func TestOpenClose(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	r, err := New(server.URL+"/file.mp4", &Config{SegmentSize: 1024, TmpDir: t.TempDir()}).Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 100)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, content[:100]) {
+		t.Errorf("unexpected content: %s", buf)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(buf); err == nil {
+		t.Error("expected an error reading a closed stream")
+	}
+}
@@ -0,0 +1,164 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// errSuffixRangeRejected means the server does not understand the suffix range bytes=-N
+var errSuffixRangeRejected = errors.New("suffix range rejected")
+
+// DownloadTail returns the last n bytes of the file, e.g. the end of a log file
+// or the end of central directory record of a zip, nothing is written to the disk.
+// A suffix range (bytes=-N) is sent, the servers rejecting it are asked the explicit range from the probed size,
+// and the servers ignoring ranges stream the whole file, keeping only its end.
+func (d *Downloader) DownloadTail(ctx context.Context, n int64) ([]byte, error) {
+	jobCtx, cancel := d.withMaxTime(ctx)
+	defer cancel()
+	d.ctx = jobCtx
+
+	data, err := d.downloadTail(n)
+	if err := d.checkMaxTime(ctx, err); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (d *Downloader) downloadTail(n int64) ([]byte, error) {
+	if n <= 0 {
+		return []byte{}, nil
+	}
+
+	if err := d.extract(); err != nil {
+		return nil, err
+	}
+
+	data, err := d.fetchTail("bytes=-"+strconv.FormatInt(n, 10), n)
+	if !errors.Is(err, errSuffixRangeRejected) {
+		return data, err
+	}
+
+	if os.Getenv("DEBUG") == "true" {
+		fmt.Println("tail: suffix range rejected, probing the size:", d.URL)
+	}
+
+	info, err := d.probe()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case info.Size == 0:
+		return []byte{}, nil
+	case info.Size < 0 || !info.IsSupportRange:
+		// stream the whole file
+		return d.fetchTail("", n)
+	}
+
+	start := info.Size - n
+	if start < 0 {
+		start = 0
+	}
+
+	data, err = d.fetchTail("bytes="+strconv.FormatInt(start, 10)+"-", n)
+	if errors.Is(err, errSuffixRangeRejected) {
+		return d.fetchTail("", n)
+	}
+
+	return data, err
+}
+
+// fetchTail sends a GET with the range header if any, returning the last n bytes of the response
+func (d *Downloader) fetchTail(rangeHeader string, n int64) ([]byte, error) {
+	var config *requestConfig
+	if rangeHeader != "" {
+		config = &requestConfig{
+			Headers: map[string]string{
+				"Range": rangeHeader,
+			},
+		}
+	}
+
+	var data []byte
+	err := d.do(http.MethodGet, d.URL, config, func(resp *http.Response, body io.Reader) error {
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusPartialContent:
+			// some servers read bytes=-N as bytes=0-N, the range must end the file
+			_, end, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+			if err != nil || (total > 0 && end != total-1) {
+				return errSuffixRangeRejected
+			}
+		case http.StatusRequestedRangeNotSatisfiable:
+			// an empty file has no last byte
+			if resp.Header.Get("Content-Range") == "bytes */0" {
+				data = []byte{}
+				return nil
+			}
+
+			return errSuffixRangeRejected
+		default:
+			return newStatusError(resp.StatusCode)
+		}
+
+		if err := d.checkContentType(resp.Header.Get("Content-Type")); err != nil {
+			return err
+		}
+
+		var err error
+		data, err = readTail(body, n)
+		return err
+	})
+	if errors.Is(err, errSuffixRangeRejected) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, classify(err)
+	}
+
+	return data, nil
+}
+
+// readTail reads r to the end, returning its last n bytes
+func readTail(r io.Reader, n int64) ([]byte, error) {
+	var buf []byte
+	chunk := make([]byte, 32*1024)
+	for {
+		m, err := r.Read(chunk)
+		buf = append(buf, chunk[:m]...)
+		if int64(len(buf)) > 2*n {
+			// keep the memory bounded, drop what is before the tail
+			buf = append(buf[:0], buf[int64(len(buf))-n:]...)
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if int64(len(buf)) > n {
+		buf = buf[int64(len(buf))-n:]
+	}
+
+	return buf, nil
+}
+
+// DownloadTail returns the last n bytes of the file by url and config
+func DownloadTail(ctx context.Context, url string, n int64, cfg ...*Config) ([]byte, error) {
+	configX := &Config{}
+	if len(cfg) > 0 {
+		configX = cfg[0]
+	}
+
+	d := New(url, configX)
+	return d.DownloadTail(ctx, n)
+}
@@ -0,0 +1,84 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadTail(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	serveContent := func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.zip", time.Time{}, bytes.NewReader(content))
+	}
+
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"suffix range", serveContent},
+		{"suffix range rejected", func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.Header.Get("Range"), "bytes=-") {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+
+			serveContent(w, r)
+		}},
+		{"suffix range misread", func(w http.ResponseWriter, r *http.Request) {
+			if rangeHeader := r.Header.Get("Range"); strings.HasPrefix(rangeHeader, "bytes=-") {
+				r.Header.Set("Range", "bytes=0"+strings.TrimPrefix(rangeHeader, "bytes="))
+			}
+
+			serveContent(w, r)
+		}},
+		{"range ignored", func(w http.ResponseWriter, r *http.Request) {
+			w.Write(content)
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := httptest.NewServer(c.handler)
+			defer server.Close()
+
+			data, err := DownloadTail(context.Background(), server.URL+"/file.zip", 22)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, content[len(content)-22:]) {
+				t.Errorf("unexpected tail: %s", data)
+			}
+
+			data, err = DownloadTail(context.Background(), server.URL+"/file.zip", int64(len(content)+10))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, content) {
+				t.Errorf("expected the whole file when n is beyond the size, got %d bytes", len(data))
+			}
+		})
+	}
+}
+
+func TestReadTail(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	for _, n := range []int64{1, 7, 1000, 99999, 100000, 200000} {
+		data, err := readTail(bytes.NewReader(content), n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := content
+		if n < int64(len(content)) {
+			expected = content[int64(len(content))-n:]
+		}
+		if !bytes.Equal(data, expected) {
+			t.Errorf("unexpected tail of %d bytes: got %d bytes", n, len(data))
+		}
+	}
+}
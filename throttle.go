@@ -0,0 +1,118 @@
+package download
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket allowing rate tokens per second, with a burst of one second
+type rateLimiter struct {
+	sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{
+		rate:   rate,
+		tokens: rate,
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until n tokens are available, n must not exceed the rate
+func (l *rateLimiter) wait(ctx context.Context, n float64) error {
+	l.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	// reserve the tokens now, so concurrent writers queue up fairly
+	l.tokens -= n
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// diskLimiter caps the writes to the destination disk, shared by every part of the download
+type diskLimiter struct {
+	bytes *rateLimiter
+	ops   *rateLimiter
+}
+
+func (d *Downloader) diskLimiter() *diskLimiter {
+	d.diskOnce.Do(func() {
+		if d.DiskWriteRate <= 0 && d.DiskWriteIOPS <= 0 {
+			return
+		}
+
+		d.disk = &diskLimiter{}
+		if d.DiskWriteRate > 0 {
+			d.disk.bytes = newRateLimiter(float64(d.DiskWriteRate))
+		}
+		if d.DiskWriteIOPS > 0 {
+			d.disk.ops = newRateLimiter(float64(d.DiskWriteIOPS))
+		}
+	})
+
+	return d.disk
+}
+
+// throttledWriter waits for the disk limiter before each write
+type throttledWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter *diskLimiter
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		// a write never takes more than a second of the budget
+		if tw.limiter.bytes != nil && float64(len(chunk)) > tw.limiter.bytes.rate {
+			chunk = chunk[:int(tw.limiter.bytes.rate)]
+		}
+
+		if tw.limiter.ops != nil {
+			if err := tw.limiter.ops.wait(tw.ctx, 1); err != nil {
+				return written, err
+			}
+		}
+		if tw.limiter.bytes != nil {
+			if err := tw.limiter.bytes.wait(tw.ctx, float64(len(chunk))); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := tw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
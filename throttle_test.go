@@ -0,0 +1,40 @@
+package download
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDiskWriteRate(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 300)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	filePath := t.TempDir() + "/throttled.mp4"
+	startedAt := time.Now()
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:      filePath,
+		TmpDir:        t.TempDir(),
+		SegmentSize:   500,
+		DiskWriteRate: 2000,
+		DiskWriteIOPS: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a burst of 2000 bytes, then 1000 bytes at 2000 bytes per second
+	if elapsed := time.Since(startedAt); elapsed < 400*time.Millisecond {
+		t.Errorf("expected the writes to be throttled, took %s", elapsed)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("expected %d bytes, got %d", len(content), len(data))
+	}
+}
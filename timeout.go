@@ -0,0 +1,101 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// TimeoutConfig represents the fine-grained request timeouts,
+// so a slow-to-connect mirror fails fast while a long body transfer is not killed.
+type TimeoutConfig struct {
+	// Connect limits the tcp connect, zero means the default 30s
+	Connect time.Duration
+	// TLSHandshake limits the tls handshake, zero means the default 10s
+	TLSHandshake time.Duration
+	// ResponseHeader limits the wait for the response headers once the request is sent, zero means no limit
+	ResponseHeader time.Duration
+	// BodyRead limits the time without receiving any body bytes, zero means no limit
+	BodyRead time.Duration
+	// Total limits the whole request, replacing the limit of the request, zero keeps it, e.g. Config.SegmentTimeout
+	Total time.Duration
+}
+
+// DefaultSegmentTimeout is the time a request of a part is given on top of its transfer at DefaultSegmentMinSpeed,
+// for the connection and the first byte, see Config.SegmentTimeout
+var DefaultSegmentTimeout = 30 * time.Second
+
+// DefaultSegmentMinSpeed is the slowest transfer rate in bytes per second the default time limit of a request
+// of a part allows, a 10 Mb part gets about 2 minutes
+var DefaultSegmentMinSpeed int64 = 100 * 1024
+
+// segmentTimeout returns the time limit of a request of size bytes of parts, SegmentTimeout or scaled by the size
+func (d *Downloader) segmentTimeout(size int64) time.Duration {
+	if d.SegmentTimeout > 0 {
+		return d.SegmentTimeout
+	}
+
+	timeout := DefaultSegmentTimeout
+	if DefaultSegmentMinSpeed > 0 {
+		timeout += time.Duration(float64(size) / float64(DefaultSegmentMinSpeed) * float64(time.Second))
+	}
+
+	return timeout
+}
+
+// timeoutError is returned when the body read times out, it is a net.Error so it is retried
+type timeoutError struct {
+	idle time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("body read timeout: no data received for %s", e.idle)
+}
+
+func (e *timeoutError) Timeout() bool {
+	return true
+}
+
+func (e *timeoutError) Temporary() bool {
+	return true
+}
+
+// idleReader cancels the request when no data is read for idle
+type idleReader struct {
+	r        io.Reader
+	idle     time.Duration
+	timer    *time.Timer
+	timedOut int32
+}
+
+func newIdleReader(r io.Reader, idle time.Duration, cancel context.CancelFunc) *idleReader {
+	ir := &idleReader{
+		r:    r,
+		idle: idle,
+	}
+	ir.timer = time.AfterFunc(idle, func() {
+		atomic.StoreInt32(&ir.timedOut, 1)
+		cancel()
+	})
+
+	return ir
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if atomic.LoadInt32(&ir.timedOut) == 1 {
+		return n, &timeoutError{idle: ir.idle}
+	}
+
+	if n > 0 {
+		ir.timer.Reset(ir.idle)
+	}
+
+	return n, err
+}
+
+func (ir *idleReader) stop() {
+	ir.timer.Stop()
+}
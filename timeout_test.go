@@ -0,0 +1,148 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimeouts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		// a slow but steady transfer, then a stall on /stall
+		for i := 0; i < 6; i++ {
+			w.Write([]byte("0123456789"))
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+		if r.URL.Path == "/stall.mp4" {
+			time.Sleep(time.Second)
+		}
+	}))
+	defer server.Close()
+
+	timeouts := &TimeoutConfig{
+		Connect:  time.Second,
+		BodyRead: 200 * time.Millisecond,
+	}
+
+	filePath := t.TempDir() + "/slow.mp4"
+	_, err := Download(server.URL+"/slow.mp4", &Config{
+		FilePath:         filePath,
+		IsRangesDisabled: true,
+		Timeouts:         timeouts,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filePath); len(data) != 60 {
+		t.Errorf("expected 60 bytes, got %d", len(data))
+	}
+
+	_, err = Download(server.URL+"/stall.mp4", &Config{
+		FilePath:         t.TempDir() + "/stall.mp4",
+		IsRangesDisabled: true,
+		Timeouts:         timeouts,
+	})
+	var e *Error
+	if !errors.As(err, &e) || e.Kind != ErrorKindTimeout || !e.Retryable {
+		t.Fatalf("expected a retryable timeout error, got %v", err)
+	}
+}
+
+func TestSegmentTimeout(t *testing.T) {
+	d := New("http://example.com/file.mp4", &Config{})
+	if timeout := d.segmentTimeout(DefaultSegmentSize); timeout < 2*time.Minute || timeout > 3*time.Minute {
+		t.Errorf("expected about 2 minutes for a default segment, got %s", timeout)
+	}
+	if small, large := d.segmentTimeout(1024), d.segmentTimeout(100*DefaultSegmentSize); small >= large || small < DefaultSegmentTimeout {
+		t.Errorf("expected the timeout scaled by the size, got %s and %s", small, large)
+	}
+
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	// the first request of the part at 300 stalls
+	var stalls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=300-399" && atomic.AddInt32(&stalls, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+			return
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// the fine-grained timeouts without Total keep the limit of the request
+	for _, timeouts := range []*TimeoutConfig{nil, {Connect: time.Second}} {
+		atomic.StoreInt32(&stalls, 0)
+		var retries int32
+		d = New(server.URL+"/file.mp4", &Config{
+			Dir:            t.TempDir(),
+			TmpDir:         t.TempDir(),
+			SegmentSize:    100,
+			SegmentTimeout: 100 * time.Millisecond,
+			Timeouts:       timeouts,
+			RetryPolicy: &RetryPolicy{
+				BaseDelay: time.Millisecond,
+				OnRetry: func(part *FilePart, attempt int, err error) {
+					atomic.AddInt32(&retries, 1)
+				},
+			},
+		})
+		startedAt := time.Now()
+		if err := d.Download(); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(startedAt); elapsed > 5*time.Second {
+			t.Errorf("%+v: expected the stalled attempt cut after 100ms, took %s", timeouts, elapsed)
+		}
+		if n := atomic.LoadInt32(&retries); n != 1 {
+			t.Errorf("%+v: expected the stalled part retried once, got %d retries", timeouts, n)
+		}
+
+		data, err := os.ReadFile(d.FilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Error("unexpected content")
+		}
+	}
+}
+
+func TestTimeoutIsNotDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	// the time limit of the request is retried
+	_, err := (&http.Client{Timeout: 50 * time.Millisecond}).Get(server.URL)
+	if e := classify(err); e.Kind != ErrorKindTimeout || !e.Retryable {
+		t.Errorf("expected a retryable timeout, got %s %v", e.Kind, err)
+	}
+
+	// the deadline of the caller is not
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	_, err = http.DefaultClient.Do(req)
+	if e := classify(err); e.Kind != ErrorKindCanceled || e.Retryable {
+		t.Errorf("expected the deadline to cancel, got %s %v", e.Kind, err)
+	}
+}
@@ -0,0 +1,487 @@
+package download
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// TransportConfig represents the low-level connection tuning,
+// for links with a high bandwidth-delay product where the defaults leave throughput on the table.
+type TransportConfig struct {
+	// ReadBufferSize is the size of the buffer reading from the connection, zero means the default 4KB
+	ReadBufferSize int
+	// WriteBufferSize is the size of the buffer writing to the connection, zero means the default 4KB
+	WriteBufferSize int
+	// SocketReceiveBuffer sets SO_RCVBUF, a hint for the tcp receive window, zero keeps the os default
+	SocketReceiveBuffer int
+	// SocketSendBuffer sets SO_SNDBUF, zero keeps the os default
+	SocketSendBuffer int
+	// Nagle enables Nagle's algorithm, by default it is disabled (TCP_NODELAY)
+	Nagle bool
+	// IdleConnTimeout closes connections idle for longer, zero means the default 90s
+	IdleConnTimeout time.Duration
+	// MaxIdleConnsPerHost is the number of idle connections kept per host, zero means the default 2
+	MaxIdleConnsPerHost int
+}
+
+// tuneConn applies the socket options of the transport config
+func (c *TransportConfig) tuneConn(conn net.Conn) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	if c.SocketReceiveBuffer > 0 {
+		if err := tcpConn.SetReadBuffer(c.SocketReceiveBuffer); err != nil {
+			return err
+		}
+	}
+	if c.SocketSendBuffer > 0 {
+		if err := tcpConn.SetWriteBuffer(c.SocketSendBuffer); err != nil {
+			return err
+		}
+	}
+
+	return tcpConn.SetNoDelay(!c.Nagle)
+}
+
+// transport returns the http transport shared by every request of the downloader,
+// so connections are reused across parts.
+func (d *Downloader) transport() *http.Transport {
+	d.transportOnce.Do(func() {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}
+		if d.Timeouts != nil && d.Timeouts.Connect > 0 {
+			dialer.Timeout = d.Timeouts.Connect
+		}
+
+		localAddr, err := d.localAddr()
+		if err != nil {
+			d.transportErr = err
+		} else if localAddr != nil {
+			dialer.LocalAddr = localAddr
+		}
+
+		proxies, err := d.proxies()
+		if err != nil {
+			d.transportErr = err
+		}
+
+		dial := dialer.DialContext
+		if d.Transport != nil {
+			dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := dialer.DialContext(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+
+				if err := d.Transport.tuneConn(conn); err != nil {
+					conn.Close()
+					return nil, err
+				}
+
+				return conn, nil
+			}
+		}
+
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			// like curl --connect-to, connect to another endpoint keeping the url host
+			if to, ok := d.ConnectTo[addr]; ok {
+				addr = to
+			}
+
+			// the caching proxy only reaches the public addresses, dialed as resolved against dns rebinding,
+			// behind an http proxy its requests are checked before they are sent
+			if d.isPrivateRejected && (len(proxies) == 0 || isProxyDialed(proxies)) {
+				resolved, err := resolvePublic(ctx, addr)
+				if err != nil {
+					return nil, err
+				}
+				if !isProxyDialed(proxies) {
+					addr = resolved
+				}
+			}
+
+			if isProxyDialed(proxies) {
+				return dialProxies(ctx, dial, proxies, addr)
+			}
+
+			return dial(ctx, network, addr)
+		}
+
+		if d.Transport != nil {
+			t.ReadBufferSize = d.Transport.ReadBufferSize
+			t.WriteBufferSize = d.Transport.WriteBufferSize
+			if d.Transport.IdleConnTimeout > 0 {
+				t.IdleConnTimeout = d.Transport.IdleConnTimeout
+			}
+			t.MaxIdleConnsPerHost = d.Transport.MaxIdleConnsPerHost
+		}
+
+		if isProxyDialed(proxies) {
+			t.Proxy = nil
+		} else if len(proxies) == 1 {
+			t.Proxy = http.ProxyURL(proxies[0])
+		}
+
+		if d.Timeouts != nil {
+			if d.Timeouts.TLSHandshake > 0 {
+				t.TLSHandshakeTimeout = d.Timeouts.TLSHandshake
+			}
+			t.ResponseHeaderTimeout = d.Timeouts.ResponseHeader
+		}
+
+		if serverName := d.serverName(); serverName != "" {
+			t.TLSClientConfig = &tls.Config{
+				ServerName: serverName,
+			}
+		}
+
+		d.httpTransport = t
+	})
+
+	return d.httpTransport
+}
+
+// localAddr returns the source address to bind outgoing connections to,
+// from LocalAddr or the first address of Interface.
+func (d *Downloader) localAddr() (*net.TCPAddr, error) {
+	if d.LocalAddr != "" {
+		ip := net.ParseIP(d.LocalAddr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid local address: %s", d.LocalAddr)
+		}
+
+		return &net.TCPAddr{IP: ip}, nil
+	}
+
+	if d.Interface != "" {
+		iface, err := net.InterfaceByName(d.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interface %s: %s", d.Interface, err)
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("invalid interface %s: %s", d.Interface, err)
+		}
+
+		// prefer ipv4, most origins are still ipv4 only
+		var ip net.IP
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				if ipNet.IP.To4() != nil {
+					ip = ipNet.IP
+					break
+				}
+
+				if ip == nil {
+					ip = ipNet.IP
+				}
+			}
+		}
+		if ip == nil {
+			return nil, fmt.Errorf("interface %s has no address", d.Interface)
+		}
+
+		return &net.TCPAddr{IP: ip}, nil
+	}
+
+	return nil, nil
+}
+
+// serverName returns the TLS SNI override, defaults to the Host override
+func (d *Downloader) serverName() string {
+	if d.ServerName != "" {
+		return d.ServerName
+	}
+
+	if d.Host != "" {
+		if host, _, err := net.SplitHostPort(d.Host); err == nil {
+			return host
+		}
+
+		return d.Host
+	}
+
+	return ""
+}
+
+// client returns the http client of a request, the injected Client or one on the downloader transport
+func (d *Downloader) client(timeout time.Duration) (*http.Client, error) {
+	if d.Client != nil {
+		client := *d.Client
+		if client.Timeout == 0 {
+			client.Timeout = timeout
+		}
+		if checkRedirect := d.checkRedirect(); checkRedirect != nil {
+			client.CheckRedirect = checkRedirect
+		}
+
+		return &client, nil
+	}
+
+	transport := d.transport()
+	if d.transportErr != nil {
+		return nil, d.transportErr
+	}
+
+	return &http.Client{
+		Transport:     transport,
+		Timeout:       timeout,
+		CheckRedirect: d.checkRedirect(),
+	}, nil
+}
+
+// requestConfig is the config of a single request
+type requestConfig struct {
+	// Headers are set on the request
+	Headers map[string]string
+	// Timeout overrides the request timeout
+	Timeout time.Duration
+	// DownloadFilePath receives the body of a successful response, instead of the memory
+	DownloadFilePath string
+	// Context replaces the context of the downloader, e.g. for a hedged part
+	Context context.Context
+}
+
+// bufferedResponse is a response read into the memory, for the small bodies
+type bufferedResponse struct {
+	Status  int
+	Headers http.Header
+	Body    []byte
+}
+
+// do sends the request with the downloader transport, handle reads the response body
+func (d *Downloader) do(method string, url string, config *requestConfig, handle func(resp *http.Response, body io.Reader) error) error {
+	resp, body, err := d.open(method, url, config)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return handle(resp, body)
+}
+
+// open sends the request with the downloader transport, the caller streams and closes the body
+func (d *Downloader) open(method string, url string, config *requestConfig) (*http.Response, io.ReadCloser, error) {
+	if config == nil {
+		config = &requestConfig{}
+	}
+
+	parent := d.getContext()
+	if config.Context != nil {
+		parent = config.Context
+	}
+	ctx, cancel := context.WithCancel(parent)
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	req.Header.Set("User-Agent", userAgent())
+	for k, v := range config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	if d.Host != "" {
+		req.Host = d.Host
+	}
+
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = requestTimeout()
+	}
+	// the limit of the whole request, the fine-grained timeouts keep the one of the request without it
+	if d.Timeouts != nil && d.Timeouts.Total > 0 {
+		timeout = d.Timeouts.Total
+	}
+
+	client, err := d.client(timeout)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	// the host asked to slow down with a Retry-After
+	if err := hostPauses.wait(ctx, req.URL.Host); err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	// a well-mannered crawler spaces its requests to a host
+	if d.PolitenessDelay > 0 {
+		if err := hostVisits.wait(ctx, req.URL.Host, d.PolitenessDelay); err != nil {
+			cancel()
+			return nil, nil, err
+		}
+	}
+
+	// a throttled host gets fewer requests in flight, until it recovers
+	status := 0
+	if !d.IsPacingDisabled {
+		release, err := hostPacing.acquire(ctx, req.URL.Host)
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+
+		cancelRequest := cancel
+		cancel = func() {
+			cancelRequest()
+			release(status)
+		}
+	}
+
+	// the requests of the process stay within SetMaxRequests
+	releaseRequest, err := processRequests.acquire(ctx)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	cancelPaced := cancel
+	cancel = func() {
+		cancelPaced()
+		releaseRequest()
+	}
+
+	sentAt := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	d.tuner.observe(time.Since(sentAt))
+	status = resp.StatusCode
+	d.pauseHost(resp)
+
+	body := &responseBody{Reader: resp.Body, body: resp.Body, cancel: cancel}
+	if d.Timeouts != nil && d.Timeouts.BodyRead > 0 {
+		body.idle = newIdleReader(resp.Body, d.Timeouts.BodyRead, cancel)
+		body.Reader = body.idle
+	}
+
+	return resp, body, nil
+}
+
+// responseBody releases the request resources once the body is closed
+type responseBody struct {
+	io.Reader
+	body   io.Closer
+	idle   *idleReader
+	cancel context.CancelFunc
+}
+
+func (b *responseBody) Close() error {
+	if b.idle != nil {
+		b.idle.stop()
+	}
+
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
+
+// fetch sends the request with the downloader transport,
+// the body is written to config.DownloadFilePath on success instead of being returned.
+func (d *Downloader) fetch(method string, url string, config *requestConfig) (*bufferedResponse, error) {
+	if config == nil {
+		config = &requestConfig{}
+	}
+
+	var response *bufferedResponse
+	err := d.do(method, url, config, func(resp *http.Response, body io.Reader) error {
+		response = &bufferedResponse{
+			Status:  resp.StatusCode,
+			Headers: resp.Header,
+		}
+
+		// whole files sent back to range requests are never read, callers check the status
+		if _, ok := config.Headers["Range"]; ok && resp.StatusCode != http.StatusPartialContent {
+			return nil
+		}
+
+		if config.DownloadFilePath != "" {
+			// keep error pages out of the file, callers check the status
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				return nil
+			}
+
+			return d.writeFile(config.DownloadFilePath, body)
+		}
+
+		if method == http.MethodHead {
+			return nil
+		}
+
+		var err error
+		response.Body, err = ioutil.ReadAll(body)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// writeFile writes r to the file at path, truncating it
+func (d *Downloader) writeFile(path string, r io.Reader) error {
+	w, err := d.createFile(path)
+	if err != nil {
+		return err
+	}
+
+	r = d.countProgress(path, 0, r)
+	// the sum of a part is kept to find its bytes in the merged file, see repairFile
+	var h hash.Hash
+	if d.ExpectedChecksum != "" && d.progress.isPart(path) {
+		h = sha256.New()
+		r = io.TeeReader(r, h)
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if h != nil {
+		d.progress.keepPartSum(path, h.Sum(nil))
+	}
+
+	return nil
+}
+
+func (d *Downloader) fetchHead(url string) (*bufferedResponse, error) {
+	return d.fetch(http.MethodHead, url, nil)
+}
+
+func (d *Downloader) fetchGet(url string, config *requestConfig) (*bufferedResponse, error) {
+	return d.fetch(http.MethodGet, url, config)
+}
+
+func (d *Downloader) fetchDownload(url string, filepath string, config *requestConfig) (*bufferedResponse, error) {
+	if config == nil {
+		config = &requestConfig{}
+	}
+	config.DownloadFilePath = filepath
+
+	return d.fetch(http.MethodGet, url, config)
+}
@@ -0,0 +1,121 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectToAndHost(t *testing.T) {
+	content := []byte("served by the origin behind the load balancer")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "origin.example.com" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	filePath := t.TempDir() + "/connect.mp4"
+	_, err := Download("http://cdn.example.com/file.mp4", &Config{
+		FilePath: filePath,
+		TmpDir:   t.TempDir(),
+		ConnectTo: map[string]string{
+			"cdn.example.com:80": strings.TrimPrefix(server.URL, "http://"),
+		},
+		Host: "origin.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("unexpected content: %s", data)
+	}
+}
+
+func TestServerName(t *testing.T) {
+	d := New("https://10.0.0.1/file.mp4", &Config{Host: "cdn.example.com:443"})
+	if d.serverName() != "cdn.example.com" {
+		t.Errorf("expected sni to default to the host override, got %s", d.serverName())
+	}
+
+	d = New("https://10.0.0.1/file.mp4", &Config{Host: "cdn.example.com", ServerName: "sni.example.com"})
+	if d.serverName() != "sni.example.com" {
+		t.Errorf("expected sni override, got %s", d.serverName())
+	}
+}
+
+func TestLocalAddr(t *testing.T) {
+	content := []byte("bound to the source address")
+
+	var remoteAddr string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath:  t.TempDir() + "/bound.mp4",
+		TmpDir:    t.TempDir(),
+		LocalAddr: "127.0.0.2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(remoteAddr, "127.0.0.2:") {
+		t.Errorf("expected connections from 127.0.0.2, got %s", remoteAddr)
+	}
+
+	_, err = Download(server.URL+"/file.mp4", &Config{
+		FilePath:  t.TempDir() + "/bound.mp4",
+		LocalAddr: "not-an-ip",
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid local address") {
+		t.Errorf("expected invalid local address error, got %v", err)
+	}
+}
+
+func TestTransportTuning(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	d := New(server.URL+"/file.mp4", &Config{
+		FilePath: t.TempDir() + "/tuned.mp4",
+		TmpDir:   t.TempDir(),
+		Transport: &TransportConfig{
+			ReadBufferSize:      256 * 1024,
+			SocketReceiveBuffer: 4 * 1024 * 1024,
+			IdleConnTimeout:     5 * time.Second,
+			MaxIdleConnsPerHost: DefaultConcurrency,
+		},
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	transport := d.transport()
+	if transport.ReadBufferSize != 256*1024 || transport.IdleConnTimeout != 5*time.Second || transport.MaxIdleConnsPerHost != DefaultConcurrency {
+		t.Errorf("expected the transport to be tuned, got %d %s %d", transport.ReadBufferSize, transport.IdleConnTimeout, transport.MaxIdleConnsPerHost)
+	}
+
+	data, err := os.ReadFile(d.getFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("expected %d bytes, got %d", len(content), len(data))
+	}
+}
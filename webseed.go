@@ -0,0 +1,194 @@
+package download
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// source represents a http source of the file, the url itself or a mirror (web seed)
+type source struct {
+	URL string
+	// bytes and elapsed measure the speed of the source
+	bytes   int64
+	elapsed time.Duration
+	// latency is the HEAD latency measured by the health check
+	latency time.Duration
+	//
+	requests            int
+	failures            int
+	consecutiveFailures int
+	inflight            int
+	// down marks the source unhealthy, it is only picked when no healthy source is left
+	down bool
+	// disagreements counts the pieces the source served differently from the other sources
+	disagreements int
+}
+
+// score estimates the throughput a new request will get from the source
+func (s *source) score() float64 {
+	// unmeasured sources are tried first to get measured
+	speed := float64(math.MaxFloat32)
+	if s.elapsed > 0 {
+		speed = float64(s.bytes) / s.elapsed.Seconds()
+	}
+
+	return speed / float64(1+s.inflight) / float64(1+s.failures)
+}
+
+// sourcePool picks the fastest source for each part
+type sourcePool struct {
+	sync.Mutex
+	list []*source
+	// strategy is the mirror strategy, failover and nearest pick the sources in order
+	strategy string
+}
+
+func newSourcePool(urls ...string) *sourcePool {
+	p := &sourcePool{}
+	seen := map[string]bool{}
+	for _, u := range urls {
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+
+		p.list = append(p.list, &source{URL: u})
+	}
+
+	return p
+}
+
+// pick returns the best source not in exclude and its current url, nil if none left
+func (p *sourcePool) pick(exclude map[string]bool) (*source, string) {
+	p.Lock()
+	defer p.Unlock()
+
+	best := p.best(exclude, false)
+	if best == nil {
+		// every candidate is down, give them another chance rather than failing the part
+		best = p.best(exclude, true)
+	}
+
+	if best == nil {
+		return nil, ""
+	}
+
+	best.inflight++
+	best.requests++
+	return best, best.URL
+}
+
+// hasOther reports whether a source not in exclude is left
+func (p *sourcePool) hasOther(exclude map[string]bool) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.best(exclude, true) != nil
+}
+
+func (p *sourcePool) best(exclude map[string]bool, isDownIncluded bool) *source {
+	var best *source
+	bestScore := -1.0
+	for _, s := range p.list {
+		if exclude[s.URL] || (s.down && !isDownIncluded) {
+			continue
+		}
+
+		if p.strategy == MirrorStrategyFailover || p.strategy == MirrorStrategyNearest {
+			return s
+		}
+
+		if score := s.score(); score > bestScore {
+			best, bestScore = s, score
+		}
+	}
+
+	return best
+}
+
+// done records the result of a request picked from the pool
+func (p *sourcePool) done(s *source, bytes int64, elapsed time.Duration, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	s.inflight--
+	if err != nil {
+		s.failures++
+		s.consecutiveFailures++
+		if s.consecutiveFailures >= DefaultMirrorMaxFailures {
+			s.down = true
+		}
+		return
+	}
+
+	s.consecutiveFailures = 0
+	// a source flagged for serving bad pieces stays down, its bytes are not to be trusted
+	s.down = s.disagreements > 0
+	s.bytes += bytes
+	s.elapsed += elapsed
+}
+
+// abandon records a request picked from the pool and cancelled, neither a failure nor a transfer
+func (p *sourcePool) abandon(s *source) {
+	p.Lock()
+	defer p.Unlock()
+
+	s.inflight--
+}
+
+// flag marks the sources down for serving pieces the other sources disagree with
+func (p *sourcePool) flag(urls ...string) {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, u := range urls {
+		for _, s := range p.list {
+			if s.URL == u {
+				s.disagreements++
+				s.down = true
+			}
+		}
+	}
+}
+
+func (d *Downloader) parseSources() error {
+	if len(d.PieceHashes) > 0 && len(d.PieceHashes) != len(d.FileParts) {
+		return fmt.Errorf("piece hashes mismatch: expect %d pieces, got %d hashes", len(d.FileParts), len(d.PieceHashes))
+	}
+
+	d.sources = newSourcePool(append([]string{d.URL}, d.Mirrors...)...)
+	d.sources.strategy = d.MirrorStrategy
+	return nil
+}
+
+// verifyFilePart checks the sha1 of the part against PieceHashes
+func (d *Downloader) verifyFilePart(part *FilePart) error {
+	if len(d.PieceHashes) == 0 {
+		return nil
+	}
+
+	f, err := os.Open(part.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	expected := strings.ToLower(d.PieceHashes[part.Index])
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return newChecksumError(fmt.Errorf("piece %d hash mismatch: expect %s, got %s", part.Index, expected, actual))
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"testing"
+)
+
+func TestWebSeed(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	corrupted := bytes.Repeat([]byte("9876543210"), 10)
+
+	primary := newRangeServer(corrupted)
+	defer primary.Close()
+	mirror := newRangeServer(content)
+	defer mirror.Close()
+
+	pieceLength := 32
+	hashes := []string{}
+	for start := 0; start < len(content); start += pieceLength {
+		end := start + pieceLength
+		if end > len(content) {
+			end = len(content)
+		}
+		sum := sha1.Sum(content[start:end])
+		hashes = append(hashes, hex.EncodeToString(sum[:]))
+	}
+
+	filePath := t.TempDir() + "/webseed.mp4"
+	_, err := Download(primary.URL+"/file.mp4", &Config{
+		FilePath:    filePath,
+		TmpDir:      t.TempDir(),
+		Mirrors:     []string{mirror.URL + "/file.mp4"},
+		PieceLength: int64(pieceLength),
+		PieceHashes: hashes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("unexpected content: %s", data)
+	}
+}
+
+func TestConsensus(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	tampered := append([]byte{}, content...)
+	copy(tampered[40:], "tampered")
+
+	good1 := newRangeServer(content)
+	defer good1.Close()
+	bad := newRangeServer(tampered)
+	defer bad.Close()
+	good2 := newRangeServer(content)
+	defer good2.Close()
+
+	filePath := t.TempDir() + "/consensus.mp4"
+	d := New(bad.URL+"/file.mp4", &Config{
+		FilePath:       filePath,
+		TmpDir:         t.TempDir(),
+		SegmentSize:    32,
+		Mirrors:        []string{good1.URL + "/file.mp4", good2.URL + "/file.mp4"},
+		MirrorStrategy: MirrorStrategyFailover,
+		Consensus:      2,
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("unexpected content: %s", data)
+	}
+
+	for _, h := range d.MirrorsHealth() {
+		if h.URL == bad.URL+"/file.mp4" && (!h.IsDown || h.Disagreements != 1) {
+			t.Errorf("expected the tampered source to be flagged, got %+v", h)
+		}
+		if h.URL != bad.URL+"/file.mp4" && h.Disagreements != 0 {
+			t.Errorf("expected %s not to be flagged", h.URL)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+)
+
+// DefaultConcurrency is the number of parts downloaded at the same time
+var DefaultConcurrency = 3
+
+// PanicError is a panic recovered in a worker goroutine, such as a panicking hook
+type PanicError struct {
+	// Value is the value passed to panic
+	Value interface{}
+	// Stack is the stack trace of the panicking goroutine
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n%s", e.Value, e.Stack)
+}
+
+// safeRun calls fn, converting a panic into a *PanicError,
+// so a bad code path in a worker can't take down the embedding process.
+func safeRun(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{
+				Value: r,
+				Stack: debug.Stack(),
+			}
+		}
+	}()
+
+	return fn()
+}
+
+func (d *Downloader) downloadFileParts() error {
+	// a part failing for good (404, changed file...) cancels the others, which would only add to the bytes
+	// thrown away, the requests of the parts are passed ctx, the context of the downloader is left as is
+	// for the other goroutines reading it
+	parent := d.getContext()
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	d.partialErr = nil
+	d.initCheckpoints()
+	d.initPartsProgress()
+	batches := make(chan []*FilePart)
+
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	workers := DefaultConcurrency
+	d.setProgressConnections(DefaultConcurrency)
+	d.tuner = nil
+	if d.AutoConcurrency {
+		// the workers up to the cap, the tuner lets the ones under its limit run
+		workers = d.maxConcurrency()
+		d.tuner = newConcurrencyTuner(DefaultConcurrency, workers)
+		defer d.tuneConcurrency(d.tuner)()
+	}
+	d.hedges = nil
+	if d.HedgeRequests {
+		d.hedges = newHedgePool()
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				if err := d.tuner.acquire(ctx); err != nil {
+					return
+				}
+				// a worker of the pool takes the next batch, so the batches start in order
+				if err := d.WorkerPool.acquire(ctx, d.Priority); err != nil {
+					d.tuner.release()
+					return
+				}
+				parts, ok := <-batches
+				if !ok {
+					d.WorkerPool.release()
+					d.tuner.release()
+					// the idle workers hedge the parts left
+					if d.hedges != nil {
+						d.hedgeStragglers(ctx)
+					}
+					return
+				}
+
+				pending := parts
+				if len(parts) > 1 {
+					for _, part := range parts {
+						d.setPartState(part, PartDownloading)
+						d.countPartAttempt(part)
+					}
+					// the parts the multi-range request missed are downloaded one by one
+					pending = d.downloadFilePartBatch(ctx, parts)
+				}
+				isPending := map[int]bool{}
+				for _, part := range pending {
+					isPending[part.Index] = true
+				}
+
+				for _, part := range parts {
+					var err error
+					if isPending[part.Index] {
+						if os.Getenv("DEBUG") == "true" {
+							fmt.Println("downloading part:", part.Index, part.Path)
+						}
+
+						err = d.downloadFilePartWithRetry(ctx, part)
+					}
+					if err == nil {
+						err = d.checkpoint(part)
+					}
+
+					switch {
+					case err == nil:
+						d.setPartState(part, PartDone)
+					case ctx.Err() != nil:
+						// interrupted, resumed by the next run
+						d.setPartState(part, PartPending)
+					default:
+						d.setPartState(part, PartFailed)
+					}
+
+					if err != nil {
+						mu.Lock()
+						if firstErr == nil {
+							firstErr = annotate(err, d.URL, part, 0)
+						}
+						if !IsRetryable(err) {
+							cancel()
+						}
+						mu.Unlock()
+					}
+				}
+				d.WorkerPool.release()
+				d.tuner.release()
+			}
+		}()
+	}
+
+feed:
+	for _, parts := range d.batchFileParts() {
+		select {
+		case batches <- parts:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(batches)
+	wg.Wait()
+
+	if err := parent.Err(); err != nil {
+		return err
+	}
+
+	if firstErr != nil {
+		return d.partialError(firstErr)
+	}
+
+	return nil
+}
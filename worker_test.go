@@ -0,0 +1,126 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPanicRecovery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Type", "video/mp4")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "100")
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	_, err := Download(server.URL+"/file.mp4", &Config{
+		FilePath: t.TempDir() + "/panic.mp4",
+		TmpDir:   t.TempDir(),
+		RefreshURL: func(old string) (string, error) {
+			panic("bad hook")
+		},
+	})
+
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("expected a panic error, got %v", err)
+	}
+	if panicErr.Value != "bad hook" || !strings.Contains(string(panicErr.Stack), "TestPanicRecovery") {
+		t.Errorf("expected the panic value and stack, got %v", panicErr)
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var cancelled int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Range") {
+		case "bytes=0-99", "bytes=100-199":
+			// stalls until the request is cancelled
+			w.Header().Set("Content-Range", "bytes 0-99/1000")
+			w.WriteHeader(http.StatusPartialContent)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			atomic.AddInt32(&cancelled, 1)
+			return
+		case "bytes=200-299":
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	d := New(server.URL+"/file.mp4", &Config{
+		FilePath:    t.TempDir() + "/file.mp4",
+		TmpDir:      t.TempDir(),
+		SegmentSize: 100,
+	})
+	errs := make(chan error, 1)
+	go func() {
+		errs <- d.Download()
+	}()
+
+	select {
+	case err := <-errs:
+		var e *Error
+		if !errors.As(err, &e) || e.StatusCode != http.StatusNotFound {
+			t.Errorf("expected the 404 of the failed part, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the download to fail fast")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&cancelled) != 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&cancelled); n != 2 {
+		t.Errorf("expected the 2 stalled parts cancelled, got %d", n)
+	}
+
+	parts := d.Parts()
+	if parts[2].State != PartFailed || parts[0].State != PartPending || parts[9].State != PartPending {
+		t.Errorf("expected the part 2 failed and the others pending, got %+v", parts)
+	}
+}
+
+func TestPartsContext(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var d *Downloader
+	var mu sync.Mutex
+	contexts := map[context.Context]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		contexts[d.getContext()] = true
+		mu.Unlock()
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	d = New(server.URL+"/file.mp4", &Config{
+		FilePath:    t.TempDir() + "/file.mp4",
+		TmpDir:      t.TempDir(),
+		SegmentSize: 100,
+	})
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the workers pass the context of the parts down, the one of the downloader stays the same
+	if len(contexts) != 1 {
+		t.Errorf("expected the context of the downloader left as is, got %d contexts", len(contexts))
+	}
+}
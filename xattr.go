@@ -0,0 +1,109 @@
+package download
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// ErrXattrUnsupported means the platform has no extended attributes, see Config.Xattrs
+var ErrXattrUnsupported = errors.New("extended attributes are not supported")
+
+// The extended attributes of Config.Xattrs, the urls as the XDG ones browsers set
+const (
+	XattrOriginURL   = "user.xdg.origin.url"
+	XattrReferrerURL = "user.xdg.referrer.url"
+	XattrETag        = "user.download.etag"
+	XattrTime        = "user.download.time"
+	XattrChecksum    = "user.download.checksum"
+)
+
+// provenanceURL returns the url without its credentials, its user info and its query, which carries
+// the signatures and tokens of the pre-signed urls, e.g. X-Amz-Signature, an invalid url is dropped.
+func provenanceURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+
+	u.User = nil
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String()
+}
+
+// provenanceChecksum returns a verified digest of the file as algo:hex, sha256 first,
+// the sha256 of the file when none is known
+func provenanceChecksum(path string, checksums map[string]string) (string, error) {
+	if sum, ok := checksums[ChecksumSHA256]; ok {
+		return ChecksumSHA256 + ":" + sum, nil
+	}
+
+	algos := make([]string, 0, len(checksums))
+	for algo := range checksums {
+		algos = append(algos, algo)
+	}
+	if len(algos) > 0 {
+		sort.Strings(algos)
+		return algos[0] + ":" + checksums[algos[0]], nil
+	}
+
+	sum, err := sha256File(path)
+	if err != nil {
+		return "", err
+	}
+
+	return ChecksumSHA256 + ":" + sum, nil
+}
+
+// writeXattrs tags the downloaded file with the url it came from, its etag, when and its checksum
+func (d *Downloader) writeXattrs(result *Result) error {
+	if result.FinalPath == "" {
+		return nil
+	}
+
+	checksum, err := provenanceChecksum(result.FinalPath, result.Checksums)
+	if err != nil {
+		return err
+	}
+
+	attrs := map[string]string{
+		XattrOriginURL: provenanceURL(d.URL),
+		XattrTime:      time.Now().UTC().Format(time.RFC3339),
+		XattrChecksum:  checksum,
+	}
+	if d.PageURL != "" {
+		attrs[XattrReferrerURL] = provenanceURL(d.PageURL)
+	}
+	if d.ETag != "" {
+		attrs[XattrETag] = d.ETag
+	}
+
+	for name, value := range attrs {
+		if err := setXattr(result.FinalPath, name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadXattrs returns the provenance of a file downloaded with Config.Xattrs, by attribute,
+// the attributes not set are missing
+func ReadXattrs(path string) (map[string]string, error) {
+	attrs := map[string]string{}
+	for _, name := range []string{XattrOriginURL, XattrReferrerURL, XattrETag, XattrTime, XattrChecksum} {
+		value, ok, err := getXattr(path, name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			attrs[name] = value
+		}
+	}
+
+	return attrs, nil
+}
@@ -0,0 +1,38 @@
+package download
+
+import (
+	"syscall"
+)
+
+func setXattr(path, name, value string) error {
+	if err := syscall.Setxattr(path, name, []byte(value), 0); err != nil {
+		if err == syscall.ENOTSUP {
+			return ErrXattrUnsupported
+		}
+		return err
+	}
+
+	return nil
+}
+
+// getXattr returns the attribute name of the file at path, false when it is not set
+func getXattr(path, name string) (string, bool, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err == syscall.ENODATA {
+		return "", false, nil
+	}
+	if err == syscall.ENOTSUP {
+		return "", false, ErrXattrUnsupported
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	value := make([]byte, size)
+	n, err := syscall.Getxattr(path, name, value)
+	if err != nil {
+		return "", false, err
+	}
+
+	return string(value[:n]), true, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package download
+
+// setXattr is only supported on linux, the platforms without syscall.Setxattr
+func setXattr(path, name, value string) error {
+	return ErrXattrUnsupported
+}
+
+func getXattr(path, name string) (string, bool, error) {
+	return "", false, ErrXattrUnsupported
+}
@@ -0,0 +1,50 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXattrs(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := newRangeServer(content)
+	defer server.Close()
+
+	fileURL := strings.Replace(server.URL, "http://", "http://user:secret@", 1) + "/file.mp4?X-Amz-Signature=secret&token=secret"
+	result, err := Download(fileURL, &Config{
+		FilePath: t.TempDir() + "/file.mp4",
+		Xattrs:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attrs, err := ReadXattrs(result.FinalPath)
+	if errors.Is(err, ErrXattrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attrs) == 0 {
+		t.Skip("the file system does not keep the user attributes")
+	}
+
+	if attrs[XattrOriginURL] != server.URL+"/file.mp4" {
+		t.Errorf("expected the url without credentials and query, got %s", attrs[XattrOriginURL])
+	}
+
+	sum := sha256.Sum256(content)
+	if attrs[XattrChecksum] != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected checksum: %s", attrs[XattrChecksum])
+	}
+
+	if _, err := time.Parse(time.RFC3339, attrs[XattrTime]); err != nil {
+		t.Errorf("unexpected time: %v", err)
+	}
+}